@@ -1,30 +1,189 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
 	"log"
+	"os"
 
 	"github.com/emiteze/tcc-ufu/internal/api"
 	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/encryption"
+	"github.com/emiteze/tcc-ufu/internal/events"
+	"github.com/emiteze/tcc-ufu/internal/jobs"
+	"github.com/emiteze/tcc-ufu/internal/leader"
+	"github.com/emiteze/tcc-ufu/internal/migrations"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
+	"github.com/emiteze/tcc-ufu/internal/streams"
+	"github.com/emiteze/tcc-ufu/internal/webhooks"
 )
 
 func main() {
 	// Load configuration
-	cfg := config.Load()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	ctx := context.Background()
+
+	// Enable PII field encryption when a key provider is configured
+	keyProvider, err := encryption.NewKeyProvider(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize key provider: %v", err)
+	}
+	var encryptor *db.FieldEncryptor
+	if keyProvider != nil {
+		indexKey, err := base64.StdEncoding.DecodeString(cfg.BlindIndexKey)
+		if err != nil {
+			log.Fatalf("Failed to decode blind index key: %v", err)
+		}
+		encryptor, err = db.NewFieldEncryptor(keyProvider, cfg.EncryptionFields, indexKey)
+		if err != nil {
+			log.Fatalf("Failed to initialize field encryption: %v", err)
+		}
+		log.Printf("Field encryption enabled for %v", cfg.EncryptionFields)
+	}
 
-	// Initialize DynamoDB client
-	dbClient, err := db.InitDynamoDB(cfg)
+	// Initialize DynamoDB client, which creates tables with the configured
+	// billing, encryption and protection and compares existing ones with them
+	dbClient, err := db.InitDynamoDB(ctx, cfg, encryptor)
 	if err != nil {
 		log.Fatalf("Failed to initialize DynamoDB: %v", err)
 	}
 
-	// Ensure table exists
-	if err := db.EnsureTableExists(dbClient, cfg.TableName); err != nil {
+	// Ensure tables exist
+	if _, err := db.EnsureTableExists(ctx, dbClient, cfg.TableName); err != nil {
 		log.Fatalf("Failed to ensure table exists: %v", err)
 	}
+	if err := db.EnsureSettingsTableExists(ctx, dbClient, cfg.SettingsTableName); err != nil {
+		log.Fatalf("Failed to ensure settings table exists: %v", err)
+	}
+	if err := db.EnsureTagsTableExists(ctx, dbClient, cfg.TagsTableName); err != nil {
+		log.Fatalf("Failed to ensure tags table exists: %v", err)
+	}
+	if err := db.EnsureHistoryTableExists(ctx, dbClient, cfg.HistoryTableName); err != nil {
+		log.Fatalf("Failed to ensure history table exists: %v", err)
+	}
+	if err := db.EnsureNotesTableExists(ctx, dbClient, cfg.NotesTableName); err != nil {
+		log.Fatalf("Failed to ensure notes table exists: %v", err)
+	}
+	if err := db.EnsureJobsTableExists(ctx, dbClient, cfg.JobsTableName); err != nil {
+		log.Fatalf("Failed to ensure jobs table exists: %v", err)
+	}
+	if err := db.EnsureJobDataTableExists(ctx, dbClient, cfg.JobDataTableName); err != nil {
+		log.Fatalf("Failed to ensure job data table exists: %v", err)
+	}
+	if err := db.EnsureWebhooksTableExists(ctx, dbClient, cfg.WebhooksTableName); err != nil {
+		log.Fatalf("Failed to ensure webhooks table exists: %v", err)
+	}
+	if err := db.EnsureWebhookDeliveriesTableExists(ctx, dbClient, cfg.WebhookDeliveriesTableName); err != nil {
+		log.Fatalf("Failed to ensure webhook deliveries table exists: %v", err)
+	}
+	if err := db.EnsureIdempotencyTableExists(ctx, dbClient, cfg.IdempotencyTableName); err != nil {
+		log.Fatalf("Failed to ensure idempotency table exists: %v", err)
+	}
+
+	// Bring tables created by earlier versions up to date. "api migrate"
+	// applies the migrations, or reports them with "api migrate status", and exits.
+	migrator := migrations.NewRunner(dbClient, cfg)
+	migrator.Register(migrations.Customers(dbClient, cfg)...)
+	// Tables created by this version already have what the migrations add
+	if err := migrator.Baseline(ctx); err != nil {
+		log.Fatalf("Failed to record schema migrations: %v", err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, migrator, os.Args[2:]); err != nil {
+			log.Fatalf("Failed to migrate: %v", err)
+		}
+		return
+	}
+	switch cfg.Migrations {
+	case "startup":
+		if err := migrator.Run(ctx); err != nil {
+			log.Fatalf("Failed to apply schema migrations: %v", err)
+		}
+	case "manual":
+		_, pending, err := migrator.Status(ctx)
+		if err != nil {
+			log.Fatalf("Failed to check schema migrations: %v", err)
+		}
+		if len(pending) > 0 {
+			log.Fatalf("%d schema migrations are pending, apply them with the migrate subcommand", len(pending))
+		}
+	default:
+		log.Fatalf("Unknown migrations mode %q", cfg.Migrations)
+	}
+
+	streamsClient, err := db.InitDynamoDBStreams(ctx, cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize DynamoDB Streams: %v", err)
+	}
+
+	// Publish customer events in the background, to the configured sink and
+	// to subscribed webhooks, reading them from the outbox written with every
+	// customer write or from the customers table's stream
+	sink, err := events.NewSink(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize event sink: %v", err)
+	}
+	if sink != nil {
+		dispatcher := webhooks.NewDispatcher(dbClient, cfg)
+		switch cfg.EventSource {
+		case "outbox":
+			if err := db.EnsureOutboxTableExists(ctx, dbClient, cfg.OutboxTableName); err != nil {
+				log.Fatalf("Failed to ensure outbox table exists: %v", err)
+			}
+			dbClient.SetOutboxTable(cfg.OutboxTableName)
+			// One process at a time relays the outbox so a customer's events
+			// are published in order
+			relay := events.NewRelay(dbClient, cfg.OutboxTableName, events.MultiSink{sink, dispatcher}, cfg.OutboxMaxAttempts)
+			go leader.NewElector(dbClient, cfg.SettingsTableName, "outbox-relay").Run(ctx, relay.Run)
+		case "stream":
+			if err := db.EnsureStreamCheckpointsTableExists(ctx, dbClient, cfg.StreamCheckpointsTableName); err != nil {
+				log.Fatalf("Failed to ensure stream checkpoints table exists: %v", err)
+			}
+			// One process at a time publishes the stream's events, resuming
+			// from the checkpoints of the one before it
+			publisher := events.MultiSink{sink, dispatcher}
+			go leader.NewElector(dbClient, cfg.SettingsTableName, "stream-events").Run(ctx, func(ctx context.Context) {
+				consumer := streams.NewConsumer(dbClient, streamsClient, cfg.TableName, "events", cfg.StreamCheckpointsTableName)
+				consumer.Register("events", streams.PublishEvents(publisher))
+				consumer.Run(ctx)
+			})
+		default:
+			log.Fatalf("Unknown event source %q", cfg.EventSource)
+		}
+		go dispatcher.Run(ctx)
+		log.Printf("Publishing customer events from the %s to the %s sink", cfg.EventSource, cfg.EventSink)
+	}
+
+	// Keep the search index in sync with writes made by other processes. The
+	// stream is read once before the index is built so no change falls between them.
+	searchSync := streams.NewConsumer(dbClient, streamsClient, cfg.TableName, "search", "")
+	searchSync.Register("search", streams.IndexSearch(dbClient))
+	// Every replica broadcasts every committed change to its event stream
+	// clients, whichever process made it
+	changes := pubsub.NewBroker(cfg.SSEReplaySize)
+	searchSync.Register("changes", streams.PublishChanges(changes))
+	if _, err := searchSync.Poll(ctx); err != nil {
+		log.Fatalf("Failed to read customer table stream: %v", err)
+	}
+
+	// Build the search index before serving so searches see every customer
+	searchIndex, err := db.BuildSearchIndex(ctx, dbClient, cfg.TableName)
+	if err != nil {
+		log.Fatalf("Failed to build search index: %v", err)
+	}
+	dbClient.SetSearchIndex(searchIndex)
+	log.Printf("Indexed %d customers for search", searchIndex.Len())
+	go searchSync.Run(ctx)
 
-	// Setup and run the API server
-	router := api.SetupRouter(dbClient, cfg)
+	// Setup and run the API server, running background jobs alongside it.
+	// Jobs left unfinished by a previous process are resumed once their lease expires.
+	runner := jobs.NewRunner(dbClient, cfg)
+	router := api.SetupRouter(dbClient, cfg, runner, changes)
+	go runner.Run(ctx)
 
 	log.Printf("Starting server on port %s", cfg.Port)
 	if err := router.Run(":" + cfg.Port); err != nil {
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/emiteze/tcc-ufu/internal/migrations"
+)
+
+// runMigrate runs the migrate subcommand: without arguments it applies the
+// pending migrations, "status" lists the applied and pending ones
+func runMigrate(ctx context.Context, migrator *migrations.Runner, args []string) error {
+	if len(args) == 0 {
+		if err := migrator.Run(ctx); err != nil {
+			return err
+		}
+		log.Printf("Schema migrations are up to date")
+		return nil
+	}
+	if len(args) > 1 || args[0] != "status" {
+		return fmt.Errorf("usage: migrate [status]")
+	}
+
+	state, pending, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	for _, applied := range state.Applied {
+		fmt.Printf("applied  %3d  %s  %s\n", applied.Version, applied.AppliedAt, applied.Name)
+	}
+	for i, migration := range pending {
+		status := "pending"
+		if i == 0 && state.Checkpoint != "" {
+			status = "partial"
+		}
+		fmt.Printf("%-7s  %3d  %s\n", status, migration.Version, migration.Name)
+	}
+	return nil
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// BatchCustomers handles POST /customers:batch. Each operation is validated
+// and reported on its own, so the response is 200 even when some fail.
+func (h *Handler) BatchCustomers(c *gin.Context) {
+	var request models.BatchRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	operations := request.Operations
+	if len(operations) == 0 || len(operations) > h.batchMax {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("operations must contain between 1 and %d entries", h.batchMax)})
+		return
+	}
+
+	// Decode every operation first, so the customers it touches can be read in one batch
+	results := make([]models.BatchResult, len(operations))
+	customers := make([]*models.Customer, len(operations))
+	seen := map[string]bool{}
+	var ids []string
+	for i, op := range operations {
+		results[i] = models.BatchResult{Index: i, Op: op.Op, ID: op.ID}
+
+		customer, id, err := decodeBatchOperation(op)
+		if err != nil {
+			failBatchResult(&results[i], &apiError{status: http.StatusBadRequest, message: err.Error()})
+			continue
+		}
+		customers[i] = customer
+		results[i].ID = id
+
+		if id == "" {
+			continue
+		}
+		// BatchWriteItem rejects requests touching the same item twice
+		if seen[id] {
+			failBatchResult(&results[i], &apiError{status: http.StatusBadRequest, message: "id appears more than once in the batch"})
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	existing := map[string]*models.Customer{}
+	if len(ids) > 0 {
+		var err error
+		if existing, err = db.GetCustomers(c.Request.Context(), h.dbClient, h.tableName, ids); err != nil {
+			respondServerError(c, err, "Failed to check customers")
+			return
+		}
+	}
+
+	validateMetadata := h.metadataValidator(c.Request.Context())
+	var creates, updates, deletes []*models.Customer
+	pending := map[string]int{}
+	for i, op := range operations {
+		result := &results[i]
+		if result.Status != 0 {
+			continue
+		}
+		customer, stored := customers[i], existing[result.ID]
+
+		switch op.Op {
+		case models.BatchCreate:
+			if stored != nil {
+				failBatchResult(result, &apiError{status: http.StatusConflict, message: "Customer already exists"})
+				continue
+			}
+			if err := validateMetadata(customer.Metadata); err != nil {
+				failBatchResult(result, err)
+				continue
+			}
+			if err := prepareNewCustomer(customer); err != nil {
+				failBatchResult(result, &apiError{status: http.StatusBadRequest, message: err.Error()})
+				continue
+			}
+			result.ID = customer.ID
+			creates = append(creates, customer)
+
+		case models.BatchUpdate:
+			if stored == nil {
+				failBatchResult(result, &apiError{status: http.StatusNotFound, message: "Customer not found"})
+				continue
+			}
+			if stored.IsErased() {
+				failBatchResult(result, &apiError{status: http.StatusGone, message: "Customer has been erased"})
+				continue
+			}
+			if stored.IsMerged() {
+				failBatchResult(result, &apiError{status: http.StatusGone, message: "Customer was merged into " + stored.MergedInto})
+				continue
+			}
+			prepareUpdatedCustomer(customer, stored)
+			if err := validateMetadata(customer.Metadata); err != nil {
+				failBatchResult(result, err)
+				continue
+			}
+			updates = append(updates, customer)
+
+		case models.BatchDelete:
+			if stored == nil {
+				failBatchResult(result, &apiError{status: http.StatusNotFound, message: "Customer not found"})
+				continue
+			}
+			if err := h.checkNotesPolicy(c.Request.Context(), result.ID); err != nil {
+				failBatchResult(result, err)
+				continue
+			}
+			// Tag counts must change with the customer, which needs a transaction of its own
+			if len(stored.Tags) > 0 {
+				if err := db.DeleteCustomerWithTags(c.Request.Context(), h.dbClient, h.tableName, h.tagsTable, stored); err != nil {
+					failBatchResult(result, writeError(err, "Failed to delete customer"))
+				} else {
+					result.Status = http.StatusOK
+				}
+				continue
+			}
+			deletes = append(deletes, stored)
+		}
+		pending[result.ID] = i
+	}
+
+	failed := db.WriteCustomers(c.Request.Context(), h.dbClient, h.tableName, creates, updates, deletes)
+	for id, i := range pending {
+		result := &results[i]
+		if failed[id] != nil {
+			failBatchResult(result, writeError(failed[id], "Failed to write customer"))
+			continue
+		}
+
+		switch result.Op {
+		case models.BatchCreate:
+			result.Status = http.StatusCreated
+		default:
+			result.Status = http.StatusOK
+		}
+		if customers[i] != nil && result.Op != models.BatchDelete {
+			presented := presentCustomer(c, *customers[i])
+			result.Customer = &presented
+		}
+	}
+
+	// Notes only go once their customer is gone, so a failed delete keeps them
+	for i := range results {
+		result := &results[i]
+		if result.Op == models.BatchDelete && result.Status == http.StatusOK {
+			if err := h.deleteNotes(c.Request.Context(), result.ID); err != nil {
+				failBatchResult(result, err)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// decodeBatchOperation validates an operation and decodes its customer with
+// the same rules as the single-customer endpoints. It returns the ID the
+// operation targets, which is empty for creates that let the server pick one.
+func decodeBatchOperation(op models.BatchOperation) (*models.Customer, string, error) {
+	switch op.Op {
+	case models.BatchDelete:
+		if op.ID == "" {
+			return nil, "", errors.New("id is required")
+		}
+		return nil, op.ID, nil
+	case models.BatchCreate, models.BatchUpdate:
+	default:
+		return nil, "", fmt.Errorf("op must be one of %s, %s or %s", models.BatchCreate, models.BatchUpdate, models.BatchDelete)
+	}
+
+	if len(op.Customer) == 0 {
+		return nil, "", errors.New("customer is required")
+	}
+	var customer models.Customer
+	if err := json.Unmarshal(op.Customer, &customer); err != nil {
+		return nil, "", err
+	}
+	if err := binding.Validator.ValidateStruct(&customer); err != nil {
+		return nil, "", err
+	}
+
+	if op.Op == models.BatchUpdate {
+		if op.ID == "" {
+			return nil, "", errors.New("id is required")
+		}
+		return &customer, op.ID, nil
+	}
+
+	if op.ID != "" && customer.ID != "" && op.ID != customer.ID {
+		return nil, "", errors.New("id does not match customer.id")
+	}
+	if customer.ID == "" {
+		customer.ID = op.ID
+	}
+	return &customer, customer.ID, nil
+}
+
+// failBatchResult records an error as the outcome of a batch operation
+func failBatchResult(result *models.BatchResult, err *apiError) {
+	result.Status = err.status
+	result.Error = err.message
+	result.Details = err.details
+}
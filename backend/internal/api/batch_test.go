@@ -0,0 +1,167 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBatchOperation(t *testing.T) {
+	tests := []struct {
+		name       string
+		op         models.BatchOperation
+		expectedID string
+		expectErr  string
+	}{
+		{name: "create without id", op: models.BatchOperation{Op: "create", Customer: json.RawMessage(`{"name":"John","email":"john@example.com"}`)}},
+		{name: "create with id", op: models.BatchOperation{Op: "create", ID: "1", Customer: json.RawMessage(`{"name":"John","email":"john@example.com"}`)}, expectedID: "1"},
+		{name: "create with mismatched ids", op: models.BatchOperation{Op: "create", ID: "1", Customer: json.RawMessage(`{"id":"2","name":"John","email":"john@example.com"}`)}, expectErr: "does not match"},
+		{name: "create with invalid email", op: models.BatchOperation{Op: "create", Customer: json.RawMessage(`{"name":"John","email":"john"}`)}, expectErr: "Email"},
+		{name: "create without customer", op: models.BatchOperation{Op: "create"}, expectErr: "customer is required"},
+		{name: "update", op: models.BatchOperation{Op: "update", ID: "1", Customer: json.RawMessage(`{"name":"John","email":"john@example.com"}`)}, expectedID: "1"},
+		{name: "update without id", op: models.BatchOperation{Op: "update", Customer: json.RawMessage(`{"name":"John","email":"john@example.com"}`)}, expectErr: "id is required"},
+		{name: "delete", op: models.BatchOperation{Op: "delete", ID: "1"}, expectedID: "1"},
+		{name: "delete without id", op: models.BatchOperation{Op: "delete"}, expectErr: "id is required"},
+		{name: "unknown op", op: models.BatchOperation{Op: "upsert", ID: "1"}, expectErr: "op must be one of"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, id, err := decodeBatchOperation(tt.op)
+			if tt.expectErr != "" {
+				assert.ErrorContains(t, err, tt.expectErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedID, id)
+		})
+	}
+}
+
+func TestBatchCustomers_Validation(t *testing.T) {
+	router := SetupRouter(nil, &config.Config{TableName: "TestCustomers", BatchMaxOperations: 2}, nil, nil)
+
+	tests := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{name: "invalid json", body: `{`, expected: http.StatusBadRequest},
+		{name: "no operations", body: `{"operations":[]}`, expected: http.StatusBadRequest},
+		{name: "too many operations", body: `{"operations":[{"op":"delete","id":"1"},{"op":"delete","id":"2"},{"op":"delete","id":"3"}]}`, expected: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/customers:batch", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
+
+func TestBatchCustomers_PerItemErrors(t *testing.T) {
+	router := SetupRouter(db.NewClient(nil, nil), &config.Config{TableName: "TestCustomers", BatchMaxOperations: 10}, nil, nil)
+
+	body := `{"operations":[{"op":"upsert"},{"op":"create","customer":{"name":"John","email":"not-an-email"}}]}`
+	req, _ := http.NewRequest("POST", "/customers:batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var response struct {
+		Results []models.BatchResult `json:"results"`
+	}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	require.Len(t, response.Results, 2)
+	for i, result := range response.Results {
+		assert.Equal(t, i, result.Index)
+		assert.Equal(t, http.StatusBadRequest, result.Status)
+		assert.NotEmpty(t, result.Error)
+	}
+}
+
+func TestSetupRouter_UnknownRoute(t *testing.T) {
+	router := SetupRouter(nil, &config.Config{TableName: "TestCustomers"}, nil, nil)
+
+	req, _ := http.NewRequest("GET", "/customers:batch", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+// fakeDynamoDB returns a client of a DynamoDB endpoint answering each operation
+// with what handle returns. Responses with a __type are sent as faults.
+func fakeDynamoDB(t *testing.T, handle func(operation string, body map[string]interface{}) interface{}) *db.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		response := handle(operation, body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if fault, ok := response.(map[string]interface{}); ok && fault["__type"] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+func TestBatchCustomers_DeletesNotesAfterCustomer(t *testing.T) {
+	for name, deleteFails := range map[string]bool{"deleted": false, "delete fails": true} {
+		t.Run(name, func(t *testing.T) {
+			var operations []string
+			client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+				operations = append(operations, operation)
+				switch operation {
+				case "BatchGetItem":
+					return map[string]interface{}{"Responses": map[string]interface{}{"Customers": []interface{}{
+						map[string]interface{}{"id": map[string]string{"S": "c1"}, "name": map[string]string{"S": "John"}},
+					}}}
+				case "DeleteItem":
+					if deleteFails {
+						return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ValidationException", "message": "boom"}
+					}
+				}
+				return map[string]interface{}{}
+			})
+			router := SetupRouter(client, &config.Config{TableName: "Customers", NotesTableName: "Notes", BatchMaxOperations: 10}, nil, nil)
+
+			req, _ := http.NewRequest("POST", "/customers:batch", strings.NewReader(`{"operations":[{"op":"delete","id":"c1"}]}`))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			require.Equal(t, http.StatusOK, w.Code)
+			if deleteFails {
+				assert.Equal(t, []string{"BatchGetItem", "DeleteItem"}, operations, "the notes are kept")
+				return
+			}
+			assert.Equal(t, []string{"BatchGetItem", "DeleteItem", "Query"}, operations)
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/gin-gonic/gin"
+)
+
+// apiError is a failure to be reported to the client with an HTTP status
+type apiError struct {
+	status  int
+	message string
+	details string
+	// retryAfter is sent as the Retry-After header when set
+	retryAfter time.Duration
+}
+
+// body returns the JSON error body used by every handler
+func (e *apiError) body() gin.H {
+	if e.details == "" {
+		return gin.H{"error": e.message}
+	}
+	return gin.H{"error": e.message, "details": e.details}
+}
+
+// serverError returns the error reporting a failed operation: 503 while the
+// database is considered down, 504 when it didn't answer in time and 500 with
+// message otherwise
+func serverError(err error, message string) *apiError {
+	switch {
+	case errors.Is(err, db.ErrCircuitOpen):
+		retryAfter, _ := db.RetryAfter(err)
+		return &apiError{status: http.StatusServiceUnavailable, message: "Database unavailable, retry later", retryAfter: retryAfter}
+	case db.IsTimeout(err):
+		return &apiError{status: http.StatusGatewayTimeout, message: "Timed out waiting for the database"}
+	}
+	return &apiError{status: http.StatusInternalServerError, message: message}
+}
+
+// writeError returns the error reporting a failed customer write: 404 when
+// the customer is gone, 409 when it changed since it was read and the errors
+// of serverError otherwise
+func writeError(err error, message string) *apiError {
+	switch {
+	case errors.Is(err, db.ErrCustomerNotFound):
+		return &apiError{status: http.StatusNotFound, message: "Customer not found"}
+	case errors.Is(err, db.ErrCustomerChanged):
+		return &apiError{status: http.StatusConflict, message: "Customer changed, reload and retry"}
+	}
+	return serverError(err, message)
+}
+
+// respondServerError reports a failed operation to the client
+func respondServerError(c *gin.Context, err error, message string) {
+	respondError(c, serverError(err, message))
+}
+
+// respondError writes err as the response
+func respondError(c *gin.Context, err *apiError) {
+	if err.retryAfter > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(err.retryAfter.Seconds()))))
+	}
+	c.JSON(err.status, err.body())
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServerError(t *testing.T) {
+	timeout := fmt.Errorf("failed to get customer: %w", &smithy.OperationError{ServiceID: "DynamoDB", OperationName: "GetItem", Err: context.DeadlineExceeded})
+	assert.Equal(t, &apiError{status: http.StatusGatewayTimeout, message: "Timed out waiting for the database"}, serverError(timeout, "Failed to get customer"))
+
+	open := fmt.Errorf("failed to get customer: %w", db.ErrCircuitOpen)
+	assert.Equal(t, &apiError{status: http.StatusServiceUnavailable, message: "Database unavailable, retry later"}, serverError(open, "Failed to get customer"))
+
+	canceled := &smithy.OperationError{ServiceID: "DynamoDB", OperationName: "GetItem", Err: context.Canceled}
+	assert.Equal(t, &apiError{status: http.StatusInternalServerError, message: "Failed to get customer"}, serverError(canceled, "Failed to get customer"))
+}
+
+func TestRespondError_RetryAfter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	respondError(c, &apiError{status: http.StatusServiceUnavailable, message: "Database unavailable, retry later", retryAfter: 2500 * time.Millisecond})
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "3", w.Header().Get("Retry-After"))
+}
+
+func TestWriteError(t *testing.T) {
+	changed := fmt.Errorf("failed to put item: %w", db.ErrCustomerChanged)
+	assert.Equal(t, http.StatusConflict, writeError(changed, "Failed to update customer").status)
+
+	gone := fmt.Errorf("failed to delete item: %w", db.ErrCustomerNotFound)
+	assert.Equal(t, http.StatusNotFound, writeError(gone, "Failed to delete customer").status)
+
+	assert.Equal(t, &apiError{status: http.StatusInternalServerError, message: "Failed to delete customer"}, writeError(fmt.Errorf("boom"), "Failed to delete customer"))
+}
+
+func TestHandler_GetCustomer_DeadlineExpired(t *testing.T) {
+	handler, router := setupTestHandler()
+	handler.dbClient = (&fakeKeyTable{items: map[string]map[string]interface{}{}}).client(t)
+	router.GET("/customers/:id", handler.GetCustomer)
+
+	ctx, cancel := context.WithTimeout(context.Background(), -time.Second)
+	defer cancel()
+	req := httptest.NewRequest(http.MethodGet, "/customers/123", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusGatewayTimeout, w.Code)
+	assert.JSONEq(t, `{"error":"Timed out waiting for the database"}`, w.Body.String())
+}
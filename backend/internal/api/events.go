@@ -0,0 +1,84 @@
+package api
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// streamResetEvent tells a resuming subscriber it missed changes and must reload
+const streamResetEvent = "reset"
+
+// customerChange is the data of a streamed customer change. Customer is
+// left out of deletes.
+type customerChange struct {
+	ID       string           `json:"id"`
+	Customer *models.Customer `json:"customer,omitempty"`
+}
+
+// StreamCustomerEvents handles GET /customers/events. Customers created,
+// updated and deleted, through any instance, are streamed as server-sent
+// events, masked for the caller like any other response. Clients resuming
+// with Last-Event-ID first receive the changes they missed, or a reset event
+// when those are no longer held.
+func (h *Handler) StreamCustomerEvents(c *gin.Context) {
+	replay, complete, sub := h.changes.Subscribe(c.GetHeader("Last-Event-ID"))
+	defer h.changes.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop proxies from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	if !complete {
+		if err := sse.Encode(c.Writer, sse.Event{Event: streamResetEvent, Data: gin.H{}}); err != nil {
+			return
+		}
+	}
+	for _, message := range replay {
+		if err := writeCustomerChange(c, message); err != nil {
+			return
+		}
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(h.sseHeartbeat)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			if _, err := io.WriteString(c.Writer, ": heartbeat\n\n"); err != nil {
+				return
+			}
+		case message, ok := <-sub.C:
+			// A closed subscription fell behind, the client reconnects and resumes
+			if !ok {
+				return
+			}
+			if err := writeCustomerChange(c, message); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// writeCustomerChange writes a change as a server-sent event named after its type
+func writeCustomerChange(c *gin.Context, message pubsub.Message) error {
+	change := customerChange{ID: message.CustomerID}
+	if message.Customer != nil {
+		presented := presentCustomer(c, *message.Customer)
+		change.Customer = &presented
+	}
+	return sse.Encode(c.Writer, sse.Event{Id: message.ID, Event: message.Type, Data: change})
+}
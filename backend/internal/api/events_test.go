@@ -0,0 +1,97 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// openCustomerStream connects to the stream of handler, resuming after lastID when given
+func openCustomerStream(t *testing.T, handler *Handler, lastID string) *bufio.Reader {
+	router := gin.New()
+	router.GET("/customers/events", handler.StreamCustomerEvents)
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/customers/events", nil)
+	if lastID != "" {
+		req.Header.Set("Last-Event-ID", lastID)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	return bufio.NewReader(resp.Body)
+}
+
+// readStreamEvent reads the lines of the next event or comment
+func readStreamEvent(t *testing.T, stream *bufio.Reader) []string {
+	var lines []string
+	for {
+		line, err := stream.ReadString('\n')
+		require.NoError(t, err)
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return lines
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestStreamCustomerEvents_BroadcastsMaskedChanges(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewHandler(nil, &config.Config{TableName: "TestCustomers", SSEReplaySize: 10, SSEHeartbeatSeconds: 60})
+	stream := openCustomerStream(t, handler, "")
+
+	handler.changes.Publish(pubsub.Message{ID: "001", Type: models.EventCustomerCreated, CustomerID: "c1", Customer: &models.Customer{ID: "c1", Name: "John Doe", Email: "john.doe@gmail.com"}})
+	handler.changes.Publish(pubsub.Message{ID: "002", Type: models.EventCustomerDeleted, CustomerID: "c2"})
+
+	created := readStreamEvent(t, stream)
+	require.Len(t, created, 3)
+	assert.Equal(t, "id:001", created[0])
+	assert.Equal(t, "event:customer.created", created[1])
+	assert.Contains(t, created[2], `"id":"c1"`)
+	assert.NotContains(t, created[2], "john.doe@gmail.com")
+
+	deleted := readStreamEvent(t, stream)
+	assert.Equal(t, "event:customer.deleted", deleted[1])
+	assert.Equal(t, `data:{"id":"c2"}`, deleted[2])
+}
+
+func TestStreamCustomerEvents_Resume(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewHandler(nil, &config.Config{TableName: "TestCustomers", SSEReplaySize: 10, SSEHeartbeatSeconds: 60})
+	_, _, sub := handler.changes.Subscribe("")
+	handler.changes.Publish(pubsub.Message{ID: "001", Type: models.EventCustomerDeleted, CustomerID: "c1"})
+	handler.changes.Publish(pubsub.Message{ID: "002", Type: models.EventCustomerDeleted, CustomerID: "c2"})
+	first := <-sub.C
+
+	stream := openCustomerStream(t, handler, first.ID)
+
+	replayed := readStreamEvent(t, stream)
+	assert.Equal(t, `data:{"id":"c2"}`, replayed[2])
+}
+
+func TestStreamCustomerEvents_ResetAndHeartbeat(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewHandler(nil, &config.Config{TableName: "TestCustomers", SSEReplaySize: 10, SSEHeartbeatSeconds: 1})
+
+	stream := openCustomerStream(t, handler, "unknown-1")
+
+	assert.Equal(t, []string{"event:reset", "data:{}"}, readStreamEvent(t, stream))
+	assert.Equal(t, []string{": heartbeat"}, readStreamEvent(t, stream))
+}
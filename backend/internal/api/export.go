@@ -0,0 +1,138 @@
+package api
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// exportFlushInterval is the number of customers written between flushes to the client
+const exportFlushInterval = 100
+
+// customerEncoder writes customers to an export stream. Output is buffered
+// until Flush.
+type customerEncoder interface {
+	Encode(customer models.Customer) error
+	Flush() error
+}
+
+// csvEncoder writes customers as CSV rows, after a header row unless the
+// output continues an earlier export
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+// newCSVEncoder returns a csvEncoder writing to w, with the header row first when header is set
+func newCSVEncoder(w io.Writer, header bool) (*csvEncoder, error) {
+	writer := csv.NewWriter(w)
+	if header {
+		if err := writer.Write(models.CSVExportColumns); err != nil {
+			return nil, err
+		}
+	}
+	return &csvEncoder{writer: writer}, nil
+}
+
+// Encode writes a customer as a CSV row
+func (e *csvEncoder) Encode(customer models.Customer) error {
+	record, err := customer.CSVRecord()
+	if err != nil {
+		return err
+	}
+	return e.writer.Write(record)
+}
+
+// Flush writes the buffered rows to the output
+func (e *csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// ndjsonEncoder writes customers as one JSON object per line
+type ndjsonEncoder struct {
+	buffer  *bufio.Writer
+	encoder *json.Encoder
+}
+
+// newNDJSONEncoder returns an ndjsonEncoder writing to w
+func newNDJSONEncoder(w io.Writer) *ndjsonEncoder {
+	buffer := bufio.NewWriter(w)
+	return &ndjsonEncoder{buffer: buffer, encoder: json.NewEncoder(buffer)}
+}
+
+// Encode writes a customer as a line of JSON
+func (e *ndjsonEncoder) Encode(customer models.Customer) error {
+	return e.encoder.Encode(customer)
+}
+
+// Flush writes the buffered lines to the output
+func (e *ndjsonEncoder) Flush() error {
+	return e.buffer.Flush()
+}
+
+// ExportAllCustomers handles GET /customers/export. Customers are streamed
+// as the table is scanned, so the export is never held in memory.
+func (h *Handler) ExportAllCustomers(c *gin.Context) {
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'csv' or 'ndjson'"})
+		return
+	}
+
+	var encoder customerEncoder
+	contentType := "application/x-ndjson"
+	if format == "csv" {
+		csvEncoder, err := newCSVEncoder(c.Writer, true)
+		if err != nil {
+			respondServerError(c, err, "Failed to export customers")
+			return
+		}
+		encoder = csvEncoder
+		contentType = "text/csv"
+	} else {
+		encoder = newNDJSONEncoder(c.Writer)
+	}
+	// The headers are only set once the export can start, so errors keep a JSON content type
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="customers.%s"`, format))
+	c.Status(http.StatusOK)
+
+	written := 0
+	err := db.ScanCustomers(c.Request.Context(), h.dbClient, h.tableName, h.exportSegments, func(customer models.Customer) error {
+		if err := encoder.Encode(presentCustomer(c, customer)); err != nil {
+			return err
+		}
+		if written++; written%exportFlushInterval == 0 {
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			c.Writer.Flush()
+		}
+		// Stop scanning once the client has gone away
+		return c.Request.Context().Err()
+	})
+
+	// Nothing has reached the client yet, so the failure can still be reported properly
+	if err != nil && !c.Writer.Written() {
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+		respondServerError(c, err, "Failed to export customers")
+		return
+	}
+	if err != nil {
+		log.Printf("Customer export stopped after %d customers: %v", written, err)
+		return
+	}
+
+	if err := encoder.Flush(); err != nil {
+		log.Printf("Failed to finish customer export: %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"bytes"
+	"encoding/csv"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSVEncoder(t *testing.T) {
+	var out bytes.Buffer
+	encoder, err := newCSVEncoder(&out, true)
+	require.NoError(t, err)
+
+	customer := models.Customer{ID: "1", Name: `Doe, "Johnny"`, Email: "john@example.com", Metadata: map[string]interface{}{"note": "line1\nline2"}}
+	require.NoError(t, encoder.Encode(customer))
+	assert.Empty(t, out.String(), "output is buffered until flushed")
+	require.NoError(t, encoder.Flush())
+
+	// Values with separators, quotes and newlines survive a round trip
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	assert.Equal(t, models.CSVExportColumns, records[0])
+	assert.Equal(t, `Doe, "Johnny"`, records[1][1])
+	assert.Equal(t, `{"note":"line1\nline2"}`, records[1][6])
+}
+
+func TestNDJSONEncoder(t *testing.T) {
+	var out bytes.Buffer
+	encoder := newNDJSONEncoder(&out)
+
+	require.NoError(t, encoder.Encode(models.Customer{ID: "1", Name: "John", Email: "john@example.com"}))
+	require.NoError(t, encoder.Encode(models.Customer{ID: "2", Name: "Jane", Email: "jane@example.com"}))
+	require.NoError(t, encoder.Flush())
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	assert.Equal(t, []string{
+		`{"id":"1","name":"John","email":"john@example.com","telephone":""}`,
+		`{"id":"2","name":"Jane","email":"jane@example.com","telephone":""}`,
+	}, lines)
+}
+
+func TestExportAllCustomers_InvalidFormat(t *testing.T) {
+	router := SetupRouter(nil, &config.Config{TableName: "TestCustomers"}, nil, nil)
+
+	req, _ := http.NewRequest("GET", "/customers/export?format=xlsx", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestCSVEncoder_WithoutHeader(t *testing.T) {
+	var out bytes.Buffer
+	encoder, err := newCSVEncoder(&out, false)
+	require.NoError(t, err)
+
+	require.NoError(t, encoder.Encode(models.Customer{ID: "1", Name: "John"}))
+	require.NoError(t, encoder.Flush())
+
+	records, err := csv.NewReader(&out).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "1", records[0][0])
+}
+
+func TestExportAllCustomers_ScanFailureIsJSON(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException",
+			"message": "Requested resource not found",
+		}
+	})
+	handler := NewHandler(client, &config.Config{TableName: "Customers"})
+	router := gin.New()
+	router.GET("/customers/export", handler.ExportAllCustomers)
+
+	req, _ := http.NewRequest("GET", "/customers/export", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Type"), "application/json")
+	assert.Empty(t, w.Header().Get("Content-Disposition"))
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// parseFieldsParam reads the sparse fieldset from the fields query parameter.
+// It returns nil when the parameter is absent so every field is returned.
+func parseFieldsParam(c *gin.Context) ([]string, error) {
+	raw, ok := c.GetQuery("fields")
+	if !ok {
+		return nil, nil
+	}
+	return models.ParseCustomerFields(raw)
+}
+
+// respondCustomer writes a presented customer, limited to the fieldset when one is given
+func respondCustomer(c *gin.Context, status int, customer models.Customer, fields []string) {
+	customer = presentCustomer(c, customer)
+	if len(fields) == 0 {
+		c.JSON(status, customer)
+		return
+	}
+	c.JSON(status, customer.Fields(fields))
+}
+
+// respondCustomers writes presented customers, limited to the fieldset when one is given
+func respondCustomers(c *gin.Context, status int, customers []models.Customer, fields []string) {
+	customers = presentCustomers(c, customers)
+	if len(fields) == 0 {
+		c.JSON(status, customers)
+		return
+	}
+
+	selected := make([]map[string]interface{}, len(customers))
+	for i, customer := range customers {
+		selected[i] = customer.Fields(fields)
+	}
+	c.JSON(status, selected)
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFieldsParam(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name      string
+		query     string
+		expected  []string
+		expectErr bool
+	}{
+		{name: "absent", query: "", expected: nil},
+		{name: "fieldset", query: "?fields=id,name", expected: []string{"id", "name"}},
+		{name: "unknown field", query: "?fields=id,secret", expectErr: true},
+		{name: "empty", query: "?fields=", expectErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request, _ = http.NewRequest("GET", "/customers"+tt.query, nil)
+
+			fields, err := parseFieldsParam(c)
+			if tt.expectErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, fields)
+		})
+	}
+}
+
+func TestRespondCustomers_Fieldset(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/customers", nil)
+	c.Set(principalKey, config.APIToken{Principal: "bob"})
+
+	customers := []models.Customer{{ID: "123", Name: "John Doe", Email: "john.doe@example.com", Telephone: "555-0123"}}
+	respondCustomers(c, http.StatusOK, customers, []string{"id", "email"})
+
+	var body []map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &body))
+	// Only the selected fields are returned, still masked for callers without PII access
+	assert.Equal(t, []map[string]interface{}{{"id": "123", "email": "j***@example.com"}}, body)
+}
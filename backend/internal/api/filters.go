@@ -0,0 +1,92 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// listParams are the query parameters supported by GET /customers. Metadata
+// filters are passed as metadata[key]=value.
+var listParams = map[string]bool{
+	"email":         true,
+	"tag":           true,
+	"tag_mode":      true,
+	"status":        true,
+	"fields":        true,
+	"name_prefix":   true,
+	"email_domain":  true,
+	"created_after": true,
+	"sort":          true,
+}
+
+// validateListParams rejects query parameters GET /customers doesn't support,
+// so a misspelled filter fails instead of silently returning everything
+func validateListParams(c *gin.Context) error {
+	for param := range c.Request.URL.Query() {
+		if listParams[param] {
+			continue
+		}
+		if strings.HasPrefix(param, "metadata[") && strings.HasSuffix(param, "]") {
+			continue
+		}
+		return fmt.Errorf("unsupported query parameter %q", param)
+	}
+	return nil
+}
+
+// piiFilters are the GET /customers filters that match on PII
+var piiFilters = []string{"email", "email_domain"}
+
+// piiFilter returns the first filter of the request that matches on PII
+func piiFilter(c *gin.Context) (string, bool) {
+	for _, param := range piiFilters {
+		if _, ok := c.GetQuery(param); ok {
+			return param, true
+		}
+	}
+	return "", false
+}
+
+// parseEmailDomain reads the email_domain filter, accepting it with or without a leading '@'
+func parseEmailDomain(c *gin.Context) (string, error) {
+	raw, ok := c.GetQuery("email_domain")
+	if !ok {
+		return "", nil
+	}
+	domain := strings.TrimPrefix(strings.TrimSpace(raw), "@")
+	if domain == "" || strings.Contains(domain, "@") {
+		return "", fmt.Errorf("email_domain must be a domain such as example.com")
+	}
+	return domain, nil
+}
+
+// parseCreatedAfter reads the created_after filter and converts it to the
+// UTC layout creation timestamps are stored in
+func parseCreatedAfter(c *gin.Context) (string, error) {
+	raw, ok := c.GetQuery("created_after")
+	if !ok {
+		return "", nil
+	}
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return "", fmt.Errorf("created_after must be an RFC 3339 timestamp")
+	}
+	return t.UTC().Format(time.RFC3339), nil
+}
+
+// parseSort reads the sort parameter. It reports false when none is given.
+func parseSort(c *gin.Context) (models.CustomerSort, bool, error) {
+	raw, ok := c.GetQuery("sort")
+	if !ok {
+		return models.CustomerSort{}, false, nil
+	}
+	s, err := models.ParseCustomerSort(raw)
+	if err != nil {
+		return models.CustomerSort{}, false, err
+	}
+	return s, true, nil
+}
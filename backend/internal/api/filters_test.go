@@ -0,0 +1,104 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func listContext(query string) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/customers"+query, nil)
+	return c
+}
+
+func TestValidateListParams(t *testing.T) {
+	assert.NoError(t, validateListParams(listContext("?name_prefix=Jo&metadata[tier]=gold&sort=-createdAt")))
+	assert.ErrorContains(t, validateListParams(listContext("?nmae_prefix=Jo")), `"nmae_prefix"`)
+	assert.Error(t, validateListParams(listContext("?metadata=gold")))
+}
+
+func TestParseEmailDomain(t *testing.T) {
+	domain, err := parseEmailDomain(listContext("?email_domain=@example.com"))
+	require.NoError(t, err)
+	assert.Equal(t, "example.com", domain)
+
+	_, err = parseEmailDomain(listContext("?email_domain=john@example.com"))
+	assert.Error(t, err)
+}
+
+func TestParseCreatedAfter(t *testing.T) {
+	createdAfter, err := parseCreatedAfter(listContext("?created_after=2024-01-01T10:00:00%2B02:00"))
+	require.NoError(t, err)
+	assert.Equal(t, "2024-01-01T08:00:00Z", createdAfter)
+
+	_, err = parseCreatedAfter(listContext("?created_after=yesterday"))
+	assert.Error(t, err)
+}
+
+func TestParseSort(t *testing.T) {
+	s, sorted, err := parseSort(listContext("?sort=-createdAt"))
+	require.NoError(t, err)
+	assert.True(t, sorted)
+	assert.Equal(t, models.CustomerSort{Field: "createdAt", Descending: true}, s)
+
+	_, sorted, err = parseSort(listContext(""))
+	require.NoError(t, err)
+	assert.False(t, sorted)
+
+	_, _, err = parseSort(listContext("?sort=telephone"))
+	assert.Error(t, err)
+}
+
+func TestGetAllCustomers_UnsupportedParam(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.GET("/customers", handler.GetAllCustomers)
+
+	req, _ := http.NewRequest("GET", "/customers?colour=red", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetAllCustomers_PIIFiltersRequirePermission(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.Use(AuthMiddleware(map[string]config.APIToken{"reader-token": {Principal: "bob"}}))
+	router.GET("/customers", handler.GetAllCustomers)
+
+	for _, token := range []string{"", "reader-token"} {
+		for _, query := range []string{"?email=john@example.com", "?email_domain=example.com"} {
+			req, _ := http.NewRequest("GET", "/customers"+query, nil)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusForbidden, w.Code, query)
+		}
+	}
+}
+
+func TestGetAllCustomers_PIIFilterWithPermission(t *testing.T) {
+	handler, router := setupTestHandler()
+	handler.dbClient = fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"Items": []interface{}{}}
+	})
+	router.Use(AuthMiddleware(map[string]config.APIToken{"pii-token": {Principal: "carol", Permissions: []string{PermissionPII}}}))
+	router.GET("/customers", handler.GetAllCustomers)
+
+	req, _ := http.NewRequest("GET", "/customers?email=john@example.com", nil)
+	req.Header.Set("Authorization", "Bearer pii-token")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
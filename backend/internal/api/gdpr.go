@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// ExportCustomer handles GET /customers/:id/export
+func (h *Handler) ExportCustomer(c *gin.Context) {
+	id := c.Param("id")
+
+	customer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer")
+		return
+	}
+	if customer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	history, err := db.ListHistory(c.Request.Context(), h.dbClient, h.historyTable, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer history")
+		return
+	}
+
+	notes, err := db.ListAllNotes(c.Request.Context(), h.dbClient, h.notesTable, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer notes")
+		return
+	}
+
+	export := models.CustomerExport{
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Customer:   presentCustomer(c, *customer),
+		History:    history,
+		Notes:      notes,
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="customer-%s.json"`, id))
+	c.IndentedJSON(http.StatusOK, export)
+}
+
+// EraseCustomer handles POST /customers/:id/erase
+func (h *Handler) EraseCustomer(c *gin.Context) {
+	id := c.Param("id")
+
+	var request models.EraseRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to check customer")
+		return
+	}
+	if existingCustomer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if existingCustomer.IsErased() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Customer already erased"})
+		return
+	}
+
+	// Notes are free text and may hold personal data, so they go first; a
+	// failure leaves the customer untouched and the erasure can be retried
+	if err := db.DeleteCustomerNotes(c.Request.Context(), h.dbClient, h.notesTable, id); err != nil {
+		respondServerError(c, err, "Failed to delete customer notes")
+		return
+	}
+
+	entry := &models.HistoryEntry{
+		CustomerID: id,
+		From:       existingCustomer.CurrentStatus(),
+		Reason:     request.Reason,
+		Actor:      principalName(c),
+	}
+
+	if err := db.EraseCustomer(c.Request.Context(), h.dbClient, h.tableName, h.historyTable, entry); err != nil {
+		switch {
+		case errors.Is(err, db.ErrCustomerNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		case errors.Is(err, db.ErrCustomerErased):
+			c.JSON(http.StatusConflict, gin.H{"error": "Customer already erased"})
+		default:
+			respondServerError(c, err, "Failed to erase customer")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupRouter_DataSubjectRoutesRequireAdmin(t *testing.T) {
+	cfg := &config.Config{
+		TableName: "TestCustomers",
+		APITokens: map[string]config.APIToken{
+			"reader-token": {Principal: "bob"},
+		},
+	}
+	router := SetupRouter(nil, cfg, nil, nil)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		expectedCode  int
+	}{
+		{name: "export anonymous", method: "GET", path: "/customers/123/export", expectedCode: http.StatusUnauthorized},
+		{name: "export without permission", method: "GET", path: "/customers/123/export", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+		{name: "erase anonymous", method: "POST", path: "/customers/123/erase", expectedCode: http.StatusUnauthorized},
+		{name: "erase without permission", method: "POST", path: "/customers/123/erase", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+		{name: "admin schema without permission", method: "GET", path: "/admin/metadata-schema", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_EraseCustomer_MissingReason(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/customers/:id/erase", handler.EraseCustomer)
+
+	req, _ := http.NewRequest("POST", "/customers/123/erase", bytes.NewBufferString(`{}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
@@ -1,27 +1,87 @@
 package api
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
-	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/jobs"
 	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
 )
 
 // Handler contains dependencies for API handlers
 type Handler struct {
-	dbClient  *dynamodb.DynamoDB
-	tableName string
+	dbClient         *db.Client
+	tableName        string
+	settingsTable    string
+	tagsTable        string
+	historyTable     string
+	notesTable       string
+	notesPolicy      string
+	metadataMaxBytes int
+	batchMax         int
+	importMaxBytes   int
+	importWorkers    int
+	exportSegments   int
+	schemas          schemaCache
+
+	// jobs runs background jobs, nil when they are disabled
+	jobs              *jobs.Runner
+	jobsTable         string
+	jobDataTable      string
+	jobRetention      time.Duration
+	jobImportMaxBytes int
+
+	webhooksTable          string
+	webhookDeliveriesTable string
+	webhookAllowPrivate    bool
+
+	idempotencyTable string
+	idempotencyTTL   time.Duration
+
+	// changes broadcasts the customer changes read from the table's stream
+	// to event streams
+	changes      *pubsub.Broker
+	sseHeartbeat time.Duration
 }
 
 // NewHandler creates a new Handler
-func NewHandler(dbClient *dynamodb.DynamoDB, cfg *config.Config) *Handler {
+func NewHandler(dbClient *db.Client, cfg *config.Config) *Handler {
 	return &Handler{
-		dbClient:  dbClient,
-		tableName: cfg.TableName,
+		dbClient:         dbClient,
+		tableName:        cfg.TableName,
+		settingsTable:    cfg.SettingsTableName,
+		tagsTable:        cfg.TagsTableName,
+		historyTable:     cfg.HistoryTableName,
+		notesTable:       cfg.NotesTableName,
+		notesPolicy:      cfg.NotesDeletePolicy,
+		metadataMaxBytes: cfg.MetadataMaxBytes,
+		batchMax:         cfg.BatchMaxOperations,
+		importMaxBytes:   cfg.ImportMaxBytes,
+		importWorkers:    cfg.ImportConcurrency,
+		exportSegments:   cfg.ExportScanSegments,
+
+		jobsTable:         cfg.JobsTableName,
+		jobDataTable:      cfg.JobDataTableName,
+		jobRetention:      time.Duration(cfg.JobRetentionHours) * time.Hour,
+		jobImportMaxBytes: cfg.JobImportMaxBytes,
+
+		webhooksTable:          cfg.WebhooksTableName,
+		webhookDeliveriesTable: cfg.WebhookDeliveriesTableName,
+		webhookAllowPrivate:    cfg.WebhookAllowPrivateNetworks,
+
+		idempotencyTable: cfg.IdempotencyTableName,
+		idempotencyTTL:   time.Duration(cfg.IdempotencyTTLHours) * time.Hour,
+
+		changes:      pubsub.NewBroker(cfg.SSEReplaySize),
+		sseHeartbeat: time.Duration(max(cfg.SSEHeartbeatSeconds, 1)) * time.Second,
 	}
 }
 
@@ -33,38 +93,151 @@ func (h *Handler) CreateCustomer(c *gin.Context) {
 		return
 	}
 
-	// Generate unique ID if not provided
-	if customer.ID == "" {
-		customer.ID = uuid.New().String()
+	if !h.validateMetadata(c, customer.Metadata) {
+		return
+	}
+
+	// A retry taking over an expired Idempotency-Key creates the same customer
+	idempotent := customer.ID == "" && idempotentID(c) != ""
+	if idempotent {
+		customer.ID = idempotentID(c)
+	}
+	if err := prepareNewCustomer(&customer); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
 
 	// Save customer to DynamoDB
-	if err := db.PutCustomer(h.dbClient, h.tableName, &customer); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create customer"})
+	if err := db.CreateCustomer(c.Request.Context(), h.dbClient, h.tableName, &customer); err != nil {
+		if errors.Is(err, db.ErrCustomerExists) && idempotent {
+			// An earlier attempt with the same key and body created the customer
+			// but failed to respond, so respond with the customer it created
+			h.respondCreatedCustomer(c, customer.ID)
+			return
+		}
+		if errors.Is(err, db.ErrCustomerExists) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Customer already exists"})
+			return
+		}
+		respondServerError(c, err, "Failed to create customer")
 		return
 	}
 
-	c.JSON(http.StatusCreated, customer)
+	c.JSON(http.StatusCreated, presentCustomer(c, customer))
+}
+
+// respondCreatedCustomer responds with a customer created by an earlier attempt of the request
+func (h *Handler) respondCreatedCustomer(c *gin.Context, id string) {
+	customer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer")
+		return
+	}
+	c.JSON(http.StatusCreated, presentCustomer(c, *customer))
 }
 
 // GetAllCustomers handles GET /customers
 func (h *Handler) GetAllCustomers(c *gin.Context) {
-	customers, err := db.ListCustomers(h.dbClient, h.tableName)
+	if err := validateListParams(c); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Callers who can't see PII can't filter on it either, so listing can't be
+	// used to probe for an email address
+	if param, ok := piiFilter(c); ok && !hasPermission(c, PermissionPII) {
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("Filtering by %s requires the %s permission", param, PermissionPII)})
+		return
+	}
+
+	metadataFilter, err := parseMetadataFilter(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customers"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusOK, customers)
+	tags, err := parseTagFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	statuses, err := parseStatusFilter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	emailDomain, err := parseEmailDomain(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	createdAfter, err := parseCreatedAfter(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sortBy, sorted, err := parseSort(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	fields, err := parseFieldsParam(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	filter := db.CustomerFilter{
+		Email:        c.Query("email"),
+		Metadata:     metadataFilter,
+		Tags:         tags,
+		MatchAnyTag:  c.Query("tag_mode") == "any",
+		Statuses:     statuses,
+		NamePrefix:   c.Query("name_prefix"),
+		EmailDomain:  emailDomain,
+		CreatedAfter: createdAfter,
+		Fields:       fields,
+	}
+	// The sort field has to be read even when the fieldset leaves it out of the response
+	if sorted && len(fields) > 0 {
+		filter.Fields = append(append([]string{}, fields...), sortBy.Field)
+	}
+
+	customers, err := db.ListCustomers(c.Request.Context(), h.dbClient, h.tableName, filter)
+	if errors.Is(err, db.ErrEncryptedFilter) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		respondServerError(c, err, "Failed to get customers")
+		return
+	}
+
+	if sorted {
+		models.SortCustomers(customers, sortBy)
+	}
+
+	respondCustomers(c, http.StatusOK, customers, fields)
 }
 
 // GetCustomer handles GET /customers/:id
 func (h *Handler) GetCustomer(c *gin.Context) {
 	id := c.Param("id")
 
-	customer, err := db.GetCustomer(h.dbClient, h.tableName, id)
+	fields, err := parseFieldsParam(c)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get customer"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	customer, err := db.GetCustomerFields(c.Request.Context(), h.dbClient, h.tableName, id, fields)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer")
 		return
 	}
 
@@ -73,7 +246,7 @@ func (h *Handler) GetCustomer(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, customer)
+	respondCustomer(c, http.StatusOK, *customer, fields)
 }
 
 // UpdateCustomer handles PUT /customers/:id
@@ -81,9 +254,9 @@ func (h *Handler) UpdateCustomer(c *gin.Context) {
 	id := c.Param("id")
 
 	// Check if customer exists
-	existingCustomer, err := db.GetCustomer(h.dbClient, h.tableName, id)
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check customer"})
+		respondServerError(c, err, "Failed to check customer")
 		return
 	}
 
@@ -92,22 +265,36 @@ func (h *Handler) UpdateCustomer(c *gin.Context) {
 		return
 	}
 
+	if existingCustomer.IsErased() {
+		c.JSON(http.StatusGone, gin.H{"error": "Customer has been erased"})
+		return
+	}
+
+	if existingCustomer.IsMerged() {
+		c.JSON(http.StatusGone, gin.H{"error": "Customer was merged", "mergedInto": existingCustomer.MergedInto})
+		return
+	}
+
 	var customer models.Customer
 	if err := c.ShouldBindJSON(&customer); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	// Ensure ID in path matches ID in body
-	customer.ID = id
+	prepareUpdatedCustomer(&customer, existingCustomer)
 
-	// Update customer in DynamoDB
-	if err := db.PutCustomer(h.dbClient, h.tableName, &customer); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer"})
+	if !h.validateMetadata(c, customer.Metadata) {
 		return
 	}
 
-	c.JSON(http.StatusOK, customer)
+	// Update customer in DynamoDB, unless it changed since it was read
+	if err := db.PutCustomer(c.Request.Context(), h.dbClient, h.tableName, &customer); err != nil {
+		apiErr := writeError(err, "Failed to update customer")
+		respondError(c, apiErr)
+		return
+	}
+
+	c.JSON(http.StatusOK, presentCustomer(c, customer))
 }
 
 // DeleteCustomer handles DELETE /customers/:id
@@ -115,9 +302,9 @@ func (h *Handler) DeleteCustomer(c *gin.Context) {
 	id := c.Param("id")
 
 	// Check if customer exists
-	existingCustomer, err := db.GetCustomer(h.dbClient, h.tableName, id)
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to check customer"})
+		respondServerError(c, err, "Failed to check customer")
 		return
 	}
 
@@ -126,15 +313,94 @@ func (h *Handler) DeleteCustomer(c *gin.Context) {
 		return
 	}
 
-	// Delete customer from DynamoDB
-	if err := db.DeleteCustomer(h.dbClient, h.tableName, id); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete customer"})
+	if err := h.checkNotesPolicy(c.Request.Context(), id); err != nil {
+		respondError(c, err)
+		return
+	}
+
+	// Delete customer from DynamoDB, unless it changed since it was read
+	if err := db.DeleteCustomerWithTags(c.Request.Context(), h.dbClient, h.tableName, h.tagsTable, existingCustomer); err != nil {
+		apiErr := writeError(err, "Failed to delete customer")
+		respondError(c, apiErr)
 		return
 	}
 
+	if err := h.deleteNotes(c.Request.Context(), id); err != nil {
+		respondError(c, err)
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"message": "Customer deleted successfully"})
 }
 
+// checkNotesPolicy refuses the deletion of a customer that still has notes
+// under the "block" policy
+func (h *Handler) checkNotesPolicy(ctx context.Context, id string) *apiError {
+	if h.notesPolicy != "block" {
+		return nil
+	}
+
+	hasNotes, err := db.HasNotes(ctx, h.dbClient, h.notesTable, id)
+	if err != nil {
+		return serverError(err, "Failed to check customer notes")
+	}
+	if hasNotes {
+		return &apiError{status: http.StatusConflict, message: "Customer has notes, delete them first"}
+	}
+	return nil
+}
+
+// deleteNotes removes the notes of a deleted customer unless the "block"
+// policy kept the customer from being deleted with notes. It runs once the
+// customer is gone, so a failed delete leaves the notes in place.
+func (h *Handler) deleteNotes(ctx context.Context, id string) *apiError {
+	if h.notesPolicy == "block" {
+		return nil
+	}
+
+	if err := db.DeleteCustomerNotes(ctx, h.dbClient, h.notesTable, id); err != nil {
+		return serverError(err, "Customer deleted, but failed to delete its notes")
+	}
+	return nil
+}
+
+// prepareNewCustomer sets the fields the server manages on a customer being created
+func prepareNewCustomer(customer *models.Customer) error {
+	// Tags are managed through the tags sub-resource so their counts stay accurate
+	customer.Tags = nil
+	customer.ErasedAt = ""
+	customer.MergedInto = ""
+
+	// New customers start in the default status, the others are reached through transitions
+	if customer.Status == "" {
+		customer.Status = models.DefaultStatus
+	}
+	if customer.Status != models.DefaultStatus {
+		return fmt.Errorf("invalid status, new customers start as %s", models.DefaultStatus)
+	}
+
+	// Generate unique ID if not provided
+	if customer.ID == "" {
+		customer.ID = uuid.New().String()
+	}
+	customer.CreatedAt = time.Now().UTC().Format(time.RFC3339)
+	return nil
+}
+
+// prepareUpdatedCustomer carries the fields the server manages over from the
+// stored customer to its replacement
+func prepareUpdatedCustomer(customer *models.Customer, existing *models.Customer) {
+	// Ensure ID in path matches ID in body
+	customer.ID = existing.ID
+	customer.Tags = existing.Tags
+	// Status only changes through transitions
+	customer.Status = existing.Status
+	customer.ErasedAt = ""
+	customer.MergedInto = ""
+	customer.CreatedAt = existing.CreatedAt
+	customer.Version = existing.Version
+	keepMaskedPII(customer, existing)
+}
+
 // HealthCheck handles GET /health
 func (h *Handler) HealthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -254,3 +254,43 @@ func TestHandler_HealthCheck(t *testing.T) {
 	assert.Equal(t, "ok", response["status"])
 	assert.Equal(t, "customer-api", response["service"])
 }
+
+func TestPrepareNewCustomer_InitialStatusOnly(t *testing.T) {
+	customer := models.Customer{Name: "John Doe", Email: "john@example.com"}
+	require.NoError(t, prepareNewCustomer(&customer))
+	assert.Equal(t, models.DefaultStatus, customer.Status)
+
+	customer = models.Customer{Name: "John Doe", Email: "john@example.com", Status: models.StatusActive}
+	assert.Error(t, prepareNewCustomer(&customer), "later statuses are reached through transitions")
+}
+
+func TestHandler_UpdateCustomer_Changed(t *testing.T) {
+	var condition interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "GetItem" && body["TableName"] == "Customers" {
+			return map[string]interface{}{"Item": map[string]interface{}{
+				"id":      map[string]string{"S": "123"},
+				"name":    map[string]string{"S": "John"},
+				"version": map[string]string{"N": "2"},
+			}}
+		}
+		if operation != "PutItem" {
+			return map[string]interface{}{}
+		}
+		condition = body["ConditionExpression"]
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+			"Item":    map[string]interface{}{"id": map[string]string{"S": "123"}},
+		}
+	})
+	router := SetupRouter(client, &config.Config{TableName: "Customers", SettingsTableName: "Settings", MetadataMaxBytes: 1024}, nil, nil)
+
+	req, _ := http.NewRequest("PUT", "/customers/123", bytes.NewBufferString(`{"name":"Johnny","email":"john@example.com"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusConflict, w.Code, w.Body.String())
+	assert.Equal(t, "attribute_exists(#id) AND #version = :version", condition)
+}
@@ -0,0 +1,153 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// idempotencyKeyHeader lets clients retry a request without applying it twice
+	idempotencyKeyHeader = "Idempotency-Key"
+	// idempotencyReplayedHeader marks a response replayed for a retry
+	idempotencyReplayedHeader = "Idempotent-Replayed"
+	// maxIdempotencyKeyLength bounds the keys clients may send
+	maxIdempotencyKeyLength = 255
+	// maxIdempotentBodyBytes bounds the request bodies read to be hashed
+	maxIdempotentBodyBytes = 1 << 20
+	// idempotencyLockDuration is how long a request holds its key before
+	// another may take it over, in case its process died
+	idempotencyLockDuration = 30 * time.Second
+	// idempotentIDKey is the gin context key holding the ID derived from a request's key
+	idempotentIDKey = "idempotentID"
+)
+
+// Idempotent makes a route safe to retry with an Idempotency-Key header. The
+// first request with a key is processed and its response stored; a retry with
+// the same body gets that response again, a different body is rejected with
+// 422 and a retry arriving while the first is in flight gets 409. Responses
+// of 500 and above aren't stored, so the request can be retried. Requests
+// without the header are processed as usual. Keys are scoped to the caller's
+// token, so anonymous requests can't send one.
+func (h *Handler) Idempotent() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(idempotencyKeyHeader)
+		if key == "" {
+			c.Next()
+			return
+		}
+		if _, ok := c.Get(principalKey); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required to use Idempotency-Key"})
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Idempotency-Key must be at most 255 characters"})
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, maxIdempotentBodyBytes))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Request body exceeds %d bytes", tooLarge.Limit)})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "Failed to read request body"})
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		now := time.Now()
+		record := &models.IdempotencyRecord{
+			// Keys are scoped to the caller so clients can't read each other's responses
+			ID:          principalName(c) + " " + c.Request.Method + " " + c.FullPath() + " " + key,
+			RequestHash: hex.EncodeToString(hash[:]),
+			Status:      models.IdempotencyPending,
+			Owner:       uuid.New().String(),
+			LockedUntil: now.Add(idempotencyLockDuration).Unix(),
+			CreatedAt:   now.UTC().Format(time.RFC3339),
+			ExpiresAt:   now.Add(h.idempotencyTTL).Unix(),
+		}
+		existing, err := db.ClaimIdempotencyKey(c.Request.Context(), h.dbClient, h.idempotencyTable, record, now)
+		if err != nil {
+			c.Abort()
+			respondServerError(c, err, "Failed to check Idempotency-Key")
+			return
+		}
+		if existing != nil {
+			replayIdempotent(c, existing, record.RequestHash)
+			return
+		}
+
+		// A request taking over a key whose lock expired creates the same
+		// resource as the request it replaces, so it can't be created twice
+		c.Set(idempotentIDKey, uuid.NewSHA1(uuid.NameSpaceURL, []byte("idempotency/"+record.ID)).String())
+
+		writer := &responseRecorder{ResponseWriter: c.Writer}
+		c.Writer = writer
+		c.Next()
+
+		if writer.Status() >= http.StatusInternalServerError {
+			if err := db.ReleaseIdempotencyKey(c.Request.Context(), h.dbClient, h.idempotencyTable, record); err != nil {
+				log.Printf("Failed to release idempotency key: %v", err)
+			}
+			return
+		}
+		record.ResponseStatus = writer.Status()
+		record.ResponseContentType = writer.Header().Get("Content-Type")
+		record.ResponseBody = writer.body.String()
+		if err := db.CompleteIdempotencyKey(c.Request.Context(), h.dbClient, h.idempotencyTable, record); err != nil && !errors.Is(err, db.ErrIdempotencyKeyLost) {
+			// A retry would be processed again, which the client can't tell apart
+			log.Printf("Failed to store idempotent response: %v", err)
+		}
+	}
+}
+
+// idempotentID returns the ID of the resource an idempotent request creates,
+// or "" when the request has no Idempotency-Key
+func idempotentID(c *gin.Context) string {
+	return c.GetString(idempotentIDKey)
+}
+
+// replayIdempotent answers a request whose key is already held
+func replayIdempotent(c *gin.Context, existing *models.IdempotencyRecord, requestHash string) {
+	switch {
+	case existing.RequestHash != requestHash:
+		c.AbortWithStatusJSON(http.StatusUnprocessableEntity, gin.H{"error": "Idempotency-Key was already used with a different request"})
+	case existing.Status != models.IdempotencyComplete:
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": "A request with this Idempotency-Key is in progress"})
+	default:
+		c.Header(idempotencyReplayedHeader, "true")
+		c.Data(existing.ResponseStatus, existing.ResponseContentType, []byte(existing.ResponseBody))
+		c.Abort()
+	}
+}
+
+// responseRecorder keeps a copy of the response body written through it
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseRecorder) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *responseRecorder) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
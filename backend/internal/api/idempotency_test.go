@@ -0,0 +1,352 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKeyTable serves an idempotency keys table, applying the claim, owner
+// and not-exists conditions the way DynamoDB would
+type fakeKeyTable struct {
+	mu    sync.Mutex
+	items map[string]map[string]interface{}
+}
+
+func attributeString(item map[string]interface{}, name string) string {
+	value, _ := item[name].(map[string]interface{})
+	if s, ok := value["S"].(string); ok {
+		return s
+	}
+	n, _ := value["N"].(string)
+	return n
+}
+
+func (f *fakeKeyTable) client(t *testing.T) *db.Client {
+	conditionFailed := map[string]interface{}{
+		"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+		"message": "The conditional request failed",
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var response interface{} = map[string]interface{}{}
+		switch operation {
+		case "PutItem":
+			item := body["Item"].(map[string]interface{})
+			id := attributeString(item, "id")
+			existing := f.items[id]
+			values, _ := body["ExpressionAttributeValues"].(map[string]interface{})
+			if strings.Contains(body["ConditionExpression"].(string), "attribute_not_exists") {
+				now, _ := strconv.ParseInt(attributeString(values, ":now"), 10, 64)
+				expires, _ := strconv.ParseInt(attributeString(existing, "expiresAt"), 10, 64)
+				locked, _ := strconv.ParseInt(attributeString(existing, "lockedUntil"), 10, 64)
+				if existing != nil && expires >= now && (attributeString(existing, "status") != "pending" || locked >= now) {
+					response = conditionFailed
+					break
+				}
+			} else if attributeString(existing, "owner") != attributeString(values, ":owner") {
+				response = conditionFailed
+				break
+			}
+			f.items[id] = item
+		case "GetItem":
+			if item := f.items[attributeString(body["Key"].(map[string]interface{}), "id")]; item != nil {
+				response = map[string]interface{}{"Item": item}
+			}
+		case "DeleteItem":
+			id := attributeString(body["Key"].(map[string]interface{}), "id")
+			values := body["ExpressionAttributeValues"].(map[string]interface{})
+			if attributeString(f.items[id], "owner") != attributeString(values, ":owner") {
+				response = conditionFailed
+				break
+			}
+			delete(f.items, id)
+		}
+
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if fault, ok := response.(map[string]interface{}); ok && fault["__type"] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// idempotencyTokens authenticates the callers of the idempotency tests
+var idempotencyTokens = map[string]config.APIToken{
+	"alice-token": {Principal: "alice"},
+	"bob-token":   {Principal: "bob"},
+}
+
+// idempotentRouter serves POST /customers through the idempotency middleware
+// with a handler answering with the given status and counting its calls
+func idempotentRouter(t *testing.T, status *int, calls *int, release <-chan struct{}) *gin.Engine {
+	handler, router := setupTestHandler()
+	handler.dbClient = (&fakeKeyTable{items: map[string]map[string]interface{}{}}).client(t)
+	handler.idempotencyTable = "Keys"
+	handler.idempotencyTTL = time.Hour
+	router.Use(AuthMiddleware(idempotencyTokens))
+
+	var mu sync.Mutex
+	router.POST("/customers", handler.Idempotent(), func(c *gin.Context) {
+		mu.Lock()
+		*calls++
+		n := *calls
+		mu.Unlock()
+		if release != nil {
+			<-release
+		}
+		c.JSON(*status, gin.H{"id": "c" + strconv.Itoa(n)})
+	})
+	return router
+}
+
+func postIdempotent(router *gin.Engine, key, body string) *httptest.ResponseRecorder {
+	return postIdempotentAs(router, "alice-token", key, body)
+}
+
+// postIdempotentAs posts a customer with the given token, anonymously when it is empty
+func postIdempotentAs(router *gin.Engine, token, key, body string) *httptest.ResponseRecorder {
+	req, _ := http.NewRequest("POST", "/customers", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestIdempotent_ReplaysResponse(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	first := postIdempotent(router, "k1", `{"name":"John"}`)
+	retry := postIdempotent(router, "k1", `{"name":"John"}`)
+
+	assert.Equal(t, 1, calls)
+	assert.Equal(t, http.StatusCreated, retry.Code)
+	assert.JSONEq(t, first.Body.String(), retry.Body.String())
+	assert.Equal(t, "application/json; charset=utf-8", retry.Header().Get("Content-Type"))
+	assert.Equal(t, "true", retry.Header().Get("Idempotent-Replayed"))
+	assert.Empty(t, first.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotent_DifferentBody(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	postIdempotent(router, "k1", `{"name":"John"}`)
+	w := postIdempotent(router, "k1", `{"name":"Jane"}`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotent_WithoutKey(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	postIdempotent(router, "", `{"name":"John"}`)
+	postIdempotent(router, "", `{"name":"John"}`)
+
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotent_ServerErrorIsRetried(t *testing.T) {
+	status, calls := http.StatusInternalServerError, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	assert.Equal(t, http.StatusInternalServerError, postIdempotent(router, "k1", `{"name":"John"}`).Code)
+	status = http.StatusCreated
+	w := postIdempotent(router, "k1", `{"name":"John"}`)
+
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, 2, calls)
+}
+
+func TestIdempotent_ConcurrentDuplicate(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	release := make(chan struct{})
+	router := idempotentRouter(t, &status, &calls, release)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- postIdempotent(router, "k1", `{"name":"John"}`) }()
+
+	// Wait for the first request to hold the key
+	var w *httptest.ResponseRecorder
+	require.Eventually(t, func() bool {
+		w = postIdempotent(router, "k1", `{"name":"John"}`)
+		return w.Code == http.StatusConflict
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "1", w.Header().Get("Retry-After"))
+
+	close(release)
+	assert.Equal(t, http.StatusCreated, (<-done).Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotent_KeyTooLong(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	w := postIdempotent(router, strings.Repeat("k", maxIdempotencyKeyLength+1), `{}`)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Equal(t, 0, calls)
+}
+
+func TestIdempotent_RequiresAuthentication(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	w := postIdempotentAs(router, "", "k1", `{"name":"John"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, 0, calls)
+
+	// Anonymous requests without a key are processed as usual
+	assert.Equal(t, http.StatusCreated, postIdempotentAs(router, "", "", `{"name":"John"}`).Code)
+	assert.Equal(t, 1, calls)
+}
+
+func TestIdempotent_KeysScopedToCaller(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	alice := postIdempotentAs(router, "alice-token", "k1", `{"name":"John"}`)
+	bob := postIdempotentAs(router, "bob-token", "k1", `{"name":"John"}`)
+
+	assert.Equal(t, 2, calls)
+	assert.NotEqual(t, alice.Body.String(), bob.Body.String())
+	assert.Empty(t, bob.Header().Get("Idempotent-Replayed"))
+}
+
+func TestIdempotent_TakeoverGetsSameID(t *testing.T) {
+	keys := &fakeKeyTable{items: map[string]map[string]interface{}{}}
+	handler, router := setupTestHandler()
+	handler.dbClient = keys.client(t)
+	handler.idempotencyTable = "Keys"
+	handler.idempotencyTTL = time.Hour
+	router.Use(AuthMiddleware(idempotencyTokens))
+
+	var ids []string
+	router.POST("/customers", handler.Idempotent(), func(c *gin.Context) {
+		ids = append(ids, idempotentID(c))
+		if len(ids) == 1 {
+			// The first request stalls past its lock and a retry takes the key over
+			keys.mu.Lock()
+			for _, item := range keys.items {
+				item["lockedUntil"] = map[string]string{"N": "0"}
+			}
+			keys.mu.Unlock()
+			assert.Equal(t, http.StatusCreated, postIdempotent(router, "k1", `{"name":"John"}`).Code)
+		}
+		c.JSON(http.StatusCreated, gin.H{})
+	})
+
+	postIdempotent(router, "k1", `{"name":"John"}`)
+	postIdempotentAs(router, "bob-token", "k1", `{"name":"John"}`)
+	postIdempotent(router, "", `{"name":"John"}`)
+
+	require.Len(t, ids, 4)
+	assert.NotEmpty(t, ids[0])
+	assert.Equal(t, ids[0], ids[1])
+	assert.NotEqual(t, ids[0], ids[2], "keys of other callers get other IDs")
+	assert.Empty(t, ids[3])
+}
+
+func TestCreateCustomer_IdempotentRetryAfterCommittedFailure(t *testing.T) {
+	customers := map[string]interface{}{}
+	failWrite := true
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if body["TableName"] != "TestCustomers" {
+			// Every claim of the key succeeds, as if the failed attempt released it
+			return map[string]interface{}{}
+		}
+		switch operation {
+		case "PutItem":
+			item := body["Item"].(map[string]interface{})
+			id := attributeString(item, "id")
+			if customers[id] != nil {
+				return map[string]interface{}{
+					"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+					"message": "The conditional request failed",
+					"Item":    customers[id],
+				}
+			}
+			customers[id] = item
+			if failWrite {
+				// The write is applied but the response is lost
+				failWrite = false
+				return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": "boom"}
+			}
+		case "GetItem":
+			return map[string]interface{}{"Item": customers[attributeString(body["Key"].(map[string]interface{}), "id")]}
+		}
+		return map[string]interface{}{}
+	})
+	router := SetupRouter(client, &config.Config{
+		TableName:            "TestCustomers",
+		IdempotencyTableName: "Keys",
+		IdempotencyTTLHours:  1,
+		MetadataMaxBytes:     1024,
+		APITokens:            idempotencyTokens,
+	}, nil, nil)
+
+	first := postIdempotent(router, "k1", `{"name":"John","email":"john@example.com"}`)
+	retry := postIdempotent(router, "k1", `{"name":"John","email":"john@example.com"}`)
+
+	assert.Equal(t, http.StatusInternalServerError, first.Code)
+	require.Equal(t, http.StatusCreated, retry.Code)
+	assert.Len(t, customers, 1)
+	var created map[string]interface{}
+	require.NoError(t, json.Unmarshal(retry.Body.Bytes(), &created))
+	assert.Contains(t, customers, created["id"])
+	assert.Equal(t, "John", created["name"])
+
+	// Without a key, a customer that already exists is still a conflict
+	id := created["id"].(string)
+	w := postIdempotent(router, "", `{"id":"`+id+`","name":"John","email":"john@example.com"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+}
+
+func TestIdempotent_BodyTooLarge(t *testing.T) {
+	status, calls := http.StatusCreated, 0
+	router := idempotentRouter(t, &status, &calls, nil)
+
+	w := postIdempotent(router, "k1", `{"name":"`+strings.Repeat("x", maxIdempotentBodyBytes)+`"}`)
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	assert.Equal(t, 0, calls)
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// importChunkSize is the number of customers written per batch during an import
+const importChunkSize = 25
+
+// importRow is a CSV row that passed validation and is waiting to be written
+type importRow struct {
+	row      int
+	customer *models.Customer
+}
+
+// ImportCustomers handles POST /customers/import. The CSV file is sent as the
+// request body or as the "file" field of a multipart form. With dryRun=true
+// rows are validated but not written. With report=csv the rejected rows are
+// returned as a CSV attachment instead of a JSON report.
+func (h *Handler) ImportCustomers(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dryRun", "false"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "dryRun must be true or false"})
+		return
+	}
+	reportFormat := c.DefaultQuery("report", "json")
+	if reportFormat != "json" && reportFormat != "csv" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "report must be 'json' or 'csv'"})
+		return
+	}
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.importMaxBytes))
+	body, err := importBody(c)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	reader := csv.NewReader(body)
+	reader.TrimLeadingSpace = true
+	headerRecord, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("CSV file is empty")
+		}
+		respondImportError(c, err)
+		return
+	}
+	header, err := models.ParseCSVHeader(headerRecord, c.QueryMap("map"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reader.FieldsPerRecord = len(headerRecord)
+
+	report := models.ImportReport{DryRun: dryRun, Errors: []models.ImportRowError{}}
+	reject := func(row int, email string, reason string) {
+		report.Errors = append(report.Errors, models.ImportRowError{Row: row, Email: email, Error: reason})
+	}
+
+	// Validate every row before writing, so a dry run reports exactly what an import would do
+	validator := h.newImportValidator(c.Request.Context(), header)
+	var rows []importRow
+	for row := 2; ; row++ {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			report.Rows++
+			reject(row, "", parseErr.Err.Error())
+			continue
+		}
+		if err != nil {
+			respondImportError(c, err)
+			return
+		}
+		report.Rows++
+
+		customer, rowErr := validator.validate(row, record)
+		if rowErr != nil {
+			report.Errors = append(report.Errors, *rowErr)
+			continue
+		}
+		rows = append(rows, importRow{row: row, customer: customer})
+	}
+
+	rejectRow := func(row importRow, reason string) { reject(row.row, row.customer.Email, reason) }
+	pending := h.withoutExistingEmails(c.Request.Context(), rows, rejectRow)
+	if !dryRun {
+		h.writeImportRows(c.Request.Context(), pending, rejectRow)
+	}
+
+	sort.Slice(report.Errors, func(i, j int) bool { return report.Errors[i].Row < report.Errors[j].Row })
+	report.Failed = len(report.Errors)
+	report.Imported = report.Rows - report.Failed
+
+	if reportFormat == "csv" {
+		writeImportErrorReport(c, report.Errors)
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
+
+// importValidator checks CSV rows the way POST /customers checks a customer,
+// also rejecting emails repeated earlier in the file
+type importValidator struct {
+	header           models.CSVHeader
+	validateMetadata func(map[string]interface{}) *apiError
+	firstRowByEmail  map[string]int
+}
+
+func (h *Handler) newImportValidator(ctx context.Context, header models.CSVHeader) *importValidator {
+	return &importValidator{
+		header:           header,
+		validateMetadata: h.metadataValidator(ctx),
+		firstRowByEmail:  map[string]int{},
+	}
+}
+
+// validate returns the customer a row creates, or the error rejecting it.
+// Rows must be validated in file order for duplicates to be detected.
+func (v *importValidator) validate(row int, record []string) (*models.Customer, *models.ImportRowError) {
+	customer := v.header.Customer(record)
+	reject := func(reason string) (*models.Customer, *models.ImportRowError) {
+		return nil, &models.ImportRowError{Row: row, Email: customer.Email, Error: reason}
+	}
+
+	if err := binding.Validator.ValidateStruct(&customer); err != nil {
+		return reject(err.Error())
+	}
+	email := strings.ToLower(customer.Email)
+	if first, ok := v.firstRowByEmail[email]; ok {
+		return reject(fmt.Sprintf("duplicate of row %d", first))
+	}
+	v.firstRowByEmail[email] = row
+
+	if err := v.validateMetadata(customer.Metadata); err != nil {
+		return reject(err.message)
+	}
+	if err := prepareNewCustomer(&customer); err != nil {
+		return reject(err.Error())
+	}
+	return &customer, nil
+}
+
+// withoutExistingEmails rejects the rows whose email already belongs to a
+// customer and returns the others. reject is never called concurrently.
+func (h *Handler) withoutExistingEmails(ctx context.Context, rows []importRow, reject func(row importRow, reason string)) []importRow {
+	var mu sync.Mutex
+	exists := make([]bool, len(rows))
+	parallel(len(rows), h.importWorkers, func(i int) {
+		found, err := db.CustomerEmailExists(ctx, h.dbClient, h.tableName, rows[i].customer.Email)
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			reject(rows[i], "failed to check for an existing customer")
+			exists[i] = true
+			return
+		}
+		if found {
+			reject(rows[i], "a customer with this email already exists")
+			exists[i] = true
+		}
+	})
+
+	var pending []importRow
+	for i, row := range rows {
+		if !exists[i] {
+			pending = append(pending, row)
+		}
+	}
+	return pending
+}
+
+// writeImportRows writes the customers of rows in parallel batches, rejecting
+// the rows that couldn't be written. reject is never called concurrently.
+func (h *Handler) writeImportRows(ctx context.Context, rows []importRow, reject func(row importRow, reason string)) {
+	var mu sync.Mutex
+	chunks := (len(rows) + importChunkSize - 1) / importChunkSize
+	parallel(chunks, h.importWorkers, func(chunk int) {
+		end := min((chunk+1)*importChunkSize, len(rows))
+		batch := rows[chunk*importChunkSize : end]
+
+		customers := make([]*models.Customer, len(batch))
+		for i, row := range batch {
+			customers[i] = row.customer
+		}
+		failed := db.WriteCustomers(ctx, h.dbClient, h.tableName, customers, nil, nil)
+
+		mu.Lock()
+		defer mu.Unlock()
+		for _, row := range batch {
+			if failed[row.customer.ID] != nil {
+				reject(row, "failed to write customer")
+			}
+		}
+	})
+}
+
+// importBody returns the CSV file of an import request, streaming it from
+// the "file" part of a multipart form or from the raw body
+func importBody(c *gin.Context) (io.Reader, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return c.Request.Body, nil
+	}
+
+	form, err := c.Request.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := form.NextPart()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil, errors.New("multipart form has no file field")
+			}
+			return nil, err
+		}
+		if part.FormName() == "file" {
+			return part, nil
+		}
+	}
+}
+
+// respondImportError reports a failure to read the uploaded file
+func respondImportError(c *gin.Context, err error) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("CSV file exceeds %d bytes", tooLarge.Limit)})
+		return
+	}
+	c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+}
+
+// writeImportErrorReport sends the rejected rows of an import as a CSV attachment
+func writeImportErrorReport(c *gin.Context, rowErrors []models.ImportRowError) {
+	c.Header("Content-Disposition", `attachment; filename="import-errors.csv"`)
+	c.Header("Content-Type", "text/csv")
+	c.Status(http.StatusOK)
+
+	writer := csv.NewWriter(c.Writer)
+	writer.Write([]string{"row", "email", "error"})
+	for _, rowErr := range rowErrors {
+		writer.Write([]string{strconv.Itoa(rowErr.Row), rowErr.Email, rowErr.Error})
+	}
+	writer.Flush()
+}
+
+// parallel calls fn for every index below n, running at most workers calls at once
+func parallel(n, workers int, fn func(i int)) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < min(workers, n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				fn(i)
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+}
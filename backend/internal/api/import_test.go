@@ -0,0 +1,118 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func importRouterConfig() *config.Config {
+	return &config.Config{TableName: "TestCustomers", ImportMaxBytes: 1024, ImportConcurrency: 2}
+}
+
+func TestImportCustomers_RequestErrors(t *testing.T) {
+	router := SetupRouter(nil, importRouterConfig(), nil, nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected int
+	}{
+		{name: "invalid dryRun", path: "/customers/import?dryRun=maybe", body: "name,email\n", expected: http.StatusBadRequest},
+		{name: "invalid report", path: "/customers/import?report=xml", body: "name,email\n", expected: http.StatusBadRequest},
+		{name: "empty file", path: "/customers/import", body: "", expected: http.StatusBadRequest},
+		{name: "unknown column", path: "/customers/import", body: "name,email,age\n", expected: http.StatusBadRequest},
+		{name: "too large", path: "/customers/import", body: "name,email\n" + strings.Repeat("x", 2048), expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/csv")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
+
+// rejectedRows fail before the import reads metadata settings or customers
+const rejectedRows = "name,email\n" +
+	"John Doe,not-an-email\n" +
+	",jane@example.com\n" +
+	"Bob,bob@example.com,extra\n"
+
+func TestImportCustomers_RowErrors(t *testing.T) {
+	router := SetupRouter(nil, importRouterConfig(), nil, nil)
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	file, _ := form.CreateFormFile("file", "customers.csv")
+	file.Write([]byte(rejectedRows))
+	form.Close()
+
+	req, _ := http.NewRequest("POST", "/customers/import?dryRun=true", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	var report models.ImportReport
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &report))
+	assert.True(t, report.DryRun)
+	assert.Equal(t, 3, report.Rows)
+	assert.Equal(t, 0, report.Imported)
+	assert.Equal(t, 3, report.Failed)
+
+	var rows []int
+	for _, rowErr := range report.Errors {
+		rows = append(rows, rowErr.Row)
+	}
+	assert.Equal(t, []int{2, 3, 4}, rows)
+}
+
+func TestImportCustomers_CSVReport(t *testing.T) {
+	router := SetupRouter(nil, importRouterConfig(), nil, nil)
+
+	req, _ := http.NewRequest("POST", "/customers/import?dryRun=true&report=csv", strings.NewReader(rejectedRows))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Header().Get("Content-Disposition"), "import-errors.csv")
+	lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+	assert.Equal(t, "row,email,error", lines[0])
+	assert.Len(t, lines, 4)
+	assert.True(t, strings.HasPrefix(lines[1], "2,not-an-email,"))
+}
+
+func TestParallel(t *testing.T) {
+	var calls, running, peak int32
+	parallel(20, 3, func(i int) {
+		current := atomic.AddInt32(&running, 1)
+		for {
+			seen := atomic.LoadInt32(&peak)
+			if current <= seen || atomic.CompareAndSwapInt32(&peak, seen, current) {
+				break
+			}
+		}
+		atomic.AddInt32(&calls, 1)
+		atomic.AddInt32(&running, -1)
+	})
+
+	assert.Equal(t, int32(20), calls)
+	assert.LessOrEqual(t, peak, int32(3))
+}
@@ -0,0 +1,470 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/jobs"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// importJobCheckpointRows is the number of rows an import job processes between checkpoints
+	importJobCheckpointRows = 500
+	// exportJobPageSize is the number of customers an export job reads per scan page
+	exportJobPageSize = 500
+	// exportJobChunkBytes is the size at which an export job stores a result
+	// chunk. It leaves room for the customer that crosses it.
+	exportJobChunkBytes = db.MaxJobChunkBytes / 2
+	// jobMappingParam prefixes the column mapping of an import job in its params
+	jobMappingParam = "map."
+)
+
+// registerJobExecutors sets the executors of the job types handled by the API
+func (h *Handler) registerJobExecutors(runner *jobs.Runner) {
+	h.jobs = runner
+	runner.Register(models.JobImport, h.runImportJob)
+	runner.Register(models.JobExport, h.runExportJob)
+}
+
+// CreateImportJob handles POST /jobs/imports. The CSV file is accepted like
+// POST /customers/import, stored with the job and imported in the background.
+func (h *Handler) CreateImportJob(c *gin.Context) {
+	if !h.jobsEnabled(c) {
+		return
+	}
+
+	params := map[string]string{}
+	for header, column := range c.QueryMap("map") {
+		params[jobMappingParam+header] = column
+	}
+	job := h.newJob(c, models.JobImport, params)
+
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.jobImportMaxBytes))
+	body, err := importBody(c)
+	if err != nil {
+		respondImportError(c, err)
+		return
+	}
+
+	// Store the file as it is read, counting its rows for progress reporting.
+	// Chunks of a rejected upload are left to expire.
+	input := &jobChunkWriter{ctx: c.Request.Context(), handler: h, job: job}
+	reader := csv.NewReader(io.TeeReader(body, input))
+	reader.TrimLeadingSpace = true
+	headerRecord, err := reader.Read()
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = errors.New("CSV file is empty")
+		}
+		respondJobInputError(c, err)
+		return
+	}
+	if _, err := models.ParseCSVHeader(headerRecord, c.QueryMap("map")); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	reader.FieldsPerRecord = len(headerRecord)
+
+	for {
+		_, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			respondJobInputError(c, err)
+			return
+		}
+		job.Total++
+	}
+	if err := input.Close(); err != nil {
+		respondJobInputError(c, err)
+		return
+	}
+	job.InputChunks = input.chunks
+
+	h.startJob(c, job)
+}
+
+// CreateExportJob handles POST /jobs/exports. The export is produced in the
+// background like GET /customers/export and downloaded from the job's result.
+func (h *Handler) CreateExportJob(c *gin.Context) {
+	if !h.jobsEnabled(c) {
+		return
+	}
+
+	format := c.DefaultQuery("format", "csv")
+	if format != "csv" && format != "ndjson" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be 'csv' or 'ndjson'"})
+		return
+	}
+
+	// The job runs without the caller, so record whether it may see PII
+	params := map[string]string{
+		"format": format,
+		"pii":    strconv.FormatBool(hasPermission(c, PermissionPII)),
+	}
+	h.startJob(c, h.newJob(c, models.JobExport, params))
+}
+
+// GetJob handles GET /jobs/:id
+func (h *Handler) GetJob(c *gin.Context) {
+	job, ok := h.findJob(c)
+	if !ok {
+		return
+	}
+
+	job.ComputePercent()
+	c.JSON(http.StatusOK, job)
+}
+
+// GetJobResult handles GET /jobs/:id/result. Imports return their rejected
+// rows as a CSV file and exports return the exported customers.
+func (h *Handler) GetJobResult(c *gin.Context) {
+	job, ok := h.findJob(c)
+	if !ok {
+		return
+	}
+	if !job.IsFinished() {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job has not finished"})
+		return
+	}
+
+	if job.Type == models.JobImport {
+		writeImportErrorReport(c, job.Errors)
+		return
+	}
+	if job.Status != models.JobSucceeded {
+		c.JSON(http.StatusConflict, gin.H{"error": "Job failed", "details": job.Error})
+		return
+	}
+
+	format := job.Params["format"]
+	if format == "csv" {
+		c.Header("Content-Type", "text/csv")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="customers.%s"`, format))
+	c.Status(http.StatusOK)
+
+	result := db.NewJobChunkReader(c.Request.Context(), h.dbClient, h.jobDataTable, job.ID, db.JobResult, job.ResultChunks)
+	if _, err := io.Copy(c.Writer, result); err != nil {
+		log.Printf("Failed to send result of job %s: %v", job.ID, err)
+	}
+}
+
+// jobsEnabled rejects the request when no job runner is configured
+func (h *Handler) jobsEnabled(c *gin.Context) bool {
+	if h.jobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Background jobs are not enabled"})
+		return false
+	}
+	return true
+}
+
+// newJob returns a queued job owned by the caller
+func (h *Handler) newJob(c *gin.Context, jobType string, params map[string]string) *models.Job {
+	now := time.Now().UTC()
+	return &models.Job{
+		ID:        uuid.New().String(),
+		Type:      jobType,
+		Status:    models.JobQueued,
+		Owner:     principalName(c),
+		Params:    params,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiresAt: now.Add(h.jobRetention).Unix(),
+	}
+}
+
+// startJob stores a new job, wakes the runner and responds with the job
+func (h *Handler) startJob(c *gin.Context, job *models.Job) {
+	if err := db.CreateJob(c.Request.Context(), h.dbClient, h.jobsTable, job); err != nil {
+		respondServerError(c, err, "Failed to create job")
+		return
+	}
+	h.jobs.Notify()
+
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// findJob loads the job in the path. Jobs of other callers are reported as
+// missing unless the caller is an admin.
+func (h *Handler) findJob(c *gin.Context) (*models.Job, bool) {
+	job, err := db.GetJob(c.Request.Context(), h.dbClient, h.jobsTable, c.Param("id"))
+	if errors.Is(err, db.ErrJobNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, false
+	}
+	if err != nil {
+		respondServerError(c, err, "Failed to get job")
+		return nil, false
+	}
+	if job.Owner != principalName(c) && !hasPermission(c, PermissionAdmin) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Job not found"})
+		return nil, false
+	}
+	return job, true
+}
+
+// errJobInput marks a failure to store an uploaded file, as opposed to reading it
+var errJobInput = errors.New("failed to store job input")
+
+// respondJobInputError reports a failure to read or store the file of an import job
+func respondJobInputError(c *gin.Context, err error) {
+	if errors.Is(err, errJobInput) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store CSV file"})
+		return
+	}
+	respondImportError(c, err)
+}
+
+// jobChunkWriter stores the data written to it as the input chunks of a job
+type jobChunkWriter struct {
+	ctx     context.Context
+	handler *Handler
+	job     *models.Job
+	buffer  bytes.Buffer
+	chunks  int
+}
+
+func (w *jobChunkWriter) Write(p []byte) (int, error) {
+	w.buffer.Write(p)
+	for w.buffer.Len() >= db.MaxJobChunkBytes {
+		if err := w.store(w.buffer.Next(db.MaxJobChunkBytes)); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// Close stores the data left in the buffer
+func (w *jobChunkWriter) Close() error {
+	if w.buffer.Len() == 0 {
+		return nil
+	}
+	return w.store(w.buffer.Next(w.buffer.Len()))
+}
+
+func (w *jobChunkWriter) store(data []byte) error {
+	if err := db.PutJobChunk(w.ctx, w.handler.dbClient, w.handler.jobDataTable, w.job, db.JobInput, w.chunks, data); err != nil {
+		log.Printf("Failed to store input of job %s: %v", w.job.ID, err)
+		return errJobInput
+	}
+	w.chunks++
+	return nil
+}
+
+// runImportJob imports the CSV file stored with an import job. The checkpoint
+// is the last row processed. Customers get IDs derived from the job and row,
+// so rows written just before an interruption are recognised on resume.
+func (h *Handler) runImportJob(ctx context.Context, task *jobs.Task) error {
+	job := task.Job
+	reader := csv.NewReader(task.Input())
+	reader.TrimLeadingSpace = true
+	headerRecord, err := reader.Read()
+	if err != nil {
+		return fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	mapping := map[string]string{}
+	for param, column := range job.Params {
+		if header, ok := strings.CutPrefix(param, jobMappingParam); ok {
+			mapping[header] = column
+		}
+	}
+	header, err := models.ParseCSVHeader(headerRecord, mapping)
+	if err != nil {
+		return err
+	}
+	reader.FieldsPerRecord = len(headerRecord)
+
+	checkpoint, _ := strconv.Atoi(job.Checkpoint)
+	validator := h.newImportValidator(ctx, header)
+	reject := func(row importRow, reason string) {
+		job.AddError(models.ImportRowError{Row: row.row, Email: row.customer.Email, Error: reason})
+	}
+
+	var rows []importRow
+	processed := 0
+	firstCheckpoint := true
+	save := func(lastRow int) error {
+		pending := rows
+		if firstCheckpoint {
+			// Rows after the checkpoint may have been written before the job was interrupted
+			var err error
+			if pending, err = h.withoutImportedRows(ctx, pending); err != nil {
+				return err
+			}
+			firstCheckpoint = false
+		}
+		pending = h.withoutExistingEmails(ctx, pending, reject)
+		h.writeImportRows(ctx, pending, reject)
+
+		sort.SliceStable(job.Errors, func(i, j int) bool { return job.Errors[i].Row < job.Errors[j].Row })
+		job.Processed += processed
+		job.Checkpoint = strconv.Itoa(lastRow)
+		rows, processed = nil, 0
+		return task.Save()
+	}
+
+	lastRow := checkpoint
+	for row := 2; ; row++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		var parseErr *csv.ParseError
+		if err != nil && !errors.As(err, &parseErr) {
+			return fmt.Errorf("failed to read CSV file: %v", err)
+		}
+
+		// Rows before the checkpoint are only validated again, to remember their emails
+		var customer *models.Customer
+		var rowErr *models.ImportRowError
+		if parseErr == nil {
+			customer, rowErr = validator.validate(row, record)
+		}
+		if row <= checkpoint {
+			continue
+		}
+
+		lastRow = row
+		processed++
+		switch {
+		case parseErr != nil:
+			job.AddError(models.ImportRowError{Row: row, Error: parseErr.Err.Error()})
+		case rowErr != nil:
+			job.AddError(*rowErr)
+		default:
+			customer.ID = uuid.NewSHA1(uuid.NameSpaceURL, []byte(fmt.Sprintf("jobs/%s/rows/%d", job.ID, row))).String()
+			rows = append(rows, importRow{row: row, customer: customer})
+		}
+
+		if processed == importJobCheckpointRows {
+			if err := save(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	if processed > 0 {
+		return save(lastRow)
+	}
+	return nil
+}
+
+// withoutImportedRows drops the rows whose customer already exists
+func (h *Handler) withoutImportedRows(ctx context.Context, rows []importRow) ([]importRow, error) {
+	ids := make([]string, len(rows))
+	for i, row := range rows {
+		ids[i] = row.customer.ID
+	}
+	existing, err := db.GetCustomers(ctx, h.dbClient, h.tableName, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []importRow
+	for _, row := range rows {
+		if existing[row.customer.ID] == nil {
+			pending = append(pending, row)
+		}
+	}
+	return pending, nil
+}
+
+// runExportJob exports every customer into the job's result chunks. The
+// checkpoint is the ID of the last customer in a stored chunk, where a
+// resumed export continues the scan.
+func (h *Handler) runExportJob(ctx context.Context, task *jobs.Task) error {
+	job := task.Job
+	if job.Total == 0 {
+		count, err := db.ApproximateCustomerCount(ctx, h.dbClient, h.tableName)
+		if err != nil {
+			return err
+		}
+		job.Total = count
+	}
+
+	var buffer bytes.Buffer
+	var encoder customerEncoder = newNDJSONEncoder(&buffer)
+	if job.Params["format"] == "csv" {
+		csvEncoder, err := newCSVEncoder(&buffer, job.ResultChunks == 0)
+		if err != nil {
+			return err
+		}
+		encoder = csvEncoder
+	}
+
+	cursor := job.Checkpoint
+	encoded := 0
+	store := func() error {
+		if err := encoder.Flush(); err != nil {
+			return err
+		}
+		if buffer.Len() > 0 {
+			if err := task.WriteResult(buffer.Bytes()); err != nil {
+				return err
+			}
+			buffer.Reset()
+		}
+		job.Processed += encoded
+		job.Checkpoint = cursor
+		encoded = 0
+		return task.Save()
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		customers, next, err := db.ScanCustomersPage(ctx, h.dbClient, h.tableName, cursor, exportJobPageSize)
+		if err != nil {
+			return err
+		}
+		for _, customer := range customers {
+			if job.Params["pii"] != "true" {
+				customer = customer.Masked()
+			}
+			if err := encoder.Encode(customer); err != nil {
+				return err
+			}
+			cursor = customer.ID
+			encoded++
+
+			if err := encoder.Flush(); err != nil {
+				return err
+			}
+			if buffer.Len() >= exportJobChunkBytes {
+				if err := store(); err != nil {
+					return err
+				}
+			}
+		}
+
+		if next == "" {
+			return store()
+		}
+		cursor = next
+	}
+}
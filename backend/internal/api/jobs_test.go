@@ -0,0 +1,424 @@
+package api
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/jobs"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func jobsRouterConfig() *config.Config {
+	return &config.Config{
+		TableName:         "TestCustomers",
+		JobsTableName:     "TestJobs",
+		JobDataTableName:  "TestJobData",
+		JobImportMaxBytes: 1024,
+		APITokens:         map[string]config.APIToken{"jobs-token": {Principal: "alice"}},
+	}
+}
+
+// jobsRequest builds a request authenticated as the caller of jobsRouterConfig
+func jobsRequest(method, path string, body io.Reader) *http.Request {
+	req, _ := http.NewRequest(method, path, body)
+	req.Header.Set("Authorization", "Bearer jobs-token")
+	return req
+}
+
+func TestJobs_Disabled(t *testing.T) {
+	router := SetupRouter(nil, jobsRouterConfig(), nil, nil)
+
+	for _, path := range []string{"/jobs/imports", "/jobs/exports"} {
+		req := jobsRequest("POST", path, strings.NewReader("name,email\n"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusServiceUnavailable, w.Code, path)
+	}
+}
+
+func TestJobs_RequireAuthentication(t *testing.T) {
+	cfg := jobsRouterConfig()
+	router := SetupRouter(nil, cfg, jobs.NewRunner(nil, cfg), nil)
+
+	for _, path := range []string{"/jobs/imports", "/jobs/exports", "/jobs/j1", "/jobs/j1/result"} {
+		method := "GET"
+		if path == "/jobs/imports" || path == "/jobs/exports" {
+			method = "POST"
+		}
+		req, _ := http.NewRequest(method, path, strings.NewReader("name,email\n"))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusUnauthorized, w.Code, path)
+	}
+}
+
+func TestCreateJob_RequestErrors(t *testing.T) {
+	cfg := jobsRouterConfig()
+	router := SetupRouter(nil, cfg, jobs.NewRunner(nil, cfg), nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		body     string
+		expected int
+	}{
+		{name: "invalid export format", path: "/jobs/exports?format=xml", expected: http.StatusBadRequest},
+		{name: "empty file", path: "/jobs/imports", body: "", expected: http.StatusBadRequest},
+		{name: "unknown column", path: "/jobs/imports", body: "name,email,age\n", expected: http.StatusBadRequest},
+		{name: "unmapped column", path: "/jobs/imports?map[Full%20Name]=name", body: "Full Name,Mail\n", expected: http.StatusBadRequest},
+		{name: "too large", path: "/jobs/imports", body: "name,email\n" + strings.Repeat("x", 2048), expected: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := jobsRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "text/csv")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
+
+func TestImportJob_ResumesAfterInterruption(t *testing.T) {
+	cfg := jobsRouterConfig()
+	cfg.SettingsTableName = "TestSettings"
+	cfg.JobImportMaxBytes = 1 << 20
+	cfg.ImportConcurrency = 4
+	cfg.MetadataMaxBytes = 1024
+	tables, client := newFakeJobTables(t)
+
+	var file strings.Builder
+	file.WriteString("name,email\n")
+	for i := 0; i < 1200; i++ {
+		fmt.Fprintf(&file, "Customer %d,customer%d@example.com\n", i, i)
+	}
+	id := createJob(t, client, cfg, "/jobs/imports", file.String())
+
+	// The instance stops while saving the second checkpoint, after writing its rows
+	runJobs(t, client, cfg, func(stop func()) { tables.interruptAt, tables.interrupt = 2, stop })
+	job, err := db.GetJob(context.Background(), client, cfg.JobsTableName, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobRunning, job.Status)
+	assert.Equal(t, 500, job.Processed)
+	assert.Len(t, tables.customers, 1000)
+
+	// Another instance resumes the job once its lease expires
+	_, err = db.ClaimJob(context.Background(), client, cfg.JobsTableName, id, "other", time.Now(), time.Now().Add(time.Minute))
+	assert.ErrorIs(t, err, db.ErrJobNotClaimable)
+	tables.expireLease(id)
+	runJobs(t, client, cfg, func(stop func()) { tables.finished = stop })
+
+	job, err = db.GetJob(context.Background(), client, cfg.JobsTableName, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, job.Status)
+	assert.Equal(t, 1200, job.Processed)
+	assert.Empty(t, job.Errors)
+
+	// Every row is imported exactly once
+	require.Len(t, tables.customers, 1200)
+	emails := map[string]bool{}
+	for customerID, customer := range tables.customers {
+		assert.Equal(t, 1, tables.writes[customerID], "customer %s", customerID)
+		emails[attributeString(customer, "email")] = true
+	}
+	assert.Len(t, emails, 1200)
+}
+
+func TestExportJob_ResumesAfterInterruption(t *testing.T) {
+	cfg := jobsRouterConfig()
+	tables, client := newFakeJobTables(t)
+
+	expected := make([]string, 2000)
+	for i := range expected {
+		expected[i] = fmt.Sprintf("c%04d", i)
+		tables.customers[expected[i]] = map[string]interface{}{
+			"id":    map[string]string{"S": expected[i]},
+			"name":  map[string]string{"S": strings.Repeat("x", 250)},
+			"email": map[string]string{"S": expected[i] + "@example.com"},
+		}
+	}
+	id := createJob(t, client, cfg, "/jobs/exports", "")
+
+	// The instance stops while saving the second chunk, after writing it
+	runJobs(t, client, cfg, func(stop func()) { tables.interruptAt, tables.interrupt = 2, stop })
+	job, err := db.GetJob(context.Background(), client, cfg.JobsTableName, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobRunning, job.Status)
+	assert.Equal(t, 1, job.ResultChunks)
+	assert.Equal(t, expected[job.Processed-1], job.Checkpoint)
+
+	// Another instance resumes the job once its lease expires
+	tables.expireLease(id)
+	runJobs(t, client, cfg, func(stop func()) { tables.finished = stop })
+
+	job, err = db.GetJob(context.Background(), client, cfg.JobsTableName, id)
+	require.NoError(t, err)
+	assert.Equal(t, models.JobSucceeded, job.Status)
+	assert.Equal(t, 2000, job.Processed)
+	assert.Greater(t, job.ResultChunks, 2)
+
+	// Every customer is exported exactly once, in order, under a single header
+	router := SetupRouter(client, cfg, jobs.NewRunner(client, cfg), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, jobsRequest("GET", "/jobs/"+id+"/result", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	records, err := csv.NewReader(w.Body).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, "id", records[0][0])
+	var exported []string
+	for _, record := range records[1:] {
+		exported = append(exported, record[0])
+	}
+	assert.Equal(t, expected, exported)
+}
+
+// createJob creates a job through the API and returns its ID
+func createJob(t *testing.T, client *db.Client, cfg *config.Config, path, body string) string {
+	router := SetupRouter(client, cfg, jobs.NewRunner(client, cfg), nil)
+	req := jobsRequest("POST", path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "text/csv")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	require.Equal(t, http.StatusAccepted, w.Code, w.Body.String())
+
+	var job models.Job
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &job))
+	return job.ID
+}
+
+// runJobs runs the jobs of a new instance until arm's stop function is called
+func runJobs(t *testing.T, client *db.Client, cfg *config.Config, arm func(stop func())) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	arm(cancel)
+
+	runner := jobs.NewRunner(client, cfg)
+	SetupRouter(client, cfg, runner, nil)
+	runner.Run(ctx)
+	require.NotErrorIs(t, ctx.Err(), context.DeadlineExceeded, "the runner was not stopped")
+}
+
+// fakeJobTables keeps the customers, jobs and job data tables in memory for
+// the operations jobs use. A progress save can be made to fail, cancelling the
+// runner as if its instance stopped.
+type fakeJobTables struct {
+	mu        sync.Mutex
+	customers map[string]map[string]interface{}
+	jobs      map[string]map[string]interface{}
+	data      map[string]map[string]interface{}
+	writes    map[string]int
+	saves     int
+
+	// interruptAt is the progress save that fails, calling interrupt
+	interruptAt int
+	interrupt   func()
+	// finished is called once a job finishes
+	finished func()
+}
+
+var (
+	updateAssignments = regexp.MustCompile(`(#\w+) = (:\w+)`)
+	updateRemovals    = regexp.MustCompile(`REMOVE ((?:#\w+(?:, )?)+)`)
+)
+
+func newFakeJobTables(t *testing.T) (*fakeJobTables, *db.Client) {
+	tables := &fakeJobTables{
+		customers: map[string]map[string]interface{}{},
+		jobs:      map[string]map[string]interface{}{},
+		data:      map[string]map[string]interface{}{},
+		writes:    map[string]int{},
+		finished:  func() {},
+	}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		tables.mu.Lock()
+		defer tables.mu.Unlock()
+		return tables.handle(operation, body)
+	})
+	return tables, client
+}
+
+func (f *fakeJobTables) handle(operation string, body map[string]interface{}) interface{} {
+	table, _ := body["TableName"].(string)
+	switch {
+	case operation == "DescribeTable":
+		return map[string]interface{}{"Table": map[string]interface{}{"TableName": table, "ItemCount": len(f.customers)}}
+	case operation == "Scan" && table == "TestCustomers":
+		return f.scanCustomers(body)
+	case operation == "Query":
+		// Email lookups of the import
+		var email string
+		values := body["ExpressionAttributeValues"].(map[string]interface{})
+		for name := range values {
+			email = attributeString(values, name)
+		}
+		items := []interface{}{}
+		for _, item := range f.customers {
+			if attributeString(item, "email") == email {
+				items = append(items, item)
+			}
+		}
+		return map[string]interface{}{"Items": items, "Count": len(items)}
+	case operation == "BatchGetItem":
+		responses := []interface{}{}
+		for _, key := range body["RequestItems"].(map[string]interface{})["TestCustomers"].(map[string]interface{})["Keys"].([]interface{}) {
+			if item, ok := f.customers[attributeString(key.(map[string]interface{}), "id")]; ok {
+				responses = append(responses, item)
+			}
+		}
+		return map[string]interface{}{"Responses": map[string]interface{}{"TestCustomers": responses}}
+	case operation == "BatchWriteItem":
+		for _, request := range body["RequestItems"].(map[string]interface{})["TestCustomers"].([]interface{}) {
+			item := request.(map[string]interface{})["PutRequest"].(map[string]interface{})["Item"].(map[string]interface{})
+			id := attributeString(item, "id")
+			f.customers[id] = item
+			f.writes[id]++
+		}
+		return map[string]interface{}{"UnprocessedItems": map[string]interface{}{}}
+	case operation == "Scan" && table == "TestJobs":
+		items := []interface{}{}
+		for id, job := range f.jobs {
+			if f.claimable(job, time.Now().Unix()) {
+				items = append(items, map[string]interface{}{"id": map[string]string{"S": id}})
+			}
+		}
+		return map[string]interface{}{"Items": items}
+	case operation == "UpdateItem":
+		return f.updateJob(body)
+	case operation == "PutItem" && table == "TestJobs":
+		item := body["Item"].(map[string]interface{})
+		f.jobs[attributeString(item, "id")] = item
+		return map[string]interface{}{}
+	case operation == "PutItem" && table == "TestJobData":
+		item := body["Item"].(map[string]interface{})
+		f.data[attributeString(item, "jobId")+"/"+attributeString(item, "chunk")] = item
+		return map[string]interface{}{}
+	case operation == "GetItem" && table == "TestJobs":
+		key := body["Key"].(map[string]interface{})
+		if job, ok := f.jobs[attributeString(key, "id")]; ok {
+			return map[string]interface{}{"Item": job}
+		}
+	case operation == "GetItem" && table == "TestJobData":
+		key := body["Key"].(map[string]interface{})
+		if item, ok := f.data[attributeString(key, "jobId")+"/"+attributeString(key, "chunk")]; ok {
+			return map[string]interface{}{"Item": item}
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// scanCustomers returns a page of customers in ID order
+func (f *fakeJobTables) scanCustomers(body map[string]interface{}) interface{} {
+	ids := make([]string, 0, len(f.customers))
+	for id := range f.customers {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	if start, ok := body["ExclusiveStartKey"].(map[string]interface{}); ok {
+		after := attributeString(start, "id")
+		ids = ids[sort.Search(len(ids), func(i int) bool { return ids[i] > after }):]
+	}
+	result := map[string]interface{}{}
+	if limit := int(body["Limit"].(float64)); len(ids) > limit {
+		ids = ids[:limit]
+		result["LastEvaluatedKey"] = map[string]interface{}{"id": map[string]string{"S": ids[limit-1]}}
+	}
+	items := make([]interface{}, len(ids))
+	for i, id := range ids {
+		items[i] = f.customers[id]
+	}
+	result["Items"] = items
+	return result
+}
+
+// updateJob applies a claim, progress save or finish of a job
+func (f *fakeJobTables) updateJob(body map[string]interface{}) interface{} {
+	job := f.jobs[attributeString(body["Key"].(map[string]interface{}), "id")]
+	names := body["ExpressionAttributeNames"].(map[string]interface{})
+	values := body["ExpressionAttributeValues"].(map[string]interface{})
+	conditionFailed := map[string]interface{}{
+		"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+		"message": "The conditional request failed",
+	}
+
+	if body["ReturnValues"] == "ALL_NEW" {
+		if !f.claimable(job, attributeInt(values, ":nowUnix")) {
+			return conditionFailed
+		}
+	} else {
+		for _, match := range updateAssignments.FindAllStringSubmatch(body["ConditionExpression"].(string), -1) {
+			if attributeString(job, names[match[1]].(string)) != attributeString(values, match[2]) {
+				return conditionFailed
+			}
+		}
+	}
+
+	assignments := map[string]interface{}{}
+	for _, match := range updateAssignments.FindAllStringSubmatch(body["UpdateExpression"].(string), -1) {
+		assignments[names[match[1]].(string)] = values[match[2]]
+	}
+	if _, ok := assignments["checkpoint"]; ok && assignments["status"] == nil {
+		f.saves++
+		if f.saves == f.interruptAt {
+			f.interrupt()
+			return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": "interrupted"}
+		}
+	}
+
+	for name, value := range assignments {
+		job[name] = value
+	}
+	if removals := updateRemovals.FindStringSubmatch(body["UpdateExpression"].(string)); removals != nil {
+		for _, name := range strings.Split(removals[1], ", ") {
+			delete(job, names[name].(string))
+		}
+	}
+	if status := attributeString(job, "status"); status == models.JobSucceeded || status == models.JobFailed {
+		f.finished()
+	}
+	return map[string]interface{}{"Attributes": job}
+}
+
+// claimable reports whether a job is unfinished and its lease is free or expired
+func (f *fakeJobTables) claimable(job map[string]interface{}, now int64) bool {
+	status := attributeString(job, "status")
+	if status != models.JobQueued && status != models.JobRunning {
+		return false
+	}
+	_, leased := job["leaseExpiresAt"]
+	return !leased || attributeInt(job, "leaseExpiresAt") < now
+}
+
+// expireLease lets a job be claimed again, as if its worker's lease ran out
+func (f *fakeJobTables) expireLease(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.jobs[id]["leaseExpiresAt"] = map[string]interface{}{"N": strconv.FormatInt(time.Now().Add(-time.Second).Unix(), 10)}
+}
+
+// attributeInt returns the number held by an attribute of item
+func attributeInt(item map[string]interface{}, name string) int64 {
+	n, _ := strconv.ParseInt(attributeString(item, name), 10, 64)
+	return n
+}
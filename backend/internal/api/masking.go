@@ -0,0 +1,40 @@
+package api
+
+import (
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// presentCustomer shapes a customer for a response, masking its email and
+// telephone unless the caller holds the PII permission
+func presentCustomer(c *gin.Context, customer models.Customer) models.Customer {
+	if hasPermission(c, PermissionPII) {
+		return customer
+	}
+	return customer.Masked()
+}
+
+// presentCustomers applies presentCustomer to every customer in a list
+func presentCustomers(c *gin.Context, customers []models.Customer) []models.Customer {
+	if hasPermission(c, PermissionPII) {
+		return customers
+	}
+
+	masked := make([]models.Customer, len(customers))
+	for i, customer := range customers {
+		masked[i] = customer.Masked()
+	}
+	return masked
+}
+
+// keepMaskedPII restores fields a client sent back exactly as they were
+// masked for it, so an edit made from a masked view doesn't overwrite the
+// stored email or telephone with its masked form
+func keepMaskedPII(customer *models.Customer, existing *models.Customer) {
+	if existing.Email != "" && customer.Email == models.MaskEmail(existing.Email) {
+		customer.Email = existing.Email
+	}
+	if existing.Telephone != "" && customer.Telephone == models.MaskTelephone(existing.Telephone) {
+		customer.Telephone = existing.Telephone
+	}
+}
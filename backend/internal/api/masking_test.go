@@ -0,0 +1,66 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func testContext(token *config.APIToken) *gin.Context {
+	gin.SetMode(gin.TestMode)
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request, _ = http.NewRequest("GET", "/customers", nil)
+	if token != nil {
+		c.Set(principalKey, *token)
+	}
+	return c
+}
+
+func TestPresentCustomer(t *testing.T) {
+	customer := models.Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com", Telephone: "+55 11 98765-1234"}
+
+	tests := []struct {
+		name              string
+		token             *config.APIToken
+		expectedEmail     string
+		expectedTelephone string
+	}{
+		{name: "anonymous", token: nil, expectedEmail: "j***@example.com", expectedTelephone: "+55*******1234"},
+		{name: "without permission", token: &config.APIToken{Principal: "bob", Permissions: []string{PermissionAdmin}}, expectedEmail: "j***@example.com", expectedTelephone: "+55*******1234"},
+		{name: "with permission", token: &config.APIToken{Principal: "alice", Permissions: []string{PermissionPII}}, expectedEmail: "john.doe@example.com", expectedTelephone: "+55 11 98765-1234"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testContext(tt.token)
+
+			presented := presentCustomer(c, customer)
+			assert.Equal(t, tt.expectedEmail, presented.Email)
+			assert.Equal(t, tt.expectedTelephone, presented.Telephone)
+
+			list := presentCustomers(c, []models.Customer{customer})
+			assert.Equal(t, tt.expectedEmail, list[0].Email)
+		})
+	}
+}
+
+func TestKeepMaskedPII(t *testing.T) {
+	existing := &models.Customer{ID: "123", Email: "john.doe@example.com", Telephone: "+55 11 98765-1234"}
+
+	// Masked values sent back unchanged keep the stored values
+	customer := &models.Customer{ID: "123", Email: "j***@example.com", Telephone: "+55*******1234"}
+	keepMaskedPII(customer, existing)
+	assert.Equal(t, "john.doe@example.com", customer.Email)
+	assert.Equal(t, "+55 11 98765-1234", customer.Telephone)
+
+	// Real edits go through
+	customer = &models.Customer{ID: "123", Email: "john@example.org", Telephone: "+55 11 91111-2222"}
+	keepMaskedPII(customer, existing)
+	assert.Equal(t, "john@example.org", customer.Email)
+	assert.Equal(t, "+55 11 91111-2222", customer.Telephone)
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/search"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultDuplicateScore is the lowest score reported when min_score isn't given
+	defaultDuplicateScore = 0.75
+	// defaultDuplicateLimit and maxDuplicateLimit bound the pairs returned
+	defaultDuplicateLimit = 50
+	maxDuplicateLimit     = 500
+)
+
+// FindDuplicateCustomers handles GET /customers/duplicates. Every active
+// customer is compared in memory, so the endpoint is meant for periodic
+// clean-ups rather than interactive use.
+func (h *Handler) FindDuplicateCustomers(c *gin.Context) {
+	minScore, err := strconv.ParseFloat(c.DefaultQuery("min_score", strconv.FormatFloat(defaultDuplicateScore, 'f', -1, 64)), 64)
+	if err != nil || minScore <= 0 || minScore > 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "min_score must be a number above 0 and at most 1"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultDuplicateLimit)))
+	if err != nil || limit < 1 || limit > maxDuplicateLimit {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("limit must be between 1 and %d", maxDuplicateLimit)})
+		return
+	}
+
+	customers := map[string]models.Customer{}
+	var docs []search.Document
+	err = db.ScanCustomers(c.Request.Context(), h.dbClient, h.tableName, h.exportSegments, func(customer models.Customer) error {
+		if customer.IsErased() || customer.IsMerged() {
+			return nil
+		}
+		customers[customer.ID] = customer
+		docs = append(docs, search.Document{ID: customer.ID, Name: customer.Name, Email: customer.Email, Telephone: customer.Telephone})
+		return nil
+	})
+	if err != nil {
+		respondServerError(c, err, "Failed to read customers")
+		return
+	}
+
+	found := search.FindDuplicates(docs, minScore)
+	pairs := make([]models.DuplicatePair, 0, min(len(found), limit))
+	for _, pair := range found[:min(len(found), limit)] {
+		pairs = append(pairs, models.DuplicatePair{
+			Score: roundScore(pair.Score),
+			Signals: map[string]float64{
+				"email":     roundScore(pair.Email),
+				"telephone": roundScore(pair.Telephone),
+				"name":      roundScore(pair.Name),
+			},
+			Customers: presentCustomers(c, []models.Customer{customers[pair.IDs[0]], customers[pair.IDs[1]]}),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pairs": pairs})
+}
+
+// roundScore keeps three decimals of a score for display
+func roundScore(score float64) float64 {
+	return math.Round(score*1000) / 1000
+}
+
+// MergeCustomers handles POST /customers/merge. The loser is merged into the
+// survivor, its notes are moved over and it is kept as a closed tombstone.
+func (h *Handler) MergeCustomers(c *gin.Context) {
+	var request models.MergeRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := request.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	stored, err := db.GetCustomers(c.Request.Context(), h.dbClient, h.tableName, []string{request.SurvivorID, request.LoserID})
+	if err != nil {
+		respondServerError(c, err, "Failed to check customers")
+		return
+	}
+	survivor, loser := stored[request.SurvivorID], stored[request.LoserID]
+	if survivor == nil || loser == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	// Repeating a merge that already happened finishes moving the loser's notes
+	if loser.MergedInto == survivor.ID {
+		if err := db.MoveCustomerNotes(c.Request.Context(), h.dbClient, h.notesTable, loser.ID, survivor.ID); err != nil {
+			respondServerError(c, err, "Failed to move customer notes")
+			return
+		}
+		c.JSON(http.StatusOK, presentCustomer(c, *survivor))
+		return
+	}
+
+	for _, customer := range []*models.Customer{survivor, loser} {
+		if customer.IsErased() {
+			c.JSON(http.StatusGone, gin.H{"error": fmt.Sprintf("Customer %s has been erased", customer.ID)})
+			return
+		}
+		if customer.IsMerged() {
+			c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Customer %s was merged into %s", customer.ID, customer.MergedInto)})
+			return
+		}
+	}
+
+	merged := models.MergeCustomers(*survivor, *loser, request)
+	if len(merged.Tags) > models.MaxTagsPerCustomer {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": fmt.Sprintf("Merged customer would have more than %d tags", models.MaxTagsPerCustomer)})
+		return
+	}
+	// The survivor's status only moves the way a transition could move it
+	if from := survivor.CurrentStatus(); merged.Status != from && !models.CanTransition(from, merged.Status) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   fmt.Sprintf("Cannot transition survivor from %s to %s", from, merged.Status),
+			"allowed": models.AllowedTransitions(from),
+		})
+		return
+	}
+	if !h.validateMetadata(c, merged.Metadata) {
+		return
+	}
+
+	entry := &models.HistoryEntry{Reason: request.Reason, Actor: principalName(c)}
+	if err := db.MergeCustomers(c.Request.Context(), h.dbClient, h.tableName, h.tagsTable, h.historyTable, survivor, &merged, loser, entry); err != nil {
+		switch {
+		case errors.Is(err, db.ErrCustomerNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		case errors.Is(err, db.ErrMergeConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Customer changed, reload and retry"})
+		default:
+			respondServerError(c, err, "Failed to merge customers")
+		}
+		return
+	}
+
+	// Notes move after the merge commits, so a failure here is finished by repeating the request
+	if err := db.MoveCustomerNotes(c.Request.Context(), h.dbClient, h.notesTable, loser.ID, survivor.ID); err != nil {
+		respondServerError(c, err, "Customers merged but notes were not moved, repeat the request to finish")
+		return
+	}
+
+	c.JSON(http.StatusOK, presentCustomer(c, merged))
+}
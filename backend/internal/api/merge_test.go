@@ -0,0 +1,108 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSetupRouter_MergeRoutesRequirePermissions(t *testing.T) {
+	cfg := &config.Config{
+		TableName: "TestCustomers",
+		APITokens: map[string]config.APIToken{
+			"reader-token": {Principal: "bob"},
+			"pii-token":    {Principal: "carol", Permissions: []string{PermissionPII}},
+		},
+	}
+	router := SetupRouter(nil, cfg, nil, nil)
+
+	tests := []struct {
+		name          string
+		method        string
+		path          string
+		authorization string
+		expectedCode  int
+	}{
+		{name: "duplicates without permission", method: "GET", path: "/customers/duplicates", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+		{name: "merge without permission", method: "POST", path: "/customers/merge", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+		{name: "merge with pii only", method: "POST", path: "/customers/merge", authorization: "Bearer pii-token", expectedCode: http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			req.Header.Set("Authorization", tt.authorization)
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestHandler_FindDuplicateCustomers_InvalidParams(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.GET("/customers/duplicates", handler.FindDuplicateCustomers)
+
+	for _, query := range []string{"min_score=0", "min_score=1.5", "min_score=high", "limit=0", "limit=501"} {
+		req, _ := http.NewRequest("GET", "/customers/duplicates?"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusBadRequest, w.Code, query)
+	}
+}
+
+func TestHandler_MergeCustomers_InvalidRequest(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/customers/merge", handler.MergeCustomers)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "missing loser", body: `{"survivorId": "1"}`},
+		{name: "same customer", body: `{"survivorId": "1", "loserId": "1"}`},
+		{name: "unknown strategy", body: `{"survivorId": "1", "loserId": "2", "strategy": "oldest"}`},
+		{name: "unknown field", body: `{"survivorId": "1", "loserId": "2", "fields": {"id": "loser"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/customers/merge", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestHandler_MergeCustomers_StatusTransition(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "BatchGetItem", operation, "nothing is written")
+		return map[string]interface{}{"Responses": map[string]interface{}{"Customers": []interface{}{
+			map[string]interface{}{"id": map[string]string{"S": "1"}, "name": map[string]string{"S": "John"}, "status": map[string]string{"S": "active"}},
+			map[string]interface{}{"id": map[string]string{"S": "2"}, "name": map[string]string{"S": "Jon"}, "status": map[string]string{"S": "lead"}},
+		}}}
+	})
+	handler, router := setupTestHandler()
+	handler.dbClient = client
+	handler.tableName = "Customers"
+	router.POST("/customers/merge", handler.MergeCustomers)
+
+	req, _ := http.NewRequest("POST", "/customers/merge", bytes.NewBufferString(`{"survivorId": "1", "loserId": "2", "fields": {"status": "loser"}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, w.Code)
+	assert.Contains(t, w.Body.String(), "Cannot transition survivor from active to lead")
+}
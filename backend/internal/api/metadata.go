@@ -0,0 +1,158 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// metadataSchemaURL is the resource name the metadata schema is compiled under
+const metadataSchemaURL = "metadata-schema.json"
+
+// schemaCache keeps the compiled metadata schema for the most recently seen version
+type schemaCache struct {
+	mu       sync.Mutex
+	version  int
+	compiled *jsonschema.Schema
+}
+
+// get returns the compiled form of schema, compiling it only when the version changes
+func (sc *schemaCache) get(schema *models.MetadataSchema) (*jsonschema.Schema, error) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	if sc.compiled != nil && sc.version == schema.Version {
+		return sc.compiled, nil
+	}
+
+	compiled, err := compileMetadataSchema(schema.Schema)
+	if err != nil {
+		return nil, err
+	}
+	sc.version = schema.Version
+	sc.compiled = compiled
+	return compiled, nil
+}
+
+// compileMetadataSchema compiles a JSON Schema document
+func compileMetadataSchema(raw []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource(metadataSchemaURL, bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile(metadataSchemaURL)
+}
+
+// validateMetadata checks customer metadata against the size limits and the
+// configured schema. It writes an error response and returns false on failure.
+func (h *Handler) validateMetadata(c *gin.Context, metadata map[string]interface{}) bool {
+	if err := h.metadataValidator(c.Request.Context())(metadata); err != nil {
+		respondError(c, err)
+		return false
+	}
+	return true
+}
+
+// metadataValidator returns a function checking metadata against the size
+// limits and the configured schema. The schema is loaded on first use and
+// reused, so many customers can be validated with a single read.
+func (h *Handler) metadataValidator(ctx context.Context) func(map[string]interface{}) *apiError {
+	var loaded bool
+	var compiled *jsonschema.Schema
+
+	return func(metadata map[string]interface{}) *apiError {
+		if err := models.ValidateMetadata(metadata, h.metadataMaxBytes); err != nil {
+			return &apiError{status: http.StatusBadRequest, message: err.Error()}
+		}
+
+		if !loaded {
+			schema, err := db.GetMetadataSchema(ctx, h.dbClient, h.settingsTable)
+			if err != nil {
+				return serverError(err, "Failed to load metadata schema")
+			}
+			if schema != nil {
+				if compiled, err = h.schemas.get(schema); err != nil {
+					return &apiError{status: http.StatusInternalServerError, message: "Stored metadata schema is invalid"}
+				}
+			}
+			loaded = true
+		}
+
+		if compiled == nil {
+			return nil // No schema configured, any metadata within limits is accepted
+		}
+		if err := validateAgainstSchema(compiled, metadata); err != nil {
+			return &apiError{status: http.StatusBadRequest, message: "Metadata does not match schema", details: err.Error()}
+		}
+		return nil
+	}
+}
+
+// validateAgainstSchema validates metadata, treating missing metadata as an empty object
+func validateAgainstSchema(schema *jsonschema.Schema, metadata map[string]interface{}) error {
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	return schema.Validate(metadata)
+}
+
+// GetMetadataSchema handles GET /admin/metadata-schema
+func (h *Handler) GetMetadataSchema(c *gin.Context) {
+	schema, err := db.GetMetadataSchema(c.Request.Context(), h.dbClient, h.settingsTable)
+	if err != nil {
+		respondServerError(c, err, "Failed to get metadata schema")
+		return
+	}
+
+	if schema == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Metadata schema not configured"})
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// PutMetadataSchema handles PUT /admin/metadata-schema
+func (h *Handler) PutMetadataSchema(c *gin.Context) {
+	var schema models.MetadataSchema
+	if err := c.ShouldBindJSON(&schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Reject schemas that don't compile before they can break customer writes
+	if _, err := compileMetadataSchema(schema.Schema); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON Schema", "details": err.Error()})
+		return
+	}
+
+	// The client must send back the version it read, so concurrent edits don't overwrite each other
+	if err := db.PutMetadataSchema(c.Request.Context(), h.dbClient, h.settingsTable, &schema); err != nil {
+		if errors.Is(err, db.ErrVersionConflict) {
+			c.JSON(http.StatusConflict, gin.H{"error": "Metadata schema was modified, reload and retry"})
+			return
+		}
+		respondServerError(c, err, "Failed to save metadata schema")
+		return
+	}
+
+	c.JSON(http.StatusOK, schema)
+}
+
+// parseMetadataFilter reads metadata[key]=value query parameters
+func parseMetadataFilter(c *gin.Context) (map[string]string, error) {
+	filter := c.QueryMap("metadata")
+	for key := range filter {
+		if err := models.ValidateMetadataKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return filter, nil
+}
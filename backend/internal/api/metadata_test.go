@@ -0,0 +1,119 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testMetadataSchema = `{
+	"type": "object",
+	"properties": {
+		"loyaltyTier": {"type": "string", "enum": ["bronze", "silver", "gold"]},
+		"crmId": {"type": "string"}
+	},
+	"required": ["crmId"]
+}`
+
+func TestCompileMetadataSchema(t *testing.T) {
+	_, err := compileMetadataSchema([]byte(testMetadataSchema))
+	require.NoError(t, err)
+
+	_, err = compileMetadataSchema([]byte(`{"type": "not-a-type"}`))
+	assert.Error(t, err)
+
+	_, err = compileMetadataSchema([]byte(`not json`))
+	assert.Error(t, err)
+}
+
+func TestValidateAgainstSchema(t *testing.T) {
+	schema, err := compileMetadataSchema([]byte(testMetadataSchema))
+	require.NoError(t, err)
+
+	assert.NoError(t, validateAgainstSchema(schema, map[string]interface{}{"crmId": "CRM-1", "loyaltyTier": "gold"}))
+	assert.Error(t, validateAgainstSchema(schema, map[string]interface{}{"crmId": "CRM-1", "loyaltyTier": "platinum"}))
+	assert.Error(t, validateAgainstSchema(schema, nil), "missing metadata should fail required properties")
+}
+
+func TestSchemaCache_RecompilesOnNewVersion(t *testing.T) {
+	var cache schemaCache
+
+	first, err := cache.get(&models.MetadataSchema{Schema: json.RawMessage(`{"type":"object"}`), Version: 1})
+	require.NoError(t, err)
+
+	same, err := cache.get(&models.MetadataSchema{Schema: json.RawMessage(`{"type":"object"}`), Version: 1})
+	require.NoError(t, err)
+	assert.Same(t, first, same)
+
+	next, err := cache.get(&models.MetadataSchema{Schema: json.RawMessage(testMetadataSchema), Version: 2})
+	require.NoError(t, err)
+	assert.NotSame(t, first, next)
+}
+
+func TestHandler_CreateCustomer_MetadataTooLarge(t *testing.T) {
+	handler, router := setupTestHandler()
+	handler.metadataMaxBytes = 32
+	router.POST("/customers", handler.CreateCustomer)
+
+	body := `{"name":"John Doe","email":"john.doe@example.com","metadata":{"notes":"this value is far too long for the limit"}}`
+	req, _ := http.NewRequest("POST", "/customers", bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "maximum is 32")
+}
+
+func TestHandler_PutMetadataSchema_InvalidSchema(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.PUT("/admin/metadata-schema", handler.PutMetadataSchema)
+
+	req, _ := http.NewRequest("PUT", "/admin/metadata-schema", bytes.NewBufferString(`{"schema":{"type":42}}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	assert.Contains(t, w.Body.String(), "Invalid JSON Schema")
+}
+
+func TestParseMetadataFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected map[string]string
+		wantErr  bool
+	}{
+		{name: "no filter", query: "", expected: map[string]string{}},
+		{name: "single key", query: "metadata[loyaltyTier]=gold", expected: map[string]string{"loyaltyTier": "gold"}},
+		{name: "invalid key", query: "metadata[loyalty.tier]=gold", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "/customers?"+tt.query, nil)
+
+			filter, err := parseMetadataFilter(c)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, filter)
+		})
+	}
+}
@@ -2,7 +2,9 @@ package api
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/gin-gonic/gin"
 )
 
@@ -21,3 +23,86 @@ func CORSMiddleware() gin.HandlerFunc {
 		c.Next()
 	}
 }
+
+// PermissionAdmin grants access to administrative operations such as data
+// subject exports and erasure
+const PermissionAdmin = "customers:admin"
+
+// PermissionPII allows callers to see unmasked emails and telephone numbers
+const PermissionPII = "customers:pii"
+
+// principalKey is the gin context key holding the authenticated caller
+const principalKey = "principal"
+
+// AuthMiddleware identifies callers from their bearer token. Requests without a
+// token continue anonymously; requests with an unknown token are rejected.
+func AuthMiddleware(tokens map[string]config.APIToken) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.GetHeader("Authorization")
+		if header == "" {
+			c.Next()
+			return
+		}
+
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		apiToken, known := tokens[token]
+		if !ok || !known {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Invalid token"})
+			return
+		}
+
+		c.Set(principalKey, apiToken)
+		c.Next()
+	}
+}
+
+// RequireAuthentication rejects anonymous callers
+func RequireAuthentication() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(principalKey); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission rejects callers that don't hold the given permission
+func RequirePermission(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if _, ok := c.Get(principalKey); !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Authentication required"})
+			return
+		}
+
+		if !hasPermission(c, permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Missing permission " + permission})
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// hasPermission reports whether the authenticated caller holds permission
+func hasPermission(c *gin.Context, permission string) bool {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return false
+	}
+
+	for _, granted := range value.(config.APIToken).Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// principalName returns the authenticated caller's name, or "" for anonymous requests
+func principalName(c *gin.Context) string {
+	if value, ok := c.Get(principalKey); ok {
+		return value.(config.APIToken).Principal
+	}
+	return ""
+}
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"testing"
 
+	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 )
@@ -146,3 +147,72 @@ func TestCORSMiddleware_MultipleCalls(t *testing.T) {
 		assert.Equal(t, "Content-Type, Authorization", w.Header().Get("Access-Control-Allow-Headers"))
 	}
 }
+
+func setupAuthRouter(permission string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AuthMiddleware(map[string]config.APIToken{
+		"admin-token":  {Principal: "alice", Permissions: []string{PermissionAdmin}},
+		"reader-token": {Principal: "bob"},
+	}))
+
+	router.GET("/open", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"principal": principalName(c)})
+	})
+	router.GET("/authenticated", RequireAuthentication(), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"principal": principalName(c)})
+	})
+	router.GET("/guarded", RequirePermission(permission), func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"principal": principalName(c)})
+	})
+
+	return router
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	tests := []struct {
+		name          string
+		path          string
+		authorization string
+		expectedCode  int
+	}{
+		{name: "anonymous on open route", path: "/open", expectedCode: http.StatusOK},
+		{name: "known token on open route", path: "/open", authorization: "Bearer reader-token", expectedCode: http.StatusOK},
+		{name: "unknown token", path: "/open", authorization: "Bearer wrong", expectedCode: http.StatusUnauthorized},
+		{name: "non bearer scheme", path: "/open", authorization: "Basic admin-token", expectedCode: http.StatusUnauthorized},
+		{name: "anonymous on authenticated route", path: "/authenticated", expectedCode: http.StatusUnauthorized},
+		{name: "known token on authenticated route", path: "/authenticated", authorization: "Bearer reader-token", expectedCode: http.StatusOK},
+		{name: "anonymous on guarded route", path: "/guarded", expectedCode: http.StatusUnauthorized},
+		{name: "missing permission", path: "/guarded", authorization: "Bearer reader-token", expectedCode: http.StatusForbidden},
+		{name: "granted permission", path: "/guarded", authorization: "Bearer admin-token", expectedCode: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := setupAuthRouter(PermissionAdmin)
+
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expectedCode, w.Code)
+		})
+	}
+}
+
+func TestAuthMiddleware_SetsPrincipal(t *testing.T) {
+	router := setupAuthRouter(PermissionAdmin)
+
+	req, _ := http.NewRequest("GET", "/guarded", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"principal":"alice"}`, w.Body.String())
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultNotesPageSize is used when no limit is given
+	defaultNotesPageSize = 20
+	// maxNotesPageSize caps the limit query parameter
+	maxNotesPageSize = 100
+)
+
+// CreateNote handles POST /customers/:id/notes
+func (h *Handler) CreateNote(c *gin.Context) {
+	id := c.Param("id")
+
+	var request models.NoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to check customer")
+		return
+	}
+	if existingCustomer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	note := models.Note{
+		CustomerID: id,
+		Author:     principalName(c),
+		Body:       request.Body,
+	}
+
+	if err := db.CreateNote(c.Request.Context(), h.dbClient, h.notesTable, &note); err != nil {
+		respondServerError(c, err, "Failed to create note")
+		return
+	}
+
+	c.JSON(http.StatusCreated, note)
+}
+
+// ListNotes handles GET /customers/:id/notes
+func (h *Handler) ListNotes(c *gin.Context) {
+	id := c.Param("id")
+
+	limit, err := parseNotesLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := db.ListNotes(c.Request.Context(), h.dbClient, h.notesTable, id, limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		respondServerError(c, err, "Failed to get notes")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// UpdateNote handles PUT /customers/:id/notes/:noteId
+func (h *Handler) UpdateNote(c *gin.Context) {
+	id := c.Param("id")
+	noteID := c.Param("noteId")
+
+	var request models.NoteRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	note, err := db.UpdateNote(c.Request.Context(), h.dbClient, h.notesTable, id, noteID, principalName(c), request.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrNoteNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+		case errors.Is(err, db.ErrNotNoteAuthor):
+			c.JSON(http.StatusForbidden, gin.H{"error": "Only the author can edit a note"})
+		default:
+			respondServerError(c, err, "Failed to update note")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, note)
+}
+
+// DeleteNote handles DELETE /customers/:id/notes/:noteId
+func (h *Handler) DeleteNote(c *gin.Context) {
+	id := c.Param("id")
+	noteID := c.Param("noteId")
+
+	if err := db.DeleteNote(c.Request.Context(), h.dbClient, h.notesTable, id, noteID); err != nil {
+		if errors.Is(err, db.ErrNoteNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Note not found"})
+			return
+		}
+		respondServerError(c, err, "Failed to delete note")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Note deleted successfully"})
+}
+
+// parseNotesLimit validates the page size of a notes listing
+func parseNotesLimit(raw string) (int64, error) {
+	if raw == "" {
+		return defaultNotesPageSize, nil
+	}
+
+	limit, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || limit < 1 || limit > maxNotesPageSize {
+		return 0, errors.New("limit must be a number between 1 and 100")
+	}
+	return limit, nil
+}
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseNotesLimit(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int64
+		wantErr  bool
+	}{
+		{raw: "", expected: defaultNotesPageSize},
+		{raw: "1", expected: 1},
+		{raw: "100", expected: 100},
+		{raw: "0", wantErr: true},
+		{raw: "101", wantErr: true},
+		{raw: "ten", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			limit, err := parseNotesLimit(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, limit)
+		})
+	}
+}
+
+func TestHandler_CreateNote_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "invalid JSON", body: "invalid json"},
+		{name: "missing body", body: `{}`},
+		{name: "body too long", body: `{"body":"` + strings.Repeat("x", 4001) + `"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, router := setupTestHandler()
+			router.POST("/customers/:id/notes", handler.CreateNote)
+
+			req, _ := http.NewRequest("POST", "/customers/123/notes", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestHandler_CreateNote_AuthorIsPrincipal(t *testing.T) {
+	var note map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "GetItem" {
+			return map[string]interface{}{"Item": map[string]interface{}{"id": map[string]string{"S": "123"}}}
+		}
+		note = body["Item"].(map[string]interface{})
+		return map[string]interface{}{}
+	})
+	handler := NewHandler(client, &config.Config{TableName: "Customers", NotesTableName: "Notes"})
+	router := gin.New()
+	router.POST("/customers/:id/notes", func(c *gin.Context) {
+		c.Set(principalKey, config.APIToken{Principal: "alice"})
+	}, handler.CreateNote)
+
+	req, _ := http.NewRequest("POST", "/customers/123/notes", bytes.NewBufferString(`{"author":"mallory","body":"called the customer"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, map[string]interface{}{"S": "alice"}, note["author"])
+}
+
+func TestHandler_ListNotes_InvalidLimit(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.GET("/customers/:id/notes", handler.ListNotes)
+
+	req, _ := http.NewRequest("GET", "/customers/123/notes?limit=500", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
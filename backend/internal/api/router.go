@@ -1,30 +1,99 @@
 package api
 
 import (
-	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"expvar"
+	"net/http"
+
 	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/jobs"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
 	"github.com/gin-gonic/gin"
 )
 
-// SetupRouter configures the Gin router
-func SetupRouter(dbClient *dynamodb.DynamoDB, cfg *config.Config) *gin.Engine {
+// SetupRouter configures the Gin router. The job endpoints respond 503 when
+// runner is nil. The customer event stream broadcasts the changes published
+// to changes, or none when it is nil.
+func SetupRouter(dbClient *db.Client, cfg *config.Config, runner *jobs.Runner, changes *pubsub.Broker) *gin.Engine {
 	router := gin.Default()
 
 	// Add middleware
 	router.Use(CORSMiddleware())
+	router.Use(AuthMiddleware(cfg.APITokens))
 
 	// Create a handler with the db client and config
 	handler := NewHandler(dbClient, cfg)
+	if runner != nil {
+		handler.registerJobExecutors(runner)
+	}
+	if changes != nil {
+		handler.changes = changes
+	}
 
 	// Health check endpoint (for Kubernetes liveness probe)
 	router.GET("/health", handler.HealthCheck)
 
 	// Customer API routes
-	router.POST("/customers", handler.CreateCustomer)
+	router.POST("/customers", handler.Idempotent(), handler.CreateCustomer)
+	router.POST("/customers/import", handler.ImportCustomers)
 	router.GET("/customers", handler.GetAllCustomers)
+	router.GET("/customers/search", handler.SearchCustomers)
+	router.GET("/customers/events", handler.StreamCustomerEvents)
+	router.GET("/customers/export", handler.ExportAllCustomers)
+	router.GET("/customers/duplicates", RequirePermission(PermissionPII), handler.FindDuplicateCustomers)
+	router.POST("/customers/merge", RequirePermission(PermissionAdmin), handler.MergeCustomers)
 	router.GET("/customers/:id", handler.GetCustomer)
 	router.PUT("/customers/:id", handler.UpdateCustomer)
 	router.DELETE("/customers/:id", handler.DeleteCustomer)
+	router.POST("/customers/:id/tags/:tag", handler.AddCustomerTag)
+	router.DELETE("/customers/:id/tags/:tag", handler.RemoveCustomerTag)
+	router.POST("/customers/:id/transitions", handler.TransitionCustomer)
+	router.GET("/customers/:id/history", handler.GetCustomerHistory)
+	router.POST("/customers/:id/notes", RequireAuthentication(), handler.CreateNote)
+	router.GET("/customers/:id/notes", handler.ListNotes)
+	router.PUT("/customers/:id/notes/:noteId", RequireAuthentication(), handler.UpdateNote)
+	router.DELETE("/customers/:id/notes/:noteId", handler.DeleteNote)
+
+	// Data subject requests
+	router.GET("/customers/:id/export", RequirePermission(PermissionAdmin), handler.ExportCustomer)
+	router.POST("/customers/:id/erase", RequirePermission(PermissionAdmin), handler.EraseCustomer)
+
+	// Tag routes
+	router.GET("/tags", handler.GetTags)
+
+	// Background job routes
+	router.POST("/jobs/imports", RequireAuthentication(), handler.CreateImportJob)
+	router.POST("/jobs/exports", RequireAuthentication(), handler.CreateExportJob)
+	router.GET("/jobs/:id", RequireAuthentication(), handler.GetJob)
+	router.GET("/jobs/:id/result", RequireAuthentication(), handler.GetJobResult)
+
+	// Webhook routes
+	webhooks := router.Group("/webhooks", RequirePermission(PermissionAdmin))
+	webhooks.POST("", handler.CreateWebhook)
+	webhooks.GET("", handler.ListWebhooks)
+	webhooks.GET("/:id", handler.GetWebhook)
+	webhooks.PUT("/:id", handler.UpdateWebhook)
+	webhooks.DELETE("/:id", handler.DeleteWebhook)
+	webhooks.GET("/:id/deliveries", handler.ListWebhookDeliveries)
+
+	// Gin can't route a literal colon, so custom methods such as
+	// POST /customers:batch are dispatched when no route matches
+	customMethods := map[string]gin.HandlerFunc{
+		"POST /customers:batch": handler.BatchCustomers,
+	}
+	router.NoRoute(func(c *gin.Context) {
+		if handle, ok := customMethods[c.Request.Method+" "+c.Request.URL.Path]; ok {
+			handle(c)
+			return
+		}
+		c.String(http.StatusNotFound, "404 page not found")
+	})
+
+	// Admin routes
+	admin := router.Group("/admin", RequirePermission(PermissionAdmin))
+	admin.GET("/metadata-schema", handler.GetMetadataSchema)
+	admin.PUT("/metadata-schema", handler.PutMetadataSchema)
+	admin.GET("/metrics", gin.WrapH(expvar.Handler()))
 
 	return router
 }
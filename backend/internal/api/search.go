@@ -0,0 +1,66 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/search"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// defaultSearchLimit is used when no limit is given
+	defaultSearchLimit = 20
+	// maxSearchLimit caps the limit query parameter
+	maxSearchLimit = 100
+)
+
+// SearchCustomers handles GET /customers/search
+func (h *Handler) SearchCustomers(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	limit, err := parseSearchLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Callers who can't see PII only match on names, so search can't be used
+	// to probe for an email address or telephone number
+	fields := search.FieldName
+	if hasPermission(c, PermissionPII) {
+		fields = search.AllFields
+	}
+
+	customers, err := db.SearchCustomers(c.Request.Context(), h.dbClient, h.tableName, query, fields, limit)
+	if errors.Is(err, db.ErrSearchNotConfigured) {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Search is not available"})
+		return
+	}
+	if err != nil {
+		respondServerError(c, err, "Failed to search customers")
+		return
+	}
+
+	c.JSON(http.StatusOK, presentCustomers(c, customers))
+}
+
+// parseSearchLimit validates the maximum number of search results
+func parseSearchLimit(raw string) (int, error) {
+	if raw == "" {
+		return defaultSearchLimit, nil
+	}
+
+	limit, err := strconv.Atoi(raw)
+	if err != nil || limit < 1 || limit > maxSearchLimit {
+		return 0, errors.New("limit must be a number between 1 and 100")
+	}
+	return limit, nil
+}
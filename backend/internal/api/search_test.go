@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSearchLimit(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected int
+		wantErr  bool
+	}{
+		{raw: "", expected: defaultSearchLimit},
+		{raw: "5", expected: 5},
+		{raw: "0", wantErr: true},
+		{raw: "101", wantErr: true},
+		{raw: "many", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			limit, err := parseSearchLimit(tt.raw)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, limit)
+		})
+	}
+}
+
+func TestSetupRouter_Search(t *testing.T) {
+	router := SetupRouter(db.NewClient(nil, nil), &config.Config{TableName: "TestCustomers"}, nil, nil)
+
+	tests := []struct {
+		name     string
+		path     string
+		expected int
+	}{
+		// The search route must not be captured by GET /customers/:id
+		{name: "missing query", path: "/customers/search", expected: http.StatusBadRequest},
+		{name: "invalid limit", path: "/customers/search?q=john&limit=0", expected: http.StatusBadRequest},
+		{name: "index not configured", path: "/customers/search?q=john", expected: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tt.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+		})
+	}
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// TransitionCustomer handles POST /customers/:id/transitions
+func (h *Handler) TransitionCustomer(c *gin.Context) {
+	id := c.Param("id")
+
+	var request models.TransitionRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if !models.IsValidStatus(request.To) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
+		return
+	}
+
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to check customer")
+		return
+	}
+	if existingCustomer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+
+	from := existingCustomer.CurrentStatus()
+	if !models.CanTransition(from, request.To) {
+		c.JSON(http.StatusUnprocessableEntity, gin.H{
+			"error":   fmt.Sprintf("Cannot transition from %s to %s", from, request.To),
+			"allowed": models.AllowedTransitions(from),
+		})
+		return
+	}
+
+	entry := &models.HistoryEntry{
+		CustomerID: id,
+		From:       from,
+		To:         request.To,
+		Reason:     request.Reason,
+		Actor:      principalName(c),
+	}
+
+	if err := db.TransitionCustomerStatus(c.Request.Context(), h.dbClient, h.tableName, h.historyTable, entry); err != nil {
+		switch {
+		case errors.Is(err, db.ErrCustomerNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		case errors.Is(err, db.ErrStatusConflict):
+			c.JSON(http.StatusConflict, gin.H{"error": "Customer status changed, reload and retry"})
+		default:
+			respondServerError(c, err, "Failed to transition customer")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, entry)
+}
+
+// GetCustomerHistory handles GET /customers/:id/history
+func (h *Handler) GetCustomerHistory(c *gin.Context) {
+	id := c.Param("id")
+
+	entries, err := db.ListHistory(c.Request.Context(), h.dbClient, h.historyTable, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to get customer history")
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// parseStatusFilter reads repeated status query parameters
+func parseStatusFilter(c *gin.Context) ([]string, error) {
+	statuses := c.QueryArray("status")
+	for _, status := range statuses {
+		if !models.IsValidStatus(status) {
+			return nil, fmt.Errorf("invalid status %q", status)
+		}
+	}
+	return statuses, nil
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandler_TransitionCustomer_Validation(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "invalid JSON", body: "invalid json"},
+		{name: "missing reason", body: `{"to":"active"}`},
+		{name: "unknown status", body: `{"to":"archived","reason":"old"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler, router := setupTestHandler()
+			router.POST("/customers/:id/transitions", handler.TransitionCustomer)
+
+			req, _ := http.NewRequest("POST", "/customers/123/transitions", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestHandler_TransitionCustomer_ActorIsPrincipal(t *testing.T) {
+	var history map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "GetItem" {
+			return map[string]interface{}{"Item": map[string]interface{}{"id": map[string]string{"S": "123"}, "status": map[string]string{"S": "lead"}}}
+		}
+		items := body["TransactItems"].([]interface{})
+		history = items[1].(map[string]interface{})["Put"].(map[string]interface{})["Item"].(map[string]interface{})
+		return map[string]interface{}{}
+	})
+	handler := NewHandler(client, &config.Config{TableName: "Customers", HistoryTableName: "History"})
+	router := gin.New()
+	router.POST("/customers/:id/transitions", func(c *gin.Context) {
+		c.Set(principalKey, config.APIToken{Principal: "alice"})
+	}, handler.TransitionCustomer)
+
+	req, _ := http.NewRequest("POST", "/customers/123/transitions", bytes.NewBufferString(`{"to":"active","reason":"signed","actor":"mallory"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]interface{}{"S": "alice"}, history["actor"])
+}
+
+func TestParseStatusFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/customers?status=active&status=suspended", nil)
+
+	statuses, err := parseStatusFilter(c)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"active", "suspended"}, statuses)
+
+	c, _ = gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest("GET", "/customers?status=archived", nil)
+	_, err = parseStatusFilter(c)
+	assert.Error(t, err)
+}
@@ -0,0 +1,121 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/gin-gonic/gin"
+)
+
+// AddCustomerTag handles POST /customers/:id/tags/:tag
+func (h *Handler) AddCustomerTag(c *gin.Context) {
+	id := c.Param("id")
+
+	tag, err := models.NormalizeTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Check the tag limit before writing
+	existingCustomer, err := db.GetCustomer(c.Request.Context(), h.dbClient, h.tableName, id)
+	if err != nil {
+		respondServerError(c, err, "Failed to check customer")
+		return
+	}
+	if existingCustomer == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		return
+	}
+	if existingCustomer.IsErased() || existingCustomer.IsMerged() {
+		respondCustomerInactive(c)
+		return
+	}
+	if !existingCustomer.HasTag(tag) && len(existingCustomer.Tags) >= models.MaxTagsPerCustomer {
+		respondTooManyTags(c)
+		return
+	}
+
+	// The write re-checks both conditions, so concurrent changes can still reject it
+	added, err := db.AddCustomerTag(c.Request.Context(), h.dbClient, h.tableName, h.tagsTable, id, tag)
+	if err != nil {
+		switch {
+		case errors.Is(err, db.ErrCustomerNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+		case errors.Is(err, db.ErrCustomerInactive):
+			respondCustomerInactive(c)
+		case errors.Is(err, db.ErrTooManyTags):
+			respondTooManyTags(c)
+		default:
+			respondServerError(c, err, "Failed to add tag")
+		}
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "tag": tag, "added": added})
+}
+
+// respondCustomerInactive rejects tagging a customer that was erased or merged
+func respondCustomerInactive(c *gin.Context) {
+	c.JSON(http.StatusGone, gin.H{"error": "Customer has been erased or merged"})
+}
+
+// respondTooManyTags rejects adding a tag to a customer at the tag limit
+func respondTooManyTags(c *gin.Context) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Customer already has %d tags", models.MaxTagsPerCustomer)})
+}
+
+// RemoveCustomerTag handles DELETE /customers/:id/tags/:tag
+func (h *Handler) RemoveCustomerTag(c *gin.Context) {
+	id := c.Param("id")
+
+	tag, err := models.NormalizeTag(c.Param("tag"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	removed, err := db.RemoveCustomerTag(c.Request.Context(), h.dbClient, h.tableName, h.tagsTable, id, tag)
+	if err != nil {
+		if errors.Is(err, db.ErrCustomerNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Customer not found"})
+			return
+		}
+		respondServerError(c, err, "Failed to remove tag")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": id, "tag": tag, "removed": removed})
+}
+
+// GetTags handles GET /tags
+func (h *Handler) GetTags(c *gin.Context) {
+	counts, err := db.ListTagCounts(c.Request.Context(), h.dbClient, h.tagsTable)
+	if err != nil {
+		respondServerError(c, err, "Failed to get tags")
+		return
+	}
+
+	c.JSON(http.StatusOK, counts)
+}
+
+// parseTagFilter reads and normalizes repeated tag query parameters
+func parseTagFilter(c *gin.Context) ([]string, error) {
+	mode := c.Query("tag_mode")
+	if mode != "" && mode != "all" && mode != "any" {
+		return nil, fmt.Errorf("tag_mode must be 'all' or 'any'")
+	}
+
+	var tags []string
+	for _, raw := range c.QueryArray("tag") {
+		tag, err := models.NormalizeTag(raw)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+	return tags, nil
+}
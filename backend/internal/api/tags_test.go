@@ -0,0 +1,140 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTagFilter(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []string
+		wantErr  bool
+	}{
+		{name: "no tags", query: "", expected: nil},
+		{name: "single tag", query: "tag=vip", expected: []string{"vip"}},
+		{name: "repeated tags are normalized", query: "tag=VIP&tag=churn-risk&tag_mode=any", expected: []string{"vip", "churn-risk"}},
+		{name: "invalid tag", query: "tag=not%20valid", wantErr: true},
+		{name: "invalid mode", query: "tag=vip&tag_mode=some", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request, _ = http.NewRequest("GET", "/customers?"+tt.query, nil)
+
+			tags, err := parseTagFilter(c)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, tags)
+		})
+	}
+}
+
+func TestHandler_AddCustomerTag_InvalidTag(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/customers/:id/tags/:tag", handler.AddCustomerTag)
+
+	req, _ := http.NewRequest("POST", "/customers/123/tags/-bad", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_RemoveCustomerTag_InvalidTag(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.DELETE("/customers/:id/tags/:tag", handler.RemoveCustomerTag)
+
+	req, _ := http.NewRequest("DELETE", "/customers/123/tags/bad!", nil)
+	w := httptest.NewRecorder()
+
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestHandler_AddCustomerTag_RejectedByWrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		stored map[string]interface{}
+		status int
+	}{
+		{name: "erased", stored: map[string]interface{}{"erasedAt": map[string]string{"S": "2024-01-01T00:00:00Z"}}, status: http.StatusGone},
+		{name: "merged", stored: map[string]interface{}{"mergedInto": map[string]string{"S": "c2"}}, status: http.StatusGone},
+		{name: "at the limit", stored: map[string]interface{}{"tags": map[string]interface{}{"SS": []string{"a"}}}, status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var operations []string
+			client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+				operations = append(operations, operation)
+				if operation == "GetItem" {
+					// The read sees an active customer with room for the tag
+					return map[string]interface{}{"Item": map[string]interface{}{"id": map[string]string{"S": "c1"}}}
+				}
+				stored := map[string]interface{}{"id": map[string]string{"S": "c1"}}
+				for name, value := range tt.stored {
+					stored[name] = value
+				}
+				return map[string]interface{}{
+					"__type":  "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+					"message": "Transaction cancelled",
+					"CancellationReasons": []map[string]interface{}{
+						{"Code": "ConditionalCheckFailed", "Item": stored},
+						{"Code": "None"},
+					},
+				}
+			})
+			handler := &Handler{dbClient: client, tableName: "Customers", tagsTable: "Tags"}
+			router := gin.New()
+			router.POST("/customers/:id/tags/:tag", handler.AddCustomerTag)
+
+			req, _ := http.NewRequest("POST", "/customers/c1/tags/vip", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.status, w.Code, w.Body.String())
+			assert.Equal(t, []string{"GetItem", "TransactWriteItems"}, operations)
+		})
+	}
+}
+
+func TestHandler_AddCustomerTag_Tombstone(t *testing.T) {
+	var operations []string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		return map[string]interface{}{"Item": map[string]interface{}{
+			"id":         map[string]string{"S": "c1"},
+			"mergedInto": map[string]string{"S": "c2"},
+		}}
+	})
+	handler := &Handler{dbClient: client, tableName: "Customers", tagsTable: "Tags"}
+	router := gin.New()
+	router.POST("/customers/:id/tags/:tag", handler.AddCustomerTag)
+
+	req, _ := http.NewRequest("POST", "/customers/c1/tags/vip", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+
+	require.Equal(t, http.StatusGone, w.Code)
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "Customer has been erased or merged", response["error"])
+	assert.Equal(t, []string{"GetItem"}, operations, "nothing is written")
+}
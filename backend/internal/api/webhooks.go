@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/webhooks"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// webhookSecretBytes is the size of generated webhook secrets
+const webhookSecretBytes = 32
+
+// CreateWebhook handles POST /webhooks. The response is the only one
+// carrying the webhook's secret.
+func (h *Handler) CreateWebhook(c *gin.Context) {
+	request, ok := h.bindWebhookRequest(c)
+	if !ok {
+		return
+	}
+
+	secret := request.Secret
+	if secret == "" {
+		generated, err := newWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate webhook secret"})
+			return
+		}
+		secret = generated
+	}
+
+	webhook := models.Webhook{
+		ID:        uuid.New().String(),
+		URL:       request.URL,
+		Secret:    secret,
+		Events:    request.Events,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	if err := db.PutWebhook(c.Request.Context(), h.dbClient, h.webhooksTable, &webhook); err != nil {
+		respondServerError(c, err, "Failed to create webhook")
+		return
+	}
+
+	c.Header("Location", "/webhooks/"+webhook.ID)
+	c.JSON(http.StatusCreated, webhook)
+}
+
+// ListWebhooks handles GET /webhooks
+func (h *Handler) ListWebhooks(c *gin.Context) {
+	webhooks, err := db.ListWebhooks(c.Request.Context(), h.dbClient, h.webhooksTable)
+	if err != nil {
+		respondServerError(c, err, "Failed to get webhooks")
+		return
+	}
+
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+	c.JSON(http.StatusOK, webhooks)
+}
+
+// GetWebhook handles GET /webhooks/:id
+func (h *Handler) GetWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+
+	webhook.Secret = ""
+	c.JSON(http.StatusOK, webhook)
+}
+
+// UpdateWebhook handles PUT /webhooks/:id. The secret is kept unless a new one is given.
+func (h *Handler) UpdateWebhook(c *gin.Context) {
+	webhook, ok := h.findWebhook(c)
+	if !ok {
+		return
+	}
+	request, ok := h.bindWebhookRequest(c)
+	if !ok {
+		return
+	}
+
+	webhook.URL = request.URL
+	webhook.Events = request.Events
+	if request.Secret != "" {
+		webhook.Secret = request.Secret
+	}
+	webhook.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	if err := db.PutWebhook(c.Request.Context(), h.dbClient, h.webhooksTable, webhook); err != nil {
+		respondServerError(c, err, "Failed to update webhook")
+		return
+	}
+
+	webhook.Secret = ""
+	c.JSON(http.StatusOK, webhook)
+}
+
+// DeleteWebhook handles DELETE /webhooks/:id. Pending deliveries to the
+// webhook are dropped on their next attempt.
+func (h *Handler) DeleteWebhook(c *gin.Context) {
+	if _, ok := h.findWebhook(c); !ok {
+		return
+	}
+
+	if err := db.DeleteWebhook(c.Request.Context(), h.dbClient, h.webhooksTable, c.Param("id")); err != nil {
+		respondServerError(c, err, "Failed to delete webhook")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook deleted successfully"})
+}
+
+// ListWebhookDeliveries handles GET /webhooks/:id/deliveries
+func (h *Handler) ListWebhookDeliveries(c *gin.Context) {
+	limit, err := parseNotesLimit(c.Query("limit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if _, ok := h.findWebhook(c); !ok {
+		return
+	}
+
+	page, err := db.ListWebhookDeliveries(c.Request.Context(), h.dbClient, h.webhookDeliveriesTable, c.Param("id"), limit, c.Query("cursor"))
+	if err != nil {
+		if errors.Is(err, db.ErrInvalidCursor) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cursor"})
+			return
+		}
+		respondServerError(c, err, "Failed to get webhook deliveries")
+		return
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// findWebhook loads the webhook named by the :id parameter, responding with
+// an error when it can't
+func (h *Handler) findWebhook(c *gin.Context) (*models.Webhook, bool) {
+	webhook, err := db.GetWebhook(c.Request.Context(), h.dbClient, h.webhooksTable, c.Param("id"))
+	if err != nil {
+		if errors.Is(err, db.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Webhook not found"})
+			return nil, false
+		}
+		respondServerError(c, err, "Failed to get webhook")
+		return nil, false
+	}
+	return webhook, true
+}
+
+// bindWebhookRequest decodes and validates a webhook request, responding
+// with an error when it is invalid or its URL reaches a non-public address
+func (h *Handler) bindWebhookRequest(c *gin.Context) (*models.WebhookRequest, bool) {
+	var request models.WebhookRequest
+	if err := c.ShouldBindJSON(&request); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if err := request.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+	if !h.webhookAllowPrivate {
+		if err := webhooks.CheckURL(c.Request.Context(), request.URL); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return nil, false
+		}
+	}
+	return &request, true
+}
+
+// newWebhookSecret returns a random hex-encoded secret
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, webhookSecretBytes)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(secret), nil
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetupRouter_WebhookRoutesRequireAdmin(t *testing.T) {
+	cfg := &config.Config{
+		TableName: "TestCustomers",
+		APITokens: map[string]config.APIToken{
+			"pii-token": {Principal: "carol", Permissions: []string{PermissionPII}},
+		},
+	}
+	router := SetupRouter(nil, cfg, nil, nil)
+
+	for _, route := range [][2]string{
+		{"POST", "/webhooks"},
+		{"GET", "/webhooks"},
+		{"GET", "/webhooks/w1"},
+		{"PUT", "/webhooks/w1"},
+		{"DELETE", "/webhooks/w1"},
+		{"GET", "/webhooks/w1/deliveries"},
+	} {
+		req, _ := http.NewRequest(route[0], route[1], nil)
+		req.Header.Set("Authorization", "Bearer pii-token")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusForbidden, w.Code, route[0]+" "+route[1])
+	}
+}
+
+func TestHandler_CreateWebhook_InvalidRequest(t *testing.T) {
+	handler, router := setupTestHandler()
+	router.POST("/webhooks", handler.CreateWebhook)
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "missing url", body: `{"events": ["customer.created"]}`},
+		{name: "relative url", body: `{"url": "/hooks"}`},
+		{name: "short secret", body: `{"url": "https://partner.example.com/hooks", "secret": "abc"}`},
+		{name: "unknown event", body: `{"url": "https://partner.example.com/hooks", "events": ["customer.viewed"]}`},
+		{name: "loopback url", body: `{"url": "http://127.0.0.1:8080/hooks"}`},
+		{name: "metadata url", body: `{"url": "http://169.254.169.254/latest/meta-data"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, _ := http.NewRequest("POST", "/webhooks", bytes.NewBufferString(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, http.StatusBadRequest, w.Code)
+		})
+	}
+}
+
+func TestNewWebhookSecret(t *testing.T) {
+	first, err := newWebhookSecret()
+	assert.NoError(t, err)
+	second, err := newWebhookSecret()
+	assert.NoError(t, err)
+
+	assert.Len(t, first, 2*webhookSecretBytes)
+	assert.NotEqual(t, first, second)
+}
@@ -1,23 +1,263 @@
 package config
 
-import "os"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
 
 // Config holds application configuration
 type Config struct {
-	AWSRegion        string
-	DynamoDBEndpoint string
-	TableName        string
-	Port             string
+	AWSRegion         string
+	DynamoDBEndpoint  string
+	TableName         string
+	SettingsTableName string
+	TagsTableName     string
+	HistoryTableName  string
+	NotesTableName    string
+	Port              string
+	MetadataMaxBytes  int
+	// NotesDeletePolicy controls what happens to notes when their customer is
+	// deleted: "cascade" removes them, "block" refuses to delete the customer
+	NotesDeletePolicy string
+	// APITokens maps bearer tokens to the caller they identify
+	APITokens map[string]APIToken
+	// EncryptionProvider selects the key provider for PII field encryption:
+	// "" disables encryption, "local" reads keys from EncryptionKeyFile and
+	// "kms" uses the KMS key EncryptionKMSKeyID
+	EncryptionProvider string
+	EncryptionKeyFile  string
+	EncryptionKMSKeyID string
+	EncryptionFields   []string
+	// BlindIndexKey is the base64-encoded HMAC key of the email blind index
+	BlindIndexKey string
+	// BatchMaxOperations caps the operations accepted by a bulk customer write
+	BatchMaxOperations int
+	// ImportMaxBytes caps the size of an uploaded customer CSV file
+	ImportMaxBytes int
+	// ImportConcurrency bounds the DynamoDB calls a CSV import makes at once
+	ImportConcurrency int
+	// ExportScanSegments is the number of parallel scan segments used by exports
+	ExportScanSegments int
+	// JobsTableName and JobDataTableName store background jobs and their
+	// uploaded input and results
+	JobsTableName    string
+	JobDataTableName string
+	// JobWorkers is the number of background jobs this instance runs at once
+	JobWorkers int
+	// JobRetentionHours is how long jobs and their data are kept
+	JobRetentionHours int
+	// JobImportMaxBytes caps the size of a CSV file imported by a background job
+	JobImportMaxBytes int
+	// OutboxTableName stores customer events until they are published
+	OutboxTableName string
+	// OutboxMaxAttempts is the number of times an event is published before
+	// it is dead-lettered in the outbox
+	OutboxMaxAttempts int
+	// EventSink selects where customer events are published: "log" writes
+	// them to the log, "webhook" posts them to EventWebhookURL and "none"
+	// disables events
+	EventSink       string
+	EventWebhookURL string
+	// EventSource selects where events are read from: "outbox" records them
+	// with the writes made through the API, "stream" derives them from the
+	// customers table's stream so writes bypassing the API are published too
+	EventSource string
+	// StreamCheckpointsTableName stores how far stream consumers have read
+	StreamCheckpointsTableName string
+	// WebhooksTableName and WebhookDeliveriesTableName store webhook
+	// subscriptions and their delivery log
+	WebhooksTableName          string
+	WebhookDeliveriesTableName string
+	// WebhookMaxAttempts is the number of attempts before a delivery is dead
+	WebhookMaxAttempts int
+	// WebhookRetentionHours is how long deliveries are kept
+	WebhookRetentionHours int
+	// WebhookAllowPrivateNetworks lets webhooks reach loopback, link-local
+	// and private addresses, for local development
+	WebhookAllowPrivateNetworks bool
+	// IdempotencyTableName stores the responses of requests made with an
+	// Idempotency-Key, kept for IdempotencyTTLHours
+	IdempotencyTableName string
+	IdempotencyTTLHours  int
+	// SSEReplaySize is the number of customer changes kept for streams
+	// resuming with Last-Event-ID
+	SSEReplaySize int
+	// SSEHeartbeatSeconds is the interval of keep-alive comments on idle streams
+	SSEHeartbeatSeconds int
+	// DynamoDBTimeoutMillis bounds each DynamoDB operation, retries included.
+	// 0 leaves operations bounded only by their request.
+	DynamoDBTimeoutMillis int
+	// DynamoDBMaxAttempts is how many times a throttled or transiently failed
+	// DynamoDB operation is tried, waiting a jittered backoff doubling from
+	// DynamoDBRetryBaseDelayMillis up to DynamoDBRetryMaxDelayMillis between tries
+	DynamoDBMaxAttempts          int
+	DynamoDBRetryBaseDelayMillis int
+	DynamoDBRetryMaxDelayMillis  int
+	// DynamoDBBreakerThreshold consecutive failed operations open the circuit
+	// breaker, failing DynamoDB operations fast for DynamoDBBreakerCooldownSeconds
+	// before one is let through to probe. 0 disables the breaker.
+	DynamoDBBreakerThreshold       int
+	DynamoDBBreakerCooldownSeconds int
+	// Migrations selects when pending schema migrations are applied:
+	// "startup" applies them before serving, "manual" refuses to start until
+	// they are applied with the migrate subcommand
+	Migrations string
+	// MigrationBatchSize is the number of items a migration rewrites between checkpoints
+	MigrationBatchSize int
+	// DynamoDBBillingMode is how the tables created are billed: "PAY_PER_REQUEST",
+	// or "PROVISIONED" with DynamoDBReadCapacity and DynamoDBWriteCapacity units
+	// for each table and index
+	DynamoDBBillingMode   string
+	DynamoDBReadCapacity  int
+	DynamoDBWriteCapacity int
+	// DynamoDBMaxReadRequestUnits and DynamoDBMaxWriteRequestUnits cap the
+	// throughput of on-demand tables. 0 leaves it uncapped.
+	DynamoDBMaxReadRequestUnits  int
+	DynamoDBMaxWriteRequestUnits int
+	// DynamoDBAutoscaling leaves the capacity of provisioned tables to
+	// autoscaling: the configured capacities only apply to new tables
+	DynamoDBAutoscaling bool
+	// DynamoDBKMSKeyID encrypts tables with a KMS key. "" uses the key owned by DynamoDB.
+	DynamoDBKMSKeyID            string
+	DynamoDBPointInTimeRecovery bool
+	DynamoDBDeletionProtection  bool
+	// DynamoDBReconcile selects what startup does when an existing table's
+	// settings differ from these: "off" ignores it, "log" logs the differences
+	// and "apply" updates the table
+	DynamoDBReconcile string
+}
+
+// strictIntSettings are the integer settings whose malformed values fail Load
+// rather than fall back to their defaults
+var strictIntSettings = []string{
+	"DYNAMODB_TIMEOUT_MS",
+	"DYNAMODB_MAX_ATTEMPTS",
+	"DYNAMODB_RETRY_BASE_DELAY_MS",
+	"DYNAMODB_RETRY_MAX_DELAY_MS",
+	"DYNAMODB_BREAKER_THRESHOLD",
+	"DYNAMODB_BREAKER_COOLDOWN_SECONDS",
+	"DYNAMODB_READ_CAPACITY",
+	"DYNAMODB_WRITE_CAPACITY",
+	"DYNAMODB_MAX_READ_REQUEST_UNITS",
+	"DYNAMODB_MAX_WRITE_REQUEST_UNITS",
+}
+
+// APIToken identifies a caller and the permissions granted to it
+type APIToken struct {
+	Principal   string
+	Permissions []string
 }
 
 // Load returns configuration loaded from environment variables
-func Load() *Config {
-	return &Config{
-		AWSRegion:        getEnv("AWS_REGION", "us-east-1"),
-		DynamoDBEndpoint: getEnv("DYNAMODB_ENDPOINT", "http://localhost:8000"),
-		TableName:        getEnv("TABLE_NAME", "Customers"),
-		Port:             getEnv("PORT", "8080"),
+func Load() (*Config, error) {
+	cfg := &Config{
+		AWSRegion:         getEnv("AWS_REGION", "us-east-1"),
+		DynamoDBEndpoint:  getEnv("DYNAMODB_ENDPOINT", "http://localhost:8000"),
+		TableName:         getEnv("TABLE_NAME", "Customers"),
+		SettingsTableName: getEnv("SETTINGS_TABLE_NAME", "CustomerSettings"),
+		TagsTableName:     getEnv("TAGS_TABLE_NAME", "CustomerTags"),
+		HistoryTableName:  getEnv("HISTORY_TABLE_NAME", "CustomerHistory"),
+		NotesTableName:    getEnv("NOTES_TABLE_NAME", "CustomerNotes"),
+		Port:              getEnv("PORT", "8080"),
+		MetadataMaxBytes:  getEnvInt("METADATA_MAX_BYTES", 16384),
+		NotesDeletePolicy: getEnv("NOTES_DELETE_POLICY", "cascade"),
+		APITokens:         parseAPITokens(getEnv("API_TOKENS", "")),
+
+		EncryptionProvider: getEnv("ENCRYPTION_PROVIDER", ""),
+		EncryptionKeyFile:  getEnv("ENCRYPTION_KEY_FILE", "keys.json"),
+		EncryptionKMSKeyID: getEnv("ENCRYPTION_KMS_KEY_ID", ""),
+		EncryptionFields:   getEnvList("ENCRYPTION_FIELDS", []string{"name", "email", "telephone"}),
+		BlindIndexKey:      getEnv("BLIND_INDEX_KEY", ""),
+		BatchMaxOperations: getEnvInt("BATCH_MAX_OPERATIONS", 100),
+		ImportMaxBytes:     getEnvInt("IMPORT_MAX_BYTES", 10<<20),
+		ImportConcurrency:  getEnvInt("IMPORT_CONCURRENCY", 4),
+		ExportScanSegments: getEnvInt("EXPORT_SCAN_SEGMENTS", 1),
+		JobsTableName:      getEnv("JOBS_TABLE_NAME", "CustomerJobs"),
+		JobDataTableName:   getEnv("JOB_DATA_TABLE_NAME", "CustomerJobData"),
+		JobWorkers:         getEnvInt("JOB_WORKERS", 2),
+		JobRetentionHours:  getEnvInt("JOB_RETENTION_HOURS", 168),
+		JobImportMaxBytes:  getEnvInt("JOB_IMPORT_MAX_BYTES", 100<<20),
+		OutboxTableName:    getEnv("OUTBOX_TABLE_NAME", "CustomerOutbox"),
+		OutboxMaxAttempts:  getEnvInt("OUTBOX_MAX_ATTEMPTS", 20),
+		EventSink:          getEnv("EVENT_SINK", "log"),
+		EventWebhookURL:    getEnv("EVENT_WEBHOOK_URL", ""),
+		EventSource:        getEnv("EVENT_SOURCE", "outbox"),
+
+		StreamCheckpointsTableName: getEnv("STREAM_CHECKPOINTS_TABLE_NAME", "CustomerStreamCheckpoints"),
+
+		WebhooksTableName:           getEnv("WEBHOOKS_TABLE_NAME", "CustomerWebhooks"),
+		WebhookDeliveriesTableName:  getEnv("WEBHOOK_DELIVERIES_TABLE_NAME", "CustomerWebhookDeliveries"),
+		WebhookMaxAttempts:          getEnvInt("WEBHOOK_MAX_ATTEMPTS", 8),
+		WebhookRetentionHours:       getEnvInt("WEBHOOK_RETENTION_HOURS", 168),
+		WebhookAllowPrivateNetworks: getEnvBool("WEBHOOK_ALLOW_PRIVATE_NETWORKS", false),
+
+		IdempotencyTableName: getEnv("IDEMPOTENCY_TABLE_NAME", "CustomerIdempotencyKeys"),
+		IdempotencyTTLHours:  getEnvInt("IDEMPOTENCY_TTL_HOURS", 24),
+
+		SSEReplaySize:       getEnvInt("SSE_REPLAY_SIZE", 1000),
+		SSEHeartbeatSeconds: getEnvInt("SSE_HEARTBEAT_SECONDS", 15),
+
+		DynamoDBTimeoutMillis:          getEnvInt("DYNAMODB_TIMEOUT_MS", 5000),
+		DynamoDBMaxAttempts:            getEnvInt("DYNAMODB_MAX_ATTEMPTS", 4),
+		DynamoDBRetryBaseDelayMillis:   getEnvInt("DYNAMODB_RETRY_BASE_DELAY_MS", 50),
+		DynamoDBRetryMaxDelayMillis:    getEnvInt("DYNAMODB_RETRY_MAX_DELAY_MS", 1000),
+		DynamoDBBreakerThreshold:       getEnvInt("DYNAMODB_BREAKER_THRESHOLD", 5),
+		DynamoDBBreakerCooldownSeconds: getEnvInt("DYNAMODB_BREAKER_COOLDOWN_SECONDS", 10),
+
+		Migrations:         getEnv("MIGRATIONS", "startup"),
+		MigrationBatchSize: getEnvInt("MIGRATION_BATCH_SIZE", 100),
+
+		DynamoDBBillingMode:          getEnv("DYNAMODB_BILLING_MODE", "PAY_PER_REQUEST"),
+		DynamoDBReadCapacity:         getEnvInt("DYNAMODB_READ_CAPACITY", 5),
+		DynamoDBWriteCapacity:        getEnvInt("DYNAMODB_WRITE_CAPACITY", 5),
+		DynamoDBMaxReadRequestUnits:  getEnvInt("DYNAMODB_MAX_READ_REQUEST_UNITS", 0),
+		DynamoDBMaxWriteRequestUnits: getEnvInt("DYNAMODB_MAX_WRITE_REQUEST_UNITS", 0),
+		DynamoDBAutoscaling:          getEnvBool("DYNAMODB_AUTOSCALING", false),
+		DynamoDBKMSKeyID:             getEnv("DYNAMODB_KMS_KEY_ID", ""),
+		DynamoDBPointInTimeRecovery:  getEnvBool("DYNAMODB_POINT_IN_TIME_RECOVERY", false),
+		DynamoDBDeletionProtection:   getEnvBool("DYNAMODB_DELETION_PROTECTION", false),
+		DynamoDBReconcile:            getEnv("DYNAMODB_RECONCILE", "log"),
+	}
+
+	if err := checkEnvInts(strictIntSettings); err != nil {
+		return nil, err
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// validate rejects settings whose values aren't among the accepted ones
+func (c *Config) validate() error {
+	switch c.NotesDeletePolicy {
+	case "cascade", "block":
+	default:
+		return fmt.Errorf("NOTES_DELETE_POLICY must be cascade or block, got %q", c.NotesDeletePolicy)
+	}
+	switch c.EventSink {
+	case "log", "none":
+	case "webhook":
+		if c.EventWebhookURL == "" {
+			return errors.New("EVENT_WEBHOOK_URL is required by the webhook event sink")
+		}
+	default:
+		return fmt.Errorf("EVENT_SINK must be log, webhook or none, got %q", c.EventSink)
 	}
+	switch c.EventSource {
+	case "outbox", "stream":
+	default:
+		return fmt.Errorf("EVENT_SOURCE must be outbox or stream, got %q", c.EventSource)
+	}
+	switch c.DynamoDBReconcile {
+	case "off", "log", "apply":
+	default:
+		return fmt.Errorf("DYNAMODB_RECONCILE must be off, log or apply, got %q", c.DynamoDBReconcile)
+	}
+	return nil
 }
 
 // getEnv retrieves an environment variable or returns a default value
@@ -27,3 +267,71 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvInt retrieves an integer environment variable or returns a default value
+func getEnvInt(key string, fallback int) int {
+	value, err := strconv.Atoi(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// checkEnvInts rejects malformed values of integer environment variables
+func checkEnvInts(keys []string) error {
+	for _, key := range keys {
+		value := getEnv(key, "")
+		if value == "" {
+			continue
+		}
+		if _, err := strconv.Atoi(value); err != nil {
+			return fmt.Errorf("%s must be an integer, got %q", key, value)
+		}
+	}
+	return nil
+}
+
+// getEnvBool retrieves a boolean environment variable or returns a default value
+func getEnvBool(key string, fallback bool) bool {
+	value, err := strconv.ParseBool(getEnv(key, ""))
+	if err != nil {
+		return fallback
+	}
+	return value
+}
+
+// getEnvList retrieves a comma-separated environment variable or returns a default value
+func getEnvList(key string, fallback []string) []string {
+	var values []string
+	for _, value := range strings.Split(getEnv(key, ""), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	if len(values) == 0 {
+		return fallback
+	}
+	return values
+}
+
+// parseAPITokens parses entries of the form "principal:token=perm1,perm2"
+// separated by semicolons. Malformed entries are skipped.
+func parseAPITokens(raw string) map[string]APIToken {
+	tokens := map[string]APIToken{}
+	for _, entry := range strings.Split(raw, ";") {
+		credentials, permissions, _ := strings.Cut(strings.TrimSpace(entry), "=")
+		principal, token, ok := strings.Cut(credentials, ":")
+		if !ok || principal == "" || token == "" {
+			continue
+		}
+
+		apiToken := APIToken{Principal: principal}
+		for _, permission := range strings.Split(permissions, ",") {
+			if permission = strings.TrimSpace(permission); permission != "" {
+				apiToken.Permissions = append(apiToken.Permissions, permission)
+			}
+		}
+		tokens[token] = apiToken
+	}
+	return tokens
+}
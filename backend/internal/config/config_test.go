@@ -5,13 +5,22 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// mustLoad loads the configuration, failing the test on invalid settings
+func mustLoad(t *testing.T) *Config {
+	t.Helper()
+	cfg, err := Load()
+	require.NoError(t, err)
+	return cfg
+}
+
 func TestLoad_WithDefaults(t *testing.T) {
 	// Clear environment variables to ensure defaults are used
 	clearEnvironmentVariables()
 
-	cfg := Load()
+	cfg := mustLoad(t)
 
 	assert.Equal(t, "us-east-1", cfg.AWSRegion)
 	assert.Equal(t, "http://localhost:8000", cfg.DynamoDBEndpoint)
@@ -28,7 +37,7 @@ func TestLoad_WithEnvironmentVariables(t *testing.T) {
 
 	defer clearEnvironmentVariables()
 
-	cfg := Load()
+	cfg := mustLoad(t)
 
 	assert.Equal(t, "us-west-2", cfg.AWSRegion)
 	assert.Equal(t, "https://dynamodb.us-west-2.amazonaws.com", cfg.DynamoDBEndpoint)
@@ -46,7 +55,7 @@ func TestLoad_WithPartialEnvironmentVariables(t *testing.T) {
 
 	defer clearEnvironmentVariables()
 
-	cfg := Load()
+	cfg := mustLoad(t)
 
 	// Should use environment variables where set
 	assert.Equal(t, "eu-west-1", cfg.AWSRegion)
@@ -66,7 +75,7 @@ func TestLoad_WithEmptyEnvironmentVariables(t *testing.T) {
 
 	defer clearEnvironmentVariables()
 
-	cfg := Load()
+	cfg := mustLoad(t)
 
 	// Should use defaults when environment variables are empty
 	assert.Equal(t, "us-east-1", cfg.AWSRegion)
@@ -113,27 +122,486 @@ func TestConfig_StructFields(t *testing.T) {
 }
 
 func TestLoad_MultipleCalls(t *testing.T) {
-	// Ensure each call to Load() returns a new instance with current environment
+	// Ensure each call to Load returns a new instance with current environment
 	clearEnvironmentVariables()
 
-	cfg1 := Load()
+	cfg1 := mustLoad(t)
 	assert.Equal(t, "us-east-1", cfg1.AWSRegion)
 
 	// Change environment variable
 	os.Setenv("AWS_REGION", "ap-south-1")
 	defer os.Unsetenv("AWS_REGION")
 
-	cfg2 := Load()
+	cfg2 := mustLoad(t)
 	assert.Equal(t, "ap-south-1", cfg2.AWSRegion)
 
 	// Original config should still have the old value
 	assert.Equal(t, "us-east-1", cfg1.AWSRegion)
 }
 
+func TestLoad_MetadataSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerSettings", cfg.SettingsTableName)
+	assert.Equal(t, "CustomerTags", cfg.TagsTableName)
+	assert.Equal(t, "CustomerHistory", cfg.HistoryTableName)
+	assert.Equal(t, 16384, cfg.MetadataMaxBytes)
+
+	os.Setenv("SETTINGS_TABLE_NAME", "TestSettings")
+	os.Setenv("METADATA_MAX_BYTES", "1024")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "TestSettings", cfg.SettingsTableName)
+	assert.Equal(t, 1024, cfg.MetadataMaxBytes)
+}
+
+func TestGetEnvInt_WithInvalidValue(t *testing.T) {
+	os.Setenv("INVALID_INT_VAR", "not-a-number")
+	defer os.Unsetenv("INVALID_INT_VAR")
+
+	result := getEnvInt("INVALID_INT_VAR", 42)
+	assert.Equal(t, 42, result)
+}
+
+func TestLoad_NotesSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerNotes", cfg.NotesTableName)
+	assert.Equal(t, "cascade", cfg.NotesDeletePolicy)
+
+	os.Setenv("NOTES_TABLE_NAME", "TestNotes")
+	os.Setenv("NOTES_DELETE_POLICY", "block")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "TestNotes", cfg.NotesTableName)
+	assert.Equal(t, "block", cfg.NotesDeletePolicy)
+}
+
+func TestLoad_InvalidNotesDeletePolicy(t *testing.T) {
+	clearEnvironmentVariables()
+	os.Setenv("NOTES_DELETE_POLICY", "keep")
+	defer clearEnvironmentVariables()
+
+	cfg, err := Load()
+
+	assert.Nil(t, cfg)
+	assert.ErrorContains(t, err, "NOTES_DELETE_POLICY")
+}
+
+func TestParseAPITokens(t *testing.T) {
+	tokens := parseAPITokens("alice:s3cret=customers:admin, customers:pii;ops:t0ken=;malformed;:missing=x;nobody:=x")
+
+	assert.Len(t, tokens, 2)
+	assert.Equal(t, APIToken{Principal: "alice", Permissions: []string{"customers:admin", "customers:pii"}}, tokens["s3cret"])
+	assert.Equal(t, APIToken{Principal: "ops"}, tokens["t0ken"])
+}
+
+func TestLoad_APITokens(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Empty(t, cfg.APITokens)
+
+	os.Setenv("API_TOKENS", "alice:s3cret=customers:admin")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "alice", cfg.APITokens["s3cret"].Principal)
+}
+
+func TestLoad_EncryptionSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "", cfg.EncryptionProvider)
+	assert.Equal(t, []string{"name", "email", "telephone"}, cfg.EncryptionFields)
+
+	os.Setenv("ENCRYPTION_PROVIDER", "kms")
+	os.Setenv("ENCRYPTION_KMS_KEY_ID", "alias/customers")
+	os.Setenv("ENCRYPTION_FIELDS", "email, telephone")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "kms", cfg.EncryptionProvider)
+	assert.Equal(t, "alias/customers", cfg.EncryptionKMSKeyID)
+	assert.Equal(t, []string{"email", "telephone"}, cfg.EncryptionFields)
+}
+
+func TestLoad_BatchSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, 100, cfg.BatchMaxOperations)
+
+	os.Setenv("BATCH_MAX_OPERATIONS", "25")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, 25, cfg.BatchMaxOperations)
+}
+
+func TestLoad_ImportExportSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, 10<<20, cfg.ImportMaxBytes)
+	assert.Equal(t, 4, cfg.ImportConcurrency)
+	assert.Equal(t, 1, cfg.ExportScanSegments)
+
+	os.Setenv("IMPORT_MAX_BYTES", "1024")
+	os.Setenv("IMPORT_CONCURRENCY", "8")
+	os.Setenv("EXPORT_SCAN_SEGMENTS", "4")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, 1024, cfg.ImportMaxBytes)
+	assert.Equal(t, 8, cfg.ImportConcurrency)
+	assert.Equal(t, 4, cfg.ExportScanSegments)
+}
+
+func TestLoad_JobSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerJobs", cfg.JobsTableName)
+	assert.Equal(t, "CustomerJobData", cfg.JobDataTableName)
+	assert.Equal(t, 2, cfg.JobWorkers)
+	assert.Equal(t, 168, cfg.JobRetentionHours)
+	assert.Equal(t, 100<<20, cfg.JobImportMaxBytes)
+
+	os.Setenv("JOBS_TABLE_NAME", "Jobs")
+	os.Setenv("JOB_DATA_TABLE_NAME", "JobData")
+	os.Setenv("JOB_WORKERS", "5")
+	os.Setenv("JOB_RETENTION_HOURS", "24")
+	os.Setenv("JOB_IMPORT_MAX_BYTES", "2048")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "Jobs", cfg.JobsTableName)
+	assert.Equal(t, "JobData", cfg.JobDataTableName)
+	assert.Equal(t, 5, cfg.JobWorkers)
+	assert.Equal(t, 24, cfg.JobRetentionHours)
+	assert.Equal(t, 2048, cfg.JobImportMaxBytes)
+}
+
+func TestLoad_EventSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerOutbox", cfg.OutboxTableName)
+	assert.Equal(t, 20, cfg.OutboxMaxAttempts)
+	assert.Equal(t, "log", cfg.EventSink)
+	assert.Equal(t, "", cfg.EventWebhookURL)
+
+	os.Setenv("OUTBOX_TABLE_NAME", "Outbox")
+	os.Setenv("OUTBOX_MAX_ATTEMPTS", "5")
+	os.Setenv("EVENT_SINK", "webhook")
+	os.Setenv("EVENT_WEBHOOK_URL", "https://example.com/events")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "Outbox", cfg.OutboxTableName)
+	assert.Equal(t, 5, cfg.OutboxMaxAttempts)
+	assert.Equal(t, "webhook", cfg.EventSink)
+	assert.Equal(t, "https://example.com/events", cfg.EventWebhookURL)
+}
+
+func TestLoad_StreamSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "outbox", cfg.EventSource)
+	assert.Equal(t, "CustomerStreamCheckpoints", cfg.StreamCheckpointsTableName)
+
+	os.Setenv("EVENT_SOURCE", "stream")
+	os.Setenv("STREAM_CHECKPOINTS_TABLE_NAME", "Checkpoints")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "stream", cfg.EventSource)
+	assert.Equal(t, "Checkpoints", cfg.StreamCheckpointsTableName)
+}
+
+func TestLoad_InvalidEventSettings(t *testing.T) {
+	tests := []struct {
+		name     string
+		env      map[string]string
+		expected string
+	}{
+		{name: "unknown sink", env: map[string]string{"EVENT_SINK": "kafka"}, expected: "EVENT_SINK"},
+		{name: "webhook sink without URL", env: map[string]string{"EVENT_SINK": "webhook"}, expected: "EVENT_WEBHOOK_URL"},
+		{name: "unknown source", env: map[string]string{"EVENT_SOURCE": "queue"}, expected: "EVENT_SOURCE"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearEnvironmentVariables()
+			for key, value := range tt.env {
+				os.Setenv(key, value)
+			}
+			defer clearEnvironmentVariables()
+
+			cfg, err := Load()
+
+			assert.Nil(t, cfg)
+			assert.ErrorContains(t, err, tt.expected)
+		})
+	}
+}
+
+func TestLoad_IdempotencySettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerIdempotencyKeys", cfg.IdempotencyTableName)
+	assert.Equal(t, 24, cfg.IdempotencyTTLHours)
+
+	os.Setenv("IDEMPOTENCY_TABLE_NAME", "Keys")
+	os.Setenv("IDEMPOTENCY_TTL_HOURS", "48")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "Keys", cfg.IdempotencyTableName)
+	assert.Equal(t, 48, cfg.IdempotencyTTLHours)
+}
+
+func TestLoad_WebhookSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "CustomerWebhooks", cfg.WebhooksTableName)
+	assert.Equal(t, "CustomerWebhookDeliveries", cfg.WebhookDeliveriesTableName)
+	assert.Equal(t, 8, cfg.WebhookMaxAttempts)
+	assert.Equal(t, 168, cfg.WebhookRetentionHours)
+	assert.False(t, cfg.WebhookAllowPrivateNetworks)
+
+	os.Setenv("WEBHOOKS_TABLE_NAME", "Webhooks")
+	os.Setenv("WEBHOOK_DELIVERIES_TABLE_NAME", "Deliveries")
+	os.Setenv("WEBHOOK_MAX_ATTEMPTS", "3")
+	os.Setenv("WEBHOOK_RETENTION_HOURS", "24")
+	os.Setenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS", "true")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "Webhooks", cfg.WebhooksTableName)
+	assert.Equal(t, "Deliveries", cfg.WebhookDeliveriesTableName)
+	assert.Equal(t, 3, cfg.WebhookMaxAttempts)
+	assert.Equal(t, 24, cfg.WebhookRetentionHours)
+	assert.True(t, cfg.WebhookAllowPrivateNetworks)
+}
+
+func TestLoad_SSESettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, 1000, cfg.SSEReplaySize)
+	assert.Equal(t, 15, cfg.SSEHeartbeatSeconds)
+
+	os.Setenv("SSE_REPLAY_SIZE", "50")
+	os.Setenv("SSE_HEARTBEAT_SECONDS", "5")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, 50, cfg.SSEReplaySize)
+	assert.Equal(t, 5, cfg.SSEHeartbeatSeconds)
+}
+
+func TestLoad_DynamoDBTimeout(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, 5000, cfg.DynamoDBTimeoutMillis)
+
+	os.Setenv("DYNAMODB_TIMEOUT_MS", "250")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, 250, cfg.DynamoDBTimeoutMillis)
+}
+
+func TestLoad_DynamoDBResilienceSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, 4, cfg.DynamoDBMaxAttempts)
+	assert.Equal(t, 50, cfg.DynamoDBRetryBaseDelayMillis)
+	assert.Equal(t, 1000, cfg.DynamoDBRetryMaxDelayMillis)
+	assert.Equal(t, 5, cfg.DynamoDBBreakerThreshold)
+	assert.Equal(t, 10, cfg.DynamoDBBreakerCooldownSeconds)
+
+	os.Setenv("DYNAMODB_MAX_ATTEMPTS", "2")
+	os.Setenv("DYNAMODB_RETRY_BASE_DELAY_MS", "10")
+	os.Setenv("DYNAMODB_RETRY_MAX_DELAY_MS", "200")
+	os.Setenv("DYNAMODB_BREAKER_THRESHOLD", "0")
+	os.Setenv("DYNAMODB_BREAKER_COOLDOWN_SECONDS", "60")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, 2, cfg.DynamoDBMaxAttempts)
+	assert.Equal(t, 10, cfg.DynamoDBRetryBaseDelayMillis)
+	assert.Equal(t, 200, cfg.DynamoDBRetryMaxDelayMillis)
+	assert.Equal(t, 0, cfg.DynamoDBBreakerThreshold)
+	assert.Equal(t, 60, cfg.DynamoDBBreakerCooldownSeconds)
+}
+
+func TestLoad_MigrationSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "startup", cfg.Migrations)
+	assert.Equal(t, 100, cfg.MigrationBatchSize)
+
+	os.Setenv("MIGRATIONS", "manual")
+	os.Setenv("MIGRATION_BATCH_SIZE", "25")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "manual", cfg.Migrations)
+	assert.Equal(t, 25, cfg.MigrationBatchSize)
+}
+
+func TestLoad_DynamoDBTableSettings(t *testing.T) {
+	clearEnvironmentVariables()
+
+	cfg := mustLoad(t)
+	assert.Equal(t, "PAY_PER_REQUEST", cfg.DynamoDBBillingMode)
+	assert.Equal(t, 5, cfg.DynamoDBReadCapacity)
+	assert.Equal(t, 5, cfg.DynamoDBWriteCapacity)
+	assert.Equal(t, 0, cfg.DynamoDBMaxReadRequestUnits)
+	assert.Equal(t, 0, cfg.DynamoDBMaxWriteRequestUnits)
+	assert.False(t, cfg.DynamoDBAutoscaling)
+	assert.Equal(t, "", cfg.DynamoDBKMSKeyID)
+	assert.False(t, cfg.DynamoDBPointInTimeRecovery)
+	assert.False(t, cfg.DynamoDBDeletionProtection)
+	assert.Equal(t, "log", cfg.DynamoDBReconcile)
+
+	os.Setenv("DYNAMODB_BILLING_MODE", "PROVISIONED")
+	os.Setenv("DYNAMODB_READ_CAPACITY", "100")
+	os.Setenv("DYNAMODB_WRITE_CAPACITY", "50")
+	os.Setenv("DYNAMODB_MAX_READ_REQUEST_UNITS", "1000")
+	os.Setenv("DYNAMODB_MAX_WRITE_REQUEST_UNITS", "500")
+	os.Setenv("DYNAMODB_AUTOSCALING", "true")
+	os.Setenv("DYNAMODB_KMS_KEY_ID", "alias/customers")
+	os.Setenv("DYNAMODB_POINT_IN_TIME_RECOVERY", "1")
+	os.Setenv("DYNAMODB_DELETION_PROTECTION", "true")
+	os.Setenv("DYNAMODB_RECONCILE", "apply")
+	defer clearEnvironmentVariables()
+
+	cfg = mustLoad(t)
+	assert.Equal(t, "PROVISIONED", cfg.DynamoDBBillingMode)
+	assert.Equal(t, 100, cfg.DynamoDBReadCapacity)
+	assert.Equal(t, 50, cfg.DynamoDBWriteCapacity)
+	assert.Equal(t, 1000, cfg.DynamoDBMaxReadRequestUnits)
+	assert.Equal(t, 500, cfg.DynamoDBMaxWriteRequestUnits)
+	assert.True(t, cfg.DynamoDBAutoscaling)
+	assert.Equal(t, "alias/customers", cfg.DynamoDBKMSKeyID)
+	assert.True(t, cfg.DynamoDBPointInTimeRecovery)
+	assert.True(t, cfg.DynamoDBDeletionProtection)
+	assert.Equal(t, "apply", cfg.DynamoDBReconcile)
+}
+
+func TestLoad_InvalidDynamoDBReconcile(t *testing.T) {
+	clearEnvironmentVariables()
+	os.Setenv("DYNAMODB_RECONCILE", "fix")
+	defer clearEnvironmentVariables()
+
+	cfg, err := Load()
+
+	assert.Nil(t, cfg)
+	assert.ErrorContains(t, err, "DYNAMODB_RECONCILE")
+}
+
+func TestLoad_MalformedStrictInts(t *testing.T) {
+	for _, key := range strictIntSettings {
+		t.Run(key, func(t *testing.T) {
+			clearEnvironmentVariables()
+			os.Setenv(key, "5ms")
+			defer clearEnvironmentVariables()
+
+			cfg, err := Load()
+
+			assert.Nil(t, cfg)
+			assert.ErrorContains(t, err, key)
+		})
+	}
+}
+
+func TestGetEnvBool_Invalid(t *testing.T) {
+	os.Setenv("BOOL_VAR", "maybe")
+	defer os.Unsetenv("BOOL_VAR")
+
+	assert.True(t, getEnvBool("BOOL_VAR", true))
+}
+
+func TestGetEnvList_WithBlankEntries(t *testing.T) {
+	os.Setenv("LIST_VAR", " , ,")
+	defer os.Unsetenv("LIST_VAR")
+
+	assert.Equal(t, []string{"default"}, getEnvList("LIST_VAR", []string{"default"}))
+}
+
 // Helper function to clear all environment variables used by the config
 func clearEnvironmentVariables() {
 	os.Unsetenv("AWS_REGION")
 	os.Unsetenv("DYNAMODB_ENDPOINT")
 	os.Unsetenv("TABLE_NAME")
 	os.Unsetenv("PORT")
+	os.Unsetenv("SETTINGS_TABLE_NAME")
+	os.Unsetenv("TAGS_TABLE_NAME")
+	os.Unsetenv("HISTORY_TABLE_NAME")
+	os.Unsetenv("NOTES_TABLE_NAME")
+	os.Unsetenv("NOTES_DELETE_POLICY")
+	os.Unsetenv("API_TOKENS")
+	os.Unsetenv("ENCRYPTION_PROVIDER")
+	os.Unsetenv("ENCRYPTION_KEY_FILE")
+	os.Unsetenv("ENCRYPTION_KMS_KEY_ID")
+	os.Unsetenv("ENCRYPTION_FIELDS")
+	os.Unsetenv("BLIND_INDEX_KEY")
+	os.Unsetenv("METADATA_MAX_BYTES")
+	os.Unsetenv("BATCH_MAX_OPERATIONS")
+	os.Unsetenv("IMPORT_MAX_BYTES")
+	os.Unsetenv("IMPORT_CONCURRENCY")
+	os.Unsetenv("EXPORT_SCAN_SEGMENTS")
+	os.Unsetenv("JOBS_TABLE_NAME")
+	os.Unsetenv("JOB_DATA_TABLE_NAME")
+	os.Unsetenv("JOB_WORKERS")
+	os.Unsetenv("JOB_RETENTION_HOURS")
+	os.Unsetenv("JOB_IMPORT_MAX_BYTES")
+	os.Unsetenv("OUTBOX_TABLE_NAME")
+	os.Unsetenv("OUTBOX_MAX_ATTEMPTS")
+	os.Unsetenv("EVENT_SINK")
+	os.Unsetenv("EVENT_WEBHOOK_URL")
+	os.Unsetenv("EVENT_SOURCE")
+	os.Unsetenv("IDEMPOTENCY_TABLE_NAME")
+	os.Unsetenv("IDEMPOTENCY_TTL_HOURS")
+	os.Unsetenv("STREAM_CHECKPOINTS_TABLE_NAME")
+	os.Unsetenv("WEBHOOKS_TABLE_NAME")
+	os.Unsetenv("WEBHOOK_DELIVERIES_TABLE_NAME")
+	os.Unsetenv("WEBHOOK_MAX_ATTEMPTS")
+	os.Unsetenv("WEBHOOK_RETENTION_HOURS")
+	os.Unsetenv("WEBHOOK_ALLOW_PRIVATE_NETWORKS")
+	os.Unsetenv("SSE_REPLAY_SIZE")
+	os.Unsetenv("SSE_HEARTBEAT_SECONDS")
+	os.Unsetenv("DYNAMODB_TIMEOUT_MS")
+	os.Unsetenv("DYNAMODB_MAX_ATTEMPTS")
+	os.Unsetenv("DYNAMODB_RETRY_BASE_DELAY_MS")
+	os.Unsetenv("DYNAMODB_RETRY_MAX_DELAY_MS")
+	os.Unsetenv("DYNAMODB_BREAKER_THRESHOLD")
+	os.Unsetenv("DYNAMODB_BREAKER_COOLDOWN_SECONDS")
+	os.Unsetenv("MIGRATIONS")
+	os.Unsetenv("MIGRATION_BATCH_SIZE")
+	os.Unsetenv("DYNAMODB_BILLING_MODE")
+	os.Unsetenv("DYNAMODB_READ_CAPACITY")
+	os.Unsetenv("DYNAMODB_WRITE_CAPACITY")
+	os.Unsetenv("DYNAMODB_MAX_READ_REQUEST_UNITS")
+	os.Unsetenv("DYNAMODB_MAX_WRITE_REQUEST_UNITS")
+	os.Unsetenv("DYNAMODB_AUTOSCALING")
+	os.Unsetenv("DYNAMODB_KMS_KEY_ID")
+	os.Unsetenv("DYNAMODB_POINT_IN_TIME_RECOVERY")
+	os.Unsetenv("DYNAMODB_DELETION_PROTECTION")
+	os.Unsetenv("DYNAMODB_RECONCILE")
 }
@@ -0,0 +1,111 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+const (
+	// maxBatchWriteItems is DynamoDB's limit of requests per BatchWriteItem call
+	maxBatchWriteItems = 25
+	// maxBatchGetItems is DynamoDB's limit of keys per BatchGetItem call
+	maxBatchGetItems = 100
+	// maxBatchRetries bounds how often unprocessed items are resubmitted
+	maxBatchRetries = 8
+	// batchBaseBackoff is the delay before the first resubmission, doubled on each retry
+	batchBaseBackoff = 50 * time.Millisecond
+)
+
+// batchWrite submits write requests to a table in chunks of 25, resubmitting
+// unprocessed items with exponential backoff
+func batchWrite(ctx context.Context, client *Client, tableName string, requests []types.WriteRequest) error {
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		unprocessed, err := writeChunk(ctx, client, tableName, requests[start:end])
+		if err != nil {
+			return err
+		}
+		if len(unprocessed) > 0 {
+			return fmt.Errorf("failed to write %d items after %d retries", len(unprocessed), maxBatchRetries)
+		}
+	}
+
+	return nil
+}
+
+// writeChunk submits up to 25 write requests, resubmitting unprocessed items
+// with exponential backoff. It returns the requests still unprocessed once
+// the retries are exhausted.
+func writeChunk(ctx context.Context, client *Client, tableName string, requests []types.WriteRequest) ([]types.WriteRequest, error) {
+	pending := map[string][]types.WriteRequest{tableName: requests}
+	for attempt := 0; len(pending[tableName]) > 0; attempt++ {
+		if attempt > maxBatchRetries {
+			return pending[tableName], nil
+		}
+		if attempt > 0 {
+			time.Sleep(batchBaseBackoff << (attempt - 1))
+		}
+
+		result, err := client.BatchWriteItem(ctx, &dynamodb.BatchWriteItemInput{RequestItems: pending})
+		if err != nil {
+			return nil, fmt.Errorf("failed to batch write items: %w", err)
+		}
+		pending = result.UnprocessedItems
+	}
+
+	return nil, nil
+}
+
+// batchGet reads items by key in chunks of 100, resubmitting unprocessed keys
+// with exponential backoff. Items are returned in no particular order and
+// missing keys are skipped.
+func batchGet(ctx context.Context, client *Client, tableName string, keys []map[string]types.AttributeValue) ([]map[string]types.AttributeValue, error) {
+	var items []map[string]types.AttributeValue
+	for start := 0; start < len(keys); start += maxBatchGetItems {
+		end := start + maxBatchGetItems
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		pending := map[string]types.KeysAndAttributes{tableName: {Keys: keys[start:end]}}
+		for attempt := 0; len(pending[tableName].Keys) > 0; attempt++ {
+			if attempt > maxBatchRetries {
+				return nil, fmt.Errorf("failed to read %d items after %d retries", len(pending[tableName].Keys), maxBatchRetries)
+			}
+			if attempt > 0 {
+				time.Sleep(batchBaseBackoff << (attempt - 1))
+			}
+
+			result, err := client.BatchGetItem(ctx, &dynamodb.BatchGetItemInput{RequestItems: pending})
+			if err != nil {
+				return nil, fmt.Errorf("failed to batch get items: %w", err)
+			}
+			items = append(items, result.Responses[tableName]...)
+			pending = result.UnprocessedKeys
+		}
+	}
+
+	return items, nil
+}
+
+// putRequest builds a batch put request for an item
+func putRequest(item map[string]types.AttributeValue) types.WriteRequest {
+	return types.WriteRequest{
+		PutRequest: &types.PutRequest{Item: item},
+	}
+}
+
+// deleteRequest builds a batch delete request for a key
+func deleteRequest(key map[string]types.AttributeValue) types.WriteRequest {
+	return types.WriteRequest{
+		DeleteRequest: &types.DeleteRequest{Key: key},
+	}
+}
@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// ErrCircuitOpen is returned without calling DynamoDB while the circuit
+// breaker considers it down
+var ErrCircuitOpen = errors.New("dynamodb circuit breaker is open")
+
+// circuitOpenError is the ErrCircuitOpen of a rejected operation, with the
+// time left before the breaker lets operations through again
+type circuitOpenError struct {
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string { return ErrCircuitOpen.Error() }
+
+func (e *circuitOpenError) Is(target error) bool { return target == ErrCircuitOpen }
+
+// RetryAfter returns how long to wait before retrying an operation the
+// circuit breaker rejected, or false when err isn't such a rejection
+func RetryAfter(err error) (time.Duration, bool) {
+	var open *circuitOpenError
+	if errors.As(err, &open) {
+		return open.retryAfter, true
+	}
+	return 0, false
+}
+
+// metrics counts DynamoDB retries and circuit breaker activity, published
+// with the process's other expvar variables
+var metrics = expvar.NewMap("dynamodb")
+
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half-open"
+)
+
+// outcome is how an operation's result counts towards the breaker's state
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	// outcomeIgnored is the result of operations cancelled by their caller,
+	// which say nothing about DynamoDB's health
+	outcomeIgnored
+)
+
+// probeKey is the context key tagging the operation sent as the probe, with
+// the probe's number
+type probeKey struct{}
+
+// circuitBreaker fails DynamoDB operations fast once threshold operations in
+// a row failed transiently. After cooldown a single operation is let through
+// as a probe: its success closes the breaker, its failure opens it again.
+// Operations sent before the breaker opened don't change the state once it has.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	// probes numbers the probes sent, and probing is set while the last one is in flight
+	probes  uint64
+	probing bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	b := &circuitBreaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+	b.setState(breakerClosed)
+	return b
+}
+
+// addMiddleware makes the operations sent through stack go through the breaker
+func (b *circuitBreaker) addMiddleware(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CircuitBreaker",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			ctx, err := b.allow(ctx)
+			if err != nil {
+				metrics.Add("breakerRejected", 1)
+				return middleware.InitializeOutput{}, middleware.Metadata{}, err
+			}
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			b.record(ctx, operationOutcome(err))
+			return out, metadata, err
+		}), middleware.Before)
+}
+
+// allow returns the context to send an operation with, tagged when the
+// operation is the probe, or a circuitOpenError when it may not be sent
+func (b *circuitBreaker) allow(ctx context.Context) (context.Context, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerOpen:
+		if elapsed := b.now().Sub(b.openedAt); elapsed < b.cooldown {
+			return ctx, &circuitOpenError{retryAfter: b.cooldown - elapsed}
+		}
+		b.setState(breakerHalfOpen)
+	case breakerClosed:
+		return ctx, nil
+	}
+
+	if b.probing {
+		return ctx, &circuitOpenError{retryAfter: b.cooldown}
+	}
+	b.probes++
+	b.probing = true
+	return context.WithValue(ctx, probeKey{}, b.probes), nil
+}
+
+// record counts the outcome of an operation the breaker allowed. While half
+// open, only the outcome of the probe changes the state.
+func (b *circuitBreaker) record(ctx context.Context, result outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case breakerHalfOpen:
+		if probe, _ := ctx.Value(probeKey{}).(uint64); probe != b.probes || !b.probing {
+			return
+		}
+		b.probing = false
+		switch result {
+		case outcomeFailure:
+			b.open()
+		case outcomeSuccess:
+			b.failures = 0
+			b.setState(breakerClosed)
+		}
+		// A cancelled probe leaves the breaker half open for the next operation to probe
+	case breakerClosed:
+		switch result {
+		case outcomeFailure:
+			b.failures++
+			if b.failures >= b.threshold {
+				b.open()
+			}
+		case outcomeSuccess:
+			b.failures = 0
+		}
+	}
+}
+
+func (b *circuitBreaker) open() {
+	b.openedAt = b.now()
+	b.failures = 0
+	metrics.Add("breakerOpened", 1)
+	b.setState(breakerOpen)
+}
+
+func (b *circuitBreaker) setState(state string) {
+	if b.state != "" && b.state != state {
+		log.Printf("DynamoDB circuit breaker %s, was %s", state, b.state)
+	}
+	b.state = state
+	value := new(expvar.String)
+	value.Set(state)
+	metrics.Set("breakerState", value)
+}
+
+// operationOutcome classifies the error an operation returned
+func operationOutcome(err error) outcome {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return outcomeIgnored
+	case transientFailure(err):
+		return outcomeFailure
+	}
+	return outcomeSuccess
+}
+
+// transientFailure reports whether an operation failed because DynamoDB was
+// unavailable, throttling or too slow, rather than rejecting the request
+func transientFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if IsTimeout(err) || retry.IsErrorRetryables(retry.DefaultRetryables).IsErrorRetryable(err) == aws.TrueTernary {
+		return true
+	}
+	var responseErr *awshttp.ResponseError
+	return errors.As(err, &responseErr) && responseErr.HTTPStatusCode() >= 500
+}
@@ -0,0 +1,209 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock is a settable time source for circuit breakers
+type fakeClock struct{ now time.Time }
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func testBreaker(threshold int) (*circuitBreaker, *fakeClock) {
+	clock := &fakeClock{now: time.Now()}
+	breaker := newCircuitBreaker(threshold, 10*time.Second)
+	breaker.now = clock.Now
+	return breaker, clock
+}
+
+// withBreaker sends a client's operations through breaker
+func withBreaker(breaker *circuitBreaker) func(*dynamodb.Options) {
+	return func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, breaker.addMiddleware)
+	}
+}
+
+// allowed reports whether breaker lets an operation through
+func allowed(breaker *circuitBreaker) bool {
+	_, err := breaker.allow(context.Background())
+	return err == nil
+}
+
+func TestCircuitBreaker_OpensAfterConsecutiveFailures(t *testing.T) {
+	breaker, _ := testBreaker(3)
+	ctx := context.Background()
+
+	breaker.record(ctx, outcomeFailure)
+	breaker.record(ctx, outcomeFailure)
+	breaker.record(ctx, outcomeSuccess)
+	breaker.record(ctx, outcomeFailure)
+	breaker.record(ctx, outcomeFailure)
+	assert.True(t, allowed(breaker), "a success resets the count")
+
+	breaker.record(ctx, outcomeFailure)
+	assert.False(t, allowed(breaker))
+	assert.Equal(t, breakerOpen, breaker.state)
+}
+
+func TestCircuitBreaker_ProbesAfterCooldown(t *testing.T) {
+	breaker, clock := testBreaker(1)
+	breaker.record(context.Background(), outcomeFailure)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	probe, err := breaker.allow(context.Background())
+	require.NoError(t, err, "the probe is let through")
+	assert.False(t, allowed(breaker), "only one probe at a time")
+
+	breaker.record(probe, outcomeSuccess)
+	assert.Equal(t, breakerClosed, breaker.state)
+	assert.True(t, allowed(breaker))
+}
+
+func TestCircuitBreaker_FailedProbeReopens(t *testing.T) {
+	breaker, clock := testBreaker(1)
+	breaker.record(context.Background(), outcomeFailure)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	probe, err := breaker.allow(context.Background())
+	require.NoError(t, err)
+	breaker.record(probe, outcomeFailure)
+
+	assert.Equal(t, breakerOpen, breaker.state)
+	assert.False(t, allowed(breaker))
+	clock.now = clock.now.Add(10 * time.Second)
+	assert.True(t, allowed(breaker))
+}
+
+func TestCircuitBreaker_OnlyTheProbeChangesHalfOpen(t *testing.T) {
+	breaker, clock := testBreaker(1)
+	inFlight, err := breaker.allow(context.Background())
+	require.NoError(t, err)
+	breaker.record(context.Background(), outcomeFailure)
+
+	clock.now = clock.now.Add(10 * time.Second)
+	probe, err := breaker.allow(context.Background())
+	require.NoError(t, err)
+
+	breaker.record(inFlight, outcomeSuccess)
+	assert.Equal(t, breakerHalfOpen, breaker.state, "an operation sent before the breaker opened isn't the probe")
+
+	breaker.record(probe, outcomeFailure)
+	assert.Equal(t, breakerOpen, breaker.state)
+}
+
+func TestCircuitBreaker_IgnoresCancelledOperations(t *testing.T) {
+	breaker, clock := testBreaker(2)
+	ctx := context.Background()
+
+	breaker.record(ctx, outcomeFailure)
+	breaker.record(ctx, operationOutcome(context.Canceled))
+	breaker.record(ctx, outcomeFailure)
+	require.Equal(t, breakerOpen, breaker.state, "a cancelled operation doesn't reset the count")
+
+	clock.now = clock.now.Add(10 * time.Second)
+	probe, err := breaker.allow(ctx)
+	require.NoError(t, err)
+	breaker.record(probe, outcomeIgnored)
+
+	assert.Equal(t, breakerHalfOpen, breaker.state)
+	assert.True(t, allowed(breaker), "another operation probes in place of the cancelled one")
+}
+
+func TestCircuitBreaker_RejectionsCarryRetryAfter(t *testing.T) {
+	breaker, clock := testBreaker(1)
+	breaker.record(context.Background(), outcomeFailure)
+	clock.now = clock.now.Add(4 * time.Second)
+
+	_, err := breaker.allow(context.Background())
+
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	retryAfter, ok := RetryAfter(err)
+	assert.True(t, ok)
+	assert.Equal(t, 6*time.Second, retryAfter)
+}
+
+func TestCircuitBreaker_FailsFastWhileOpen(t *testing.T) {
+	calls := 0
+	breaker, _ := testBreaker(2)
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		calls++
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ProvisionedThroughputExceededException",
+			"message": "Throughput exceeded",
+		}
+	}, withBreaker(breaker))
+
+	for i := 0; i < 2; i++ {
+		_, err := GetCustomer(context.Background(), client, "Customers", "123")
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+
+	_, err := GetCustomer(context.Background(), client, "Customers", "123")
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	assert.Equal(t, 2, calls)
+}
+
+func TestCircuitBreaker_IgnoresRejectedRequests(t *testing.T) {
+	breaker, _ := testBreaker(1)
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	}, withBreaker(breaker))
+
+	for i := 0; i < 3; i++ {
+		err := DeleteCustomer(context.Background(), client, "Customers", &models.Customer{ID: "123"})
+		require.Error(t, err)
+		assert.NotErrorIs(t, err, ErrCircuitOpen)
+	}
+	assert.Equal(t, breakerClosed, breaker.state)
+}
+
+func TestNewRetryer_RetriesThrottledOperations(t *testing.T) {
+	calls := 0
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		calls++
+		if calls < 3 {
+			return map[string]interface{}{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ThrottlingException",
+				"message": "Rate exceeded",
+			}
+		}
+		return map[string]interface{}{}
+	}, func(o *dynamodb.Options) {
+		o.Retryer = newRetryer(&config.Config{DynamoDBMaxAttempts: 3, DynamoDBRetryBaseDelayMillis: 1, DynamoDBRetryMaxDelayMillis: 5})
+	})
+
+	_, err := GetCustomer(context.Background(), client, "Customers", "123")
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestNewRetryer_StopsAfterMaxAttempts(t *testing.T) {
+	calls := 0
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		calls++
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ThrottlingException",
+			"message": "Rate exceeded",
+		}
+	}, func(o *dynamodb.Options) {
+		o.Retryer = newRetryer(&config.Config{DynamoDBMaxAttempts: 2, DynamoDBRetryBaseDelayMillis: 1, DynamoDBRetryMaxDelayMillis: 5})
+	})
+
+	_, err := GetCustomer(context.Background(), client, "Customers", "123")
+
+	require.Error(t, err)
+	assert.Equal(t, 2, calls)
+}
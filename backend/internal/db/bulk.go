@@ -0,0 +1,201 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrUnprocessed is reported for batch writes DynamoDB still hadn't processed after every retry
+var ErrUnprocessed = errors.New("write was not processed after retries")
+
+// GetCustomers retrieves customers by ID, keyed by ID. Missing customers are left out.
+func GetCustomers(ctx context.Context, client *Client, tableName string, ids []string) (map[string]*models.Customer, error) {
+	keys := make([]map[string]types.AttributeValue, len(ids))
+	for i, id := range ids {
+		keys[i] = customerKey(id)
+	}
+
+	items, err := batchGet(ctx, client, tableName, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	customers := make(map[string]*models.Customer, len(items))
+	for _, item := range items {
+		var customer models.Customer
+		if err := client.encryptor.unmarshalCustomer(ctx, item, &customer); err != nil {
+			return nil, err
+		}
+		customers[customer.ID] = &customer
+	}
+	return customers, nil
+}
+
+// outboxWriteWorkers bounds the single writes WriteCustomers runs at once
+const outboxWriteWorkers = 8
+
+// WriteCustomers creates, updates and deletes customers. Each write succeeds
+// or fails on its own, so the outcome is reported per customer ID: the
+// returned map holds the IDs that failed. IDs must be unique across all
+// writes. Creates are not checked against existing customers. Updates and
+// deletes apply to customers read at their Version, failing with
+// ErrCustomerChanged if they were written since. Deleting through here leaves
+// tag counts untouched, so customers with tags should use
+// DeleteCustomerWithTags.
+//
+// Creates are sent as batch writes, or as one transaction per customer while
+// events are enabled so that each write carries its event. Updates and
+// deletes are conditional, so they are always written one by one.
+func WriteCustomers(ctx context.Context, client *Client, tableName string, creates, updates, deletes []*models.Customer) map[string]error {
+	for _, customer := range creates {
+		customer.Version = 1
+	}
+
+	var failed map[string]error
+	if client.outboxTable == "" {
+		failed = batchWriteCustomers(ctx, client, tableName, creates)
+		for id, err := range transactWriteCustomers(ctx, client, tableName, nil, updates, deletes) {
+			failed[id] = err
+		}
+	} else {
+		failed = transactWriteCustomers(ctx, client, tableName, creates, updates, deletes)
+	}
+
+	// Keep the search index in step with the writes that went through
+	for _, customers := range [][]*models.Customer{creates, updates} {
+		for _, customer := range customers {
+			if failed[customer.ID] == nil {
+				client.indexCustomer(customer)
+			}
+		}
+	}
+	for _, customer := range deletes {
+		if failed[customer.ID] == nil {
+			client.unindexCustomer(customer.ID)
+		}
+	}
+
+	for id, err := range failed {
+		failed[id] = fmt.Errorf("failed to write customer: %w", err)
+	}
+	return failed
+}
+
+// batchWriteCustomers puts customers with batch writes
+func batchWriteCustomers(ctx context.Context, client *Client, tableName string, puts []*models.Customer) map[string]error {
+	failed := map[string]error{}
+
+	requests := make([]types.WriteRequest, 0, len(puts))
+	for _, customer := range puts {
+		item, err := client.encryptor.marshalCustomer(ctx, customer)
+		if err != nil {
+			failed[customer.ID] = err
+			continue
+		}
+		requests = append(requests, putRequest(item))
+	}
+
+	for start := 0; start < len(requests); start += maxBatchWriteItems {
+		end := start + maxBatchWriteItems
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		unprocessed, err := writeChunk(ctx, client, tableName, requests[start:end])
+		if err != nil {
+			for _, request := range requests[start:end] {
+				failed[writeRequestID(request)] = err
+			}
+			continue
+		}
+		for _, request := range unprocessed {
+			failed[writeRequestID(request)] = ErrUnprocessed
+		}
+	}
+	return failed
+}
+
+// transactWriteCustomers writes each customer in a transaction of its own
+// together with its event, running up to outboxWriteWorkers at once
+func transactWriteCustomers(ctx context.Context, client *Client, tableName string, creates, updates, deletes []*models.Customer) map[string]error {
+	type write struct {
+		id    string
+		item  types.TransactWriteItem
+		event *models.Event
+	}
+
+	failed := map[string]error{}
+	writes := make([]write, 0, len(creates)+len(updates)+len(deletes))
+	for _, customer := range creates {
+		item, err := client.encryptor.marshalCustomer(ctx, customer)
+		if err != nil {
+			failed[customer.ID] = err
+			continue
+		}
+		writes = append(writes, write{
+			id:    customer.ID,
+			item:  types.TransactWriteItem{Put: &types.Put{TableName: aws.String(tableName), Item: item}},
+			event: newEvent(models.EventCustomerCreated, customer.ID, customer, nil),
+		})
+	}
+	for _, customer := range updates {
+		put, err := versionedPut(ctx, client.encryptor, tableName, customer)
+		if err != nil {
+			failed[customer.ID] = err
+			continue
+		}
+		writes = append(writes, write{
+			id:    customer.ID,
+			item:  types.TransactWriteItem{Put: put},
+			event: newEvent(models.EventCustomerUpdated, customer.ID, customer, nil),
+		})
+	}
+	for _, customer := range deletes {
+		writes = append(writes, write{
+			id:    customer.ID,
+			item:  types.TransactWriteItem{Delete: versionedDelete(tableName, customer)},
+			event: newEvent(models.EventCustomerDeleted, customer.ID, nil, nil),
+		})
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, outboxWriteWorkers)
+	for _, w := range writes {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(w write) {
+			defer func() { <-slots; wg.Done() }()
+			if err := writeWithEvent(ctx, client, w.item, w.event); err != nil {
+				mu.Lock()
+				failed[w.id] = versionedWriteError(err)
+				mu.Unlock()
+			}
+		}(w)
+	}
+	wg.Wait()
+	return failed
+}
+
+// writeRequestID returns the ID of the customer a batch write request targets
+func writeRequestID(request types.WriteRequest) string {
+	if request.PutRequest != nil {
+		return attributeString(request.PutRequest.Item["id"])
+	}
+	return attributeString(request.DeleteRequest.Key["id"])
+}
+
+// CustomerEmailExists reports whether a customer with the email exists, ignoring case
+func CustomerEmailExists(ctx context.Context, client *Client, tableName string, email string) (bool, error) {
+	customers, err := ListCustomers(ctx, client, tableName, CustomerFilter{Email: email, Fields: []string{"id"}})
+	if err != nil {
+		return false, err
+	}
+	return len(customers) > 0, nil
+}
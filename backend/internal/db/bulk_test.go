@@ -0,0 +1,16 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteRequestID(t *testing.T) {
+	put := putRequest(map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "1"}, "name": &types.AttributeValueMemberS{Value: "John"}})
+	del := deleteRequest(customerKey("2"))
+
+	assert.Equal(t, "1", writeRequestID(put))
+	assert.Equal(t, "2", writeRequestID(del))
+}
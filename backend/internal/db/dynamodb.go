@@ -1,156 +1,500 @@
 package db
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"math/rand"
+	"strconv"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/smithy-go/middleware"
 	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/search"
 )
 
-// InitDynamoDB initializes a DynamoDB client
-func InitDynamoDB(cfg *config.Config) (*dynamodb.DynamoDB, error) {
-	awsConfig := &aws.Config{
-		Region: aws.String(cfg.AWSRegion),
+// tableActiveTimeout bounds the wait for a created or updated table to be
+// usable. Updating the billing mode or encryption of a table with indexes
+// takes minutes.
+const tableActiveTimeout = 10 * time.Minute
+
+// tablePollInterval is how often a table is described while waiting for it
+var tablePollInterval = time.Second
+
+// Client is a DynamoDB client along with the field encryptor applied to the
+// customers it reads and writes, the search index kept in sync with them, the
+// outbox table receiving their events and the settings of the tables it creates
+type Client struct {
+	*dynamodb.Client
+	encryptor   *FieldEncryptor
+	searchIndex *search.Index
+	outboxTable string
+	settings    TableSettings
+}
+
+// NewClient wraps a DynamoDB client. A nil encryptor stores customers in
+// plaintext, and tables are created with the zero TableSettings.
+func NewClient(client *dynamodb.Client, encryptor *FieldEncryptor) *Client {
+	return &Client{Client: client, encryptor: encryptor}
+}
+
+// InitDynamoDB initializes a DynamoDB client encrypting customer fields with
+// encryptor, which is nil when field encryption is disabled, and creating and
+// reconciling tables with the table settings in cfg
+func InitDynamoDB(ctx context.Context, cfg *config.Config, encryptor *FieldEncryptor) (*Client, error) {
+	settings, err := NewTableSettings(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid table settings: %w", err)
 	}
+	awsConfig, err := loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
+	}
+	client := NewClient(dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+		o.BaseEndpoint = dynamoDBEndpoint(cfg)
+		if cfg.DynamoDBBreakerThreshold > 0 {
+			breaker := newCircuitBreaker(cfg.DynamoDBBreakerThreshold, time.Duration(cfg.DynamoDBBreakerCooldownSeconds)*time.Second)
+			o.APIOptions = append(o.APIOptions, breaker.addMiddleware)
+		}
+	}), encryptor)
+	client.settings = settings
+	return client, nil
+}
 
-	// Only set endpoint for local development (DynamoDB Local)
-	if cfg.DynamoDBEndpoint != "" && cfg.DynamoDBEndpoint != "https://dynamodb."+cfg.AWSRegion+".amazonaws.com" {
-		awsConfig.Endpoint = aws.String(cfg.DynamoDBEndpoint)
+// InitDynamoDBStreams initializes a client of the streams of DynamoDB tables
+func InitDynamoDBStreams(ctx context.Context, cfg *config.Config) (*dynamodbstreams.Client, error) {
+	awsConfig, err := loadAWSConfig(ctx, cfg)
+	if err != nil {
+		return nil, err
 	}
+	return dynamodbstreams.NewFromConfig(awsConfig, func(o *dynamodbstreams.Options) {
+		o.BaseEndpoint = dynamoDBEndpoint(cfg)
+	}), nil
+}
 
-	sess, err := session.NewSession(awsConfig)
+// loadAWSConfig loads the AWS configuration shared by the DynamoDB clients
+func loadAWSConfig(ctx context.Context, cfg *config.Config) (aws.Config, error) {
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithRegion(cfg.AWSRegion),
+		awsconfig.WithRetryer(func() aws.Retryer { return newRetryer(cfg) }),
+	)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+		return aws.Config{}, fmt.Errorf("failed to load AWS configuration: %w", err)
+	}
+	awsConfig.APIOptions = append(awsConfig.APIOptions, operationTimeout(time.Duration(cfg.DynamoDBTimeoutMillis)*time.Millisecond))
+	return awsConfig, nil
+}
+
+// dynamoDBEndpoint returns the endpoint overriding the regional one, set only
+// for local development (DynamoDB Local)
+func dynamoDBEndpoint(cfg *config.Config) *string {
+	if cfg.DynamoDBEndpoint != "" && cfg.DynamoDBEndpoint != "https://dynamodb."+cfg.AWSRegion+".amazonaws.com" {
+		return aws.String(cfg.DynamoDBEndpoint)
 	}
+	return nil
+}
+
+// newRetryer retries throttled and transiently failed operations with a
+// jittered backoff doubling from the base delay up to the maximum
+func newRetryer(cfg *config.Config) aws.Retryer {
+	baseDelay := time.Duration(cfg.DynamoDBRetryBaseDelayMillis) * time.Millisecond
+	maxDelay := time.Duration(cfg.DynamoDBRetryMaxDelayMillis) * time.Millisecond
+	return countingRetryer{retry.NewStandard(func(o *retry.StandardOptions) {
+		o.MaxAttempts = max(cfg.DynamoDBMaxAttempts, 1)
+		o.Backoff = retry.BackoffDelayerFunc(func(attempt int, err error) (time.Duration, error) {
+			delay := baseDelay << min(attempt-1, 30)
+			if delay <= 0 || delay > maxDelay {
+				delay = maxDelay
+			}
+			return time.Duration(rand.Int63n(int64(delay) + 1)), nil
+		})
+		// Attempts are bounded by MaxAttempts and the operation timeout alone
+		o.RateLimiter = ratelimit.None
+	})}
+}
 
-	return dynamodb.New(sess), nil
+// countingRetryer counts the retries it allows in the metrics
+type countingRetryer struct {
+	aws.RetryerV2
 }
 
-// EnsureTableExists checks if the table exists and creates it if it doesn't
-func EnsureTableExists(client *dynamodb.DynamoDB, tableName string) error {
+// RetryDelay counts the retry before returning the standard retryer's backoff for it
+func (r countingRetryer) RetryDelay(attempt int, err error) (time.Duration, error) {
+	metrics.Add("retries", 1)
+	return r.RetryerV2.RetryDelay(attempt, err)
+}
+
+// operationTimeout bounds every operation, retries included, on top of the
+// deadline of the context it was given
+func operationTimeout(timeout time.Duration) func(*middleware.Stack) error {
+	return func(stack *middleware.Stack) error {
+		if timeout <= 0 {
+			return nil
+		}
+		return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("OperationTimeout", func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			ctx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			return next.HandleInitialize(ctx, in)
+		}), middleware.Before)
+	}
+}
+
+// IsTimeout reports whether err comes from a DynamoDB operation that ran out of time
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded)
+}
+
+// EnsureTableExists checks if the table exists and creates it if it doesn't,
+// reporting whether it was created. Tables created before the stream or the
+// email index are given them by schema migrations.
+func EnsureTableExists(ctx context.Context, client *Client, tableName string) (bool, error) {
+	return ensureTable(ctx, client, customersTableInput(tableName))
+}
+
+// EnsureSettingsTableExists checks if the settings table exists and creates it if it doesn't
+func EnsureSettingsTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "key", ""))
+	return err
+}
+
+// EnsureTagsTableExists checks if the tag counts table exists and creates it if it doesn't
+func EnsureTagsTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "tag", ""))
+	return err
+}
+
+// EnsureHistoryTableExists checks if the customer history table exists and creates it if it doesn't
+func EnsureHistoryTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "customerId", "eventId"))
+	return err
+}
+
+// ensureTable creates the table described by input if it doesn't exist yet,
+// reporting whether it was created. An existing table is reconciled with the
+// configured table settings.
+func ensureTable(ctx context.Context, client *Client, input *dynamodb.CreateTableInput) (bool, error) {
+	tableName := aws.ToString(input.TableName)
+
 	// Check if table exists
-	tables, err := client.ListTables(&dynamodb.ListTablesInput{})
+	tables, err := client.ListTables(ctx, &dynamodb.ListTablesInput{})
 	if err != nil {
-		return fmt.Errorf("failed to list tables: %v", err)
+		return false, fmt.Errorf("failed to list tables: %w", err)
 	}
 
 	// Check if our table exists
-	tableExists := false
 	for _, t := range tables.TableNames {
-		if *t == tableName {
-			tableExists = true
-			break
+		if t == tableName {
+			return false, reconcileTable(ctx, client, tableName)
 		}
 	}
 
-	// If table doesn't exist, create it
-	if !tableExists {
-		if err := createTable(client, tableName); err != nil {
-			return err
-		}
+	// Table doesn't exist, create it
+	if err := createTable(ctx, client, input); err != nil {
+		return false, err
 	}
 
-	return nil
+	return true, nil
 }
 
-// createTable creates a new DynamoDB table
-func createTable(client *dynamodb.DynamoDB, tableName string) error {
-	input := &dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+// createTable creates a new DynamoDB table with the configured table settings.
+// A table another replica is creating at the same time is waited for instead.
+func createTable(ctx context.Context, client *Client, input *dynamodb.CreateTableInput) error {
+	tableName := aws.ToString(input.TableName)
+
+	client.settings.apply(input)
+	_, err := client.CreateTable(ctx, input)
+	if isResourceInUse(err) {
+		log.Printf("Table %s is being created, waiting for it", tableName)
+		return waitForTableActive(ctx, client, tableName)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create table: %w", err)
+	}
+
+	log.Printf("Created table: %s", tableName)
+
+	// Wait for table to be active
+	if err := waitForTableActive(ctx, client, tableName); err != nil {
+		return err
+	}
+	return enablePointInTimeRecovery(ctx, client, tableName)
+}
+
+// customersTableInput builds the CreateTable request of the customers table
+func customersTableInput(tableName string) *dynamodb.CreateTableInput {
+	input := createTableInput(tableName, "id", "")
+	input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+		AttributeName: aws.String(emailIndexAttribute),
+		AttributeType: types.ScalarAttributeTypeS,
+	})
+	input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{emailIndex()}
+	input.StreamSpecification = customerStream()
+	return input
+}
+
+// emailIndex describes the global secondary index used for exact email lookups
+func emailIndex() types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(emailIndexName),
+		KeySchema: []types.KeySchemaElement{
 			{
-				AttributeName: aws.String("id"),
-				AttributeType: aws.String("S"),
+				AttributeName: aws.String(emailIndexAttribute),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
-		KeySchema: []*dynamodb.KeySchemaElement{
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+}
+
+// AddEmailIndex adds the email index to a customers table created before it
+// existed. Items written earlier are only indexed once BackfillEmailIndex
+// rewrites them.
+func AddEmailIndex(ctx context.Context, client *Client, tableName string) error {
+	return AddGlobalSecondaryIndex(ctx, client, tableName, emailIndex(), types.AttributeDefinition{
+		AttributeName: aws.String(emailIndexAttribute),
+		AttributeType: types.ScalarAttributeTypeS,
+	})
+}
+
+// HasEmailIndex reports whether a customers table has an active email index
+func HasEmailIndex(ctx context.Context, client *Client, tableName string) (bool, error) {
+	return hasActiveIndex(ctx, client, tableName, emailIndexName)
+}
+
+// createTableInput builds the CreateTable request for a table with string keys
+func createTableInput(tableName, hashKey, rangeKey string) *dynamodb.CreateTableInput {
+	input := &dynamodb.CreateTableInput{
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
-				AttributeName: aws.String("id"),
-				KeyType:       aws.String("HASH"),
+				AttributeName: aws.String(hashKey),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
-			ReadCapacityUnits:  aws.Int64(5),
-			WriteCapacityUnits: aws.Int64(5),
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String(hashKey),
+				KeyType:       types.KeyTypeHash,
+			},
 		},
 		TableName: aws.String(tableName),
 	}
 
-	_, err := client.CreateTable(input)
-	if err != nil {
-		return fmt.Errorf("failed to create table: %v", err)
+	if rangeKey != "" {
+		input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+			AttributeName: aws.String(rangeKey),
+			AttributeType: types.ScalarAttributeTypeS,
+		})
+		input.KeySchema = append(input.KeySchema, types.KeySchemaElement{
+			AttributeName: aws.String(rangeKey),
+			KeyType:       types.KeyTypeRange,
+		})
 	}
 
-	log.Printf("Created table: %s", tableName)
-
-	// Wait for table to be active
-	return waitForTableActive(client, tableName)
+	return input
 }
 
-// waitForTableActive waits for a table to become active
-func waitForTableActive(client *dynamodb.DynamoDB, tableName string) error {
-	input := &dynamodb.DescribeTableInput{
-		TableName: aws.String(tableName),
-	}
-
-	// Wait for table to be active with a timeout
-	for i := 0; i < 30; i++ {
-		result, err := client.DescribeTable(input)
-		if err != nil {
-			return fmt.Errorf("failed to describe table: %v", err)
-		}
-
-		if *result.Table.TableStatus == "ACTIVE" {
+// waitForTableActive waits for a table and its indexes to become active and
+// for changes to its encryption to finish
+func waitForTableActive(ctx context.Context, client *Client, tableName string) error {
+	ctx, cancel := context.WithTimeout(ctx, tableActiveTimeout)
+	defer cancel()
+
+	for {
+		result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		var notFound *types.ResourceNotFoundException
+		switch {
+		case errors.As(err, &notFound):
+			// A table just created may not be described yet
+		case err != nil:
+			return fmt.Errorf("failed waiting for table %s to become active: %w", tableName, err)
+		case tableActive(result.Table):
 			log.Printf("Table %s is now active", tableName)
 			return nil
 		}
 
-		// Wait before checking again
-		time.Sleep(1 * time.Second)
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("failed waiting for table %s to become active: %w", tableName, ctx.Err())
+		case <-time.After(tablePollInterval):
+		}
 	}
+}
 
-	return fmt.Errorf("timed out waiting for table %s to become active", tableName)
+// tableActive reports whether a table and its indexes are active and its
+// encryption isn't changing
+func tableActive(table *types.TableDescription) bool {
+	if table == nil || table.TableStatus != types.TableStatusActive {
+		return false
+	}
+	for _, index := range table.GlobalSecondaryIndexes {
+		if index.IndexStatus != "" && index.IndexStatus != types.IndexStatusActive {
+			return false
+		}
+	}
+	if sse := table.SSEDescription; sse != nil {
+		switch sse.Status {
+		case types.SSEStatusEnabling, types.SSEStatusDisabling, types.SSEStatusUpdating:
+			return false
+		}
+	}
+	return true
 }
 
-// PutCustomer adds or updates a customer in DynamoDB
-func PutCustomer(client *dynamodb.DynamoDB, tableName string, customer *models.Customer) error {
-	item, err := dynamodbattribute.MarshalMap(customer)
+// isResourceInUse reports whether err is DynamoDB refusing to create or
+// update a table another request is already creating or updating
+func isResourceInUse(err error) bool {
+	var inUse *types.ResourceInUseException
+	return errors.As(err, &inUse)
+}
+
+// ErrCustomerExists is returned when creating a customer whose ID is already taken
+var ErrCustomerExists = errors.New("customer already exists")
+
+// ErrCustomerChanged is returned when a customer was written between the read
+// and the write based on it
+var ErrCustomerChanged = errors.New("customer changed since it was read")
+
+// versionAttribute counts the writes made to a customer, so that writes based
+// on an earlier read can require that nothing changed since
+const versionAttribute = "version"
+
+// versionIncrement is added to the version of a customer by each update
+var versionIncrement = &types.AttributeValueMemberN{Value: "1"}
+
+// CreateCustomer adds a new customer in DynamoDB, failing with
+// ErrCustomerExists if a customer with the same ID exists
+func CreateCustomer(ctx context.Context, client *Client, tableName string, customer *models.Customer) error {
+	customer.Version = 1
+	item, err := client.encryptor.marshalCustomer(ctx, customer)
 	if err != nil {
-		return fmt.Errorf("failed to marshal customer: %v", err)
+		return err
 	}
 
-	input := &dynamodb.PutItemInput{
-		Item:      item,
-		TableName: aws.String(tableName),
+	put := &types.Put{
+		Item:                                item,
+		TableName:                           aws.String(tableName),
+		ConditionExpression:                 aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames:            map[string]string{"#id": "id"},
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}
+	err = writeWithEvent(ctx, client, types.TransactWriteItem{Put: put}, newEvent(models.EventCustomerCreated, customer.ID, customer, nil))
+	if failed, _ := writeConditionFailed(err); failed {
+		return ErrCustomerExists
+	}
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	client.indexCustomer(customer)
+	return nil
+}
+
+// PutCustomer replaces a customer read at customer.Version, advancing the
+// version. It fails with ErrCustomerChanged if the customer was written since
+// it was read, and with ErrCustomerNotFound if it was deleted.
+func PutCustomer(ctx context.Context, client *Client, tableName string, customer *models.Customer) error {
+	put, err := versionedPut(ctx, client.encryptor, tableName, customer)
+	if err != nil {
+		return err
 	}
 
-	_, err = client.PutItem(input)
+	err = writeWithEvent(ctx, client, types.TransactWriteItem{Put: put}, newEvent(models.EventCustomerUpdated, customer.ID, customer, nil))
 	if err != nil {
-		return fmt.Errorf("failed to put item: %v", err)
+		customer.Version--
+		return fmt.Errorf("failed to put item: %w", versionedWriteError(err))
 	}
 
+	client.indexCustomer(customer)
 	return nil
 }
 
+// versionedPut builds the put replacing a customer read at customer.Version,
+// conditioned on the customer still being at that version. customer.Version
+// is advanced to the version written.
+func versionedPut(ctx context.Context, encryptor *FieldEncryptor, tableName string, customer *models.Customer) (*types.Put, error) {
+	condition, names, values := versionCondition(customer.Version)
+	customer.Version++
+	item, err := encryptor.marshalCustomer(ctx, customer)
+	if err != nil {
+		customer.Version--
+		return nil, err
+	}
+
+	names["#id"] = "id"
+	return &types.Put{
+		Item:                                item,
+		TableName:                           aws.String(tableName),
+		ConditionExpression:                 aws.String("attribute_exists(#id) AND " + condition),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+	}, nil
+}
+
+// versionedWriteError converts the failed condition of a write conditioned on
+// versionCondition to ErrCustomerNotFound or ErrCustomerChanged, returning
+// other errors as they are
+func versionedWriteError(err error) error {
+	if failed, exists := writeConditionFailed(err); failed {
+		if !exists {
+			return ErrCustomerNotFound
+		}
+		return ErrCustomerChanged
+	}
+	return err
+}
+
+// versionCondition returns the condition holding while a customer is still at
+// version, with the names and values it refers to. Customers stored before
+// versions were kept have none and are at version 0.
+func versionCondition(version int64) (string, map[string]string, map[string]types.AttributeValue) {
+	names := map[string]string{"#version": versionAttribute}
+	if version == 0 {
+		return "attribute_not_exists(#version)", names, nil
+	}
+	return "#version = :version", names, map[string]types.AttributeValue{
+		":version": &types.AttributeValueMemberN{Value: strconv.FormatInt(version, 10)},
+	}
+}
+
 // GetCustomer retrieves a customer by ID
-func GetCustomer(client *dynamodb.DynamoDB, tableName string, id string) (*models.Customer, error) {
+func GetCustomer(ctx context.Context, client *Client, tableName string, id string) (*models.Customer, error) {
+	return GetCustomerFields(ctx, client, tableName, id, nil)
+}
+
+// GetCustomerFields retrieves a customer by ID, reading only the given
+// fieldset. An empty fieldset reads every attribute.
+func GetCustomerFields(ctx context.Context, client *Client, tableName string, id string, fields []string) (*models.Customer, error) {
 	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
-			},
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: id},
 		},
 		TableName: aws.String(tableName),
 	}
+	if len(fields) > 0 {
+		expr, err := expression.NewBuilder().WithProjection(projection(fields)).Build()
+		if err != nil {
+			return nil, fmt.Errorf("failed to build projection: %w", err)
+		}
+		input.ProjectionExpression = expr.Projection()
+		input.ExpressionAttributeNames = expr.Names()
+	}
 
-	result, err := client.GetItem(input)
+	result, err := client.GetItem(ctx, input)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get item: %v", err)
+		return nil, fmt.Errorf("failed to get item: %w", err)
 	}
 
 	if result.Item == nil {
@@ -158,49 +502,154 @@ func GetCustomer(client *dynamodb.DynamoDB, tableName string, id string) (*model
 	}
 
 	var customer models.Customer
-	err = dynamodbattribute.UnmarshalMap(result.Item, &customer)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal customer: %v", err)
+	if err := client.encryptor.unmarshalCustomer(ctx, result.Item, &customer); err != nil {
+		return nil, err
 	}
 
 	return &customer, nil
 }
 
-// ListCustomers retrieves all customers
-func ListCustomers(client *dynamodb.DynamoDB, tableName string) ([]models.Customer, error) {
+// ListCustomers retrieves all customers matching the filter. Filters with an
+// email are served from the email index instead of scanning the table.
+func ListCustomers(ctx context.Context, client *Client, tableName string, filter CustomerFilter) ([]models.Customer, error) {
+	expr, ok, err := buildListFilter(client.encryptor, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// Filtered reads may return empty pages, so keep reading until the results are exhausted
+	customers := []models.Customer{}
+	collect := func(items []map[string]types.AttributeValue) error {
+		pageCustomers, err := client.encryptor.unmarshalCustomers(ctx, items)
+		customers = append(customers, pageCustomers...)
+		return err
+	}
+
+	if filter.Email != "" {
+		paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+			TableName:                 aws.String(tableName),
+			IndexName:                 aws.String(emailIndexName),
+			KeyConditionExpression:    expr.KeyCondition(),
+			FilterExpression:          expr.Filter(),
+			ProjectionExpression:      expr.Projection(),
+			ExpressionAttributeNames:  expr.Names(),
+			ExpressionAttributeValues: expr.Values(),
+		})
+		for paginator.HasMorePages() {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read customers: %w", err)
+			}
+			if err := collect(page.Items); err != nil {
+				return nil, err
+			}
+		}
+		return customers, nil
+	}
+
 	input := &dynamodb.ScanInput{
 		TableName: aws.String(tableName),
 	}
+	if ok {
+		input.FilterExpression = expr.Filter()
+		input.ProjectionExpression = expr.Projection()
+		input.ExpressionAttributeNames = expr.Names()
+		input.ExpressionAttributeValues = expr.Values()
+	}
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read customers: %w", err)
+		}
+		if err := collect(page.Items); err != nil {
+			return nil, err
+		}
+	}
+	return customers, nil
+}
 
-	result, err := client.Scan(input)
+// DeleteCustomer removes a customer read at customer.Version. It fails with
+// ErrCustomerChanged if the customer was written since it was read, and with
+// ErrCustomerNotFound if it is already gone.
+func DeleteCustomer(ctx context.Context, client *Client, tableName string, customer *models.Customer) error {
+	remove := versionedDelete(tableName, customer)
+	err := writeWithEvent(ctx, client, types.TransactWriteItem{Delete: remove}, newEvent(models.EventCustomerDeleted, customer.ID, nil, nil))
 	if err != nil {
-		return nil, fmt.Errorf("failed to scan table: %v", err)
+		return fmt.Errorf("failed to delete item: %w", versionedWriteError(err))
 	}
 
-	var customers []models.Customer
-	err = dynamodbattribute.UnmarshalListOfMaps(result.Items, &customers)
-	if err != nil {
-		return nil, fmt.Errorf("failed to unmarshal customers: %v", err)
+	client.unindexCustomer(customer.ID)
+	return nil
+}
+
+// versionedDelete builds the delete of a customer read at customer.Version,
+// conditioned on the customer still being at that version
+func versionedDelete(tableName string, customer *models.Customer) *types.Delete {
+	condition, names, values := versionCondition(customer.Version)
+	names["#id"] = "id"
+	return &types.Delete{
+		TableName:                           aws.String(tableName),
+		Key:                                 customerKey(customer.ID),
+		ConditionExpression:                 aws.String("attribute_exists(#id) AND " + condition),
+		ExpressionAttributeNames:            names,
+		ExpressionAttributeValues:           values,
+		ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
 	}
+}
 
-	return customers, nil
+// isConditionalCheckFailed reports whether err is a failed condition expression
+func isConditionalCheckFailed(err error) bool {
+	var failed *types.ConditionalCheckFailedException
+	return errors.As(err, &failed)
 }
 
-// DeleteCustomer removes a customer by ID
-func DeleteCustomer(client *dynamodb.DynamoDB, tableName string, id string) error {
-	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String(id),
-			},
-		},
-		TableName: aws.String(tableName),
+// attributeString returns the value of a string attribute, or "" when it isn't one
+func attributeString(av types.AttributeValue) string {
+	if s, ok := av.(*types.AttributeValueMemberS); ok {
+		return s.Value
 	}
+	return ""
+}
 
-	_, err := client.DeleteItem(input)
-	if err != nil {
-		return fmt.Errorf("failed to delete item: %v", err)
+// writeConditionFailed reports whether a write sent by writeWithEvent failed
+// its condition, and whether the item existed at the time. The write must
+// request ALL_OLD values on condition check failure.
+func writeConditionFailed(err error) (failed bool, exists bool) {
+	var checkFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &checkFailed) {
+		return true, checkFailed.Item != nil
 	}
+	return firstConditionFailed(err)
+}
 
-	return nil
+// firstConditionFailed reports whether a transaction was canceled because the
+// condition on its first item failed, and whether that item existed at the time.
+// The first item must request ALL_OLD values on condition check failure.
+func firstConditionFailed(err error) (failed bool, exists bool) {
+	return conditionFailed(err, 0)
+}
+
+// conditionFailed reports whether a transaction was canceled because the
+// condition on its item at index failed, and whether that item existed at the
+// time. The item must request ALL_OLD values on condition check failure.
+func conditionFailed(err error, index int) (failed bool, exists bool) {
+	failed, item := conditionFailedItem(err, index)
+	return failed, item != nil
+}
+
+// conditionFailedItem reports whether a transaction was canceled because the
+// condition on its item at index failed, and returns that item as it was at
+// the time, nil if it didn't exist.
+func conditionFailedItem(err error, index int) (bool, map[string]types.AttributeValue) {
+	var canceled *types.TransactionCanceledException
+	if !errors.As(err, &canceled) || len(canceled.CancellationReasons) <= index {
+		return false, nil
+	}
+
+	reason := canceled.CancellationReasons[index]
+	if reason.Code == nil || *reason.Code != "ConditionalCheckFailed" {
+		return false, nil
+	}
+	return true, reason.Item
 }
@@ -1,11 +1,14 @@
 package db
 
 import (
+	"context"
 	"testing"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/dynamodb"
-	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
 	"github.com/emiteze/tcc-ufu/internal/config"
 	"github.com/emiteze/tcc-ufu/internal/models"
 	"github.com/stretchr/testify/assert"
@@ -18,7 +21,7 @@ func TestInitDynamoDB_Configuration(t *testing.T) {
 		DynamoDBEndpoint: "http://localhost:8000",
 	}
 
-	client, err := InitDynamoDB(cfg)
+	client, err := InitDynamoDB(context.Background(), cfg, nil)
 
 	require.NoError(t, err)
 	assert.NotNil(t, client)
@@ -30,13 +33,24 @@ func TestInitDynamoDB_InvalidRegion(t *testing.T) {
 		DynamoDBEndpoint: "http://localhost:8000",
 	}
 
-	client, err := InitDynamoDB(cfg)
+	client, err := InitDynamoDB(context.Background(), cfg, nil)
 
 	// Should still work with empty region
 	require.NoError(t, err)
 	assert.NotNil(t, client)
 }
 
+func TestInitDynamoDB_InvalidTableSettings(t *testing.T) {
+	cfg := &config.Config{
+		AWSRegion:           "us-east-1",
+		DynamoDBBillingMode: "PROVISIONED",
+	}
+
+	_, err := InitDynamoDB(context.Background(), cfg, nil)
+
+	assert.ErrorContains(t, err, "invalid table settings")
+}
+
 func TestCustomerDataIntegrity_MarshalUnmarshal(t *testing.T) {
 	// Test that customer data maintains integrity through marshal/unmarshal
 	originalCustomer := &models.Customer{
@@ -46,7 +60,7 @@ func TestCustomerDataIntegrity_MarshalUnmarshal(t *testing.T) {
 	}
 
 	// Marshal to DynamoDB format
-	item, err := dynamodbattribute.MarshalMap(originalCustomer)
+	item, err := attributevalue.MarshalMap(originalCustomer)
 	require.NoError(t, err)
 
 	// Verify the marshaled data has the correct structure
@@ -56,7 +70,7 @@ func TestCustomerDataIntegrity_MarshalUnmarshal(t *testing.T) {
 
 	// Unmarshal back to Customer
 	var reconstructedCustomer models.Customer
-	err = dynamodbattribute.UnmarshalMap(item, &reconstructedCustomer)
+	err = attributevalue.UnmarshalMap(item, &reconstructedCustomer)
 	require.NoError(t, err)
 
 	// Verify data integrity
@@ -69,11 +83,11 @@ func TestCustomerDataIntegrity_EmptyCustomer(t *testing.T) {
 	// Test marshaling/unmarshaling of empty customer
 	originalCustomer := &models.Customer{}
 
-	item, err := dynamodbattribute.MarshalMap(originalCustomer)
+	item, err := attributevalue.MarshalMap(originalCustomer)
 	require.NoError(t, err)
 
 	var reconstructedCustomer models.Customer
-	err = dynamodbattribute.UnmarshalMap(item, &reconstructedCustomer)
+	err = attributevalue.UnmarshalMap(item, &reconstructedCustomer)
 	require.NoError(t, err)
 
 	assert.Equal(t, originalCustomer.ID, reconstructedCustomer.ID)
@@ -89,11 +103,11 @@ func TestCustomerDataIntegrity_SpecialCharacters(t *testing.T) {
 		Email: "jose.maria+test@example.com",
 	}
 
-	item, err := dynamodbattribute.MarshalMap(originalCustomer)
+	item, err := attributevalue.MarshalMap(originalCustomer)
 	require.NoError(t, err)
 
 	var reconstructedCustomer models.Customer
-	err = dynamodbattribute.UnmarshalMap(item, &reconstructedCustomer)
+	err = attributevalue.UnmarshalMap(item, &reconstructedCustomer)
 	require.NoError(t, err)
 
 	assert.Equal(t, originalCustomer.ID, reconstructedCustomer.ID)
@@ -108,7 +122,7 @@ func TestDynamoDBInputValidation_PutItem(t *testing.T) {
 		Email: "john.doe@example.com",
 	}
 
-	item, err := dynamodbattribute.MarshalMap(customer)
+	item, err := attributevalue.MarshalMap(customer)
 	require.NoError(t, err)
 
 	input := &dynamodb.PutItemInput{
@@ -126,10 +140,8 @@ func TestDynamoDBInputValidation_PutItem(t *testing.T) {
 
 func TestDynamoDBInputValidation_GetItem(t *testing.T) {
 	input := &dynamodb.GetItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String("123"),
-			},
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "123"},
 		},
 		TableName: aws.String("TestTable"),
 	}
@@ -138,15 +150,13 @@ func TestDynamoDBInputValidation_GetItem(t *testing.T) {
 	assert.NotNil(t, input.Key)
 	assert.Equal(t, "TestTable", *input.TableName)
 	assert.NotNil(t, input.Key["id"])
-	assert.Equal(t, "123", *input.Key["id"].S)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "123"}, input.Key["id"])
 }
 
 func TestDynamoDBInputValidation_DeleteItem(t *testing.T) {
 	input := &dynamodb.DeleteItemInput{
-		Key: map[string]*dynamodb.AttributeValue{
-			"id": {
-				S: aws.String("123"),
-			},
+		Key: map[string]types.AttributeValue{
+			"id": &types.AttributeValueMemberS{Value: "123"},
 		},
 		TableName: aws.String("TestTable"),
 	}
@@ -155,7 +165,7 @@ func TestDynamoDBInputValidation_DeleteItem(t *testing.T) {
 	assert.NotNil(t, input.Key)
 	assert.Equal(t, "TestTable", *input.TableName)
 	assert.NotNil(t, input.Key["id"])
-	assert.Equal(t, "123", *input.Key["id"].S)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "123"}, input.Key["id"])
 }
 
 func TestDynamoDBInputValidation_ScanInput(t *testing.T) {
@@ -171,19 +181,19 @@ func TestCreateTableInputValidation(t *testing.T) {
 	tableName := "TestTable"
 
 	input := &dynamodb.CreateTableInput{
-		AttributeDefinitions: []*dynamodb.AttributeDefinition{
+		AttributeDefinitions: []types.AttributeDefinition{
 			{
 				AttributeName: aws.String("id"),
-				AttributeType: aws.String("S"),
+				AttributeType: types.ScalarAttributeTypeS,
 			},
 		},
-		KeySchema: []*dynamodb.KeySchemaElement{
+		KeySchema: []types.KeySchemaElement{
 			{
 				AttributeName: aws.String("id"),
-				KeyType:       aws.String("HASH"),
+				KeyType:       types.KeyTypeHash,
 			},
 		},
-		ProvisionedThroughput: &dynamodb.ProvisionedThroughput{
+		ProvisionedThroughput: &types.ProvisionedThroughput{
 			ReadCapacityUnits:  aws.Int64(5),
 			WriteCapacityUnits: aws.Int64(5),
 		},
@@ -194,14 +204,34 @@ func TestCreateTableInputValidation(t *testing.T) {
 	assert.Equal(t, tableName, *input.TableName)
 	assert.Len(t, input.AttributeDefinitions, 1)
 	assert.Equal(t, "id", *input.AttributeDefinitions[0].AttributeName)
-	assert.Equal(t, "S", *input.AttributeDefinitions[0].AttributeType)
+	assert.Equal(t, types.ScalarAttributeTypeS, input.AttributeDefinitions[0].AttributeType)
 	assert.Len(t, input.KeySchema, 1)
 	assert.Equal(t, "id", *input.KeySchema[0].AttributeName)
-	assert.Equal(t, "HASH", *input.KeySchema[0].KeyType)
+	assert.Equal(t, types.KeyTypeHash, input.KeySchema[0].KeyType)
 	assert.Equal(t, int64(5), *input.ProvisionedThroughput.ReadCapacityUnits)
 	assert.Equal(t, int64(5), *input.ProvisionedThroughput.WriteCapacityUnits)
 }
 
+func TestCreateTableInput_WithRangeKey(t *testing.T) {
+	input := createTableInput("TestHistory", "customerId", "eventId")
+
+	assert.Equal(t, "TestHistory", *input.TableName)
+	require.Len(t, input.KeySchema, 2)
+	assert.Equal(t, "customerId", *input.KeySchema[0].AttributeName)
+	assert.Equal(t, types.KeyTypeHash, input.KeySchema[0].KeyType)
+	assert.Equal(t, "eventId", *input.KeySchema[1].AttributeName)
+	assert.Equal(t, types.KeyTypeRange, input.KeySchema[1].KeyType)
+	assert.Len(t, input.AttributeDefinitions, 2)
+}
+
+func TestCreateTableInput_HashKeyOnly(t *testing.T) {
+	input := createTableInput("TestCustomers", "id", "")
+
+	require.Len(t, input.KeySchema, 1)
+	assert.Equal(t, "id", *input.KeySchema[0].AttributeName)
+	assert.Len(t, input.AttributeDefinitions, 1)
+}
+
 func TestDynamoDBAttributeValues(t *testing.T) {
 	// Test different attribute value types
 	tests := []struct {
@@ -223,32 +253,31 @@ func TestDynamoDBAttributeValues(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			av, err := dynamodbattribute.Marshal(tt.value)
+			av, err := attributevalue.Marshal(tt.value)
 			require.NoError(t, err)
 
-			assert.NotNil(t, av.S)
-			assert.Equal(t, tt.expected, *av.S)
+			assert.Equal(t, &types.AttributeValueMemberS{Value: tt.expected}, av)
 		})
 	}
 }
 
 func TestListOfMapsUnmarshaling(t *testing.T) {
 	// Test unmarshaling a list of DynamoDB items
-	items := []map[string]*dynamodb.AttributeValue{
+	items := []map[string]types.AttributeValue{
 		{
-			"id":    {S: aws.String("123")},
-			"name":  {S: aws.String("John Doe")},
-			"email": {S: aws.String("john.doe@example.com")},
+			"id":    &types.AttributeValueMemberS{Value: "123"},
+			"name":  &types.AttributeValueMemberS{Value: "John Doe"},
+			"email": &types.AttributeValueMemberS{Value: "john.doe@example.com"},
 		},
 		{
-			"id":    {S: aws.String("456")},
-			"name":  {S: aws.String("Jane Smith")},
-			"email": {S: aws.String("jane.smith@example.com")},
+			"id":    &types.AttributeValueMemberS{Value: "456"},
+			"name":  &types.AttributeValueMemberS{Value: "Jane Smith"},
+			"email": &types.AttributeValueMemberS{Value: "jane.smith@example.com"},
 		},
 	}
 
 	var customers []models.Customer
-	err := dynamodbattribute.UnmarshalListOfMaps(items, &customers)
+	err := attributevalue.UnmarshalListOfMaps(items, &customers)
 	require.NoError(t, err)
 
 	require.Len(t, customers, 2)
@@ -262,11 +291,79 @@ func TestListOfMapsUnmarshaling(t *testing.T) {
 
 func TestEmptyListUnmarshaling(t *testing.T) {
 	// Test unmarshaling an empty list
-	items := []map[string]*dynamodb.AttributeValue{}
+	items := []map[string]types.AttributeValue{}
 
 	var customers []models.Customer
-	err := dynamodbattribute.UnmarshalListOfMaps(items, &customers)
+	err := attributevalue.UnmarshalListOfMaps(items, &customers)
 	require.NoError(t, err)
 
 	assert.Len(t, customers, 0)
 }
+
+func TestFirstConditionFailed(t *testing.T) {
+	canceled := func(item map[string]types.AttributeValue) error {
+		return &types.TransactionCanceledException{
+			CancellationReasons: []types.CancellationReason{
+				{Code: aws.String("ConditionalCheckFailed"), Item: item},
+				{Code: aws.String("None")},
+			},
+		}
+	}
+
+	failed, exists := firstConditionFailed(canceled(nil))
+	assert.True(t, failed)
+	assert.False(t, exists)
+
+	failed, exists = firstConditionFailed(canceled(map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "123"}}))
+	assert.True(t, failed)
+	assert.True(t, exists)
+
+	failed, _ = firstConditionFailed(&types.TransactionCanceledException{
+		CancellationReasons: []types.CancellationReason{{Code: aws.String("None")}, {Code: aws.String("ConditionalCheckFailed")}},
+	})
+	assert.False(t, failed)
+
+	failed, _ = firstConditionFailed(assert.AnError)
+	assert.False(t, failed)
+}
+
+func TestOperationTimeout_ExpiresSlowOperations(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		time.Sleep(200 * time.Millisecond)
+		return map[string]interface{}{}
+	}, func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, operationTimeout(20*time.Millisecond))
+	})
+
+	_, err := GetCustomer(context.Background(), client, "Customers", "123")
+
+	require.Error(t, err)
+	assert.True(t, IsTimeout(err))
+}
+
+func TestOperationTimeout_KeepsFastOperations(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	}, func(o *dynamodb.Options) {
+		o.APIOptions = append(o.APIOptions, operationTimeout(time.Second))
+	})
+
+	customer, err := GetCustomer(context.Background(), client, "Customers", "123")
+
+	require.NoError(t, err)
+	assert.Nil(t, customer)
+}
+
+func TestIsTimeout_CanceledRequest(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := GetCustomer(ctx, client, "Customers", "123")
+
+	require.Error(t, err)
+	assert.False(t, IsTimeout(err))
+	assert.False(t, IsTimeout(assert.AnError))
+}
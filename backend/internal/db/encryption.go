@@ -0,0 +1,354 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/encryption"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+const (
+	// envelopeAttribute holds the wrapped data key and the list of encrypted fields
+	envelopeAttribute = "enc"
+	// emailIndexAttribute holds the normalized email, or its blind index when encryption is enabled
+	emailIndexAttribute = "emailIndex"
+	// emailDomainAttribute holds the normalized email domain, or its blind index when encryption is enabled
+	emailDomainAttribute = "emailDomain"
+	// emailIndexName is the global secondary index used for exact email lookups
+	emailIndexName = "emailIndex-index"
+	// maxCachedDataKeys bounds the cache of unwrapped data keys
+	maxCachedDataKeys = 1024
+	// dataKeyMaxAge and dataKeyMaxItems bound the reuse of a generated data
+	// key, limiting how much data a single key protects
+	dataKeyMaxAge   = 5 * time.Minute
+	dataKeyMaxItems = 10000
+)
+
+// encryptableFields are the customer attributes that may be encrypted
+var encryptableFields = map[string]bool{"name": true, "email": true, "telephone": true}
+
+// ErrEncryptionNotConfigured is returned when reading an encrypted item without a FieldEncryptor
+var ErrEncryptionNotConfigured = errors.New("item is encrypted but field encryption is not configured")
+
+// FieldEncryptor encrypts configured customer attributes before they are written
+// and decrypts them after they are read. Items are encrypted with a data key
+// wrapped by the key provider and stored alongside the item, so key encryption
+// keys can be rotated without rewriting existing items. A data key is reused
+// for the items written within dataKeyMaxAge, up to dataKeyMaxItems, so writes
+// and the reads of items written together need few provider calls. A nil
+// FieldEncryptor stores customers in plaintext.
+type FieldEncryptor struct {
+	provider encryption.KeyProvider
+	fields   []string
+	indexKey []byte
+
+	mu       sync.Mutex
+	dataKeys map[string][]byte
+
+	// generateMu serializes the generation of the data key reused by writes
+	generateMu sync.Mutex
+	current    *writeKey
+}
+
+// writeKey is a generated data key reused to encrypt items
+type writeKey struct {
+	plaintext []byte
+	wrapped   []byte
+	keyID     string
+	expiresAt time.Time
+	uses      int
+}
+
+// NewFieldEncryptor creates an encryptor for the given fields. indexKey is the
+// HMAC key of the email blind index.
+func NewFieldEncryptor(provider encryption.KeyProvider, fields []string, indexKey []byte) (*FieldEncryptor, error) {
+	for _, field := range fields {
+		if !encryptableFields[field] {
+			return nil, fmt.Errorf("field %q can't be encrypted", field)
+		}
+	}
+	if len(indexKey) < 16 {
+		return nil, fmt.Errorf("blind index key must be at least 16 bytes")
+	}
+
+	return &FieldEncryptor{
+		provider: provider,
+		fields:   fields,
+		indexKey: indexKey,
+		dataKeys: map[string][]byte{},
+	}, nil
+}
+
+// encryptItem replaces the configured string attributes with their ciphertext
+func (fe *FieldEncryptor) encryptItem(ctx context.Context, id string, item map[string]types.AttributeValue) error {
+	return fe.encryptAttributes(ctx, id, item, fe.fields)
+}
+
+// encryptAttributes replaces the given string attributes with their
+// ciphertext, bound to the item's id so decryptItem restores them
+func (fe *FieldEncryptor) encryptAttributes(ctx context.Context, id string, item map[string]types.AttributeValue, fields []string) error {
+	key, err := fe.writeKey(ctx)
+	if err != nil {
+		return err
+	}
+	dataKey := key.plaintext
+
+	var encrypted []string
+	for _, field := range fields {
+		plaintext := attributeString(item[field])
+		if plaintext == "" {
+			continue
+		}
+
+		ciphertext, err := encryption.Seal(dataKey, []byte(plaintext), fieldContext(id, field))
+		if err != nil {
+			return fmt.Errorf("failed to encrypt %s: %v", field, err)
+		}
+		item[field] = &types.AttributeValueMemberB{Value: ciphertext}
+		encrypted = append(encrypted, field)
+	}
+
+	if len(encrypted) > 0 {
+		item[envelopeAttribute] = key.envelope(encrypted)
+	}
+
+	return nil
+}
+
+// encryptBytes encrypts data bound to additionalData, returning the
+// ciphertext and the envelope to store alongside it
+func (fe *FieldEncryptor) encryptBytes(ctx context.Context, data, additionalData []byte, field string) ([]byte, types.AttributeValue, error) {
+	key, err := fe.writeKey(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	ciphertext, err := encryption.Seal(key.plaintext, data, additionalData)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to encrypt %s: %v", field, err)
+	}
+	return ciphertext, key.envelope([]string{field}), nil
+}
+
+// decryptBytes decrypts a ciphertext produced by encryptBytes
+func (fe *FieldEncryptor) decryptBytes(ctx context.Context, ciphertext []byte, envelope types.AttributeValue, additionalData []byte, field string) ([]byte, error) {
+	m, ok := envelope.(*types.AttributeValueMemberM)
+	if !ok {
+		return nil, fmt.Errorf("%s has an invalid encryption envelope", field)
+	}
+	dataKey, err := fe.envelopeKey(ctx, m)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := encryption.Open(dataKey, ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt %s: %v", field, err)
+	}
+	return plaintext, nil
+}
+
+// envelope describes the fields encrypted with the key, storing the key wrapped
+func (key *writeKey) envelope(fields []string) types.AttributeValue {
+	return &types.AttributeValueMemberM{Value: map[string]types.AttributeValue{
+		"keyId":   &types.AttributeValueMemberS{Value: key.keyID},
+		"dataKey": &types.AttributeValueMemberB{Value: key.wrapped},
+		"fields":  &types.AttributeValueMemberSS{Value: fields},
+	}}
+}
+
+// envelopeKey unwraps the data key stored in an envelope
+func (fe *FieldEncryptor) envelopeKey(ctx context.Context, envelope *types.AttributeValueMemberM) ([]byte, error) {
+	var wrappedKey []byte
+	if b, ok := envelope.Value["dataKey"].(*types.AttributeValueMemberB); ok {
+		wrappedKey = b.Value
+	}
+	return fe.dataKey(ctx, wrappedKey, attributeString(envelope.Value["keyId"]))
+}
+
+// writeKey returns the data key to encrypt an item with, generating a new one
+// once the current key is too old or has encrypted dataKeyMaxItems items
+func (fe *FieldEncryptor) writeKey(ctx context.Context) (*writeKey, error) {
+	fe.generateMu.Lock()
+	defer fe.generateMu.Unlock()
+
+	if key := fe.current; key != nil && key.uses < dataKeyMaxItems && time.Now().Before(key.expiresAt) {
+		key.uses++
+		return key, nil
+	}
+
+	plaintext, wrapped, keyID, err := fe.provider.GenerateDataKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fe.current = &writeKey{plaintext: plaintext, wrapped: wrapped, keyID: keyID, expiresAt: time.Now().Add(dataKeyMaxAge), uses: 1}
+
+	// Items written with the key are read back without unwrapping it
+	fe.cacheDataKey(dataKeyCacheKey(wrapped, keyID), plaintext)
+	return fe.current, nil
+}
+
+// decryptItem restores the plaintext of the fields listed in the item's envelope
+func (fe *FieldEncryptor) decryptItem(ctx context.Context, item map[string]types.AttributeValue) error {
+	envelope, ok := item[envelopeAttribute].(*types.AttributeValueMemberM)
+	if !ok {
+		return nil // Written before encryption was enabled
+	}
+
+	dataKey, err := fe.envelopeKey(ctx, envelope)
+	if err != nil {
+		return err
+	}
+
+	id := attributeString(item["id"])
+	var fields []string
+	if ss, ok := envelope.Value["fields"].(*types.AttributeValueMemberSS); ok {
+		fields = ss.Value
+	}
+	for _, field := range fields {
+		ciphertext, ok := item[field].(*types.AttributeValueMemberB)
+		if !ok {
+			continue
+		}
+
+		plaintext, err := encryption.Open(dataKey, ciphertext.Value, fieldContext(id, field))
+		if err != nil {
+			return fmt.Errorf("failed to decrypt %s: %v", field, err)
+		}
+		item[field] = &types.AttributeValueMemberS{Value: string(plaintext)}
+	}
+
+	delete(item, envelopeAttribute)
+	return nil
+}
+
+// dataKey unwraps a data key, caching the result so the items sharing a data
+// key need a single provider call
+func (fe *FieldEncryptor) dataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	cacheKey := dataKeyCacheKey(wrapped, keyID)
+
+	fe.mu.Lock()
+	key, ok := fe.dataKeys[cacheKey]
+	fe.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+
+	key, err := fe.provider.DecryptDataKey(ctx, wrapped, keyID)
+	if err != nil {
+		return nil, err
+	}
+
+	fe.cacheDataKey(cacheKey, key)
+	return key, nil
+}
+
+// cacheDataKey remembers an unwrapped data key, emptying the cache when full
+func (fe *FieldEncryptor) cacheDataKey(cacheKey string, key []byte) {
+	fe.mu.Lock()
+	defer fe.mu.Unlock()
+	if len(fe.dataKeys) >= maxCachedDataKeys {
+		fe.dataKeys = map[string][]byte{}
+	}
+	fe.dataKeys[cacheKey] = key
+}
+
+// dataKeyCacheKey identifies a wrapped data key in the cache
+func dataKeyCacheKey(wrapped []byte, keyID string) string {
+	return keyID + "/" + string(wrapped)
+}
+
+// fieldContext binds a ciphertext to its item and attribute so it can't be moved elsewhere
+func fieldContext(id, field string) []byte {
+	return []byte(id + "/" + field)
+}
+
+// emailIndexValue returns the value stored in the email index for an email address
+func (fe *FieldEncryptor) emailIndexValue(email string) string {
+	normalized := strings.ToLower(strings.TrimSpace(email))
+	if fe == nil {
+		return normalized
+	}
+	return encryption.BlindIndex(fe.indexKey, normalized)
+}
+
+// emailDomainValue returns the value stored for the domain of an email address,
+// or "" when the address has no domain
+func (fe *FieldEncryptor) emailDomainValue(email string) string {
+	normalized := strings.ToLower(strings.TrimSpace(email))
+	at := strings.LastIndex(normalized, "@")
+	if at < 0 || at == len(normalized)-1 {
+		return ""
+	}
+	return fe.emailIndexValue(normalized[at+1:])
+}
+
+// isEncryptedField reports whether a customer attribute is stored encrypted
+func (fe *FieldEncryptor) isEncryptedField(field string) bool {
+	if fe == nil {
+		return false
+	}
+	for _, f := range fe.fields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// marshalCustomer converts a customer to a DynamoDB item, indexing its email and
+// encrypting PII fields when encryption is enabled
+func (fe *FieldEncryptor) marshalCustomer(ctx context.Context, customer *models.Customer) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(customer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal customer: %w", err)
+	}
+
+	if customer.Email != "" {
+		item[emailIndexAttribute] = &types.AttributeValueMemberS{Value: fe.emailIndexValue(customer.Email)}
+	}
+	if domain := fe.emailDomainValue(customer.Email); domain != "" {
+		item[emailDomainAttribute] = &types.AttributeValueMemberS{Value: domain}
+	}
+
+	if fe != nil {
+		if err := fe.encryptItem(ctx, customer.ID, item); err != nil {
+			return nil, err
+		}
+	}
+
+	return item, nil
+}
+
+// unmarshalCustomer converts a DynamoDB item to a customer, decrypting PII fields
+func (fe *FieldEncryptor) unmarshalCustomer(ctx context.Context, item map[string]types.AttributeValue, customer *models.Customer) error {
+	if item[envelopeAttribute] != nil {
+		if fe == nil {
+			return ErrEncryptionNotConfigured
+		}
+		if err := fe.decryptItem(ctx, item); err != nil {
+			return err
+		}
+	}
+
+	if err := attributevalue.UnmarshalMap(item, customer); err != nil {
+		return fmt.Errorf("failed to unmarshal customer: %w", err)
+	}
+	return nil
+}
+
+// unmarshalCustomers converts a list of DynamoDB items to customers
+func (fe *FieldEncryptor) unmarshalCustomers(ctx context.Context, items []map[string]types.AttributeValue) ([]models.Customer, error) {
+	customers := make([]models.Customer, len(items))
+	for i, item := range items {
+		if err := fe.unmarshalCustomer(ctx, item, &customers[i]); err != nil {
+			return nil, err
+		}
+	}
+	return customers, nil
+}
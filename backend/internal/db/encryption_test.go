@@ -0,0 +1,207 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/encryption"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testEncryptor returns an encryptor of fields with a fixed local key
+func testEncryptor(t *testing.T, fields []string) *FieldEncryptor {
+	provider, err := encryption.NewLocalKeyProviderFromKeys("test", map[string][]byte{
+		"test": bytes.Repeat([]byte{5}, encryption.DataKeySize),
+	})
+	require.NoError(t, err)
+
+	encryptor, err := NewFieldEncryptor(provider, fields, []byte("0123456789abcdef"))
+	require.NoError(t, err)
+
+	return encryptor
+}
+
+// countingProvider counts the calls made to a key provider
+type countingProvider struct {
+	encryption.KeyProvider
+	generated, decrypted int
+}
+
+func (p *countingProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	p.generated++
+	return p.KeyProvider.GenerateDataKey(ctx)
+}
+
+func (p *countingProvider) DecryptDataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	p.decrypted++
+	return p.KeyProvider.DecryptDataKey(ctx, wrapped, keyID)
+}
+
+// countingEncryptor returns an encryptor of names and emails counting its provider calls
+func countingEncryptor(t *testing.T) (*FieldEncryptor, *countingProvider) {
+	provider := &countingProvider{KeyProvider: testEncryptor(t, nil).provider}
+	encryptor, err := NewFieldEncryptor(provider, []string{"name", "email"}, []byte("0123456789abcdef"))
+	require.NoError(t, err)
+	return encryptor, provider
+}
+
+func TestFieldEncryptor_ReusesDataKeyAcrossScan(t *testing.T) {
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "PutItem" {
+			items = append(items, body["Item"])
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Items": items}
+	})
+	var written *countingProvider
+	client.encryptor, written = countingEncryptor(t)
+	for i := 0; i < 5; i++ {
+		customer := &models.Customer{ID: fmt.Sprintf("c%d", i), Name: "John", Email: fmt.Sprintf("john%d@example.com", i)}
+		require.NoError(t, CreateCustomer(context.Background(), client, "Customers", customer))
+	}
+	assert.Equal(t, 1, written.generated)
+
+	// Another instance scans the items, unwrapping their shared key once
+	var read *countingProvider
+	client.encryptor, read = countingEncryptor(t)
+
+	customers, err := ListCustomers(context.Background(), client, "Customers", CustomerFilter{})
+	require.NoError(t, err)
+	require.Len(t, customers, 5)
+	assert.Equal(t, "john4@example.com", customers[4].Email)
+	assert.Equal(t, 1, read.decrypted)
+	assert.Equal(t, 0, read.generated)
+}
+
+func TestFieldEncryptor_RenewsDataKey(t *testing.T) {
+	encryptor, provider := countingEncryptor(t)
+	customer := &models.Customer{ID: "123", Name: "John", Email: "john@example.com"}
+
+	_, err := encryptor.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+	encryptor.current.expiresAt = time.Now().Add(-time.Second)
+	_, err = encryptor.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+	assert.Equal(t, 2, provider.generated)
+
+	encryptor.current.uses = dataKeyMaxItems
+	item, err := encryptor.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+	assert.Equal(t, 3, provider.generated)
+
+	// Items written by the encryptor are read without unwrapping their key
+	var decrypted models.Customer
+	require.NoError(t, encryptor.unmarshalCustomer(context.Background(), item, &decrypted))
+	assert.Equal(t, "john@example.com", decrypted.Email)
+	assert.Equal(t, 0, provider.decrypted)
+}
+
+func TestMarshalCustomer_Plaintext(t *testing.T) {
+	customer := &models.Customer{ID: "123", Name: "John Doe", Email: "John.Doe@Example.com", Telephone: "+1-555-0123"}
+	var plaintext *FieldEncryptor
+
+	item, err := plaintext.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "John Doe"}, item["name"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "john.doe@example.com"}, item[emailIndexAttribute])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "example.com"}, item[emailDomainAttribute])
+	assert.Nil(t, item[envelopeAttribute])
+}
+
+func TestMarshalCustomer_EncryptsConfiguredFields(t *testing.T) {
+	encryptor := testEncryptor(t, []string{"name", "email", "telephone"})
+	customer := &models.Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com", Telephone: "+1-555-0123", Status: "active"}
+
+	item, err := encryptor.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+
+	for _, field := range []string{"name", "email", "telephone"} {
+		require.IsType(t, &types.AttributeValueMemberB{}, item[field], field)
+		assert.NotEmpty(t, item[field].(*types.AttributeValueMemberB).Value, field)
+	}
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "active"}, item["status"])
+	envelope := item[envelopeAttribute].(*types.AttributeValueMemberM).Value
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "test"}, envelope["keyId"])
+	assert.Len(t, envelope["fields"].(*types.AttributeValueMemberSS).Value, 3)
+
+	// The email index is a keyed hash rather than the address
+	assert.Equal(t, &types.AttributeValueMemberS{Value: encryptor.emailIndexValue("JOHN.DOE@example.com")}, item[emailIndexAttribute])
+	assert.NotContains(t, attributeString(item[emailIndexAttribute]), "example.com")
+
+	var decrypted models.Customer
+	require.NoError(t, encryptor.unmarshalCustomer(context.Background(), item, &decrypted))
+	assert.Equal(t, *customer, decrypted)
+}
+
+func TestMarshalCustomer_SkipsEmptyFields(t *testing.T) {
+	encryptor := testEncryptor(t, []string{"telephone"})
+	customer := &models.Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com"}
+
+	item, err := encryptor.marshalCustomer(context.Background(), customer)
+	require.NoError(t, err)
+
+	assert.Nil(t, item[envelopeAttribute])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "John Doe"}, item["name"])
+}
+
+func TestUnmarshalCustomer_RejectsMovedCiphertext(t *testing.T) {
+	encryptor := testEncryptor(t, []string{"email"})
+
+	item, err := encryptor.marshalCustomer(context.Background(), &models.Customer{ID: "123", Name: "John", Email: "john@example.com"})
+	require.NoError(t, err)
+
+	// A ciphertext copied onto another customer no longer decrypts
+	item["id"] = &types.AttributeValueMemberS{Value: "456"}
+
+	var customer models.Customer
+	assert.Error(t, encryptor.unmarshalCustomer(context.Background(), item, &customer))
+}
+
+func TestUnmarshalCustomer_EncryptedWithoutEncryptor(t *testing.T) {
+	encryptor := testEncryptor(t, []string{"email"})
+	item, err := encryptor.marshalCustomer(context.Background(), &models.Customer{ID: "123", Name: "John", Email: "john@example.com"})
+	require.NoError(t, err)
+
+	var customer models.Customer
+	var plaintext *FieldEncryptor
+	assert.ErrorIs(t, plaintext.unmarshalCustomer(context.Background(), item, &customer), ErrEncryptionNotConfigured)
+}
+
+func TestNewFieldEncryptor_Validation(t *testing.T) {
+	provider, err := encryption.NewLocalKeyProviderFromKeys("test", map[string][]byte{
+		"test": bytes.Repeat([]byte{5}, encryption.DataKeySize),
+	})
+	require.NoError(t, err)
+
+	_, err = NewFieldEncryptor(provider, []string{"id"}, []byte("0123456789abcdef"))
+	assert.Error(t, err)
+
+	_, err = NewFieldEncryptor(provider, []string{"email"}, []byte("short"))
+	assert.Error(t, err)
+}
+
+func TestBuildListFilter_Email(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{Email: "John@Example.com", Statuses: []string{"active"}})
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.NotNil(t, expr.KeyCondition())
+	assert.NotNil(t, expr.Filter())
+}
+
+func TestCustomersTableInput_HasEmailIndex(t *testing.T) {
+	input := customersTableInput("TestCustomers")
+
+	require.Len(t, input.GlobalSecondaryIndexes, 1)
+	assert.Equal(t, emailIndexName, *input.GlobalSecondaryIndexes[0].IndexName)
+	assert.Equal(t, emailIndexAttribute, *input.GlobalSecondaryIndexes[0].KeySchema[0].AttributeName)
+	assert.Len(t, input.AttributeDefinitions, 2)
+}
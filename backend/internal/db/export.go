@@ -0,0 +1,97 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// scanBufferSize bounds the customers read ahead of a slow consumer, so a
+// scan never holds more than a few pages in memory
+const scanBufferSize = 256
+
+// ScanCustomers passes every customer in the table to fn as the scan pages
+// through it. With more than one segment the table is split into segments
+// scanned in parallel, so customers arrive in no particular order. fn is never
+// called concurrently, and returning an error from it stops the scan.
+func ScanCustomers(ctx context.Context, client *Client, tableName string, segments int, fn func(models.Customer) error) error {
+	if segments < 1 {
+		segments = 1
+	}
+
+	customers := make(chan models.Customer, scanBufferSize)
+	done := make(chan struct{})
+	var stop sync.Once
+	cancel := func() { stop.Do(func() { close(done) }) }
+
+	errs := make(chan error, segments)
+	var wg sync.WaitGroup
+	for segment := 0; segment < segments; segment++ {
+		wg.Add(1)
+		go func(segment int) {
+			defer wg.Done()
+			if err := scanSegment(ctx, client, tableName, segment, segments, customers, done); err != nil {
+				errs <- err
+				cancel()
+			}
+		}(segment)
+	}
+	go func() {
+		wg.Wait()
+		close(customers)
+	}()
+
+	// Keep draining after a failure so the segment goroutines can exit
+	var fnErr error
+	for customer := range customers {
+		if fnErr != nil {
+			continue
+		}
+		if fnErr = fn(customer); fnErr != nil {
+			cancel()
+		}
+	}
+
+	if fnErr != nil {
+		return fnErr
+	}
+	select {
+	case err := <-errs:
+		return err
+	default:
+		return nil
+	}
+}
+
+// scanSegment sends the customers of one scan segment until it is exhausted or done is closed
+func scanSegment(ctx context.Context, client *Client, tableName string, segment, segments int, customers chan<- models.Customer, done <-chan struct{}) error {
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	if segments > 1 {
+		input.Segment = aws.Int32(int32(segment))
+		input.TotalSegments = aws.Int32(int32(segments))
+	}
+
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to scan segment %d: %w", segment, err)
+		}
+		pageCustomers, err := client.encryptor.unmarshalCustomers(ctx, page.Items)
+		if err != nil {
+			return err
+		}
+		for _, customer := range pageCustomers {
+			select {
+			case customers <- customer:
+			case <-done:
+				return nil
+			}
+		}
+	}
+	return nil
+}
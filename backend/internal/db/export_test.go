@@ -0,0 +1,105 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDynamoDB returns a client whose requests are answered by handle, which
+// receives the operation name and the decoded request body. Responses with an
+// "__type" are sent as errors. Operations aren't retried unless optFns set a retryer.
+func fakeDynamoDB(t *testing.T, handle func(operation string, body map[string]interface{}) interface{}, optFns ...func(*dynamodb.Options)) *Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		response := handle(operation, body)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		if fault, ok := response.(map[string]interface{}); ok && fault["__type"] != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}, optFns...), nil)
+}
+
+// segmentedScan answers scans with two customers per segment, split over two pages
+func segmentedScan(operation string, body map[string]interface{}) interface{} {
+	segment := 0
+	if s, ok := body["Segment"].(float64); ok {
+		segment = int(s)
+	}
+	page := 0
+	if start, ok := body["ExclusiveStartKey"].(map[string]interface{}); ok && start != nil {
+		page = 1
+	}
+
+	id := fmt.Sprintf("%d-%d", segment, page)
+	response := map[string]interface{}{
+		"Items": []map[string]interface{}{{"id": map[string]string{"S": id}, "name": map[string]string{"S": "Customer " + id}}},
+	}
+	if page == 0 {
+		response["LastEvaluatedKey"] = map[string]interface{}{"id": map[string]string{"S": id}}
+	}
+	return response
+}
+
+func TestScanCustomers_Segments(t *testing.T) {
+	client := fakeDynamoDB(t, segmentedScan)
+
+	var ids []string
+	err := ScanCustomers(context.Background(), client, "Customers", 3, func(customer models.Customer) error {
+		ids = append(ids, customer.ID)
+		return nil
+	})
+
+	require.NoError(t, err)
+	sort.Strings(ids)
+	assert.Equal(t, []string{"0-0", "0-1", "1-0", "1-1", "2-0", "2-1"}, ids)
+}
+
+func TestScanCustomers_StopsOnCallbackError(t *testing.T) {
+	client := fakeDynamoDB(t, segmentedScan)
+	stop := errors.New("client went away")
+
+	calls := 0
+	err := ScanCustomers(context.Background(), client, "Customers", 2, func(customer models.Customer) error {
+		calls++
+		return stop
+	})
+
+	assert.ErrorIs(t, err, stop)
+	assert.Equal(t, 1, calls)
+}
+
+func TestScanCustomers_ScanError(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ResourceNotFoundException", "message": "missing"}
+	})
+
+	err := ScanCustomers(context.Background(), client, "Customers", 1, func(customer models.Customer) error { return nil })
+
+	assert.Error(t, err)
+}
@@ -0,0 +1,170 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrEncryptedFilter is returned when filtering on an attribute that is stored encrypted
+var ErrEncryptedFilter = errors.New("can't filter on an encrypted field")
+
+// CustomerFilter narrows the customers returned by ListCustomers
+type CustomerFilter struct {
+	// Email matches customers with this address, ignoring case
+	Email string
+	// Metadata matches customers whose metadata has the given value for each key.
+	// Values are compared as strings, and also as numbers or booleans when they parse as one.
+	Metadata map[string]string
+	// Tags matches customers carrying every listed tag, or any of them when MatchAnyTag is set
+	Tags        []string
+	MatchAnyTag bool
+	// Statuses matches customers in any of the listed lifecycle statuses
+	Statuses []string
+	// NamePrefix matches customers whose name starts with it, respecting case
+	NamePrefix string
+	// EmailDomain matches customers whose email is at this domain, ignoring case
+	EmailDomain string
+	// CreatedAfter matches customers created after this RFC 3339 UTC timestamp
+	CreatedAfter string
+	// Fields limits the attributes read to this sparse fieldset; empty reads them all
+	Fields []string
+}
+
+// buildListFilter translates a CustomerFilter into a DynamoDB expression. The
+// email becomes a key condition on the email index and any fieldset becomes a
+// projection. It reports false when the filter has neither.
+func buildListFilter(encryptor *FieldEncryptor, filter CustomerFilter) (expression.Expression, bool, error) {
+	var conditions []expression.ConditionBuilder
+
+	// Sort keys so the generated expression is deterministic
+	keys := make([]string, 0, len(filter.Metadata))
+	for key := range filter.Metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		conditions = append(conditions, metadataCondition(key, filter.Metadata[key]))
+	}
+
+	if len(filter.Tags) > 0 {
+		var tagConditions []expression.ConditionBuilder
+		for _, tag := range filter.Tags {
+			tagConditions = append(tagConditions, expression.Name("tags").Contains(tag))
+		}
+		if filter.MatchAnyTag {
+			conditions = append(conditions, orAll(tagConditions))
+		} else {
+			conditions = append(conditions, tagConditions...)
+		}
+	}
+
+	if len(filter.Statuses) > 0 {
+		conditions = append(conditions, statusCondition(filter.Statuses))
+	}
+
+	if filter.NamePrefix != "" {
+		// Ciphertext can't be compared, so prefixes only work on plaintext names
+		if encryptor.isEncryptedField("name") {
+			return expression.Expression{}, false, fmt.Errorf("%w: name", ErrEncryptedFilter)
+		}
+		conditions = append(conditions, expression.Name("name").BeginsWith(filter.NamePrefix))
+	}
+
+	if filter.EmailDomain != "" {
+		conditions = append(conditions, expression.Name(emailDomainAttribute).Equal(expression.Value(encryptor.emailDomainValue("@"+filter.EmailDomain))))
+	}
+
+	if filter.CreatedAfter != "" {
+		// Timestamps share a fixed-width UTC layout, so they compare as strings
+		conditions = append(conditions, expression.Name("createdAt").GreaterThan(expression.Value(filter.CreatedAfter)))
+	}
+
+	if len(conditions) == 0 && filter.Email == "" && len(filter.Fields) == 0 {
+		return expression.Expression{}, false, nil
+	}
+
+	builder := expression.NewBuilder()
+	if len(filter.Fields) > 0 {
+		builder = builder.WithProjection(projection(filter.Fields))
+	}
+	if len(conditions) > 0 {
+		builder = builder.WithFilter(andAll(conditions))
+	}
+	if filter.Email != "" {
+		builder = builder.WithKeyCondition(expression.Key(emailIndexAttribute).Equal(expression.Value(encryptor.emailIndexValue(filter.Email))))
+	}
+
+	expr, err := builder.Build()
+	if err != nil {
+		return expression.Expression{}, false, fmt.Errorf("failed to build filter expression: %w", err)
+	}
+	return expr, true, nil
+}
+
+// projection selects the given customer attributes, plus the id and encryption
+// envelope that decrypting any of them depends on
+func projection(fields []string) expression.ProjectionBuilder {
+	builder := expression.NamesList(expression.Name("id"), expression.Name(envelopeAttribute))
+	// DynamoDB rejects projections that name the same attribute twice
+	seen := map[string]bool{"id": true, envelopeAttribute: true}
+	for _, field := range fields {
+		if !seen[field] {
+			seen[field] = true
+			builder = builder.AddNames(expression.Name(field))
+		}
+	}
+	return builder
+}
+
+// metadataCondition matches a metadata attribute against a query string value
+func metadataCondition(key, value string) expression.ConditionBuilder {
+	name := expression.Name("metadata." + key)
+	matches := []expression.ConditionBuilder{name.Equal(expression.Value(value))}
+
+	if number, err := strconv.ParseFloat(value, 64); err == nil {
+		matches = append(matches, name.Equal(expression.Value(number)))
+	}
+	if value == "true" || value == "false" {
+		matches = append(matches, name.Equal(expression.Value(value == "true")))
+	}
+
+	return orAll(matches)
+}
+
+// statusCondition matches any of the statuses. Items without a status
+// attribute are treated as having the default status.
+func statusCondition(statuses []string) expression.ConditionBuilder {
+	name := expression.Name("status")
+
+	var matches []expression.ConditionBuilder
+	for _, status := range statuses {
+		matches = append(matches, name.Equal(expression.Value(status)))
+		if status == models.DefaultStatus {
+			matches = append(matches, name.AttributeNotExists())
+		}
+	}
+
+	return orAll(matches)
+}
+
+// andAll combines conditions with AND
+func andAll(conditions []expression.ConditionBuilder) expression.ConditionBuilder {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return expression.And(conditions[0], conditions[1], conditions[2:]...)
+}
+
+// orAll combines conditions with OR
+func orAll(conditions []expression.ConditionBuilder) expression.ConditionBuilder {
+	if len(conditions) == 1 {
+		return conditions[0]
+	}
+	return expression.Or(conditions[0], conditions[1], conditions[2:]...)
+}
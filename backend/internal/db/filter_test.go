@@ -0,0 +1,146 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuildListFilter_Empty(t *testing.T) {
+	_, ok, err := buildListFilter(nil, CustomerFilter{})
+
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestBuildListFilter_Metadata(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{
+		Metadata: map[string]string{"loyaltyTier": "gold", "points": "120"},
+	})
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, expr.Filter())
+
+	// Both keys are nested under the metadata map attribute
+	names := map[string]bool{}
+	for _, name := range expr.Names() {
+		names[name] = true
+	}
+	assert.True(t, names["metadata"])
+	assert.True(t, names["loyaltyTier"])
+	assert.True(t, names["points"])
+
+	// "120" is matched as both a string and a number
+	var numbers, strings int
+	for _, value := range expr.Values() {
+		switch value.(type) {
+		case *types.AttributeValueMemberN:
+			numbers++
+		case *types.AttributeValueMemberS:
+			strings++
+		}
+	}
+	assert.Equal(t, 1, numbers)
+	assert.Equal(t, 2, strings)
+}
+
+func TestMetadataCondition_Boolean(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{
+		Metadata: map[string]string{"vip": "true"},
+	})
+
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	var booleans int
+	for _, value := range expr.Values() {
+		if value, ok := value.(*types.AttributeValueMemberBOOL); ok {
+			booleans++
+			assert.True(t, value.Value)
+		}
+	}
+	assert.Equal(t, 1, booleans)
+}
+
+func TestBuildListFilter_Tags(t *testing.T) {
+	tests := []struct {
+		name     string
+		filter   CustomerFilter
+		operator string
+	}{
+		{name: "all tags", filter: CustomerFilter{Tags: []string{"vip", "churn-risk"}}, operator: " AND "},
+		{name: "any tag", filter: CustomerFilter{Tags: []string{"vip", "churn-risk"}, MatchAnyTag: true}, operator: " OR "},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, ok, err := buildListFilter(nil, tt.filter)
+
+			require.NoError(t, err)
+			require.True(t, ok)
+			assert.Contains(t, *expr.Filter(), "contains")
+			assert.Contains(t, *expr.Filter(), tt.operator)
+			assert.Len(t, expr.Values(), 2)
+		})
+	}
+}
+
+func TestBuildListFilter_Statuses(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{Statuses: []string{"active"}})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.NotContains(t, *expr.Filter(), "attribute_not_exists")
+
+	// The default status also matches items stored before statuses existed
+	expr, ok, err = buildListFilter(nil, CustomerFilter{Statuses: []string{"lead", "active"}})
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Contains(t, *expr.Filter(), "attribute_not_exists")
+	assert.Contains(t, *expr.Filter(), " OR ")
+}
+
+func TestBuildListFilter_Fields(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{Fields: []string{"name", "email"}})
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, expr.Projection())
+	assert.Nil(t, expr.Filter())
+
+	// The id and encryption envelope are always read so fields can be decrypted
+	names := map[string]bool{}
+	for _, name := range expr.Names() {
+		names[name] = true
+	}
+	assert.Equal(t, map[string]bool{"id": true, envelopeAttribute: true, "name": true, "email": true}, names)
+}
+
+func TestBuildListFilter_Attributes(t *testing.T) {
+	expr, ok, err := buildListFilter(nil, CustomerFilter{
+		NamePrefix:   "Jo",
+		EmailDomain:  "Example.com",
+		CreatedAfter: "2024-01-01T00:00:00Z",
+	})
+
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.NotNil(t, expr.Filter())
+	assert.Contains(t, *expr.Filter(), "begins_with")
+
+	values := map[string]bool{}
+	for _, value := range expr.Values() {
+		values[value.(*types.AttributeValueMemberS).Value] = true
+	}
+	assert.Equal(t, map[string]bool{"Jo": true, "example.com": true, "2024-01-01T00:00:00Z": true}, values)
+}
+
+func TestBuildListFilter_EncryptedNamePrefix(t *testing.T) {
+	encryptor := testEncryptor(t, []string{"name"})
+
+	_, _, err := buildListFilter(encryptor, CustomerFilter{NamePrefix: "Jo"})
+
+	assert.ErrorIs(t, err, ErrEncryptedFilter)
+}
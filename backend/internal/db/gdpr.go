@@ -0,0 +1,84 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrCustomerErased is returned when a customer's personal data has already been erased
+var ErrCustomerErased = errors.New("customer already erased")
+
+// EraseCustomer irreversibly replaces a customer's personal data with placeholders,
+// closes the customer and records the erasure in the history table. The item
+// itself is kept as a tombstone so the erasure stays auditable. Placeholders are
+// stored in plaintext, so the encryption envelope and email indexes are dropped.
+func EraseCustomer(ctx context.Context, client *Client, tableName, historyTable string, entry *models.HistoryEntry) error {
+	entry.Type = models.HistoryErasure
+	entry.To = models.StatusClosed
+	newHistoryEntry(entry)
+
+	history, err := historyPut(historyTable, entry)
+	if err != nil {
+		return err
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName:           aws.String(tableName),
+					Key:                 customerKey(entry.CustomerID),
+					UpdateExpression:    aws.String("SET #name = :name, #email = :email, #status = :status, #erasedAt = :at REMOVE #telephone, #metadata, #enc, #emailIndex, #emailDomain ADD #version :one"),
+					ConditionExpression: aws.String("attribute_exists(#id) AND attribute_not_exists(#erasedAt)"),
+					ExpressionAttributeNames: map[string]string{
+						"#id":          "id",
+						"#name":        "name",
+						"#email":       "email",
+						"#status":      "status",
+						"#erasedAt":    "erasedAt",
+						"#telephone":   "telephone",
+						"#metadata":    "metadata",
+						"#enc":         envelopeAttribute,
+						"#emailIndex":  emailIndexAttribute,
+						"#emailDomain": emailDomainAttribute,
+						"#version":     versionAttribute,
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":name":   &types.AttributeValueMemberS{Value: models.ErasedName},
+						":email":  &types.AttributeValueMemberS{Value: models.ErasedEmail(entry.CustomerID)},
+						":status": &types.AttributeValueMemberS{Value: models.StatusClosed},
+						":at":     &types.AttributeValueMemberS{Value: entry.At},
+						":one":    versionIncrement,
+					},
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				},
+			},
+			history,
+		},
+	}
+	input.TransactItems, err = withEvents(ctx, client, input.TransactItems, newEvent(models.EventCustomerErased, entry.CustomerID, nil, nil))
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, input)
+	if err == nil {
+		client.unindexCustomer(entry.CustomerID)
+		return nil
+	}
+
+	if failed, exists := firstConditionFailed(err); failed {
+		if !exists {
+			return ErrCustomerNotFound
+		}
+		return ErrCustomerErased
+	}
+
+	return fmt.Errorf("failed to erase customer: %w", err)
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/google/uuid"
+)
+
+// sortableTimeLayout is a fixed-width timestamp that sorts lexicographically
+const sortableTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+// newSortableID returns an ID that sorts by creation time within a partition
+func newSortableID(t time.Time) string {
+	return t.UTC().Format(sortableTimeLayout) + "#" + uuid.New().String()
+}
+
+// newHistoryEntry stamps a history entry with its event ID and time
+func newHistoryEntry(entry *models.HistoryEntry) {
+	now := time.Now()
+	entry.EventID = newSortableID(now)
+	entry.At = now.UTC().Format(time.RFC3339)
+}
+
+// historyPut builds a transactional put of a history entry
+func historyPut(historyTable string, entry *models.HistoryEntry) (types.TransactWriteItem, error) {
+	item, err := attributevalue.MarshalMap(entry)
+	if err != nil {
+		return types.TransactWriteItem{}, fmt.Errorf("failed to marshal history entry: %w", err)
+	}
+
+	return types.TransactWriteItem{
+		Put: &types.Put{
+			TableName:                aws.String(historyTable),
+			Item:                     item,
+			ConditionExpression:      aws.String("attribute_not_exists(#eventId)"),
+			ExpressionAttributeNames: map[string]string{"#eventId": "eventId"},
+		},
+	}, nil
+}
+
+// ListHistory retrieves a customer's history, oldest first
+func ListHistory(ctx context.Context, client *Client, historyTable, customerID string) ([]models.HistoryEntry, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(historyTable),
+		KeyConditionExpression:   aws.String("#customerId = :customerId"),
+		ExpressionAttributeNames: map[string]string{"#customerId": "customerId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":customerId": &types.AttributeValueMemberS{Value: customerID},
+		},
+	}
+
+	entries := []models.HistoryEntry{}
+	paginator := dynamodb.NewQueryPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query history: %w", err)
+		}
+		var pageEntries []models.HistoryEntry
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageEntries); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal history: %w", err)
+		}
+		entries = append(entries, pageEntries...)
+	}
+
+	return entries, nil
+}
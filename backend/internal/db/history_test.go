@@ -0,0 +1,47 @@
+package db
+
+import (
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSortableID_SortsByTime(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ids := []string{
+		newSortableID(base.Add(time.Second)),
+		newSortableID(base.Add(10 * time.Millisecond)),
+		newSortableID(base),
+	}
+
+	sorted := append([]string{}, ids...)
+	sort.Strings(sorted)
+
+	assert.Equal(t, []string{ids[2], ids[1], ids[0]}, sorted)
+}
+
+func TestHistoryPut(t *testing.T) {
+	entry := &models.HistoryEntry{
+		CustomerID: "123",
+		Type:       models.HistoryStatusTransition,
+		From:       models.StatusLead,
+		To:         models.StatusActive,
+		Reason:     "signed contract",
+		Actor:      "alice",
+	}
+	newHistoryEntry(entry)
+
+	item, err := historyPut("TestHistory", entry)
+	require.NoError(t, err)
+
+	require.NotNil(t, item.Put)
+	assert.Equal(t, "TestHistory", *item.Put.TableName)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "123"}, item.Put.Item["customerId"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: entry.EventID}, item.Put.Item["eventId"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "signed contract"}, item.Put.Item["reason"])
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// idempotencyClaimAttempts bounds the retries of a claim racing with the
+// removal of the key it conflicted with
+const idempotencyClaimAttempts = 3
+
+// ErrIdempotencyKeyLost is returned when a request's key was taken over by
+// another after its lock expired
+var ErrIdempotencyKeyLost = errors.New("idempotency key lost")
+
+// EnsureIdempotencyTableExists checks if the idempotency keys table exists and
+// creates it if it doesn't, enabling time to live so keys expire
+func EnsureIdempotencyTableExists(ctx context.Context, client *Client, tableName string) error {
+	return ensureTableWithTTL(ctx, client, createTableInput(tableName, "id", ""))
+}
+
+// ClaimIdempotencyKey stores a pending record for a new request. When the key
+// is held, by a pending request or a complete one, that record is returned
+// instead. An expired key, or a pending one whose lock ended, is taken over.
+func ClaimIdempotencyKey(ctx context.Context, client *Client, tableName string, record *models.IdempotencyRecord, now time.Time) (*models.IdempotencyRecord, error) {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+
+	nowUnix := strconv.FormatInt(now.Unix(), 10)
+	for attempt := 0; attempt < idempotencyClaimAttempts; attempt++ {
+		_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(tableName),
+			Item:      item,
+			ConditionExpression: aws.String("attribute_not_exists(#id) OR #expiresAt < :now OR " +
+				"(#status = :pending AND #lockedUntil < :now)"),
+			ExpressionAttributeNames: map[string]string{
+				"#id":          "id",
+				"#expiresAt":   "expiresAt",
+				"#status":      "status",
+				"#lockedUntil": "lockedUntil",
+			},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":now":     &types.AttributeValueMemberN{Value: nowUnix},
+				":pending": &types.AttributeValueMemberS{Value: models.IdempotencyPending},
+			},
+		})
+		if err == nil {
+			return nil, nil
+		}
+		if !isConditionalCheckFailed(err) {
+			return nil, fmt.Errorf("failed to claim idempotency key: %w", err)
+		}
+
+		existing, err := getIdempotencyRecord(ctx, client, tableName, record.ID)
+		if err != nil {
+			return nil, err
+		}
+		if existing != nil {
+			return existing, nil
+		}
+		// The holder released the key in between, try again
+	}
+	return nil, fmt.Errorf("failed to claim idempotency key: kept changing")
+}
+
+// getIdempotencyRecord reads a key's record, nil when it doesn't exist
+func getIdempotencyRecord(ctx context.Context, client *Client, tableName, id string) (*models.IdempotencyRecord, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            customerKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get idempotency record: %w", err)
+	}
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	if result.Item[envelopeAttribute] != nil {
+		if client.encryptor == nil {
+			return nil, ErrEncryptionNotConfigured
+		}
+		if err := client.encryptor.decryptItem(ctx, result.Item); err != nil {
+			return nil, err
+		}
+	}
+
+	var record models.IdempotencyRecord
+	if err := attributevalue.UnmarshalMap(result.Item, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal idempotency record: %w", err)
+	}
+	return &record, nil
+}
+
+// CompleteIdempotencyKey stores the response of the request holding a key.
+// The response is encrypted like customer PII since it may contain some. It
+// fails with ErrIdempotencyKeyLost when another request took the key over.
+func CompleteIdempotencyKey(ctx context.Context, client *Client, tableName string, record *models.IdempotencyRecord) error {
+	record.Status = models.IdempotencyComplete
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal idempotency record: %w", err)
+	}
+	if client.encryptor != nil {
+		if err := client.encryptor.encryptAttributes(ctx, record.ID, item, []string{"responseBody"}); err != nil {
+			return err
+		}
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: record.Owner}},
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrIdempotencyKeyLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to complete idempotency key: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey removes the pending record of a request that failed,
+// so a retry is processed again. A key taken over by another is left alone.
+func ReleaseIdempotencyKey(ctx context.Context, client *Client, tableName string, record *models.IdempotencyRecord) error {
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       customerKey(record.ID),
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: record.Owner}},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("failed to release idempotency key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClaimIdempotencyKey_New(t *testing.T) {
+	var condition string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "PutItem", operation)
+		condition = body["ConditionExpression"].(string)
+		return map[string]interface{}{}
+	})
+
+	existing, err := ClaimIdempotencyKey(context.Background(), client, "Keys", &models.IdempotencyRecord{ID: "alice POST /customers k1", Status: models.IdempotencyPending}, time.Now())
+
+	require.NoError(t, err)
+	assert.Nil(t, existing)
+	assert.Contains(t, condition, "attribute_not_exists(#id)")
+}
+
+func TestClaimIdempotencyKey_Held(t *testing.T) {
+	var operations []string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		if operation == "PutItem" {
+			return map[string]interface{}{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+				"message": "The conditional request failed",
+			}
+		}
+		assert.Equal(t, true, body["ConsistentRead"])
+		return map[string]interface{}{"Item": map[string]interface{}{
+			"id":             map[string]string{"S": "alice POST /customers k1"},
+			"requestHash":    map[string]string{"S": "abc"},
+			"status":         map[string]string{"S": models.IdempotencyComplete},
+			"responseStatus": map[string]string{"N": "201"},
+			"responseBody":   map[string]string{"S": `{"id":"c1"}`},
+		}}
+	})
+
+	existing, err := ClaimIdempotencyKey(context.Background(), client, "Keys", &models.IdempotencyRecord{ID: "alice POST /customers k1"}, time.Now())
+
+	require.NoError(t, err)
+	require.NotNil(t, existing)
+	assert.Equal(t, []string{"PutItem", "GetItem"}, operations)
+	assert.Equal(t, models.IdempotencyComplete, existing.Status)
+	assert.Equal(t, 201, existing.ResponseStatus)
+	assert.Equal(t, `{"id":"c1"}`, existing.ResponseBody)
+}
+
+func TestCompleteIdempotencyKey_EncryptsResponse(t *testing.T) {
+	var stored map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "PutItem" {
+			stored = body["Item"].(map[string]interface{})
+			assert.Equal(t, "#owner = :owner", body["ConditionExpression"])
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Item": stored}
+	})
+	client.encryptor = testEncryptor(t, []string{"email"})
+	record := &models.IdempotencyRecord{ID: "alice POST /customers k1", Owner: "o1", ResponseStatus: 201, ResponseBody: `{"email":"john@example.com"}`}
+
+	require.NoError(t, CompleteIdempotencyKey(context.Background(), client, "Keys", record))
+
+	assert.NotContains(t, stored["responseBody"], "S")
+	assert.NotNil(t, stored[envelopeAttribute])
+	read, err := getIdempotencyRecord(context.Background(), client, "Keys", record.ID)
+	require.NoError(t, err)
+	assert.Equal(t, models.IdempotencyComplete, read.Status)
+	assert.Equal(t, `{"email":"john@example.com"}`, read.ResponseBody)
+}
+
+func TestCompleteIdempotencyKey_Lost(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	})
+
+	err := CompleteIdempotencyKey(context.Background(), client, "Keys", &models.IdempotencyRecord{ID: "k1", Owner: "o1"})
+	assert.ErrorIs(t, err, ErrIdempotencyKeyLost)
+
+	// Releasing a key held by another request leaves it alone
+	assert.NoError(t, ReleaseIdempotencyKey(context.Background(), client, "Keys", &models.IdempotencyRecord{ID: "k1", Owner: "o1"}))
+}
@@ -0,0 +1,379 @@
+package db
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// Kinds of data chunks stored for a job
+const (
+	JobInput  = "input"
+	JobResult = "result"
+)
+
+// MaxJobChunkBytes keeps job data chunks well below DynamoDB's 400 KB item limit
+const MaxJobChunkBytes = 256 << 10
+
+// jobTTLAttribute is the attribute DynamoDB's time to live deletes expired job items by
+const jobTTLAttribute = "expiresAt"
+
+var (
+	// ErrJobNotFound is returned when a job doesn't exist
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotClaimable is returned when a job is finished or leased by another worker
+	ErrJobNotClaimable = errors.New("job is not claimable")
+	// ErrJobLeaseLost is returned when a worker updates a job it no longer holds the lease on
+	ErrJobLeaseLost = errors.New("job lease lost")
+)
+
+// EnsureJobsTableExists checks if the jobs table exists and creates it if it
+// doesn't, enabling time to live so finished jobs expire
+func EnsureJobsTableExists(ctx context.Context, client *Client, tableName string) error {
+	return ensureTableWithTTL(ctx, client, createTableInput(tableName, "id", ""))
+}
+
+// EnsureJobDataTableExists checks if the job data table exists and creates it
+// if it doesn't, enabling time to live so job data expires with its job
+func EnsureJobDataTableExists(ctx context.Context, client *Client, tableName string) error {
+	return ensureTableWithTTL(ctx, client, createTableInput(tableName, "jobId", "chunk"))
+}
+
+// ensureTableWithTTL creates a table expiring items by jobTTLAttribute
+func ensureTableWithTTL(ctx context.Context, client *Client, input *dynamodb.CreateTableInput) error {
+	created, err := ensureTable(ctx, client, input)
+	if err != nil || !created {
+		return err
+	}
+
+	return EnableTimeToLive(ctx, client, aws.ToString(input.TableName), jobTTLAttribute)
+}
+
+// CreateJob stores a new job
+func CreateJob(ctx context.Context, client *Client, tableName string, job *models.Job) error {
+	item, err := attributevalue.MarshalMap(job)
+	if err != nil {
+		return fmt.Errorf("failed to marshal job: %w", err)
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]string{"#id": "id"},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create job: %w", err)
+	}
+	return nil
+}
+
+// GetJob retrieves a job by ID, returning ErrJobNotFound when it doesn't exist
+func GetJob(ctx context.Context, client *Client, tableName string, id string) (*models.Job, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            customerKey(id),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %w", err)
+	}
+	if result.Item == nil {
+		return nil, ErrJobNotFound
+	}
+
+	return unmarshalJob(result.Item)
+}
+
+// ListClaimableJobIDs returns the IDs of unfinished jobs whose lease is free or has expired
+func ListClaimableJobIDs(ctx context.Context, client *Client, tableName string, now time.Time) ([]string, error) {
+	filter := expression.Name("status").In(expression.Value(models.JobQueued), expression.Value(models.JobRunning)).
+		And(expression.Or(
+			expression.AttributeNotExists(expression.Name("leaseExpiresAt")),
+			expression.Name("leaseExpiresAt").LessThan(expression.Value(now.Unix())),
+		))
+	expr, err := expression.NewBuilder().
+		WithFilter(filter).
+		WithProjection(expression.NamesList(expression.Name("id"))).
+		Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build job filter: %w", err)
+	}
+
+	var ids []string
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName:                 aws.String(tableName),
+		FilterExpression:          expr.Filter(),
+		ProjectionExpression:      expr.Projection(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list jobs: %w", err)
+		}
+		for _, item := range page.Items {
+			ids = append(ids, attributeString(item["id"]))
+		}
+	}
+	return ids, nil
+}
+
+// ClaimJob leases an unfinished job to owner until the given time and marks
+// it running. It fails with ErrJobNotClaimable when the job is finished or
+// another worker holds an unexpired lease.
+func ClaimJob(ctx context.Context, client *Client, tableName string, id, owner string, now, until time.Time) (*models.Job, error) {
+	result, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:        aws.String(tableName),
+		Key:              customerKey(id),
+		UpdateExpression: aws.String("SET #status = :running, #leaseOwner = :owner, #leaseExpiresAt = :until, #updatedAt = :now"),
+		ConditionExpression: aws.String("#status IN (:queued, :running) AND " +
+			"(attribute_not_exists(#leaseExpiresAt) OR #leaseExpiresAt < :nowUnix)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status":         "status",
+			"#leaseOwner":     "leaseOwner",
+			"#leaseExpiresAt": "leaseExpiresAt",
+			"#updatedAt":      "updatedAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":queued":  &types.AttributeValueMemberS{Value: models.JobQueued},
+			":running": &types.AttributeValueMemberS{Value: models.JobRunning},
+			":owner":   &types.AttributeValueMemberS{Value: owner},
+			":until":   &types.AttributeValueMemberN{Value: strconv.FormatInt(until.Unix(), 10)},
+			":nowUnix": &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":now":     &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, ErrJobNotClaimable
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim job: %w", err)
+	}
+
+	return unmarshalJob(result.Attributes)
+}
+
+// SaveJobProgress stores a running job's progress and checkpoint and extends
+// its lease. It fails with ErrJobLeaseLost when owner no longer holds the lease.
+func SaveJobProgress(ctx context.Context, client *Client, tableName string, job *models.Job, owner string, until time.Time) error {
+	update := expression.Set(expression.Name("total"), expression.Value(job.Total)).
+		Set(expression.Name("processed"), expression.Value(job.Processed)).
+		Set(expression.Name("failed"), expression.Value(job.Failed)).
+		Set(expression.Name("inputChunks"), expression.Value(job.InputChunks)).
+		Set(expression.Name("resultChunks"), expression.Value(job.ResultChunks)).
+		Set(expression.Name("checkpoint"), expression.Value(job.Checkpoint)).
+		Set(expression.Name("leaseExpiresAt"), expression.Value(until.Unix())).
+		Set(expression.Name("updatedAt"), expression.Value(time.Now().UTC().Format(time.RFC3339)))
+	if len(job.Errors) > 0 {
+		update = update.Set(expression.Name("errors"), expression.Value(job.Errors))
+	}
+
+	return updateLeasedJob(ctx, client, tableName, job.ID, owner, update)
+}
+
+// FinishJob records the final state of a job and releases its lease
+func FinishJob(ctx context.Context, client *Client, tableName string, job *models.Job, owner string) error {
+	job.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+	update := expression.Set(expression.Name("status"), expression.Value(job.Status)).
+		Set(expression.Name("processed"), expression.Value(job.Processed)).
+		Set(expression.Name("failed"), expression.Value(job.Failed)).
+		Set(expression.Name("resultChunks"), expression.Value(job.ResultChunks)).
+		Set(expression.Name("finishedAt"), expression.Value(job.FinishedAt)).
+		Set(expression.Name("updatedAt"), expression.Value(job.FinishedAt)).
+		Remove(expression.Name("leaseOwner")).
+		Remove(expression.Name("leaseExpiresAt")).
+		Remove(expression.Name("checkpoint"))
+	if len(job.Errors) > 0 {
+		update = update.Set(expression.Name("errors"), expression.Value(job.Errors))
+	}
+	if job.Error != "" {
+		update = update.Set(expression.Name("error"), expression.Value(job.Error))
+	}
+
+	return updateLeasedJob(ctx, client, tableName, job.ID, owner, update)
+}
+
+// updateLeasedJob applies an update to a job on the condition that owner holds its lease
+func updateLeasedJob(ctx context.Context, client *Client, tableName, id, owner string, update expression.UpdateBuilder) error {
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.Name("leaseOwner").Equal(expression.Value(owner))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build job update: %w", err)
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       customerKey(id),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrJobLeaseLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update job: %w", err)
+	}
+	return nil
+}
+
+// unmarshalJob converts a DynamoDB item to a job
+func unmarshalJob(item map[string]types.AttributeValue) (*models.Job, error) {
+	var job models.Job
+	if err := attributevalue.UnmarshalMap(item, &job); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal job: %w", err)
+	}
+	return &job, nil
+}
+
+// PutJobChunk stores one chunk of a job's input or result data, encrypted
+// when field encryption is enabled since it holds customers' PII. Chunks are
+// numbered from 0 and rewriting a chunk replaces it, so a resumed job can
+// safely redo the chunk it was writing when it stopped.
+func PutJobChunk(ctx context.Context, client *Client, tableName string, job *models.Job, kind string, seq int, data []byte) error {
+	chunk := jobChunkKey(kind, seq)
+	item := map[string]types.AttributeValue{
+		"jobId":         &types.AttributeValueMemberS{Value: job.ID},
+		"chunk":         &types.AttributeValueMemberS{Value: chunk},
+		jobTTLAttribute: &types.AttributeValueMemberN{Value: strconv.FormatInt(job.ExpiresAt, 10)},
+	}
+	if client.encryptor != nil {
+		ciphertext, envelope, err := client.encryptor.encryptBytes(ctx, data, jobChunkContext(job.ID, chunk), "data")
+		if err != nil {
+			return err
+		}
+		data = ciphertext
+		item[envelopeAttribute] = envelope
+	}
+	item["data"] = &types.AttributeValueMemberB{Value: data}
+
+	_, err := client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to store job data: %w", err)
+	}
+	return nil
+}
+
+// jobChunkKey builds the sort key of a data chunk, padded so chunks sort in order
+func jobChunkKey(kind string, seq int) string {
+	return fmt.Sprintf("%s#%08d", kind, seq)
+}
+
+// jobChunkContext binds an encrypted chunk to its job and position
+func jobChunkContext(jobID, chunk string) []byte {
+	return []byte(jobID + "/" + chunk)
+}
+
+// JobChunkReader reads a job's data chunks in order, fetching one at a time
+type JobChunkReader struct {
+	ctx       context.Context
+	client    *Client
+	tableName string
+	jobID     string
+	kind      string
+	chunks    int
+	next      int
+	current   *bytes.Reader
+}
+
+// NewJobChunkReader returns a reader over the first chunks chunks of a job's data
+func NewJobChunkReader(ctx context.Context, client *Client, tableName string, jobID, kind string, chunks int) *JobChunkReader {
+	return &JobChunkReader{ctx: ctx, client: client, tableName: tableName, jobID: jobID, kind: kind, chunks: chunks}
+}
+
+// Read implements io.Reader
+func (r *JobChunkReader) Read(p []byte) (int, error) {
+	for r.current == nil || r.current.Len() == 0 {
+		if r.next >= r.chunks {
+			return 0, io.EOF
+		}
+
+		chunk := jobChunkKey(r.kind, r.next)
+		result, err := r.client.GetItem(r.ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(r.tableName),
+			Key: map[string]types.AttributeValue{
+				"jobId": &types.AttributeValueMemberS{Value: r.jobID},
+				"chunk": &types.AttributeValueMemberS{Value: chunk},
+			},
+		})
+		if err != nil {
+			return 0, fmt.Errorf("failed to read job data: %w", err)
+		}
+		data, ok := result.Item["data"].(*types.AttributeValueMemberB)
+		if !ok {
+			return 0, fmt.Errorf("job data chunk %d is missing", r.next)
+		}
+		plaintext := data.Value
+		if envelope, ok := result.Item[envelopeAttribute]; ok {
+			if r.client.encryptor == nil {
+				return 0, ErrEncryptionNotConfigured
+			}
+			if plaintext, err = r.client.encryptor.decryptBytes(r.ctx, data.Value, envelope, jobChunkContext(r.jobID, chunk), "data"); err != nil {
+				return 0, err
+			}
+		}
+		r.current = bytes.NewReader(plaintext)
+		r.next++
+	}
+
+	return r.current.Read(p)
+}
+
+// ScanCustomersPage reads up to limit customers in table order, starting
+// after the customer with ID startAfter, or from the beginning when it is
+// empty. It returns the ID to continue after, empty once the table is exhausted.
+func ScanCustomersPage(ctx context.Context, client *Client, tableName string, startAfter string, limit int) ([]models.Customer, string, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int32(int32(limit)),
+	}
+	if startAfter != "" {
+		input.ExclusiveStartKey = customerKey(startAfter)
+	}
+
+	result, err := client.Scan(ctx, input)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan customers: %w", err)
+	}
+
+	customers, err := client.encryptor.unmarshalCustomers(ctx, result.Items)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if result.LastEvaluatedKey != nil {
+		next = attributeString(result.LastEvaluatedKey["id"])
+	}
+	return customers, next, nil
+}
+
+// ApproximateCustomerCount returns DynamoDB's estimate of the number of
+// customers, which is refreshed about every six hours
+func ApproximateCustomerCount(ctx context.Context, client *Client, tableName string) (int, error) {
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe table: %w", err)
+	}
+	return int(aws.ToInt64(result.Table.ItemCount)), nil
+}
@@ -0,0 +1,127 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJobChunkKey(t *testing.T) {
+	assert.Equal(t, "input#00000000", jobChunkKey(JobInput, 0))
+	assert.Equal(t, "result#00000012", jobChunkKey(JobResult, 12))
+}
+
+func TestJobChunkReader(t *testing.T) {
+	chunks := map[string]string{"result#00000000": "id,name\n", "result#00000001": "1,John\n"}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "GetItem", operation)
+		key := body["Key"].(map[string]interface{})
+		chunk := key["chunk"].(map[string]interface{})["S"].(string)
+		return map[string]interface{}{"Item": map[string]interface{}{
+			"data": map[string]string{"B": base64.StdEncoding.EncodeToString([]byte(chunks[chunk]))},
+		}}
+	})
+
+	data, err := io.ReadAll(NewJobChunkReader(context.Background(), client, "CustomerJobData", "job-1", JobResult, 2))
+
+	require.NoError(t, err)
+	assert.Equal(t, "id,name\n1,John\n", string(data))
+}
+
+func TestJobChunkReader_MissingChunk(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{}
+	})
+
+	_, err := io.ReadAll(NewJobChunkReader(context.Background(), client, "CustomerJobData", "job-1", JobInput, 1))
+
+	assert.ErrorContains(t, err, "missing")
+}
+
+func TestPutJobChunk_Encrypted(t *testing.T) {
+	stored := map[string]interface{}{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "PutItem" {
+			item := body["Item"].(map[string]interface{})
+			stored[item["chunk"].(map[string]interface{})["S"].(string)] = item
+			return map[string]interface{}{}
+		}
+		chunk := body["Key"].(map[string]interface{})["chunk"].(map[string]interface{})["S"].(string)
+		return map[string]interface{}{"Item": stored[chunk]}
+	})
+	client.encryptor = testEncryptor(t, []string{"email"})
+	job := &models.Job{ID: "job-1", ExpiresAt: 1}
+
+	files := []string{"name,email\nJohn,john@example.com\n", `{"id":"c1","email":"john@example.com"}` + "\n"}
+	for seq, file := range files {
+		require.NoError(t, PutJobChunk(context.Background(), client, "CustomerJobData", job, JobResult, seq, []byte(file)))
+	}
+
+	// The stored bytes are ciphertext, not the CSV or NDJSON text
+	for seq, file := range files {
+		item := stored[jobChunkKey(JobResult, seq)].(map[string]interface{})
+		data, err := base64.StdEncoding.DecodeString(item["data"].(map[string]interface{})["B"].(string))
+		require.NoError(t, err)
+		assert.NotContains(t, string(data), "john@example.com")
+		assert.NotEqual(t, file, string(data))
+		assert.NotNil(t, item[envelopeAttribute])
+	}
+
+	data, err := io.ReadAll(NewJobChunkReader(context.Background(), client, "CustomerJobData", "job-1", JobResult, 2))
+	require.NoError(t, err)
+	assert.Equal(t, files[0]+files[1], string(data))
+
+	// A chunk moved to another position can't be decrypted
+	stored[jobChunkKey(JobResult, 0)], stored[jobChunkKey(JobResult, 1)] = stored[jobChunkKey(JobResult, 1)], stored[jobChunkKey(JobResult, 0)]
+	_, err = io.ReadAll(NewJobChunkReader(context.Background(), client, "CustomerJobData", "job-1", JobResult, 2))
+	assert.Error(t, err)
+
+	// Encrypted chunks can't be read without an encryptor
+	client.encryptor = nil
+	_, err = io.ReadAll(NewJobChunkReader(context.Background(), client, "CustomerJobData", "job-1", JobResult, 1))
+	assert.ErrorIs(t, err, ErrEncryptionNotConfigured)
+}
+
+func TestClaimJob(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "UpdateItem", operation)
+		return map[string]interface{}{"Attributes": map[string]interface{}{
+			"id":         map[string]string{"S": "job-1"},
+			"type":       map[string]string{"S": models.JobExport},
+			"status":     map[string]string{"S": models.JobRunning},
+			"checkpoint": map[string]string{"S": "customer-9"},
+		}}
+	})
+
+	job, err := ClaimJob(context.Background(), client, "CustomerJobs", "job-1", "worker", time.Now(), time.Now().Add(time.Minute))
+
+	require.NoError(t, err)
+	assert.Equal(t, models.JobRunning, job.Status)
+	assert.Equal(t, "customer-9", job.Checkpoint)
+}
+
+func TestClaimJob_NotClaimable(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"}
+	})
+
+	_, err := ClaimJob(context.Background(), client, "CustomerJobs", "job-1", "worker", time.Now(), time.Now().Add(time.Minute))
+
+	assert.ErrorIs(t, err, ErrJobNotClaimable)
+}
+
+func TestSaveJobProgress_LeaseLost(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "not owner"}
+	})
+
+	err := SaveJobProgress(context.Background(), client, "CustomerJobs", &models.Job{ID: "job-1"}, "worker", time.Now())
+
+	assert.ErrorIs(t, err, ErrJobLeaseLost)
+}
@@ -0,0 +1,69 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrLeaseHeld is returned when another process holds an unexpired lease
+var ErrLeaseHeld = errors.New("lease is held by another process")
+
+// leaseItemKey is the key of a named lease in the settings table
+func leaseItemKey(name string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key": &types.AttributeValueMemberS{Value: "lease#" + name},
+	}
+}
+
+// AcquireLease leases a named settings item to owner until the given time,
+// renewing the lease when owner already holds it. It fails with ErrLeaseHeld
+// when another process holds an unexpired lease.
+func AcquireLease(ctx context.Context, client *Client, tableName, name, owner string, now, until time.Time) error {
+	_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 leaseItemKey(name),
+		UpdateExpression:    aws.String("SET #leaseOwner = :owner, #leaseExpiresAt = :until"),
+		ConditionExpression: aws.String("attribute_not_exists(#leaseExpiresAt) OR #leaseExpiresAt < :now OR #leaseOwner = :owner"),
+		ExpressionAttributeNames: map[string]string{
+			"#leaseOwner":     "leaseOwner",
+			"#leaseExpiresAt": "leaseExpiresAt",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+			":until": &types.AttributeValueMemberN{Value: strconv.FormatInt(until.UnixMilli(), 10)},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.UnixMilli(), 10)},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrLeaseHeld
+	}
+	if err != nil {
+		return fmt.Errorf("failed to acquire lease %s: %w", name, err)
+	}
+	return nil
+}
+
+// ReleaseLease frees a named lease held by owner. A lease taken over by
+// another process is left alone.
+func ReleaseLease(ctx context.Context, client *Client, tableName, name, owner string) error {
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:                aws.String(tableName),
+		Key:                      leaseItemKey(name),
+		ConditionExpression:      aws.String("#leaseOwner = :owner"),
+		ExpressionAttributeNames: map[string]string{"#leaseOwner": "leaseOwner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+		},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("failed to release lease %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAcquireLease(t *testing.T) {
+	var body map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, b map[string]interface{}) interface{} {
+		require.Equal(t, "UpdateItem", operation)
+		body = b
+		return map[string]interface{}{}
+	})
+
+	now := time.UnixMilli(1700000000000)
+	require.NoError(t, AcquireLease(context.Background(), client, "Settings", "stream-events", "o1", now, now.Add(time.Minute)))
+
+	assert.Equal(t, map[string]interface{}{"key": map[string]interface{}{"S": "lease#stream-events"}}, body["Key"])
+	assert.Contains(t, body["ConditionExpression"], "#leaseOwner = :owner", "the holder renews its lease")
+	assert.Equal(t, map[string]interface{}{"N": "1700000060000"}, body["ExpressionAttributeValues"].(map[string]interface{})[":until"])
+}
+
+func TestAcquireLease_Held(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"}
+	})
+
+	now := time.Now()
+	err := AcquireLease(context.Background(), client, "Settings", "stream-events", "o2", now, now.Add(time.Minute))
+
+	assert.ErrorIs(t, err, ErrLeaseHeld)
+	assert.NoError(t, ReleaseLease(context.Background(), client, "Settings", "stream-events", "o2"), "a lease held by another process is left alone")
+}
@@ -0,0 +1,163 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrMergeConflict is returned when a customer changed, for example was
+// erased or merged, between the read a merge was based on and the merge
+var ErrMergeConflict = errors.New("customer changed concurrently")
+
+// activeCustomerCondition holds for customers that exist and are neither erased nor merged
+const activeCustomerCondition = "attribute_exists(#id) AND attribute_not_exists(#erasedAt) AND attribute_not_exists(#mergedInto)"
+
+// MergeCustomers replaces the survivor with its merged state and tombstones
+// the loser in one transaction. The loser keeps its data but is closed, points
+// to the survivor and gives up its tags and email lookups. Tag counts are
+// adjusted and the merge is recorded in both customers' history. survivor and
+// loser are the customers as they were read before merging: both writes are
+// conditioned on their versions, so edits and tag changes made since aren't
+// overwritten and the tag counts stay exact.
+func MergeCustomers(ctx context.Context, client *Client, tableName, tagsTable, historyTable string, survivor, merged, loser *models.Customer, entry *models.HistoryEntry) error {
+	merged.Version = survivor.Version + 1
+	item, err := client.encryptor.marshalCustomer(ctx, merged)
+	if err != nil {
+		return err
+	}
+
+	entry.Type = models.HistoryMerge
+	entry.CustomerID = merged.ID
+	entry.RelatedCustomerID = loser.ID
+	newHistoryEntry(entry)
+	loserEntry := *entry
+	loserEntry.CustomerID = loser.ID
+	loserEntry.RelatedCustomerID = merged.ID
+	loserEntry.From = loser.CurrentStatus()
+	loserEntry.To = models.StatusClosed
+	newHistoryEntry(&loserEntry)
+
+	survivorCondition, names, survivorValues := versionCondition(survivor.Version)
+	names["#id"] = "id"
+	names["#erasedAt"] = "erasedAt"
+	names["#mergedInto"] = "mergedInto"
+
+	loserCondition, loserNames, loserValues := versionCondition(loser.Version)
+	for placeholder, name := range map[string]string{
+		"#id":          "id",
+		"#erasedAt":    "erasedAt",
+		"#mergedInto":  "mergedInto",
+		"#status":      "status",
+		"#tags":        "tags",
+		"#emailIndex":  emailIndexAttribute,
+		"#emailDomain": emailDomainAttribute,
+	} {
+		loserNames[placeholder] = name
+	}
+	if loserValues == nil {
+		loserValues = map[string]types.AttributeValue{}
+	}
+	loserValues[":closed"] = &types.AttributeValueMemberS{Value: models.StatusClosed}
+	loserValues[":survivor"] = &types.AttributeValueMemberS{Value: merged.ID}
+	loserValues[":one"] = versionIncrement
+
+	items := []types.TransactWriteItem{
+		{
+			Put: &types.Put{
+				TableName:                           aws.String(tableName),
+				Item:                                item,
+				ConditionExpression:                 aws.String(activeCustomerCondition + " AND " + survivorCondition),
+				ExpressionAttributeNames:            names,
+				ExpressionAttributeValues:           survivorValues,
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			},
+		},
+		{
+			Update: &types.Update{
+				TableName:                           aws.String(tableName),
+				Key:                                 customerKey(loser.ID),
+				UpdateExpression:                    aws.String("SET #status = :closed, #mergedInto = :survivor REMOVE #tags, #emailIndex, #emailDomain ADD #version :one"),
+				ConditionExpression:                 aws.String(activeCustomerCondition + " AND " + loserCondition),
+				ExpressionAttributeNames:            loserNames,
+				ExpressionAttributeValues:           loserValues,
+				ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+			},
+		},
+	}
+
+	for _, history := range []*models.HistoryEntry{entry, &loserEntry} {
+		put, err := historyPut(historyTable, history)
+		if err != nil {
+			return err
+		}
+		items = append(items, put)
+	}
+
+	// Tags both customers carried lose the loser's count, the others move to the survivor
+	for _, tag := range loser.Tags {
+		if survivor.HasTag(tag) {
+			items = append(items, tagCountUpdate(tagsTable, tag, "-1"))
+		}
+	}
+
+	items, err = withEvents(ctx, client, items,
+		newEvent(models.EventCustomerUpdated, merged.ID, merged, nil),
+		newEvent(models.EventCustomerMerged, loser.ID, nil, map[string]string{"mergedInto": merged.ID}),
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err == nil {
+		client.indexCustomer(merged)
+		client.unindexCustomer(loser.ID)
+		return nil
+	}
+
+	for i := range items[:2] {
+		if failed, exists := conditionFailed(err, i); failed {
+			if !exists {
+				return ErrCustomerNotFound
+			}
+			return ErrMergeConflict
+		}
+	}
+
+	return fmt.Errorf("failed to merge customers: %w", err)
+}
+
+// MoveCustomerNotes re-files every note of a customer under another customer,
+// keeping their IDs so they stay in creation order
+func MoveCustomerNotes(ctx context.Context, client *Client, notesTable, fromID, toID string) error {
+	notes, err := ListAllNotes(ctx, client, notesTable, fromID)
+	if err != nil {
+		return err
+	}
+
+	puts := make([]types.WriteRequest, 0, len(notes))
+	deletes := make([]types.WriteRequest, 0, len(notes))
+	for _, note := range notes {
+		deletes = append(deletes, deleteRequest(noteKey(fromID, note.ID)))
+
+		note.CustomerID = toID
+		item, err := attributevalue.MarshalMap(note)
+		if err != nil {
+			return fmt.Errorf("failed to marshal note: %w", err)
+		}
+		puts = append(puts, putRequest(item))
+	}
+
+	// Copy before deleting so an interrupted move never loses a note
+	if err := batchWrite(ctx, client, notesTable, puts); err != nil {
+		return err
+	}
+	return batchWrite(ctx, client, notesTable, deletes)
+}
@@ -0,0 +1,76 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mergeFixtures() (*models.Customer, *models.Customer, *models.Customer) {
+	survivor := &models.Customer{ID: "survivor", Name: "John Smith", Email: "john@example.com", Tags: []string{"vip"}}
+	loser := &models.Customer{ID: "loser", Name: "Jon Smith", Email: "JOHN@example.com", Tags: []string{"newsletter", "vip"}}
+	merged := models.MergeCustomers(*survivor, *loser, models.MergeRequest{Strategy: models.MergeKeepSurvivor})
+	return survivor, &merged, loser
+}
+
+func TestMergeCustomers_Transaction(t *testing.T) {
+	survivor, merged, loser := mergeFixtures()
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "TransactWriteItems", operation)
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+
+	entry := &models.HistoryEntry{Actor: "admin"}
+	err := MergeCustomers(context.Background(), client, "Customers", "Tags", "History", survivor, merged, loser, entry)
+
+	require.NoError(t, err)
+	assert.Equal(t, "survivor", entry.CustomerID)
+	assert.Equal(t, "loser", entry.RelatedCustomerID)
+	// Survivor put, loser tombstone, two history entries and the shared tag's count
+	require.Len(t, items, 5)
+	tagUpdate := items[4].(map[string]interface{})["Update"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"S": "vip"}, tagUpdate["Key"].(map[string]interface{})["tag"])
+	assert.Equal(t, map[string]interface{}{"N": "-1"}, tagUpdate["ExpressionAttributeValues"].(map[string]interface{})[":delta"])
+}
+
+func TestMergeCustomers_LoserAlreadyMerged(t *testing.T) {
+	survivor, merged, loser := mergeFixtures()
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message": "Transaction cancelled",
+			"CancellationReasons": []map[string]interface{}{
+				{"Code": "None"},
+				{"Code": "ConditionalCheckFailed", "Item": map[string]interface{}{"id": map[string]string{"S": "loser"}}},
+			},
+		}
+	})
+
+	err := MergeCustomers(context.Background(), client, "Customers", "Tags", "History", survivor, merged, loser, &models.HistoryEntry{})
+
+	assert.ErrorIs(t, err, ErrMergeConflict)
+}
+
+func TestMergeCustomers_ConditionedOnVersions(t *testing.T) {
+	survivor, merged, loser := mergeFixtures()
+	survivor.Version = 4
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+
+	err := MergeCustomers(context.Background(), client, "Customers", "Tags", "History", survivor, merged, loser, &models.HistoryEntry{})
+
+	require.NoError(t, err)
+	put := transactItem(items, 0, "Put")
+	assert.Contains(t, put["ConditionExpression"], "#version = :version")
+	assert.Equal(t, map[string]interface{}{":version": map[string]interface{}{"N": "4"}}, put["ExpressionAttributeValues"])
+	assert.Equal(t, map[string]interface{}{"N": "5"}, put["Item"].(map[string]interface{})["version"])
+	assert.Contains(t, transactItem(items, 1, "Update")["ConditionExpression"], "attribute_not_exists(#version)")
+}
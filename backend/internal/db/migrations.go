@@ -0,0 +1,149 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// migrationStateKey is the settings item recording the applied schema migrations
+const migrationStateKey = "schema-migrations"
+
+var (
+	// ErrMigrationLocked is returned when another process holds the migration lease
+	ErrMigrationLocked = errors.New("schema migrations are being applied by another process")
+	// ErrMigrationLeaseLost is returned when a process updates the migration
+	// state after its lease was taken over
+	ErrMigrationLeaseLost = errors.New("schema migration lease lost")
+)
+
+// migrationStateItemKey is the key of the migration state in the settings table
+func migrationStateItemKey() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key": &types.AttributeValueMemberS{Value: migrationStateKey},
+	}
+}
+
+// GetMigrationState returns the recorded migration state, at version 0 when
+// no migration was ever applied
+func GetMigrationState(ctx context.Context, client *Client, tableName string) (*models.MigrationState, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(tableName),
+		Key:            migrationStateItemKey(),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get migration state: %w", err)
+	}
+	if result.Item == nil {
+		return &models.MigrationState{}, nil
+	}
+	return unmarshalMigrationState(result.Item)
+}
+
+// AcquireMigrationLease leases the migration state to owner until the given
+// time and returns it. It fails with ErrMigrationLocked when another process
+// holds an unexpired lease.
+func AcquireMigrationLease(ctx context.Context, client *Client, tableName string, owner string, now, until time.Time) (*models.MigrationState, error) {
+	result, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(tableName),
+		Key:                 migrationStateItemKey(),
+		UpdateExpression:    aws.String("SET #leaseOwner = :owner, #leaseExpiresAt = :until, #version = if_not_exists(#version, :zero)"),
+		ConditionExpression: aws.String("attribute_not_exists(#leaseExpiresAt) OR #leaseExpiresAt < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#leaseOwner":     "leaseOwner",
+			"#leaseExpiresAt": "leaseExpiresAt",
+			"#version":        "version",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":owner": &types.AttributeValueMemberS{Value: owner},
+			":until": &types.AttributeValueMemberN{Value: strconv.FormatInt(until.Unix(), 10)},
+			":now":   &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Unix(), 10)},
+			":zero":  &types.AttributeValueMemberN{Value: "0"},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	})
+	if isConditionalCheckFailed(err) {
+		return nil, ErrMigrationLocked
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire migration lease: %w", err)
+	}
+	return unmarshalMigrationState(result.Attributes)
+}
+
+// SaveMigrationState stores the applied migrations and the checkpoint of the
+// next one, extending the lease. It fails with ErrMigrationLeaseLost when
+// owner no longer holds the lease.
+func SaveMigrationState(ctx context.Context, client *Client, tableName string, state *models.MigrationState, owner string, until time.Time) error {
+	update := expression.Set(expression.Name("version"), expression.Value(state.Version)).
+		Set(expression.Name("leaseExpiresAt"), expression.Value(until.Unix()))
+	if len(state.Applied) > 0 {
+		update = update.Set(expression.Name("applied"), expression.Value(state.Applied))
+	}
+	if state.Checkpoint != "" {
+		update = update.Set(expression.Name("checkpoint"), expression.Value(state.Checkpoint))
+	} else {
+		update = update.Remove(expression.Name("checkpoint"))
+	}
+	return updateMigrationState(ctx, client, tableName, owner, update)
+}
+
+// ExtendMigrationLease keeps the migration lease with owner until the given time
+func ExtendMigrationLease(ctx context.Context, client *Client, tableName string, owner string, until time.Time) error {
+	update := expression.Set(expression.Name("leaseExpiresAt"), expression.Value(until.Unix()))
+	return updateMigrationState(ctx, client, tableName, owner, update)
+}
+
+// ReleaseMigrationLease frees the migration lease held by owner
+func ReleaseMigrationLease(ctx context.Context, client *Client, tableName string, owner string) error {
+	update := expression.Remove(expression.Name("leaseOwner")).
+		Remove(expression.Name("leaseExpiresAt"))
+	return updateMigrationState(ctx, client, tableName, owner, update)
+}
+
+// updateMigrationState applies an update to the migration state on the
+// condition that owner holds its lease
+func updateMigrationState(ctx context.Context, client *Client, tableName, owner string, update expression.UpdateBuilder) error {
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.Name("leaseOwner").Equal(expression.Value(owner))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build migration state update: %w", err)
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       migrationStateItemKey(),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrMigrationLeaseLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update migration state: %w", err)
+	}
+	return nil
+}
+
+// unmarshalMigrationState converts the settings item to the migration state
+func unmarshalMigrationState(item map[string]types.AttributeValue) (*models.MigrationState, error) {
+	var state models.MigrationState
+	if err := attributevalue.UnmarshalMap(item, &state); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal migration state: %w", err)
+	}
+	return &state, nil
+}
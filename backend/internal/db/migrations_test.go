@@ -0,0 +1,89 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetMigrationState_None(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "GetItem", operation)
+		assert.Equal(t, map[string]interface{}{"key": map[string]interface{}{"S": migrationStateKey}}, body["Key"])
+		return map[string]interface{}{}
+	})
+
+	state, err := GetMigrationState(context.Background(), client, "Settings")
+
+	require.NoError(t, err)
+	assert.Equal(t, &models.MigrationState{}, state)
+}
+
+func TestAcquireMigrationLease(t *testing.T) {
+	var condition string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "UpdateItem", operation)
+		condition = body["ConditionExpression"].(string)
+		return map[string]interface{}{"Attributes": map[string]interface{}{
+			"key":        map[string]string{"S": migrationStateKey},
+			"version":    map[string]string{"N": "2"},
+			"checkpoint": map[string]string{"S": `{"id":"c1"}`},
+			"applied": map[string]interface{}{"L": []interface{}{
+				map[string]interface{}{"M": map[string]interface{}{
+					"version":   map[string]string{"N": "1"},
+					"name":      map[string]string{"S": "first"},
+					"appliedAt": map[string]string{"S": "2024-01-01T00:00:00Z"},
+				}},
+			}},
+			"leaseOwner":     map[string]string{"S": "o1"},
+			"leaseExpiresAt": map[string]string{"N": "1700000060"},
+		}}
+	})
+
+	now := time.Unix(1700000000, 0)
+	state, err := AcquireMigrationLease(context.Background(), client, "Settings", "o1", now, now.Add(time.Minute))
+
+	require.NoError(t, err)
+	assert.Equal(t, "attribute_not_exists(#leaseExpiresAt) OR #leaseExpiresAt < :now", condition)
+	assert.Equal(t, 2, state.Version)
+	assert.Equal(t, `{"id":"c1"}`, state.Checkpoint)
+	assert.Equal(t, []models.AppliedMigration{{Version: 1, Name: "first", AppliedAt: "2024-01-01T00:00:00Z"}}, state.Applied)
+	assert.Equal(t, "o1", state.LeaseOwner)
+}
+
+func TestAcquireMigrationLease_Locked(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"}
+	})
+
+	_, err := AcquireMigrationLease(context.Background(), client, "Settings", "o1", time.Now(), time.Now().Add(time.Minute))
+
+	assert.ErrorIs(t, err, ErrMigrationLocked)
+}
+
+func TestSaveMigrationState_ClearsCheckpoint(t *testing.T) {
+	var update string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		update = body["UpdateExpression"].(string)
+		return map[string]interface{}{}
+	})
+	state := &models.MigrationState{Version: 1, Applied: []models.AppliedMigration{{Version: 1, Name: "first"}}}
+
+	require.NoError(t, SaveMigrationState(context.Background(), client, "Settings", state, "o1", time.Now()))
+
+	assert.Contains(t, update, "REMOVE")
+}
+
+func TestSaveMigrationState_LeaseLost(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"}
+	})
+
+	err := SaveMigrationState(context.Background(), client, "Settings", &models.MigrationState{Checkpoint: "c"}, "o1", time.Now())
+
+	assert.ErrorIs(t, err, ErrMigrationLeaseLost)
+}
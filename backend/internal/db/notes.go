@@ -0,0 +1,272 @@
+package db
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+var (
+	// ErrNoteNotFound is returned when a note doesn't exist
+	ErrNoteNotFound = errors.New("note not found")
+	// ErrNotNoteAuthor is returned when someone other than the author edits a note
+	ErrNotNoteAuthor = errors.New("only the author can edit a note")
+	// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+	ErrInvalidCursor = errors.New("invalid cursor")
+)
+
+// EnsureNotesTableExists checks if the notes table exists and creates it if it doesn't
+func EnsureNotesTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "customerId", "noteId"))
+	return err
+}
+
+// CreateNote stores a new note under its customer's partition
+func CreateNote(ctx context.Context, client *Client, notesTable string, note *models.Note) error {
+	now := time.Now()
+	note.ID = newSortableID(now)
+	note.CreatedAt = now.UTC().Format(time.RFC3339)
+	note.UpdatedAt = ""
+
+	item, err := attributevalue.MarshalMap(note)
+	if err != nil {
+		return fmt.Errorf("failed to marshal note: %w", err)
+	}
+
+	input := &dynamodb.PutItemInput{
+		Item:                     item,
+		TableName:                aws.String(notesTable),
+		ConditionExpression:      aws.String("attribute_not_exists(#noteId)"),
+		ExpressionAttributeNames: map[string]string{"#noteId": "noteId"},
+	}
+
+	_, err = client.PutItem(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	return nil
+}
+
+// GetNote retrieves a single note, or nil if it doesn't exist
+func GetNote(ctx context.Context, client *Client, notesTable, customerID, noteID string) (*models.Note, error) {
+	input := &dynamodb.GetItemInput{
+		Key:       noteKey(customerID, noteID),
+		TableName: aws.String(notesTable),
+	}
+
+	result, err := client.GetItem(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, nil // Note not found
+	}
+
+	var note models.Note
+	if err := attributevalue.UnmarshalMap(result.Item, &note); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal note: %w", err)
+	}
+
+	return &note, nil
+}
+
+// ListNotes returns a page of a customer's notes, newest first. An empty cursor
+// starts from the newest note; the returned cursor is empty on the last page.
+func ListNotes(ctx context.Context, client *Client, notesTable, customerID string, limit int64, cursor string) (*models.NotePage, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(notesTable),
+		KeyConditionExpression:   aws.String("#customerId = :customerId"),
+		ExpressionAttributeNames: map[string]string{"#customerId": "customerId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":customerId": &types.AttributeValueMemberS{Value: customerID},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	if cursor != "" {
+		noteID, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		input.ExclusiveStartKey = noteKey(customerID, noteID)
+	}
+
+	result, err := client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query notes: %w", err)
+	}
+
+	page := &models.NotePage{Items: []models.Note{}}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &page.Items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal notes: %w", err)
+	}
+
+	if lastKey := attributeString(result.LastEvaluatedKey["noteId"]); lastKey != "" {
+		page.NextCursor = encodeCursor(lastKey)
+	}
+
+	return page, nil
+}
+
+// UpdateNote replaces a note's body. It only succeeds when author wrote the note.
+func UpdateNote(ctx context.Context, client *Client, notesTable, customerID, noteID, author, body string) (*models.Note, error) {
+	input := &dynamodb.UpdateItemInput{
+		TableName:           aws.String(notesTable),
+		Key:                 noteKey(customerID, noteID),
+		UpdateExpression:    aws.String("SET #body = :body, #updatedAt = :updatedAt"),
+		ConditionExpression: aws.String("attribute_exists(#noteId) AND #author = :author"),
+		ExpressionAttributeNames: map[string]string{
+			"#body":      "body",
+			"#updatedAt": "updatedAt",
+			"#noteId":    "noteId",
+			"#author":    "author",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":body":      &types.AttributeValueMemberS{Value: body},
+			":updatedAt": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":author":    &types.AttributeValueMemberS{Value: author},
+		},
+		ReturnValues: types.ReturnValueAllNew,
+	}
+
+	result, err := client.UpdateItem(ctx, input)
+	if err != nil {
+		if !isConditionalCheckFailed(err) {
+			return nil, fmt.Errorf("failed to update item: %w", err)
+		}
+
+		// Tell a missing note apart from one written by someone else
+		existing, getErr := GetNote(ctx, client, notesTable, customerID, noteID)
+		if getErr != nil {
+			return nil, getErr
+		}
+		if existing == nil {
+			return nil, ErrNoteNotFound
+		}
+		return nil, ErrNotNoteAuthor
+	}
+
+	var note models.Note
+	if err := attributevalue.UnmarshalMap(result.Attributes, &note); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal note: %w", err)
+	}
+
+	return &note, nil
+}
+
+// DeleteNote removes a note
+func DeleteNote(ctx context.Context, client *Client, notesTable, customerID, noteID string) error {
+	input := &dynamodb.DeleteItemInput{
+		TableName:                aws.String(notesTable),
+		Key:                      noteKey(customerID, noteID),
+		ConditionExpression:      aws.String("attribute_exists(#noteId)"),
+		ExpressionAttributeNames: map[string]string{"#noteId": "noteId"},
+	}
+
+	_, err := client.DeleteItem(ctx, input)
+	if err != nil {
+		if isConditionalCheckFailed(err) {
+			return ErrNoteNotFound
+		}
+		return fmt.Errorf("failed to delete item: %w", err)
+	}
+
+	return nil
+}
+
+// ListAllNotes returns every note of a customer, oldest first
+func ListAllNotes(ctx context.Context, client *Client, notesTable, customerID string) ([]models.Note, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(notesTable),
+		KeyConditionExpression:   aws.String("#customerId = :customerId"),
+		ExpressionAttributeNames: map[string]string{"#customerId": "customerId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":customerId": &types.AttributeValueMemberS{Value: customerID},
+		},
+	}
+
+	notes := []models.Note{}
+	paginator := dynamodb.NewQueryPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query notes: %w", err)
+		}
+		var pageNotes []models.Note
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageNotes); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal notes: %w", err)
+		}
+		notes = append(notes, pageNotes...)
+	}
+
+	return notes, nil
+}
+
+// HasNotes reports whether a customer has at least one note
+func HasNotes(ctx context.Context, client *Client, notesTable, customerID string) (bool, error) {
+	page, err := ListNotes(ctx, client, notesTable, customerID, 1, "")
+	if err != nil {
+		return false, err
+	}
+	return len(page.Items) > 0, nil
+}
+
+// DeleteCustomerNotes removes every note stored under a customer
+func DeleteCustomerNotes(ctx context.Context, client *Client, notesTable, customerID string) error {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(notesTable),
+		KeyConditionExpression:   aws.String("#customerId = :customerId"),
+		ProjectionExpression:     aws.String("#customerId, #noteId"),
+		ExpressionAttributeNames: map[string]string{"#customerId": "customerId", "#noteId": "noteId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":customerId": &types.AttributeValueMemberS{Value: customerID},
+		},
+	}
+
+	var requests []types.WriteRequest
+	paginator := dynamodb.NewQueryPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query notes: %w", err)
+		}
+		for _, item := range page.Items {
+			requests = append(requests, deleteRequest(item))
+		}
+	}
+
+	return batchWrite(ctx, client, notesTable, requests)
+}
+
+// noteKey builds the primary key of a note item
+func noteKey(customerID, noteID string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"customerId": &types.AttributeValueMemberS{Value: customerID},
+		"noteId":     &types.AttributeValueMemberS{Value: noteID},
+	}
+}
+
+// encodeCursor makes a sort key opaque for use in query strings
+func encodeCursor(sortKey string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(sortKey))
+}
+
+// decodeCursor reverses encodeCursor
+func decodeCursor(cursor string) (string, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || len(decoded) == 0 {
+		return "", ErrInvalidCursor
+	}
+	return string(decoded), nil
+}
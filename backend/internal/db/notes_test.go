@@ -0,0 +1,35 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCursor_RoundTrip(t *testing.T) {
+	sortKey := "2024-01-01T12:00:00.000000000Z#123e4567-e89b-12d3-a456-426614174000"
+
+	cursor := encodeCursor(sortKey)
+	assert.NotContains(t, cursor, "#")
+
+	decoded, err := decodeCursor(cursor)
+	require.NoError(t, err)
+	assert.Equal(t, sortKey, decoded)
+}
+
+func TestDecodeCursor_Invalid(t *testing.T) {
+	_, err := decodeCursor("not base64!")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+
+	_, err = decodeCursor("")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestNoteKey(t *testing.T) {
+	key := noteKey("123", "note-1")
+
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "123"}, key["customerId"])
+	assert.Equal(t, &types.AttributeValueMemberS{Value: "note-1"}, key["noteId"])
+}
@@ -0,0 +1,233 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// eventCustomerAttribute holds an event's customer snapshot, marshalled like
+// a customer item so its PII is encrypted the same way
+const eventCustomerAttribute = "customer"
+
+// SetOutboxTable enables events, appending them to the given table in the
+// same transaction as the customer writes made through the client they
+// describe. Passing "" disables them.
+func (client *Client) SetOutboxTable(tableName string) {
+	client.outboxTable = tableName
+}
+
+// EnsureOutboxTableExists checks if the outbox table exists and creates it if it doesn't
+func EnsureOutboxTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "customerId", "eventId"))
+	return err
+}
+
+// newEvent builds an event that happened now. Its ID sorts the customer's
+// events in the order they were written.
+func newEvent(eventType, customerID string, customer *models.Customer, data map[string]string) *models.Event {
+	now := time.Now()
+	return &models.Event{
+		CustomerID: customerID,
+		ID:         newSortableID(now),
+		Type:       eventType,
+		OccurredAt: now.UTC().Format(time.RFC3339),
+		Customer:   customer,
+		Data:       data,
+	}
+}
+
+// withEvents appends the outbox puts of events to a transaction's items. It
+// returns items unchanged while the client's events are disabled.
+func withEvents(ctx context.Context, client *Client, items []types.TransactWriteItem, events ...*models.Event) ([]types.TransactWriteItem, error) {
+	if client.outboxTable == "" {
+		return items, nil
+	}
+
+	for _, event := range events {
+		item, err := client.encryptor.marshalEvent(ctx, event)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, types.TransactWriteItem{
+			Put: &types.Put{
+				TableName: aws.String(client.outboxTable),
+				Item:      item,
+			},
+		})
+	}
+	return items, nil
+}
+
+// marshalEvent converts an event to an item, encrypting its customer snapshot
+func (fe *FieldEncryptor) marshalEvent(ctx context.Context, event *models.Event) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %w", err)
+	}
+	if event.Customer != nil {
+		snapshot, err := fe.marshalCustomer(ctx, event.Customer)
+		if err != nil {
+			return nil, err
+		}
+		item[eventCustomerAttribute] = &types.AttributeValueMemberM{Value: snapshot}
+	}
+	return item, nil
+}
+
+// writeWithEvent applies a single write together with its event. Without
+// events the write is sent on its own rather than as a transaction.
+func writeWithEvent(ctx context.Context, client *Client, write types.TransactWriteItem, event *models.Event) error {
+	if client.outboxTable == "" {
+		var err error
+		switch {
+		case write.Put != nil:
+			_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+				TableName:                           write.Put.TableName,
+				Item:                                write.Put.Item,
+				ConditionExpression:                 write.Put.ConditionExpression,
+				ExpressionAttributeNames:            write.Put.ExpressionAttributeNames,
+				ExpressionAttributeValues:           write.Put.ExpressionAttributeValues,
+				ReturnValuesOnConditionCheckFailure: write.Put.ReturnValuesOnConditionCheckFailure,
+			})
+		case write.Delete != nil:
+			_, err = client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+				TableName:                           write.Delete.TableName,
+				Key:                                 write.Delete.Key,
+				ConditionExpression:                 write.Delete.ConditionExpression,
+				ExpressionAttributeNames:            write.Delete.ExpressionAttributeNames,
+				ExpressionAttributeValues:           write.Delete.ExpressionAttributeValues,
+				ReturnValuesOnConditionCheckFailure: write.Delete.ReturnValuesOnConditionCheckFailure,
+			})
+		}
+		return err
+	}
+
+	items, err := withEvents(ctx, client, []types.TransactWriteItem{write}, event)
+	if err != nil {
+		return err
+	}
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	return err
+}
+
+// ListOutboxEvents returns a page of at most limit pending events, starting
+// after cursor, and the cursor of the next page, "" after the last one.
+// Dead-lettered events are left out. Each customer's events are returned
+// together, in the order they happened.
+func ListOutboxEvents(ctx context.Context, client *Client, tableName, cursor string, limit int) ([]models.Event, string, error) {
+	startKey, err := decodeScanCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	result, err := client.Scan(ctx, &dynamodb.ScanInput{
+		TableName:                aws.String(tableName),
+		ExclusiveStartKey:        startKey,
+		Limit:                    aws.Int32(int32(limit)),
+		FilterExpression:         aws.String("attribute_not_exists(#deadLetteredAt)"),
+		ExpressionAttributeNames: map[string]string{"#deadLetteredAt": deadLetteredAtAttribute},
+	})
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to scan outbox: %w", err)
+	}
+
+	events := make([]models.Event, 0, len(result.Items))
+	for _, item := range result.Items {
+		var event models.Event
+		if err := client.encryptor.unmarshalEvent(ctx, item, &event); err != nil {
+			return nil, "", err
+		}
+		events = append(events, event)
+	}
+
+	next := ""
+	if result.LastEvaluatedKey != nil {
+		if next, err = encodeScanCursor(result.LastEvaluatedKey); err != nil {
+			return nil, "", err
+		}
+	}
+	return events, next, nil
+}
+
+// unmarshalEvent converts an outbox item to an event, decrypting its customer snapshot
+func (fe *FieldEncryptor) unmarshalEvent(ctx context.Context, item map[string]types.AttributeValue, event *models.Event) error {
+	if err := attributevalue.UnmarshalMap(item, event); err != nil {
+		return fmt.Errorf("failed to unmarshal event: %w", err)
+	}
+	if snapshot, ok := item[eventCustomerAttribute].(*types.AttributeValueMemberM); ok {
+		event.Customer = &models.Customer{}
+		if err := fe.unmarshalCustomer(ctx, snapshot.Value, event.Customer); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deadLetteredAtAttribute marks an event given up on. It stays in the outbox
+// for inspection but is no longer published.
+const deadLetteredAtAttribute = "deadLetteredAt"
+
+// RetryOutboxEvent records a failed publish of an event, which is retried from
+// nextAttemptAt on
+func RetryOutboxEvent(ctx context.Context, client *Client, tableName string, event models.Event, nextAttemptAt time.Time) error {
+	update := expression.Add(expression.Name("attempts"), expression.Value(1)).
+		Set(expression.Name("nextAttemptAt"), expression.Value(nextAttemptAt.UTC().Format(time.RFC3339Nano)))
+	return updateOutboxEvent(ctx, client, tableName, event, update)
+}
+
+// DeadLetterOutboxEvent gives up on publishing an event. The customer's later
+// events are published without it.
+func DeadLetterOutboxEvent(ctx context.Context, client *Client, tableName string, event models.Event, at time.Time) error {
+	update := expression.Add(expression.Name("attempts"), expression.Value(1)).
+		Set(expression.Name(deadLetteredAtAttribute), expression.Value(at.UTC().Format(time.RFC3339)))
+	return updateOutboxEvent(ctx, client, tableName, event, update)
+}
+
+// updateOutboxEvent applies an update to an event still in the outbox
+func updateOutboxEvent(ctx context.Context, client *Client, tableName string, event models.Event, update expression.UpdateBuilder) error {
+	expr, err := expression.NewBuilder().
+		WithUpdate(update).
+		WithCondition(expression.AttributeExists(expression.Name("eventId"))).
+		Build()
+	if err != nil {
+		return fmt.Errorf("failed to build event update: %w", err)
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"customerId": &types.AttributeValueMemberS{Value: event.CustomerID},
+			"eventId":    &types.AttributeValueMemberS{Value: event.ID},
+		},
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("failed to update event: %w", err)
+	}
+	return nil
+}
+
+// DeleteOutboxEvent removes a delivered event from the outbox
+func DeleteOutboxEvent(ctx context.Context, client *Client, tableName string, event models.Event) error {
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"customerId": &types.AttributeValueMemberS{Value: event.CustomerID},
+			"eventId":    &types.AttributeValueMemberS{Value: event.ID},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete event: %w", err)
+	}
+	return nil
+}
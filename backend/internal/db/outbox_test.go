@@ -0,0 +1,240 @@
+package db
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// transactItem returns the write of a transaction item, e.g. its "Put"
+func transactItem(items []interface{}, index int, write string) map[string]interface{} {
+	return items[index].(map[string]interface{})[write].(map[string]interface{})
+}
+
+func TestPutCustomer_WithoutOutbox(t *testing.T) {
+	var operations []string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		return map[string]interface{}{}
+	})
+
+	err := PutCustomer(context.Background(), client, "Customers", &models.Customer{ID: "c1", Name: "John Doe"})
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"PutItem"}, operations)
+}
+
+func TestPutCustomer_AppendsEvent(t *testing.T) {
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "TransactWriteItems", operation)
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+	client.SetOutboxTable("Outbox")
+
+	err := PutCustomer(context.Background(), client, "Customers", &models.Customer{ID: "c1", Name: "John Doe"})
+
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "Customers", transactItem(items, 0, "Put")["TableName"])
+	event := transactItem(items, 1, "Put")
+	assert.Equal(t, "Outbox", event["TableName"])
+	item := event["Item"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"S": "c1"}, item["customerId"])
+	assert.Equal(t, map[string]interface{}{"S": models.EventCustomerUpdated}, item["type"])
+	snapshot := item["customer"].(map[string]interface{})["M"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"S": "John Doe"}, snapshot["name"])
+}
+
+func TestCreateCustomer_Exists(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		assert.Equal(t, "attribute_not_exists(#id)", body["ConditionExpression"])
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	})
+
+	err := CreateCustomer(context.Background(), client, "Customers", &models.Customer{ID: "c1", Name: "John Doe"})
+
+	assert.ErrorIs(t, err, ErrCustomerExists)
+}
+
+func TestCreateCustomer_ExistsWithOutbox(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message": "Transaction cancelled",
+			"CancellationReasons": []map[string]interface{}{
+				{"Code": "ConditionalCheckFailed", "Item": map[string]interface{}{"id": map[string]string{"S": "c1"}}},
+				{"Code": "None"},
+			},
+		}
+	})
+	client.SetOutboxTable("Outbox")
+
+	err := CreateCustomer(context.Background(), client, "Customers", &models.Customer{ID: "c1", Name: "John Doe"})
+
+	assert.ErrorIs(t, err, ErrCustomerExists)
+}
+
+func TestPutCustomer_ConditionedOnVersion(t *testing.T) {
+	var put map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		put = body
+		return map[string]interface{}{}
+	})
+	customer := &models.Customer{ID: "c1", Name: "John Doe", Version: 3}
+
+	require.NoError(t, PutCustomer(context.Background(), client, "Customers", customer))
+
+	assert.Equal(t, "attribute_exists(#id) AND #version = :version", put["ConditionExpression"])
+	assert.Equal(t, map[string]interface{}{":version": map[string]interface{}{"N": "3"}}, put["ExpressionAttributeValues"])
+	assert.Equal(t, map[string]interface{}{"N": "4"}, put["Item"].(map[string]interface{})["version"])
+	assert.Equal(t, int64(4), customer.Version)
+
+	require.NoError(t, PutCustomer(context.Background(), client, "Customers", &models.Customer{ID: "c1", Name: "John Doe"}))
+	assert.Equal(t, "attribute_exists(#id) AND attribute_not_exists(#version)", put["ConditionExpression"], "customers stored before versions are at 0")
+}
+
+func TestPutCustomer_Changed(t *testing.T) {
+	for name, tt := range map[string]struct {
+		old      interface{}
+		expected error
+	}{
+		"changed": {old: map[string]interface{}{"id": map[string]string{"S": "c1"}}, expected: ErrCustomerChanged},
+		"deleted": {expected: ErrCustomerNotFound},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+				fault := map[string]interface{}{
+					"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+					"message": "The conditional request failed",
+				}
+				if tt.old != nil {
+					fault["Item"] = tt.old
+				}
+				return fault
+			})
+			customer := &models.Customer{ID: "c1", Name: "John Doe", Version: 3}
+
+			err := PutCustomer(context.Background(), client, "Customers", customer)
+
+			assert.ErrorIs(t, err, tt.expected)
+			assert.Equal(t, int64(3), customer.Version)
+		})
+	}
+}
+
+func TestAddCustomerTag_AppendsEvent(t *testing.T) {
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+	client.SetOutboxTable("Outbox")
+
+	added, err := AddCustomerTag(context.Background(), client, "Customers", "Tags", "c1", "vip")
+
+	require.NoError(t, err)
+	assert.True(t, added)
+	require.Len(t, items, 3)
+	item := transactItem(items, 2, "Put")["Item"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"S": models.EventCustomerTagged}, item["type"])
+	assert.Equal(t, map[string]interface{}{"M": map[string]interface{}{"tag": map[string]interface{}{"S": "vip"}}}, item["data"])
+}
+
+func TestWriteCustomers_TransactionPerCustomer(t *testing.T) {
+	var mu sync.Mutex
+	types := map[string]string{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "TransactWriteItems", operation)
+		items := body["TransactItems"].([]interface{})
+		require.Len(t, items, 2)
+		event := transactItem(items, 1, "Put")["Item"].(map[string]interface{})
+
+		mu.Lock()
+		defer mu.Unlock()
+		customerID := event["customerId"].(map[string]interface{})["S"].(string)
+		types[customerID] = event["type"].(map[string]interface{})["S"].(string)
+		if customerID == "c3" {
+			return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": "boom"}
+		}
+		return map[string]interface{}{}
+	})
+	client.SetOutboxTable("Outbox")
+
+	failed := WriteCustomers(context.Background(), client, "Customers",
+		[]*models.Customer{{ID: "c1", Name: "New"}},
+		[]*models.Customer{{ID: "c2", Name: "Changed"}},
+		[]*models.Customer{{ID: "c3"}})
+
+	assert.Equal(t, map[string]string{
+		"c1": models.EventCustomerCreated,
+		"c2": models.EventCustomerUpdated,
+		"c3": models.EventCustomerDeleted,
+	}, types)
+	require.Len(t, failed, 1)
+	assert.Error(t, failed["c3"])
+}
+
+func TestListOutboxEvents(t *testing.T) {
+	var scan map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "Scan", operation)
+		scan = body
+		return map[string]interface{}{
+			"LastEvaluatedKey": map[string]interface{}{
+				"customerId": map[string]string{"S": "c1"},
+				"eventId":    map[string]string{"S": "2024-01-01T00:00:01.000000000Z#b"},
+			},
+			"Items": []map[string]interface{}{
+				{
+					"customerId": map[string]string{"S": "c1"},
+					"eventId":    map[string]string{"S": "2024-01-01T00:00:00.000000000Z#a"},
+					"type":       map[string]string{"S": models.EventCustomerCreated},
+					"customer":   map[string]interface{}{"M": map[string]interface{}{"id": map[string]string{"S": "c1"}, "name": map[string]string{"S": "John Doe"}}},
+				},
+				{
+					"customerId": map[string]string{"S": "c1"},
+					"eventId":    map[string]string{"S": "2024-01-01T00:00:01.000000000Z#b"},
+					"type":       map[string]string{"S": models.EventCustomerDeleted},
+				},
+			},
+		}
+	})
+
+	events, next, err := ListOutboxEvents(context.Background(), client, "Outbox", `{"customerId":"c0","eventId":"x"}`, 2)
+
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, models.EventCustomerCreated, events[0].Type)
+	require.NotNil(t, events[0].Customer)
+	assert.Equal(t, "John Doe", events[0].Customer.Name)
+	assert.Equal(t, `{"customerId":"c1","eventId":"2024-01-01T00:00:01.000000000Z#b"}`, next)
+	assert.Equal(t, float64(2), scan["Limit"])
+	assert.Equal(t, map[string]interface{}{"customerId": map[string]interface{}{"S": "c0"}, "eventId": map[string]interface{}{"S": "x"}}, scan["ExclusiveStartKey"])
+	assert.Equal(t, "attribute_not_exists(#deadLetteredAt)", scan["FilterExpression"])
+}
+
+func TestDeadLetterOutboxEvent(t *testing.T) {
+	var update map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "UpdateItem", operation)
+		update = body
+		return map[string]interface{}{}
+	})
+
+	err := DeadLetterOutboxEvent(context.Background(), client, "Outbox", models.Event{CustomerID: "c1", ID: "e1"}, time.Now())
+
+	require.NoError(t, err)
+	assert.Contains(t, update["UpdateExpression"], "ADD")
+	assert.Contains(t, update["ExpressionAttributeNames"], "#2")
+	assert.Contains(t, update["ConditionExpression"], "attribute_exists", "a published event isn't brought back")
+}
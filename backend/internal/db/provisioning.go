@@ -0,0 +1,399 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/config"
+)
+
+// What startup does about an existing table whose settings differ from the
+// configured ones
+const (
+	ReconcileOff   = "off"
+	ReconcileLog   = "log"
+	ReconcileApply = "apply"
+)
+
+// TableSettings are the billing, encryption and protection settings of the
+// tables, applied to the tables created and reconciled with existing ones.
+// The zero TableSettings create on-demand tables and leave existing ones as
+// they are.
+type TableSettings struct {
+	BillingMode types.BillingMode
+	// ReadCapacity and WriteCapacity are the units of provisioned tables and
+	// of each of their indexes
+	ReadCapacity  int64
+	WriteCapacity int64
+	// MaxReadRequestUnits and MaxWriteRequestUnits cap on-demand tables, 0
+	// leaves them uncapped
+	MaxReadRequestUnits  int64
+	MaxWriteRequestUnits int64
+	// Autoscaling leaves provisioned capacity to autoscaling, so it is only
+	// set on new tables
+	Autoscaling bool
+	// KMSKeyID is the KMS key encrypting the tables, "" for the key owned by DynamoDB
+	KMSKeyID            string
+	PointInTimeRecovery bool
+	DeletionProtection  bool
+	Reconcile           string
+}
+
+// NewTableSettings returns the table settings in cfg, validating them
+func NewTableSettings(cfg *config.Config) (TableSettings, error) {
+	settings := TableSettings{
+		BillingMode:          types.BillingMode(cfg.DynamoDBBillingMode),
+		ReadCapacity:         int64(cfg.DynamoDBReadCapacity),
+		WriteCapacity:        int64(cfg.DynamoDBWriteCapacity),
+		MaxReadRequestUnits:  int64(cfg.DynamoDBMaxReadRequestUnits),
+		MaxWriteRequestUnits: int64(cfg.DynamoDBMaxWriteRequestUnits),
+		Autoscaling:          cfg.DynamoDBAutoscaling,
+		KMSKeyID:             cfg.DynamoDBKMSKeyID,
+		PointInTimeRecovery:  cfg.DynamoDBPointInTimeRecovery,
+		DeletionProtection:   cfg.DynamoDBDeletionProtection,
+		Reconcile:            cfg.DynamoDBReconcile,
+	}
+
+	switch settings.billingMode() {
+	case types.BillingModePayPerRequest:
+		if settings.MaxReadRequestUnits < 0 || settings.MaxWriteRequestUnits < 0 {
+			return TableSettings{}, fmt.Errorf("maximum request units can't be negative")
+		}
+	case types.BillingModeProvisioned:
+		if settings.ReadCapacity < 1 || settings.WriteCapacity < 1 {
+			return TableSettings{}, fmt.Errorf("provisioned tables need at least 1 read and 1 write capacity unit")
+		}
+	default:
+		return TableSettings{}, fmt.Errorf("unknown billing mode %q", settings.BillingMode)
+	}
+	switch settings.Reconcile {
+	case "", ReconcileOff, ReconcileLog, ReconcileApply:
+	default:
+		return TableSettings{}, fmt.Errorf("unknown reconcile mode %q", settings.Reconcile)
+	}
+	return settings, nil
+}
+
+// billingMode returns the configured billing mode, on-demand when unset
+func (s TableSettings) billingMode() types.BillingMode {
+	if s.BillingMode == "" {
+		return types.BillingModePayPerRequest
+	}
+	return s.BillingMode
+}
+
+// provisionedThroughput returns the configured capacity of provisioned tables
+func (s TableSettings) provisionedThroughput() *types.ProvisionedThroughput {
+	return &types.ProvisionedThroughput{
+		ReadCapacityUnits:  aws.Int64(s.ReadCapacity),
+		WriteCapacityUnits: aws.Int64(s.WriteCapacity),
+	}
+}
+
+// onDemandThroughput returns the configured cap of on-demand tables, nil when
+// uncapped. DynamoDB takes -1 for no cap when updating a table.
+func (s TableSettings) onDemandThroughput(update bool) *types.OnDemandThroughput {
+	if s.MaxReadRequestUnits == 0 && s.MaxWriteRequestUnits == 0 && !update {
+		return nil
+	}
+	return &types.OnDemandThroughput{
+		MaxReadRequestUnits:  aws.Int64(requestUnitsCap(s.MaxReadRequestUnits)),
+		MaxWriteRequestUnits: aws.Int64(requestUnitsCap(s.MaxWriteRequestUnits)),
+	}
+}
+
+func requestUnitsCap(units int64) int64 {
+	if units == 0 {
+		return -1
+	}
+	return units
+}
+
+// sseSpecification returns the configured encryption at rest
+func (s TableSettings) sseSpecification() *types.SSESpecification {
+	if s.KMSKeyID == "" {
+		return &types.SSESpecification{Enabled: aws.Bool(false)}
+	}
+	return &types.SSESpecification{
+		Enabled:        aws.Bool(true),
+		SSEType:        types.SSETypeKms,
+		KMSMasterKeyId: aws.String(s.KMSKeyID),
+	}
+}
+
+// apply sets the billing, encryption and protection of a table about to be created
+func (s TableSettings) apply(input *dynamodb.CreateTableInput) {
+	input.BillingMode = s.billingMode()
+	input.ProvisionedThroughput = nil
+	input.OnDemandThroughput = nil
+	if input.BillingMode == types.BillingModeProvisioned {
+		input.ProvisionedThroughput = s.provisionedThroughput()
+	} else {
+		input.OnDemandThroughput = s.onDemandThroughput(false)
+	}
+	for i := range input.GlobalSecondaryIndexes {
+		input.GlobalSecondaryIndexes[i].ProvisionedThroughput = input.ProvisionedThroughput
+	}
+
+	if s.KMSKeyID != "" {
+		input.SSESpecification = s.sseSpecification()
+	}
+	input.DeletionProtectionEnabled = aws.Bool(s.DeletionProtection)
+}
+
+// enablePointInTimeRecovery turns on continuous backups of a table when
+// configured. It can't be set when the table is created.
+func enablePointInTimeRecovery(ctx context.Context, client *Client, tableName string) error {
+	if !client.settings.PointInTimeRecovery {
+		return nil
+	}
+	return setPointInTimeRecovery(ctx, client, tableName, true)
+}
+
+func setPointInTimeRecovery(ctx context.Context, client *Client, tableName string, enabled bool) error {
+	_, err := client.UpdateContinuousBackups(ctx, &dynamodb.UpdateContinuousBackupsInput{
+		TableName: aws.String(tableName),
+		PointInTimeRecoverySpecification: &types.PointInTimeRecoverySpecification{
+			PointInTimeRecoveryEnabled: aws.Bool(enabled),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update point in time recovery of %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// tableBillingMode returns the billing mode of a table, which DynamoDB leaves
+// out for tables provisioned since their creation
+func tableBillingMode(table *types.TableDescription) types.BillingMode {
+	if table.BillingModeSummary != nil && table.BillingModeSummary.BillingMode != "" {
+		return table.BillingModeSummary.BillingMode
+	}
+	return types.BillingModeProvisioned
+}
+
+// indexThroughput returns the capacity of an index added to a table, which
+// only provisioned tables take
+func (s TableSettings) indexThroughput(table *types.TableDescription) *types.ProvisionedThroughput {
+	if tableBillingMode(table) != types.BillingModeProvisioned {
+		return nil
+	}
+	return s.provisionedThroughput()
+}
+
+// reconcileTable compares an existing table with the configured settings,
+// logging the differences and, when configured, updating the table to match.
+// Failures to compare are only logged unless updates are applied.
+func reconcileTable(ctx context.Context, client *Client, tableName string) error {
+	switch client.settings.Reconcile {
+	case "", ReconcileOff:
+		return nil
+	}
+	err := reconcile(ctx, client, tableName)
+	if err != nil && client.settings.Reconcile == ReconcileLog {
+		log.Printf("Failed to compare table %s with the configured settings: %v", tableName, err)
+		return nil
+	}
+	return err
+}
+
+// reconcile applies one change at a time, comparing the table again after
+// each. Every replica reconciles the tables when it starts, so a change
+// another replica is making is waited for rather than made again.
+func reconcile(ctx context.Context, client *Client, tableName string) error {
+	changes, err := describeTableChanges(ctx, client, tableName)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	descriptions := make([]string, len(changes))
+	for i, change := range changes {
+		descriptions[i] = change.description
+	}
+	log.Printf("Table %s differs from the configured settings: %s", tableName, strings.Join(descriptions, "; "))
+	if client.settings.Reconcile != ReconcileApply {
+		return nil
+	}
+
+	// DynamoDB takes one kind of table update at a time. Each change is given
+	// a second attempt in case another replica's update got in its way.
+	for attempts := 2 * len(changes); len(changes) > 0; attempts-- {
+		if attempts == 0 {
+			return fmt.Errorf("table %s still differs from the configured settings: %s", tableName, changes[0].description)
+		}
+		err := changes[0].apply(ctx, client)
+		if isResourceInUse(err) {
+			log.Printf("Table %s is being updated, waiting for the update to finish", tableName)
+			err = waitForTableActive(ctx, client, tableName)
+		}
+		if err != nil {
+			return err
+		}
+		if changes, err = describeTableChanges(ctx, client, tableName); err != nil {
+			return err
+		}
+	}
+	log.Printf("Updated table %s to the configured settings", tableName)
+	return nil
+}
+
+// describeTableChanges describes a table and returns its differences from the configured settings
+func describeTableChanges(ctx context.Context, client *Client, tableName string) ([]tableChange, error) {
+	described, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe table: %w", err)
+	}
+	backups, err := client.DescribeContinuousBackups(ctx, &dynamodb.DescribeContinuousBackupsInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe continuous backups: %w", err)
+	}
+	return tableChanges(client.settings, described.Table, pointInTimeRecoveryEnabled(backups.ContinuousBackupsDescription)), nil
+}
+
+// pointInTimeRecoveryEnabled reports whether a table's continuous backups include point in time recovery
+func pointInTimeRecoveryEnabled(description *types.ContinuousBackupsDescription) bool {
+	if description == nil || description.PointInTimeRecoveryDescription == nil {
+		return false
+	}
+	return description.PointInTimeRecoveryDescription.PointInTimeRecoveryStatus == types.PointInTimeRecoveryStatusEnabled
+}
+
+// tableChange is a difference between a table and the configured settings,
+// with the update removing it
+type tableChange struct {
+	description string
+	apply       func(ctx context.Context, client *Client) error
+}
+
+// tableChanges returns the differences between a table and the settings
+func tableChanges(settings TableSettings, table *types.TableDescription, pointInTimeRecovery bool) []tableChange {
+	tableName := aws.ToString(table.TableName)
+	var changes []tableChange
+	updateTable := func(description string, input *dynamodb.UpdateTableInput) {
+		input.TableName = aws.String(tableName)
+		changes = append(changes, tableChange{description: description, apply: func(ctx context.Context, client *Client) error {
+			if _, err := client.UpdateTable(ctx, input); err != nil {
+				return fmt.Errorf("failed to update %s of table %s: %w", description, tableName, err)
+			}
+			return waitForTableActive(ctx, client, tableName)
+		}})
+	}
+
+	billingMode := tableBillingMode(table)
+	switch {
+	case billingMode != settings.billingMode():
+		input := &dynamodb.UpdateTableInput{BillingMode: settings.billingMode()}
+		if input.BillingMode == types.BillingModeProvisioned {
+			// Every index of a table switched to provisioned needs its capacity
+			input.ProvisionedThroughput = settings.provisionedThroughput()
+			for _, index := range table.GlobalSecondaryIndexes {
+				input.GlobalSecondaryIndexUpdates = append(input.GlobalSecondaryIndexUpdates, types.GlobalSecondaryIndexUpdate{
+					Update: &types.UpdateGlobalSecondaryIndexAction{
+						IndexName:             index.IndexName,
+						ProvisionedThroughput: settings.provisionedThroughput(),
+					},
+				})
+			}
+		} else {
+			input.OnDemandThroughput = settings.onDemandThroughput(false)
+		}
+		updateTable(fmt.Sprintf("billing mode is %s, configured %s", billingMode, settings.billingMode()), input)
+
+	case billingMode == types.BillingModeProvisioned && !settings.Autoscaling:
+		if current, ok := capacityDiffers(table.ProvisionedThroughput, settings); ok {
+			updateTable(fmt.Sprintf("capacity is %s, configured %d/%d", current, settings.ReadCapacity, settings.WriteCapacity),
+				&dynamodb.UpdateTableInput{ProvisionedThroughput: settings.provisionedThroughput()})
+		}
+		for _, index := range table.GlobalSecondaryIndexes {
+			if current, ok := capacityDiffers(index.ProvisionedThroughput, settings); ok {
+				updateTable(fmt.Sprintf("index %s capacity is %s, configured %d/%d", aws.ToString(index.IndexName), current, settings.ReadCapacity, settings.WriteCapacity),
+					&dynamodb.UpdateTableInput{GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{{
+						Update: &types.UpdateGlobalSecondaryIndexAction{
+							IndexName:             index.IndexName,
+							ProvisionedThroughput: settings.provisionedThroughput(),
+						},
+					}}})
+			}
+		}
+
+	case billingMode == types.BillingModePayPerRequest:
+		var maxRead, maxWrite int64
+		if table.OnDemandThroughput != nil {
+			maxRead = max(aws.ToInt64(table.OnDemandThroughput.MaxReadRequestUnits), 0)
+			maxWrite = max(aws.ToInt64(table.OnDemandThroughput.MaxWriteRequestUnits), 0)
+		}
+		if maxRead != settings.MaxReadRequestUnits || maxWrite != settings.MaxWriteRequestUnits {
+			updateTable(fmt.Sprintf("maximum request units are %d/%d, configured %d/%d", maxRead, maxWrite, settings.MaxReadRequestUnits, settings.MaxWriteRequestUnits),
+				&dynamodb.UpdateTableInput{OnDemandThroughput: settings.onDemandThroughput(true)})
+		}
+	}
+
+	if key := tableKMSKey(table); !kmsKeyMatches(key, settings.KMSKeyID) {
+		updateTable(fmt.Sprintf("encryption key is %s, configured %s", describeKMSKey(key), describeKMSKey(settings.KMSKeyID)),
+			&dynamodb.UpdateTableInput{SSESpecification: settings.sseSpecification()})
+	}
+
+	if protected := aws.ToBool(table.DeletionProtectionEnabled); protected != settings.DeletionProtection {
+		updateTable(fmt.Sprintf("deletion protection is %t, configured %t", protected, settings.DeletionProtection),
+			&dynamodb.UpdateTableInput{DeletionProtectionEnabled: aws.Bool(settings.DeletionProtection)})
+	}
+
+	if pointInTimeRecovery != settings.PointInTimeRecovery {
+		enabled := settings.PointInTimeRecovery
+		changes = append(changes, tableChange{
+			description: fmt.Sprintf("point in time recovery is %t, configured %t", pointInTimeRecovery, enabled),
+			apply: func(ctx context.Context, client *Client) error {
+				return setPointInTimeRecovery(ctx, client, tableName, enabled)
+			},
+		})
+	}
+	return changes
+}
+
+// capacityDiffers reports whether a provisioned capacity differs from the
+// settings, describing it as "read/write"
+func capacityDiffers(throughput *types.ProvisionedThroughputDescription, settings TableSettings) (string, bool) {
+	var read, write int64
+	if throughput != nil {
+		read, write = aws.ToInt64(throughput.ReadCapacityUnits), aws.ToInt64(throughput.WriteCapacityUnits)
+	}
+	return fmt.Sprintf("%d/%d", read, write), read != settings.ReadCapacity || write != settings.WriteCapacity
+}
+
+// tableKMSKey returns the ARN of the KMS key encrypting a table, "" when it
+// uses the key owned by DynamoDB
+func tableKMSKey(table *types.TableDescription) string {
+	sse := table.SSEDescription
+	if sse == nil || sse.SSEType != types.SSETypeKms {
+		return ""
+	}
+	switch sse.Status {
+	case types.SSEStatusEnabled, types.SSEStatusEnabling, types.SSEStatusUpdating:
+		return aws.ToString(sse.KMSMasterKeyArn)
+	}
+	return ""
+}
+
+// kmsKeyMatches reports whether a table's KMS key ARN is the configured key.
+// Keys configured by alias can't be told apart from their ARN, so any KMS key
+// matches them.
+func kmsKeyMatches(arn, configured string) bool {
+	switch {
+	case configured == "" || arn == "":
+		return configured == arn
+	case strings.HasPrefix(configured, "alias/") || strings.Contains(configured, ":alias/"):
+		return true
+	}
+	return arn == configured || strings.HasSuffix(arn, ":key/"+configured)
+}
+
+func describeKMSKey(key string) string {
+	if key == "" {
+		return "owned by DynamoDB"
+	}
+	return key
+}
@@ -0,0 +1,312 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewTableSettings(t *testing.T) {
+	cfg := &config.Config{DynamoDBBillingMode: "PAY_PER_REQUEST", DynamoDBReconcile: "log", DynamoDBMaxReadRequestUnits: 100}
+
+	settings, err := NewTableSettings(cfg)
+
+	require.NoError(t, err)
+	assert.Equal(t, types.BillingModePayPerRequest, settings.BillingMode)
+	assert.Equal(t, int64(100), settings.MaxReadRequestUnits)
+	assert.Equal(t, ReconcileLog, settings.Reconcile)
+}
+
+func TestNewTableSettings_Invalid(t *testing.T) {
+	for name, cfg := range map[string]*config.Config{
+		"billing mode":  {DynamoDBBillingMode: "FREE", DynamoDBReconcile: "log"},
+		"capacity":      {DynamoDBBillingMode: "PROVISIONED", DynamoDBReconcile: "log"},
+		"request units": {DynamoDBBillingMode: "PAY_PER_REQUEST", DynamoDBMaxWriteRequestUnits: -1, DynamoDBReconcile: "log"},
+		"reconcile":     {DynamoDBBillingMode: "PAY_PER_REQUEST", DynamoDBReconcile: "sometimes"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := NewTableSettings(cfg)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestTableSettingsApply_PayPerRequest(t *testing.T) {
+	settings := TableSettings{BillingMode: types.BillingModePayPerRequest, MaxWriteRequestUnits: 50, DeletionProtection: true}
+	input := customersTableInput("Customers")
+
+	settings.apply(input)
+
+	assert.Equal(t, types.BillingModePayPerRequest, input.BillingMode)
+	assert.Nil(t, input.ProvisionedThroughput)
+	assert.Nil(t, input.GlobalSecondaryIndexes[0].ProvisionedThroughput)
+	assert.Equal(t, int64(-1), *input.OnDemandThroughput.MaxReadRequestUnits)
+	assert.Equal(t, int64(50), *input.OnDemandThroughput.MaxWriteRequestUnits)
+	assert.Nil(t, input.SSESpecification)
+	assert.True(t, *input.DeletionProtectionEnabled)
+}
+
+func TestTableSettingsApply_Provisioned(t *testing.T) {
+	settings := TableSettings{BillingMode: types.BillingModeProvisioned, ReadCapacity: 100, WriteCapacity: 20, KMSKeyID: "key-1"}
+	input := customersTableInput("Customers")
+
+	settings.apply(input)
+
+	assert.Equal(t, types.BillingModeProvisioned, input.BillingMode)
+	assert.Equal(t, int64(100), *input.ProvisionedThroughput.ReadCapacityUnits)
+	assert.Equal(t, int64(20), *input.GlobalSecondaryIndexes[0].ProvisionedThroughput.WriteCapacityUnits)
+	assert.Nil(t, input.OnDemandThroughput)
+	assert.Equal(t, types.SSETypeKms, input.SSESpecification.SSEType)
+	assert.Equal(t, "key-1", *input.SSESpecification.KMSMasterKeyId)
+}
+
+// provisionedTable describes a table provisioned with 5 units, with the email index
+func provisionedTable() *types.TableDescription {
+	capacity := &types.ProvisionedThroughputDescription{ReadCapacityUnits: aws.Int64(5), WriteCapacityUnits: aws.Int64(5)}
+	return &types.TableDescription{
+		TableName:             aws.String("Customers"),
+		ProvisionedThroughput: capacity,
+		GlobalSecondaryIndexes: []types.GlobalSecondaryIndexDescription{
+			{IndexName: aws.String(emailIndexName), ProvisionedThroughput: capacity},
+		},
+	}
+}
+
+// changeDescriptions returns the descriptions of table changes
+func changeDescriptions(changes []tableChange) []string {
+	descriptions := []string{}
+	for _, change := range changes {
+		descriptions = append(descriptions, change.description)
+	}
+	return descriptions
+}
+
+func TestTableChanges_Matching(t *testing.T) {
+	settings := TableSettings{BillingMode: types.BillingModeProvisioned, ReadCapacity: 5, WriteCapacity: 5}
+
+	assert.Empty(t, tableChanges(settings, provisionedTable(), false))
+}
+
+func TestTableChanges_Differences(t *testing.T) {
+	settings := TableSettings{
+		BillingMode:         types.BillingModePayPerRequest,
+		KMSKeyID:            "key-1",
+		PointInTimeRecovery: true,
+		DeletionProtection:  true,
+	}
+
+	changes := tableChanges(settings, provisionedTable(), false)
+
+	assert.Equal(t, []string{
+		"billing mode is PROVISIONED, configured PAY_PER_REQUEST",
+		"encryption key is owned by DynamoDB, configured key-1",
+		"deletion protection is false, configured true",
+		"point in time recovery is false, configured true",
+	}, changeDescriptions(changes))
+}
+
+func TestTableChanges_Capacity(t *testing.T) {
+	settings := TableSettings{BillingMode: types.BillingModeProvisioned, ReadCapacity: 50, WriteCapacity: 5}
+
+	assert.Equal(t, []string{
+		"capacity is 5/5, configured 50/5",
+		"index emailIndex-index capacity is 5/5, configured 50/5",
+	}, changeDescriptions(tableChanges(settings, provisionedTable(), false)))
+
+	settings.Autoscaling = true
+	assert.Empty(t, tableChanges(settings, provisionedTable(), false), "autoscaling owns the capacity")
+}
+
+func TestTableChanges_OnDemandCap(t *testing.T) {
+	table := &types.TableDescription{
+		TableName:          aws.String("Customers"),
+		BillingModeSummary: &types.BillingModeSummary{BillingMode: types.BillingModePayPerRequest},
+		OnDemandThroughput: &types.OnDemandThroughput{MaxReadRequestUnits: aws.Int64(-1), MaxWriteRequestUnits: aws.Int64(-1)},
+	}
+
+	assert.Empty(t, tableChanges(TableSettings{BillingMode: types.BillingModePayPerRequest}, table, false))
+	assert.Equal(t, []string{"maximum request units are 0/0, configured 100/0"},
+		changeDescriptions(tableChanges(TableSettings{BillingMode: types.BillingModePayPerRequest, MaxReadRequestUnits: 100}, table, false)))
+}
+
+func TestKMSKeyMatches(t *testing.T) {
+	arn := "arn:aws:kms:us-east-1:123456789012:key/key-1"
+
+	assert.True(t, kmsKeyMatches("", ""))
+	assert.True(t, kmsKeyMatches(arn, "key-1"))
+	assert.True(t, kmsKeyMatches(arn, arn))
+	assert.True(t, kmsKeyMatches(arn, "alias/customers"))
+	assert.False(t, kmsKeyMatches(arn, "key-2"))
+	assert.False(t, kmsKeyMatches(arn, ""))
+	assert.False(t, kmsKeyMatches("", "key-1"))
+}
+
+// existingTable answers the operations of reconciling a table that exists,
+// provisioned with 5 units and without deletion protection until an update
+// enables it. While inUse is set, the first update fails as another replica's
+// update of the table is in progress, and enables deletion protection itself.
+type existingTable struct {
+	operations []string
+	updates    []map[string]interface{}
+	protected  bool
+	inUse      bool
+}
+
+func (f *existingTable) handle(operation string, body map[string]interface{}) interface{} {
+	f.operations = append(f.operations, operation)
+	switch operation {
+	case "ListTables":
+		return map[string]interface{}{"TableNames": []string{"Customers"}}
+	case "DescribeContinuousBackups":
+		return map[string]interface{}{"ContinuousBackupsDescription": map[string]interface{}{
+			"ContinuousBackupsStatus":        "ENABLED",
+			"PointInTimeRecoveryDescription": map[string]interface{}{"PointInTimeRecoveryStatus": "DISABLED"},
+		}}
+	case "UpdateTable":
+		if f.inUse {
+			f.inUse = false
+			f.protected = true
+			return map[string]interface{}{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ResourceInUseException",
+				"message": "Attempt to change a resource which is still in use",
+			}
+		}
+		f.updates = append(f.updates, body)
+		if protected, ok := body["DeletionProtectionEnabled"].(bool); ok {
+			f.protected = protected
+		}
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{"Table": map[string]interface{}{
+		"TableName":                 "Customers",
+		"TableStatus":               "ACTIVE",
+		"ProvisionedThroughput":     map[string]interface{}{"ReadCapacityUnits": 5, "WriteCapacityUnits": 5},
+		"DeletionProtectionEnabled": f.protected,
+	}}
+}
+
+// protectedSettings are provisioned with 5 units and deletion protection
+func protectedSettings(reconcile string) TableSettings {
+	return TableSettings{BillingMode: types.BillingModeProvisioned, ReadCapacity: 5, WriteCapacity: 5, DeletionProtection: true, Reconcile: reconcile}
+}
+
+func TestEnsureTable_LogsDifferences(t *testing.T) {
+	table := &existingTable{}
+	client := fakeDynamoDB(t, table.handle)
+	client.settings = protectedSettings(ReconcileLog)
+
+	created, err := ensureTable(context.Background(), client, createTableInput("Customers", "id", ""))
+
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, []string{"ListTables", "DescribeTable", "DescribeContinuousBackups"}, table.operations)
+	assert.Empty(t, table.updates)
+}
+
+func TestEnsureTable_AppliesDifferences(t *testing.T) {
+	table := &existingTable{}
+	client := fakeDynamoDB(t, table.handle)
+	client.settings = protectedSettings(ReconcileApply)
+
+	_, err := ensureTable(context.Background(), client, createTableInput("Customers", "id", ""))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"ListTables", "DescribeTable", "DescribeContinuousBackups",
+		"UpdateTable", "DescribeTable",
+		"DescribeTable", "DescribeContinuousBackups",
+	}, table.operations)
+	require.Len(t, table.updates, 1)
+	assert.Equal(t, true, table.updates[0]["DeletionProtectionEnabled"])
+}
+
+func TestEnsureTable_WaitsForUpdateInProgress(t *testing.T) {
+	table := &existingTable{inUse: true}
+	client := fakeDynamoDB(t, table.handle)
+	client.settings = protectedSettings(ReconcileApply)
+
+	_, err := ensureTable(context.Background(), client, createTableInput("Customers", "id", ""))
+
+	require.NoError(t, err)
+	assert.Empty(t, table.updates, "the update in progress made the change")
+	assert.True(t, table.protected)
+}
+
+func TestEnsureTable_ReconcileOff(t *testing.T) {
+	for name, settings := range map[string]TableSettings{
+		"off":  {BillingMode: types.BillingModePayPerRequest, Reconcile: ReconcileOff},
+		"zero": {},
+	} {
+		t.Run(name, func(t *testing.T) {
+			table := &existingTable{}
+			client := fakeDynamoDB(t, table.handle)
+			client.settings = settings
+
+			_, err := ensureTable(context.Background(), client, createTableInput("Customers", "id", ""))
+
+			require.NoError(t, err)
+			assert.Equal(t, []string{"ListTables"}, table.operations)
+		})
+	}
+}
+
+func TestCreateTable_ZeroSettingsAreOnDemand(t *testing.T) {
+	var created map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "CreateTable" {
+			created = body
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Table": map[string]interface{}{"TableName": "Customers", "TableStatus": "ACTIVE"}}
+	})
+
+	require.NoError(t, createTable(context.Background(), client, createTableInput("Customers", "id", "")))
+
+	assert.Equal(t, "PAY_PER_REQUEST", created["BillingMode"])
+	assert.Nil(t, created["ProvisionedThroughput"])
+}
+
+func TestCreateTable_CreatedByAnotherReplica(t *testing.T) {
+	var operations []string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		if operation == "CreateTable" {
+			return map[string]interface{}{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ResourceInUseException",
+				"message": "Table already exists: Customers",
+			}
+		}
+		return map[string]interface{}{"Table": map[string]interface{}{"TableName": "Customers", "TableStatus": "ACTIVE"}}
+	})
+
+	require.NoError(t, createTable(context.Background(), client, createTableInput("Customers", "id", "")))
+	assert.Equal(t, []string{"CreateTable", "DescribeTable"}, operations)
+}
+
+func TestWaitForTableActive_WaitsForIndexesAndEncryption(t *testing.T) {
+	previous := tablePollInterval
+	tablePollInterval = time.Millisecond
+	t.Cleanup(func() { tablePollInterval = previous })
+
+	descriptions := []map[string]interface{}{
+		{"TableStatus": "ACTIVE", "GlobalSecondaryIndexes": []interface{}{map[string]interface{}{"IndexName": "emailIndex-index", "IndexStatus": "UPDATING"}}},
+		{"TableStatus": "ACTIVE", "SSEDescription": map[string]interface{}{"Status": "UPDATING", "SSEType": "KMS"}},
+		{"TableStatus": "ACTIVE", "SSEDescription": map[string]interface{}{"Status": "ENABLED", "SSEType": "KMS"}},
+	}
+	calls := 0
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		table := descriptions[min(calls, len(descriptions)-1)]
+		calls++
+		table["TableName"] = "Customers"
+		return map[string]interface{}{"Table": table}
+	})
+
+	require.NoError(t, waitForTableActive(context.Background(), client, "Customers"))
+	assert.Equal(t, 3, calls)
+}
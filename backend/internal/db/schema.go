@@ -0,0 +1,379 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// indexPollInterval is how often a table is described while an index it was
+// given is being built
+var indexPollInterval = 5 * time.Second
+
+// AddGlobalSecondaryIndex adds an index to an existing table, defining the
+// attributes its keys use, and waits for DynamoDB to finish building it. The
+// index of a provisioned table gets the configured capacity. A table that
+// already has the index is left as it is.
+func AddGlobalSecondaryIndex(ctx context.Context, client *Client, tableName string, index types.GlobalSecondaryIndex, attributes ...types.AttributeDefinition) error {
+	indexName := aws.ToString(index.IndexName)
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	if indexStatus(result.Table, indexName) == "" {
+		_, err = client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+			TableName:            aws.String(tableName),
+			AttributeDefinitions: attributes,
+			GlobalSecondaryIndexUpdates: []types.GlobalSecondaryIndexUpdate{
+				{
+					Create: &types.CreateGlobalSecondaryIndexAction{
+						IndexName:             index.IndexName,
+						KeySchema:             index.KeySchema,
+						Projection:            index.Projection,
+						ProvisionedThroughput: client.settings.indexThroughput(result.Table),
+					},
+				},
+			},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add index %s: %w", indexName, err)
+		}
+		log.Printf("Adding index %s to table %s", indexName, tableName)
+	}
+
+	return waitForIndexActive(ctx, client, tableName, indexName)
+}
+
+// waitForIndexActive waits for DynamoDB to finish building an index. Building
+// takes as long as reading the whole table, so the wait is only bounded by ctx.
+func waitForIndexActive(ctx context.Context, client *Client, tableName, indexName string) error {
+	for {
+		result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+		if err != nil {
+			return fmt.Errorf("failed to describe table: %w", err)
+		}
+		switch indexStatus(result.Table, indexName) {
+		case types.IndexStatusActive:
+			log.Printf("Index %s of table %s is now active", indexName, tableName)
+			return nil
+		case "":
+			return fmt.Errorf("index %s of table %s is missing", indexName, tableName)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(indexPollInterval):
+		}
+	}
+}
+
+// hasActiveIndex reports whether a table has a global secondary index built
+// and ready for queries
+func hasActiveIndex(ctx context.Context, client *Client, tableName, indexName string) (bool, error) {
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe table: %w", err)
+	}
+	return indexStatus(result.Table, indexName) == types.IndexStatusActive, nil
+}
+
+// IsTableEmpty reports whether a table has no items, so rewriting them has nothing to do
+func IsTableEmpty(ctx context.Context, client *Client, tableName string) (bool, error) {
+	result, err := client.Scan(ctx, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+		Limit:     aws.Int32(1),
+		Select:    types.SelectCount,
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to scan table: %w", err)
+	}
+	return result.Count == 0 && result.LastEvaluatedKey == nil, nil
+}
+
+// indexStatus returns the status of a table's global secondary index, "" when
+// the table doesn't have it
+func indexStatus(table *types.TableDescription, indexName string) types.IndexStatus {
+	if table == nil {
+		return ""
+	}
+	for _, index := range table.GlobalSecondaryIndexes {
+		if aws.ToString(index.IndexName) == indexName {
+			return index.IndexStatus
+		}
+	}
+	return ""
+}
+
+// EnableStream enables a table's stream with the given view type, waiting for
+// the table to be active again so later updates succeed. A table whose stream
+// has another view type is rejected, as changing it would break its consumers.
+func EnableStream(ctx context.Context, client *Client, tableName string, viewType types.StreamViewType) error {
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("failed to describe table: %w", err)
+	}
+
+	if spec := result.Table.StreamSpecification; spec != nil && aws.ToBool(spec.StreamEnabled) {
+		if spec.StreamViewType != viewType {
+			return fmt.Errorf("table %s has a %s stream, %s is required", tableName, spec.StreamViewType, viewType)
+		}
+		return nil
+	}
+
+	_, err = client.UpdateTable(ctx, &dynamodb.UpdateTableInput{
+		TableName: aws.String(tableName),
+		StreamSpecification: &types.StreamSpecification{
+			StreamEnabled:  aws.Bool(true),
+			StreamViewType: viewType,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable table stream: %w", err)
+	}
+
+	log.Printf("Enabled the stream of table %s", tableName)
+	return waitForTableActive(ctx, client, tableName)
+}
+
+// EnableTimeToLive makes DynamoDB delete a table's items once the epoch
+// seconds in attribute have passed. A table expiring items by another
+// attribute is rejected.
+func EnableTimeToLive(ctx context.Context, client *Client, tableName, attribute string) error {
+	result, err := client.DescribeTimeToLive(ctx, &dynamodb.DescribeTimeToLiveInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return fmt.Errorf("failed to describe time to live of %s: %w", tableName, err)
+	}
+
+	if description := result.TimeToLiveDescription; description != nil {
+		switch description.TimeToLiveStatus {
+		case types.TimeToLiveStatusEnabled, types.TimeToLiveStatusEnabling:
+			if current := aws.ToString(description.AttributeName); current != attribute {
+				return fmt.Errorf("table %s expires items by %s, %s is required", tableName, current, attribute)
+			}
+			return nil
+		}
+	}
+
+	_, err = client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(tableName),
+		TimeToLiveSpecification: &types.TimeToLiveSpecification{
+			AttributeName: aws.String(attribute),
+			Enabled:       aws.Bool(true),
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to enable time to live on %s: %w", tableName, err)
+	}
+	return nil
+}
+
+// ItemUpdate is the change a rewrite makes to an item. It is only applied
+// while the item exists and, when Condition is set, still meets it.
+type ItemUpdate struct {
+	Update    expression.UpdateBuilder
+	Condition *expression.ConditionBuilder
+}
+
+// ItemRewrite returns the update to make to an item, or nil to leave it as it is
+type ItemRewrite func(item map[string]types.AttributeValue) (*ItemUpdate, error)
+
+// RewriteItems scans a table in pages of pageSize items, starting after
+// cursor, and applies the updates rewrite returns. After each page but the
+// last, checkpoint receives the cursor resuming the scan after it. Items
+// deleted or changed since the scan read them are skipped. It returns the
+// number of items updated.
+func RewriteItems(ctx context.Context, client *Client, tableName, cursor string, pageSize int, rewrite ItemRewrite, checkpoint func(cursor string) error) (int, error) {
+	described, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return 0, fmt.Errorf("failed to describe table: %w", err)
+	}
+	var keyNames []string
+	for _, element := range described.Table.KeySchema {
+		keyNames = append(keyNames, aws.ToString(element.AttributeName))
+	}
+
+	input := &dynamodb.ScanInput{TableName: aws.String(tableName)}
+	if pageSize > 0 {
+		input.Limit = aws.Int32(int32(pageSize))
+	}
+	if input.ExclusiveStartKey, err = decodeScanCursor(cursor); err != nil {
+		return 0, err
+	}
+
+	rewritten := 0
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return rewritten, fmt.Errorf("failed to scan %s: %w", tableName, err)
+		}
+
+		for _, item := range page.Items {
+			update, err := rewrite(item)
+			if err != nil {
+				return rewritten, err
+			}
+			if update == nil {
+				continue
+			}
+			updated, err := applyItemUpdate(ctx, client, tableName, keyNames, item, update)
+			if err != nil {
+				return rewritten, err
+			}
+			if updated {
+				rewritten++
+			}
+		}
+
+		if page.LastEvaluatedKey != nil {
+			next, err := encodeScanCursor(page.LastEvaluatedKey)
+			if err != nil {
+				return rewritten, err
+			}
+			if err := checkpoint(next); err != nil {
+				return rewritten, err
+			}
+		}
+	}
+	return rewritten, nil
+}
+
+// applyItemUpdate makes a rewrite's update to an item, reporting false when
+// the item was deleted or no longer meets the update's condition
+func applyItemUpdate(ctx context.Context, client *Client, tableName string, keyNames []string, item map[string]types.AttributeValue, update *ItemUpdate) (bool, error) {
+	key := map[string]types.AttributeValue{}
+	for _, name := range keyNames {
+		key[name] = item[name]
+	}
+
+	condition := expression.AttributeExists(expression.Name(keyNames[0]))
+	if update.Condition != nil {
+		condition = condition.And(*update.Condition)
+	}
+	expr, err := expression.NewBuilder().WithUpdate(update.Update).WithCondition(condition).Build()
+	if err != nil {
+		return false, fmt.Errorf("failed to build item update: %w", err)
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       key,
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to rewrite item: %w", err)
+	}
+	return true, nil
+}
+
+// encodeScanCursor converts the key a scan stopped at to a cursor. Every
+// table here has string keys.
+func encodeScanCursor(key map[string]types.AttributeValue) (string, error) {
+	values := map[string]string{}
+	for name, value := range key {
+		s, ok := value.(*types.AttributeValueMemberS)
+		if !ok {
+			return "", fmt.Errorf("key attribute %s is not a string", name)
+		}
+		values[name] = s.Value
+	}
+	cursor, err := json.Marshal(values)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode scan cursor: %w", err)
+	}
+	return string(cursor), nil
+}
+
+// decodeScanCursor converts a cursor back to the key a scan resumes after,
+// nil for the empty cursor starting at the beginning
+func decodeScanCursor(cursor string) (map[string]types.AttributeValue, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	var values map[string]string
+	if err := json.Unmarshal([]byte(cursor), &values); err != nil {
+		return nil, fmt.Errorf("invalid scan cursor: %w", err)
+	}
+	key := map[string]types.AttributeValue{}
+	for name, value := range values {
+		key[name] = &types.AttributeValueMemberS{Value: value}
+	}
+	return key, nil
+}
+
+// BackfillEmailIndex indexes the email of customers written before the email
+// index and the email domain filter existed, resuming after cursor. Erased and
+// merged customers stay out of the index.
+func BackfillEmailIndex(ctx context.Context, client *Client, tableName, cursor string, pageSize int, checkpoint func(cursor string) error) (int, error) {
+	indexEmail := func(item map[string]types.AttributeValue) (*ItemUpdate, error) {
+		return client.encryptor.indexCustomerEmail(ctx, item)
+	}
+	return RewriteItems(ctx, client, tableName, cursor, pageSize, indexEmail, checkpoint)
+}
+
+// indexCustomerEmail sets the email index attributes of a customer missing them
+func (fe *FieldEncryptor) indexCustomerEmail(ctx context.Context, item map[string]types.AttributeValue) (*ItemUpdate, error) {
+	_, indexed := item[emailIndexAttribute]
+	_, hasDomain := item[emailDomainAttribute]
+	if indexed && hasDomain {
+		return nil, nil
+	}
+
+	// Decrypting replaces the stored email in item
+	storedEmail := item["email"]
+	var customer models.Customer
+	if err := fe.unmarshalCustomer(ctx, item, &customer); err != nil {
+		return nil, err
+	}
+	if customer.Email == "" || customer.ErasedAt != "" || customer.MergedInto != "" {
+		return nil, nil
+	}
+
+	update := expression.Set(expression.Name(emailIndexAttribute), expression.Value(fe.emailIndexValue(customer.Email)))
+	if domain := fe.emailDomainValue(customer.Email); domain != "" {
+		update = update.Set(expression.Name(emailDomainAttribute), expression.Value(domain))
+	}
+	// A write changing the email since the scan indexed it itself
+	condition := expression.Name("email").Equal(expression.Value(storedEmail))
+	return &ItemUpdate{Update: update, Condition: &condition}, nil
+}
+
+// LegacyCreatedAt is the creation time given to customers written before
+// creation times were recorded. It sorts them before every other customer.
+const LegacyCreatedAt = "1970-01-01T00:00:00Z"
+
+// BackfillCreatedAt gives customers written before creation times were
+// recorded LegacyCreatedAt, so that sorting and filtering by creation time
+// includes them, resuming after cursor
+func BackfillCreatedAt(ctx context.Context, client *Client, tableName, cursor string, pageSize int, checkpoint func(cursor string) error) (int, error) {
+	return RewriteItems(ctx, client, tableName, cursor, pageSize, stampCreatedAt, checkpoint)
+}
+
+// stampCreatedAt sets the creation time of a customer missing it, counting
+// the change as a write so replaces based on an earlier read are rejected
+func stampCreatedAt(item map[string]types.AttributeValue) (*ItemUpdate, error) {
+	if _, ok := item["createdAt"]; ok {
+		return nil, nil
+	}
+
+	update := expression.Set(expression.Name("createdAt"), expression.Value(LegacyCreatedAt)).
+		Add(expression.Name(versionAttribute), expression.Value(versionIncrement))
+	condition := expression.AttributeNotExists(expression.Name("createdAt"))
+	return &ItemUpdate{Update: update, Condition: &condition}, nil
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// describedTable answers DescribeTable for a table keyed by id with the given indexes
+func describedTable(indexes ...map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"Table": map[string]interface{}{
+		"TableStatus":            "ACTIVE",
+		"KeySchema":              []interface{}{map[string]string{"AttributeName": "id", "KeyType": "HASH"}},
+		"GlobalSecondaryIndexes": indexes,
+	}}
+}
+
+func TestAddGlobalSecondaryIndex_Existing(t *testing.T) {
+	var operations []string
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		return describedTable(map[string]interface{}{"IndexName": emailIndexName, "IndexStatus": "ACTIVE"})
+	})
+
+	require.NoError(t, AddEmailIndex(context.Background(), client, "Customers"))
+
+	assert.Equal(t, []string{"DescribeTable", "DescribeTable"}, operations)
+}
+
+func TestHasWebhookDeliveryDueIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		indexes  []map[string]interface{}
+		expected bool
+	}{
+		{name: "missing", expected: false},
+		{name: "building", indexes: []map[string]interface{}{{"IndexName": deliveryDueIndexName, "IndexStatus": "CREATING"}}, expected: false},
+		{name: "active", indexes: []map[string]interface{}{{"IndexName": deliveryDueIndexName, "IndexStatus": "ACTIVE"}}, expected: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+				require.Equal(t, "DescribeTable", operation)
+				return describedTable(tt.indexes...)
+			})
+
+			found, err := HasWebhookDeliveryDueIndex(context.Background(), client, "Deliveries")
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, found)
+		})
+	}
+}
+
+func TestIsTableEmpty(t *testing.T) {
+	for name, count := range map[string]int{"empty": 0, "with items": 1} {
+		t.Run(name, func(t *testing.T) {
+			client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+				require.Equal(t, "Scan", operation)
+				assert.Equal(t, "COUNT", body["Select"])
+				return map[string]interface{}{"Count": count}
+			})
+
+			empty, err := IsTableEmpty(context.Background(), client, "Customers")
+
+			require.NoError(t, err)
+			assert.Equal(t, count == 0, empty)
+		})
+	}
+}
+
+func TestAddGlobalSecondaryIndex_WaitsForBuild(t *testing.T) {
+	interval := indexPollInterval
+	indexPollInterval = time.Millisecond
+	t.Cleanup(func() { indexPollInterval = interval })
+
+	var operations []string
+	var update map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		switch {
+		case operation == "UpdateTable":
+			update = body
+			return map[string]interface{}{}
+		case update == nil:
+			return describedTable()
+		case len(operations) < 4:
+			return describedTable(map[string]interface{}{"IndexName": emailIndexName, "IndexStatus": "CREATING"})
+		}
+		return describedTable(map[string]interface{}{"IndexName": emailIndexName, "IndexStatus": "ACTIVE"})
+	})
+	client.settings = TableSettings{BillingMode: types.BillingModeProvisioned, ReadCapacity: 10, WriteCapacity: 5}
+
+	require.NoError(t, AddEmailIndex(context.Background(), client, "Customers"))
+
+	assert.Equal(t, []string{"DescribeTable", "UpdateTable", "DescribeTable", "DescribeTable"}, operations)
+	assert.Equal(t, []interface{}{map[string]interface{}{"AttributeName": emailIndexAttribute, "AttributeType": "S"}}, update["AttributeDefinitions"])
+	created := update["GlobalSecondaryIndexUpdates"].([]interface{})[0].(map[string]interface{})["Create"].(map[string]interface{})
+	assert.Equal(t, emailIndexName, created["IndexName"])
+	// The table is provisioned, so the index gets the configured capacity
+	assert.Equal(t, map[string]interface{}{"ReadCapacityUnits": float64(10), "WriteCapacityUnits": float64(5)}, created["ProvisionedThroughput"])
+}
+
+func TestEnableTimeToLive_Enables(t *testing.T) {
+	var spec interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "UpdateTimeToLive" {
+			spec = body["TimeToLiveSpecification"]
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"TimeToLiveDescription": map[string]interface{}{"TimeToLiveStatus": "DISABLED"}}
+	})
+
+	require.NoError(t, EnableTimeToLive(context.Background(), client, "Jobs", "expiresAt"))
+
+	assert.Equal(t, map[string]interface{}{"AttributeName": "expiresAt", "Enabled": true}, spec)
+}
+
+func TestEnableTimeToLive_AlreadyEnabled(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "DescribeTimeToLive", operation)
+		return map[string]interface{}{"TimeToLiveDescription": map[string]interface{}{"TimeToLiveStatus": "ENABLED", "AttributeName": "expiresAt"}}
+	})
+
+	require.NoError(t, EnableTimeToLive(context.Background(), client, "Jobs", "expiresAt"))
+	assert.Error(t, EnableTimeToLive(context.Background(), client, "Jobs", "ttl"))
+}
+
+func TestRewriteItems_ResumesAndCheckpoints(t *testing.T) {
+	var starts []interface{}
+	var updated []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		switch operation {
+		case "DescribeTable":
+			return describedTable()
+		case "UpdateItem":
+			key := body["Key"].(map[string]interface{})["id"]
+			if key.(map[string]interface{})["S"] == "c" {
+				// Deleted since the scan read it
+				return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "gone"}
+			}
+			updated = append(updated, key)
+			return map[string]interface{}{}
+		}
+
+		assert.Equal(t, float64(2), body["Limit"])
+		starts = append(starts, body["ExclusiveStartKey"])
+		if len(starts) == 1 {
+			return map[string]interface{}{
+				"Items":            []interface{}{map[string]interface{}{"id": map[string]string{"S": "b"}}, map[string]interface{}{"id": map[string]string{"S": "skip"}}},
+				"LastEvaluatedKey": map[string]interface{}{"id": map[string]string{"S": "skip"}},
+			}
+		}
+		return map[string]interface{}{"Items": []interface{}{map[string]interface{}{"id": map[string]string{"S": "c"}}}}
+	})
+
+	var checkpoints []string
+	rewritten, err := RewriteItems(context.Background(), client, "Customers", `{"id":"a"}`, 2,
+		func(item map[string]types.AttributeValue) (*ItemUpdate, error) {
+			if attributeString(item["id"]) == "skip" {
+				return nil, nil
+			}
+			return &ItemUpdate{Update: expression.Set(expression.Name("x"), expression.Value(1))}, nil
+		},
+		func(cursor string) error {
+			checkpoints = append(checkpoints, cursor)
+			return nil
+		})
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, rewritten)
+	assert.Equal(t, []interface{}{map[string]interface{}{"S": "b"}}, updated)
+	assert.Equal(t, map[string]interface{}{"id": map[string]interface{}{"S": "a"}}, starts[0])
+	assert.Equal(t, []string{`{"id":"skip"}`}, checkpoints)
+}
+
+func TestScanCursor_RoundTrip(t *testing.T) {
+	key := map[string]types.AttributeValue{
+		"customerId": &types.AttributeValueMemberS{Value: "c1"},
+		"eventId":    &types.AttributeValueMemberS{Value: "e1"},
+	}
+
+	cursor, err := encodeScanCursor(key)
+	require.NoError(t, err)
+	decoded, err := decodeScanCursor(cursor)
+	require.NoError(t, err)
+	assert.Equal(t, key, decoded)
+
+	_, err = encodeScanCursor(map[string]types.AttributeValue{"n": &types.AttributeValueMemberN{Value: "1"}})
+	assert.Error(t, err)
+}
+
+func TestBackfillEmailIndex(t *testing.T) {
+	var updates []map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		switch operation {
+		case "DescribeTable":
+			return describedTable()
+		case "UpdateItem":
+			updates = append(updates, body)
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Items": []interface{}{
+			map[string]interface{}{"id": map[string]string{"S": "old"}, "email": map[string]string{"S": "John@Example.com"}},
+			map[string]interface{}{"id": map[string]string{"S": "new"}, "email": map[string]string{"S": "a@b.com"}, "emailIndex": map[string]string{"S": "a@b.com"}, "emailDomain": map[string]string{"S": "b.com"}},
+			map[string]interface{}{"id": map[string]string{"S": "erased"}, "email": map[string]string{"S": "erased"}, "erasedAt": map[string]string{"S": "2024-01-01T00:00:00Z"}},
+		}}
+	})
+
+	rewritten, err := BackfillEmailIndex(context.Background(), client, "Customers", "", 100, func(string) error { return nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, rewritten)
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]interface{}{"id": map[string]interface{}{"S": "old"}}, updates[0]["Key"])
+	values := map[string]string{}
+	for _, value := range updates[0]["ExpressionAttributeValues"].(map[string]interface{}) {
+		values[value.(map[string]interface{})["S"].(string)] = ""
+	}
+	assert.Contains(t, values, "john@example.com")
+	assert.Contains(t, values, "example.com")
+	assert.Contains(t, values, "John@Example.com", "the update is conditioned on the stored email")
+	assert.Contains(t, updates[0]["ConditionExpression"], "attribute_exists")
+}
+
+func TestBackfillCreatedAt(t *testing.T) {
+	var updates []map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		switch operation {
+		case "DescribeTable":
+			return describedTable()
+		case "UpdateItem":
+			updates = append(updates, body)
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Items": []interface{}{
+			map[string]interface{}{"id": map[string]string{"S": "old"}, "name": map[string]string{"S": "John"}},
+			map[string]interface{}{"id": map[string]string{"S": "new"}, "createdAt": map[string]string{"S": "2024-01-01T00:00:00Z"}},
+		}}
+	})
+
+	rewritten, err := BackfillCreatedAt(context.Background(), client, "Customers", "", 100, func(string) error { return nil })
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, rewritten)
+	require.Len(t, updates, 1)
+	assert.Equal(t, map[string]interface{}{"id": map[string]interface{}{"S": "old"}}, updates[0]["Key"])
+	assert.Contains(t, updates[0]["UpdateExpression"], "ADD")
+	assert.Contains(t, updates[0]["ConditionExpression"], "attribute_not_exists")
+	values := map[string]bool{}
+	for _, value := range updates[0]["ExpressionAttributeValues"].(map[string]interface{}) {
+		if s, ok := value.(map[string]interface{})["S"].(string); ok {
+			values[s] = true
+		}
+	}
+	assert.Equal(t, map[string]bool{LegacyCreatedAt: true}, values)
+}
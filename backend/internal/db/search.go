@@ -0,0 +1,109 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/search"
+)
+
+// ErrSearchNotConfigured is returned when searching without a search index
+var ErrSearchNotConfigured = errors.New("customer search is not configured")
+
+// SetSearchIndex enables customer search, keeping the index in sync with
+// customer writes made through the client. Writes made by other processes
+// reach it through IndexCustomerChange. Passing nil disables it.
+func (client *Client) SetSearchIndex(index *search.Index) {
+	client.searchIndex = index
+}
+
+// BuildSearchIndex creates a search index from every customer in the table.
+// Erased and merged customers are left out so their tombstones don't match searches.
+func BuildSearchIndex(ctx context.Context, client *Client, tableName string) (*search.Index, error) {
+	customers, err := ListCustomers(ctx, client, tableName, CustomerFilter{
+		Fields: []string{"name", "email", "telephone", "erasedAt", "mergedInto"},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	index := search.NewIndex()
+	for _, customer := range customers {
+		if !customer.IsErased() && !customer.IsMerged() {
+			index.Put(searchDocument(customer))
+		}
+	}
+	return index, nil
+}
+
+// SearchCustomers returns up to limit customers matching the query in the
+// given fields, best matches first
+func SearchCustomers(ctx context.Context, client *Client, tableName string, query string, fields search.Field, limit int) ([]models.Customer, error) {
+	if client.searchIndex == nil {
+		return nil, ErrSearchNotConfigured
+	}
+
+	results := client.searchIndex.Search(query, fields, limit)
+	if len(results) == 0 {
+		return []models.Customer{}, nil
+	}
+
+	ids := make([]string, len(results))
+	for i, result := range results {
+		ids[i] = result.ID
+	}
+	found, err := GetCustomers(ctx, client, tableName, ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read search results: %w", err)
+	}
+
+	// Keep the ranking order, skipping customers deleted by another process
+	customers := make([]models.Customer, 0, len(results))
+	for _, result := range results {
+		if customer, ok := found[result.ID]; ok {
+			customers = append(customers, *customer)
+		}
+	}
+	return customers, nil
+}
+
+// searchDocument extracts the searchable attributes of a customer
+func searchDocument(customer models.Customer) search.Document {
+	return search.Document{
+		ID:        customer.ID,
+		Name:      customer.Name,
+		Email:     customer.Email,
+		Telephone: customer.Telephone,
+	}
+}
+
+// indexCustomer updates the search index after a customer is written
+func (client *Client) indexCustomer(customer *models.Customer) {
+	if client.searchIndex == nil {
+		return
+	}
+	if customer.IsErased() || customer.IsMerged() {
+		client.searchIndex.Remove(customer.ID)
+		return
+	}
+	client.searchIndex.Put(searchDocument(*customer))
+}
+
+// unindexCustomer removes a customer from the search index after it is deleted or erased
+func (client *Client) unindexCustomer(id string) {
+	if client.searchIndex != nil {
+		client.searchIndex.Remove(id)
+	}
+}
+
+// IndexCustomerChange updates the search index with a customer write seen on
+// the table's stream, possibly made by another process. A nil customer was deleted.
+func (client *Client) IndexCustomerChange(id string, customer *models.Customer) {
+	if customer == nil {
+		client.unindexCustomer(id)
+		return
+	}
+	client.indexCustomer(customer)
+}
@@ -0,0 +1,32 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/search"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchCustomers_NotConfigured(t *testing.T) {
+	_, err := SearchCustomers(context.Background(), NewClient(nil, nil), "Customers", "john", search.AllFields, 10)
+
+	assert.ErrorIs(t, err, ErrSearchNotConfigured)
+}
+
+func TestIndexCustomer(t *testing.T) {
+	index := search.NewIndex()
+	client := NewClient(nil, nil)
+	client.SetSearchIndex(index)
+
+	customer := &models.Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com"}
+	client.indexCustomer(customer)
+	assert.Len(t, index.Search("jon", search.AllFields, 10), 1)
+
+	// Erased customers must not match searches for their placeholders or former data
+	customer.Name = models.ErasedName
+	customer.ErasedAt = "2024-01-01T00:00:00Z"
+	client.indexCustomer(customer)
+	assert.Equal(t, 0, index.Len())
+}
@@ -0,0 +1,102 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// metadataSchemaKey is the settings item holding the customer metadata JSON Schema
+const metadataSchemaKey = "metadata-schema"
+
+// ErrVersionConflict is returned when a settings item was changed concurrently
+var ErrVersionConflict = errors.New("settings item was modified concurrently")
+
+// GetMetadataSchema retrieves the current metadata schema, or nil if none is configured
+func GetMetadataSchema(ctx context.Context, client *Client, tableName string) (*models.MetadataSchema, error) {
+	input := &dynamodb.GetItemInput{
+		Key: map[string]types.AttributeValue{
+			"key": &types.AttributeValueMemberS{Value: metadataSchemaKey},
+		},
+		TableName:      aws.String(tableName),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	result, err := client.GetItem(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get item: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, nil // No schema configured
+	}
+
+	return metadataSchemaFromItem(result.Item)
+}
+
+// PutMetadataSchema stores a new version of the metadata schema. The write only
+// succeeds if the stored version still equals schema.Version, which is then incremented.
+func PutMetadataSchema(ctx context.Context, client *Client, tableName string, schema *models.MetadataSchema) error {
+	previousVersion := schema.Version
+	schema.Version++
+	schema.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+
+	input := &dynamodb.PutItemInput{
+		Item:                      metadataSchemaItem(schema),
+		TableName:                 aws.String(tableName),
+		ConditionExpression:       aws.String("attribute_not_exists(#key) OR #version = :previous"),
+		ExpressionAttributeNames:  map[string]string{"#key": "key", "#version": "version"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":previous": &types.AttributeValueMemberN{Value: strconv.Itoa(previousVersion)}},
+	}
+
+	_, err := client.PutItem(ctx, input)
+	if err != nil {
+		schema.Version = previousVersion
+		if isConditionalCheckFailed(err) {
+			return ErrVersionConflict
+		}
+		return fmt.Errorf("failed to put item: %w", err)
+	}
+
+	return nil
+}
+
+// metadataSchemaItem converts a schema to its DynamoDB item. The schema document
+// is stored as a JSON string so it round-trips byte for byte.
+func metadataSchemaItem(schema *models.MetadataSchema) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"key":       &types.AttributeValueMemberS{Value: metadataSchemaKey},
+		"schema":    &types.AttributeValueMemberS{Value: string(schema.Schema)},
+		"version":   &types.AttributeValueMemberN{Value: strconv.Itoa(schema.Version)},
+		"updatedAt": &types.AttributeValueMemberS{Value: schema.UpdatedAt},
+	}
+}
+
+// metadataSchemaFromItem converts a DynamoDB item back to a schema
+func metadataSchemaFromItem(item map[string]types.AttributeValue) (*models.MetadataSchema, error) {
+	schema := &models.MetadataSchema{}
+
+	if av, ok := item["schema"].(*types.AttributeValueMemberS); ok {
+		schema.Schema = json.RawMessage(av.Value)
+	}
+	if av, ok := item["version"].(*types.AttributeValueMemberN); ok {
+		version, err := strconv.Atoi(av.Value)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse schema version: %w", err)
+		}
+		schema.Version = version
+	}
+	if av, ok := item["updatedAt"].(*types.AttributeValueMemberS); ok {
+		schema.UpdatedAt = av.Value
+	}
+
+	return schema, nil
+}
@@ -0,0 +1,27 @@
+package db
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadataSchemaItem_RoundTrip(t *testing.T) {
+	original := &models.MetadataSchema{
+		Schema:    json.RawMessage(`{"type":"object","required":["crmId"]}`),
+		Version:   3,
+		UpdatedAt: "2024-01-01T00:00:00Z",
+	}
+
+	item := metadataSchemaItem(original)
+	assert.Equal(t, &types.AttributeValueMemberS{Value: metadataSchemaKey}, item["key"])
+	assert.Equal(t, &types.AttributeValueMemberN{Value: "3"}, item["version"])
+
+	reconstructed, err := metadataSchemaFromItem(item)
+	require.NoError(t, err)
+	assert.Equal(t, original, reconstructed)
+}
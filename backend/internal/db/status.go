@@ -0,0 +1,79 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrStatusConflict is returned when a customer's status changed before a transition was applied
+var ErrStatusConflict = errors.New("customer status changed concurrently")
+
+// TransitionCustomerStatus moves a customer from entry.From to entry.To and records
+// the entry in the history table. The update is conditioned on the customer still
+// being in entry.From, so concurrent transitions can't both succeed.
+func TransitionCustomerStatus(ctx context.Context, client *Client, tableName, historyTable string, entry *models.HistoryEntry) error {
+	entry.Type = models.HistoryStatusTransition
+	newHistoryEntry(entry)
+
+	history, err := historyPut(historyTable, entry)
+	if err != nil {
+		return err
+	}
+
+	// Items written before statuses existed have no status attribute and count as the default
+	condition := "attribute_exists(#id) AND #status = :from"
+	if entry.From == models.DefaultStatus {
+		condition = "attribute_exists(#id) AND (#status = :from OR attribute_not_exists(#status))"
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName:           aws.String(tableName),
+					Key:                 customerKey(entry.CustomerID),
+					UpdateExpression:    aws.String("SET #status = :to, #statusUpdatedAt = :at ADD #version :one"),
+					ConditionExpression: aws.String(condition),
+					ExpressionAttributeNames: map[string]string{
+						"#id":              "id",
+						"#status":          "status",
+						"#statusUpdatedAt": "statusUpdatedAt",
+						"#version":         versionAttribute,
+					},
+					ExpressionAttributeValues: map[string]types.AttributeValue{
+						":from": &types.AttributeValueMemberS{Value: entry.From},
+						":to":   &types.AttributeValueMemberS{Value: entry.To},
+						":at":   &types.AttributeValueMemberS{Value: entry.At},
+						":one":  versionIncrement,
+					},
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				},
+			},
+			history,
+		},
+	}
+	input.TransactItems, err = withEvents(ctx, client, input.TransactItems, newEvent(models.EventCustomerStatusChanged, entry.CustomerID, nil, map[string]string{"from": entry.From, "to": entry.To}))
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, input)
+	if err == nil {
+		return nil
+	}
+
+	if failed, exists := firstConditionFailed(err); failed {
+		if !exists {
+			return ErrCustomerNotFound
+		}
+		return ErrStatusConflict
+	}
+
+	return fmt.Errorf("failed to transition status: %w", err)
+}
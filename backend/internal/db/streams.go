@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrStreamNotEnabled is returned when the customers table has no stream
+var ErrStreamNotEnabled = errors.New("customer table stream is not enabled")
+
+// customerStream describes the stream of the customers table. Records carry
+// both images so consumers can tell what a write changed.
+func customerStream() *types.StreamSpecification {
+	return &types.StreamSpecification{
+		StreamEnabled:  aws.Bool(true),
+		StreamViewType: types.StreamViewTypeNewAndOldImages,
+	}
+}
+
+// EnableCustomerStream enables the stream of a customers table created before
+// it existed
+func EnableCustomerStream(ctx context.Context, client *Client, tableName string) error {
+	return EnableStream(ctx, client, tableName, customerStream().StreamViewType)
+}
+
+// HasCustomerStream reports whether a customers table has the stream
+// EnableCustomerStream enables
+func HasCustomerStream(ctx context.Context, client *Client, tableName string) (bool, error) {
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return false, fmt.Errorf("failed to describe table: %w", err)
+	}
+	if result.Table == nil {
+		return false, nil
+	}
+	spec := result.Table.StreamSpecification
+	return spec != nil && aws.ToBool(spec.StreamEnabled) && spec.StreamViewType == customerStream().StreamViewType, nil
+}
+
+// CustomerStreamARN returns the ARN of the customers table's stream
+func CustomerStreamARN(ctx context.Context, client *Client, tableName string) (string, error) {
+	result, err := client.DescribeTable(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	if err != nil {
+		return "", fmt.Errorf("failed to describe table: %w", err)
+	}
+	if result.Table.LatestStreamArn == nil {
+		return "", ErrStreamNotEnabled
+	}
+	return aws.ToString(result.Table.LatestStreamArn), nil
+}
+
+// UnmarshalCustomerImage converts a customer image of a stream record to a
+// customer, decrypting PII fields
+func UnmarshalCustomerImage(ctx context.Context, client *Client, image map[string]types.AttributeValue) (*models.Customer, error) {
+	var customer models.Customer
+	if err := client.encryptor.unmarshalCustomer(ctx, image, &customer); err != nil {
+		return nil, err
+	}
+	return &customer, nil
+}
+
+// EnsureStreamCheckpointsTableExists checks if the stream checkpoints table exists and creates it if it doesn't
+func EnsureStreamCheckpointsTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "consumer", "shardId"))
+	return err
+}
+
+// ListStreamCheckpoints returns the checkpoints saved by a consumer, by shard ID
+func ListStreamCheckpoints(ctx context.Context, client *Client, tableName string, consumer string) (map[string]models.StreamCheckpoint, error) {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("#consumer = :consumer"),
+		ExpressionAttributeNames: map[string]string{
+			"#consumer": "consumer",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":consumer": &types.AttributeValueMemberS{Value: consumer},
+		},
+	}
+
+	checkpoints := map[string]models.StreamCheckpoint{}
+	paginator := dynamodb.NewQueryPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query stream checkpoints: %w", err)
+		}
+		var pageCheckpoints []models.StreamCheckpoint
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageCheckpoints); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal stream checkpoints: %w", err)
+		}
+		for _, checkpoint := range pageCheckpoints {
+			checkpoints[checkpoint.ShardID] = checkpoint
+		}
+	}
+	return checkpoints, nil
+}
+
+// PutStreamCheckpoint saves how far a consumer has read a shard
+func PutStreamCheckpoint(ctx context.Context, client *Client, tableName string, checkpoint *models.StreamCheckpoint) error {
+	checkpoint.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+	item, err := attributevalue.MarshalMap(checkpoint)
+	if err != nil {
+		return fmt.Errorf("failed to marshal stream checkpoint: %w", err)
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put stream checkpoint: %w", err)
+	}
+	return nil
+}
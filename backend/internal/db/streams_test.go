@@ -0,0 +1,82 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCustomersTableInput_Stream(t *testing.T) {
+	input := customersTableInput("TestCustomers")
+
+	require.NotNil(t, input.StreamSpecification)
+	assert.True(t, *input.StreamSpecification.StreamEnabled)
+	assert.Equal(t, types.StreamViewTypeNewAndOldImages, input.StreamSpecification.StreamViewType)
+}
+
+func TestEnableCustomerStream_EnablesMissingStream(t *testing.T) {
+	var operations []string
+	var update map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		operations = append(operations, operation)
+		if operation == "UpdateTable" {
+			update = body
+		}
+		return map[string]interface{}{"Table": map[string]interface{}{"TableStatus": "ACTIVE"}}
+	})
+
+	require.NoError(t, EnableCustomerStream(context.Background(), client, "Customers"))
+
+	assert.Equal(t, []string{"DescribeTable", "UpdateTable", "DescribeTable"}, operations)
+	assert.Equal(t, map[string]interface{}{"StreamEnabled": true, "StreamViewType": "NEW_AND_OLD_IMAGES"}, update["StreamSpecification"])
+}
+
+func TestEnableCustomerStream_RejectsOtherViewType(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "DescribeTable", operation)
+		return map[string]interface{}{"Table": map[string]interface{}{
+			"TableStatus":         "ACTIVE",
+			"StreamSpecification": map[string]interface{}{"StreamEnabled": true, "StreamViewType": "KEYS_ONLY"},
+		}}
+	})
+
+	assert.Error(t, EnableCustomerStream(context.Background(), client, "Customers"))
+}
+
+func TestCustomerStreamARN_NotEnabled(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{"Table": map[string]interface{}{"TableStatus": "ACTIVE"}}
+	})
+
+	_, err := CustomerStreamARN(context.Background(), client, "Customers")
+
+	assert.ErrorIs(t, err, ErrStreamNotEnabled)
+}
+
+func TestStreamCheckpoints(t *testing.T) {
+	var stored map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		switch operation {
+		case "PutItem":
+			stored = body["Item"].(map[string]interface{})
+			return map[string]interface{}{}
+		case "Query":
+			assert.Equal(t, map[string]interface{}{"S": "events"}, body["ExpressionAttributeValues"].(map[string]interface{})[":consumer"])
+			return map[string]interface{}{"Items": []interface{}{stored}}
+		}
+		return map[string]interface{}{}
+	})
+
+	checkpoint := &models.StreamCheckpoint{Consumer: "events", ShardID: "shard-1", SequenceNumber: "100"}
+	require.NoError(t, PutStreamCheckpoint(context.Background(), client, "Checkpoints", checkpoint))
+	assert.NotEmpty(t, checkpoint.UpdatedAt)
+
+	checkpoints, err := ListStreamCheckpoints(context.Background(), client, "Checkpoints", "events")
+
+	require.NoError(t, err)
+	assert.Equal(t, *checkpoint, checkpoints["shard-1"])
+}
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// ErrCustomerNotFound is returned when a write targets a customer that doesn't exist
+var ErrCustomerNotFound = errors.New("customer not found")
+
+// ErrCustomerInactive is returned when a tag is added to a customer that was erased or merged
+var ErrCustomerInactive = errors.New("customer erased or merged")
+
+// ErrTooManyTags is returned when a customer already carries models.MaxTagsPerCustomer tags
+var ErrTooManyTags = errors.New("too many tags")
+
+// AddCustomerTag adds a tag to a customer and increments the tag's count in the
+// same transaction. It reports false if the customer already had the tag, and
+// fails with ErrCustomerInactive or ErrTooManyTags if the customer was erased
+// or merged or is already at the tag limit when the write lands.
+func AddCustomerTag(ctx context.Context, client *Client, tableName, tagsTable, id, tag string) (bool, error) {
+	return changeCustomerTag(ctx, client, tableName, tagsTable, id, tag, true)
+}
+
+// RemoveCustomerTag removes a tag from a customer and decrements the tag's count
+// in the same transaction. It reports false if the customer didn't have the tag.
+func RemoveCustomerTag(ctx context.Context, client *Client, tableName, tagsTable, id, tag string) (bool, error) {
+	return changeCustomerTag(ctx, client, tableName, tagsTable, id, tag, false)
+}
+
+// changeCustomerTag adds or removes a tag. The customer update is conditioned on
+// the tag's current membership so the count only moves when the set changes.
+func changeCustomerTag(ctx context.Context, client *Client, tableName, tagsTable, id, tag string, add bool) (bool, error) {
+	updateExpression := "ADD #tags :tagSet, #version :one"
+	condition := activeCustomerCondition + " AND NOT contains(#tags, :tag) AND (attribute_not_exists(#tags) OR size(#tags) < :max)"
+	names := map[string]string{
+		"#id":         "id",
+		"#tags":       "tags",
+		"#version":    versionAttribute,
+		"#erasedAt":   "erasedAt",
+		"#mergedInto": "mergedInto",
+	}
+	values := map[string]types.AttributeValue{
+		":tagSet": &types.AttributeValueMemberSS{Value: []string{tag}},
+		":tag":    &types.AttributeValueMemberS{Value: tag},
+		":one":    versionIncrement,
+		":max":    &types.AttributeValueMemberN{Value: strconv.Itoa(models.MaxTagsPerCustomer)},
+	}
+	delta := "1"
+	eventType := models.EventCustomerTagged
+	if !add {
+		updateExpression = "DELETE #tags :tagSet ADD #version :one"
+		condition = "attribute_exists(#id) AND contains(#tags, :tag)"
+		delete(names, "#erasedAt")
+		delete(names, "#mergedInto")
+		delete(values, ":max")
+		delta = "-1"
+		eventType = models.EventCustomerUntagged
+	}
+
+	input := &dynamodb.TransactWriteItemsInput{
+		TransactItems: []types.TransactWriteItem{
+			{
+				Update: &types.Update{
+					TableName:                           aws.String(tableName),
+					Key:                                 customerKey(id),
+					UpdateExpression:                    aws.String(updateExpression),
+					ConditionExpression:                 aws.String(condition),
+					ExpressionAttributeNames:            names,
+					ExpressionAttributeValues:           values,
+					ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+				},
+			},
+			tagCountUpdate(tagsTable, tag, delta),
+		},
+	}
+	var err error
+	input.TransactItems, err = withEvents(ctx, client, input.TransactItems, newEvent(eventType, id, nil, map[string]string{"tag": tag}))
+	if err != nil {
+		return false, err
+	}
+
+	_, err = client.TransactWriteItems(ctx, input)
+	if err == nil {
+		return true, nil
+	}
+
+	if failed, item := conditionFailedItem(err, 0); failed {
+		return false, tagConditionError(item, tag, add)
+	}
+
+	return false, fmt.Errorf("failed to update tags: %w", err)
+}
+
+// tagConditionError explains why a tag change's condition failed on item, the
+// customer as it was at the time. It returns nil when the tag membership was
+// already in the requested state.
+func tagConditionError(item map[string]types.AttributeValue, tag string, add bool) error {
+	if item == nil {
+		return ErrCustomerNotFound
+	}
+	tags, _ := item["tags"].(*types.AttributeValueMemberSS)
+	hasTag := tags != nil && slices.Contains(tags.Value, tag)
+	if !add || hasTag {
+		return nil
+	}
+	if item["erasedAt"] != nil || item["mergedInto"] != nil {
+		return ErrCustomerInactive
+	}
+	return ErrTooManyTags
+}
+
+// DeleteCustomerWithTags removes a customer read at customer.Version and
+// decrements the counts of its tags atomically. Every tag change advances the
+// version, so the delete fails with ErrCustomerChanged rather than decrement
+// the counts of tags that changed since the read, and with
+// ErrCustomerNotFound if the customer is already gone.
+func DeleteCustomerWithTags(ctx context.Context, client *Client, tableName, tagsTable string, customer *models.Customer) error {
+	if len(customer.Tags) == 0 {
+		return DeleteCustomer(ctx, client, tableName, customer)
+	}
+
+	items := []types.TransactWriteItem{{Delete: versionedDelete(tableName, customer)}}
+	for _, tag := range customer.Tags {
+		items = append(items, tagCountUpdate(tagsTable, tag, "-1"))
+	}
+	items, err := withEvents(ctx, client, items, newEvent(models.EventCustomerDeleted, customer.ID, nil, nil))
+	if err != nil {
+		return err
+	}
+
+	_, err = client.TransactWriteItems(ctx, &dynamodb.TransactWriteItemsInput{TransactItems: items})
+	if err != nil {
+		return fmt.Errorf("failed to delete item: %w", versionedWriteError(err))
+	}
+
+	client.unindexCustomer(customer.ID)
+	return nil
+}
+
+// ListTagCounts returns every tag in use with the number of customers carrying it
+func ListTagCounts(ctx context.Context, client *Client, tagsTable string) ([]models.TagCount, error) {
+	input := &dynamodb.ScanInput{
+		TableName: aws.String(tagsTable),
+	}
+
+	counts := []models.TagCount{}
+	paginator := dynamodb.NewScanPaginator(client, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan table: %w", err)
+		}
+		var pageCounts []models.TagCount
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pageCounts); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal tag counts: %w", err)
+		}
+		for _, count := range pageCounts {
+			// Counts are kept at zero rather than deleted when the last customer is untagged
+			if count.Count > 0 {
+				counts = append(counts, count)
+			}
+		}
+	}
+
+	sort.Slice(counts, func(i, j int) bool { return counts[i].Tag < counts[j].Tag })
+	return counts, nil
+}
+
+// tagCountUpdate builds a transactional update that moves a tag's count by delta
+func tagCountUpdate(tagsTable, tag, delta string) types.TransactWriteItem {
+	return types.TransactWriteItem{
+		Update: &types.Update{
+			TableName: aws.String(tagsTable),
+			Key: map[string]types.AttributeValue{
+				"tag": &types.AttributeValueMemberS{Value: tag},
+			},
+			UpdateExpression:         aws.String("ADD #count :delta"),
+			ExpressionAttributeNames: map[string]string{"#count": "count"},
+			ExpressionAttributeValues: map[string]types.AttributeValue{
+				":delta": &types.AttributeValueMemberN{Value: delta},
+			},
+		},
+	}
+}
+
+// customerKey builds the primary key of a customer item
+func customerKey(id string) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"id": &types.AttributeValueMemberS{Value: id},
+	}
+}
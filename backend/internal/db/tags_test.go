@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteCustomerWithTags_ConditionedOnVersion(t *testing.T) {
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "TransactWriteItems", operation)
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+
+	err := DeleteCustomerWithTags(context.Background(), client, "Customers", "Tags", &models.Customer{ID: "c1", Tags: []string{"vip"}, Version: 2})
+
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	remove := transactItem(items, 0, "Delete")
+	assert.Equal(t, "attribute_exists(#id) AND #version = :version", remove["ConditionExpression"])
+	assert.Equal(t, map[string]interface{}{":version": map[string]interface{}{"N": "2"}}, remove["ExpressionAttributeValues"])
+}
+
+func TestDeleteCustomerWithTags_Changed(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message": "Transaction cancelled",
+			"CancellationReasons": []map[string]interface{}{
+				{"Code": "ConditionalCheckFailed", "Item": map[string]interface{}{"id": map[string]string{"S": "c1"}}},
+				{"Code": "None"},
+			},
+		}
+	})
+
+	err := DeleteCustomerWithTags(context.Background(), client, "Customers", "Tags", &models.Customer{ID: "c1", Tags: []string{"vip"}})
+
+	assert.ErrorIs(t, err, ErrCustomerChanged, "a tag changed since the read would leave its count wrong")
+}
+
+func TestDeleteCustomer_WithoutTags(t *testing.T) {
+	var condition interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "DeleteItem", operation)
+		condition = body["ConditionExpression"]
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	})
+
+	err := DeleteCustomerWithTags(context.Background(), client, "Customers", "Tags", &models.Customer{ID: "c1"})
+
+	assert.ErrorIs(t, err, ErrCustomerNotFound)
+	assert.Equal(t, "attribute_exists(#id) AND attribute_not_exists(#version)", condition)
+}
+
+func TestAddCustomerTag_ConditionedOnLimitAndActive(t *testing.T) {
+	var items []interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "TransactWriteItems", operation)
+		items = body["TransactItems"].([]interface{})
+		return map[string]interface{}{}
+	})
+
+	added, err := AddCustomerTag(context.Background(), client, "Customers", "Tags", "c1", "vip")
+
+	require.NoError(t, err)
+	assert.True(t, added)
+	update := transactItem(items, 0, "Update")
+	assert.Equal(t, activeCustomerCondition+" AND NOT contains(#tags, :tag) AND (attribute_not_exists(#tags) OR size(#tags) < :max)", update["ConditionExpression"])
+	values := update["ExpressionAttributeValues"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"N": "50"}, values[":max"])
+}
+
+// tagConditionFailure answers a tag transaction with its customer update
+// failing its condition on item
+func tagConditionFailure(item map[string]interface{}) func(string, map[string]interface{}) interface{} {
+	return func(operation string, body map[string]interface{}) interface{} {
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#TransactionCanceledException",
+			"message": "Transaction cancelled",
+			"CancellationReasons": []map[string]interface{}{
+				{"Code": "ConditionalCheckFailed", "Item": item},
+				{"Code": "None"},
+			},
+		}
+	}
+}
+
+func TestAddCustomerTag_TooManyTags(t *testing.T) {
+	client := fakeDynamoDB(t, tagConditionFailure(map[string]interface{}{
+		"id":   map[string]string{"S": "c1"},
+		"tags": map[string]interface{}{"SS": []string{"a", "b"}},
+	}))
+
+	added, err := AddCustomerTag(context.Background(), client, "Customers", "Tags", "c1", "vip")
+
+	assert.ErrorIs(t, err, ErrTooManyTags, "a concurrent add filled the customer's tags after the read")
+	assert.False(t, added)
+}
+
+func TestAddCustomerTag_Tombstone(t *testing.T) {
+	tests := []struct {
+		name      string
+		attribute string
+	}{
+		{name: "erased", attribute: "erasedAt"},
+		{name: "merged", attribute: "mergedInto"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fakeDynamoDB(t, tagConditionFailure(map[string]interface{}{
+				"id":         map[string]string{"S": "c1"},
+				tt.attribute: map[string]string{"S": "x"},
+			}))
+
+			added, err := AddCustomerTag(context.Background(), client, "Customers", "Tags", "c1", "vip")
+
+			assert.ErrorIs(t, err, ErrCustomerInactive)
+			assert.False(t, added)
+		})
+	}
+}
+
+func TestAddCustomerTag_AlreadyTagged(t *testing.T) {
+	client := fakeDynamoDB(t, tagConditionFailure(map[string]interface{}{
+		"id":   map[string]string{"S": "c1"},
+		"tags": map[string]interface{}{"SS": []string{"vip"}},
+	}))
+
+	added, err := AddCustomerTag(context.Background(), client, "Customers", "Tags", "c1", "vip")
+
+	require.NoError(t, err)
+	assert.False(t, added)
+}
@@ -0,0 +1,375 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/expression"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// deliveryEventAttribute holds the event of a webhook delivery, marshalled
+// like an outbox event so its customer snapshot stays encrypted
+const deliveryEventAttribute = "event"
+
+// deliveryDueIndexName is the index of pending deliveries by next attempt.
+// Deliveries that are no longer pending have no next attempt, so it only
+// holds pending ones.
+const deliveryDueIndexName = "status-nextAttemptAt-index"
+
+var (
+	// ErrWebhookNotFound is returned when a webhook doesn't exist
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrWebhookDeliveryClaimed is returned when another attempt claimed a delivery first
+	ErrWebhookDeliveryClaimed = errors.New("webhook delivery claimed by another attempt")
+	// ErrWebhookDeliveryLost is returned when a delivery was claimed by another
+	// attempt after its claim expired
+	ErrWebhookDeliveryLost = errors.New("webhook delivery claim lost")
+)
+
+// EnsureWebhooksTableExists checks if the webhooks table exists and creates it if it doesn't
+func EnsureWebhooksTableExists(ctx context.Context, client *Client, tableName string) error {
+	_, err := ensureTable(ctx, client, createTableInput(tableName, "id", ""))
+	return err
+}
+
+// EnsureWebhookDeliveriesTableExists checks if the webhook deliveries table
+// exists and creates it if it doesn't, enabling time to live so old
+// deliveries expire
+func EnsureWebhookDeliveriesTableExists(ctx context.Context, client *Client, tableName string) error {
+	input := createTableInput(tableName, "webhookId", "deliveryId")
+	input.AttributeDefinitions = append(input.AttributeDefinitions, deliveryDueAttributes()...)
+	input.GlobalSecondaryIndexes = []types.GlobalSecondaryIndex{deliveryDueIndex()}
+	return ensureTableWithTTL(ctx, client, input)
+}
+
+// AddWebhookDeliveryDueIndex adds the index of pending deliveries to a
+// deliveries table created before it existed
+func AddWebhookDeliveryDueIndex(ctx context.Context, client *Client, tableName string) error {
+	return AddGlobalSecondaryIndex(ctx, client, tableName, deliveryDueIndex(), deliveryDueAttributes()...)
+}
+
+// HasWebhookDeliveryDueIndex reports whether a deliveries table has an
+// active index of pending deliveries
+func HasWebhookDeliveryDueIndex(ctx context.Context, client *Client, tableName string) (bool, error) {
+	return hasActiveIndex(ctx, client, tableName, deliveryDueIndexName)
+}
+
+// deliveryDueIndex describes the index of pending deliveries by next attempt
+func deliveryDueIndex() types.GlobalSecondaryIndex {
+	return types.GlobalSecondaryIndex{
+		IndexName: aws.String(deliveryDueIndexName),
+		KeySchema: []types.KeySchemaElement{
+			{
+				AttributeName: aws.String("status"),
+				KeyType:       types.KeyTypeHash,
+			},
+			{
+				AttributeName: aws.String("nextAttemptAt"),
+				KeyType:       types.KeyTypeRange,
+			},
+		},
+		Projection: &types.Projection{
+			ProjectionType: types.ProjectionTypeAll,
+		},
+	}
+}
+
+// deliveryDueAttributes defines the attributes of the pending deliveries index keys
+func deliveryDueAttributes() []types.AttributeDefinition {
+	return []types.AttributeDefinition{
+		{AttributeName: aws.String("status"), AttributeType: types.ScalarAttributeTypeS},
+		{AttributeName: aws.String("nextAttemptAt"), AttributeType: types.ScalarAttributeTypeN},
+	}
+}
+
+// PutWebhook creates or replaces a webhook. Its secret is encrypted like
+// customer PII.
+func PutWebhook(ctx context.Context, client *Client, tableName string, webhook *models.Webhook) error {
+	item, err := attributevalue.MarshalMap(webhook)
+	if err != nil {
+		return fmt.Errorf("failed to marshal webhook: %w", err)
+	}
+	if client.encryptor != nil {
+		if err := client.encryptor.encryptAttributes(ctx, webhook.ID, item, []string{"secret"}); err != nil {
+			return err
+		}
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put webhook: %w", err)
+	}
+	return nil
+}
+
+// GetWebhook retrieves a webhook by ID, returning ErrWebhookNotFound when it doesn't exist
+func GetWebhook(ctx context.Context, client *Client, tableName string, id string) (*models.Webhook, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key:       customerKey(id),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get webhook: %w", err)
+	}
+	if result.Item == nil {
+		return nil, ErrWebhookNotFound
+	}
+
+	var webhook models.Webhook
+	if err := client.encryptor.unmarshalWebhook(ctx, result.Item, &webhook); err != nil {
+		return nil, err
+	}
+	return &webhook, nil
+}
+
+// ListWebhooks returns every webhook
+func ListWebhooks(ctx context.Context, client *Client, tableName string) ([]models.Webhook, error) {
+	webhooks := []models.Webhook{}
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{TableName: aws.String(tableName)})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan webhooks: %w", err)
+		}
+		for _, item := range page.Items {
+			var webhook models.Webhook
+			if err := client.encryptor.unmarshalWebhook(ctx, item, &webhook); err != nil {
+				return nil, err
+			}
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	return webhooks, nil
+}
+
+// DeleteWebhook removes a webhook. Its deliveries are left to expire.
+func DeleteWebhook(ctx context.Context, client *Client, tableName string, id string) error {
+	_, err := client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(tableName),
+		Key:       customerKey(id),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	return nil
+}
+
+// CreateWebhookDelivery stores a new delivery with its event. A delivery of
+// the same event to the same webhook is left as it is, so events published
+// again are not delivered twice.
+func CreateWebhookDelivery(ctx context.Context, client *Client, tableName string, delivery *models.WebhookDelivery) error {
+	item, err := client.encryptor.marshalDelivery(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                aws.String(tableName),
+		Item:                     item,
+		ConditionExpression:      aws.String("attribute_not_exists(#deliveryId)"),
+		ExpressionAttributeNames: map[string]string{"#deliveryId": "deliveryId"},
+	})
+	if err != nil && !isConditionalCheckFailed(err) {
+		return fmt.Errorf("failed to create webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ClaimWebhookDelivery claims a due delivery for an attempt identified by
+// delivery.Owner, pushing its next attempt to until so other dispatchers skip
+// it meanwhile. A claim that isn't saved before until expires, and the
+// delivery is due again. It fails with ErrWebhookDeliveryClaimed when the
+// delivery changed since it was read.
+func ClaimWebhookDelivery(ctx context.Context, client *Client, tableName string, delivery *models.WebhookDelivery, until time.Time) error {
+	update := expression.Set(expression.Name("owner"), expression.Value(delivery.Owner)).
+		Set(expression.Name("nextAttemptAt"), expression.Value(until.Unix()))
+	condition := expression.Name("status").Equal(expression.Value(models.DeliveryPending)).
+		And(expression.Name("nextAttemptAt").Equal(expression.Value(delivery.NextAttemptAt)))
+	expr, err := expression.NewBuilder().WithUpdate(update).WithCondition(condition).Build()
+	if err != nil {
+		return fmt.Errorf("failed to build delivery claim: %w", err)
+	}
+
+	_, err = client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:                 aws.String(tableName),
+		Key:                       deliveryKey(delivery),
+		UpdateExpression:          expr.Update(),
+		ConditionExpression:       expr.Condition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrWebhookDeliveryClaimed
+	}
+	if err != nil {
+		return fmt.Errorf("failed to claim webhook delivery: %w", err)
+	}
+	delivery.NextAttemptAt = until.Unix()
+	return nil
+}
+
+// SaveWebhookDelivery replaces a delivery with the outcome of an attempt. It
+// fails with ErrWebhookDeliveryLost when another attempt claimed the delivery
+// since delivery.Owner did.
+func SaveWebhookDelivery(ctx context.Context, client *Client, tableName string, delivery *models.WebhookDelivery) error {
+	item, err := client.encryptor.marshalDelivery(ctx, delivery)
+	if err != nil {
+		return err
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:                 aws.String(tableName),
+		Item:                      item,
+		ConditionExpression:       aws.String("#owner = :owner"),
+		ExpressionAttributeNames:  map[string]string{"#owner": "owner"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{":owner": &types.AttributeValueMemberS{Value: delivery.Owner}},
+	})
+	if isConditionalCheckFailed(err) {
+		return ErrWebhookDeliveryLost
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save webhook delivery: %w", err)
+	}
+	return nil
+}
+
+// ListDueWebhookDeliveries returns up to limit pending deliveries whose next
+// attempt is due, the longest overdue first. The index is eventually
+// consistent, so a delivery must be claimed before it is attempted.
+func ListDueWebhookDeliveries(ctx context.Context, client *Client, tableName string, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	keyCondition := expression.Key("status").Equal(expression.Value(models.DeliveryPending)).
+		And(expression.Key("nextAttemptAt").LessThanEqual(expression.Value(now.Unix())))
+	expr, err := expression.NewBuilder().WithKeyCondition(keyCondition).Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build key condition: %w", err)
+	}
+
+	result, err := client.Query(ctx, &dynamodb.QueryInput{
+		TableName:                 aws.String(tableName),
+		IndexName:                 aws.String(deliveryDueIndexName),
+		KeyConditionExpression:    expr.KeyCondition(),
+		ExpressionAttributeNames:  expr.Names(),
+		ExpressionAttributeValues: expr.Values(),
+		Limit:                     aws.Int32(int32(limit)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query due webhook deliveries: %w", err)
+	}
+
+	deliveries := make([]models.WebhookDelivery, 0, len(result.Items))
+	for _, item := range result.Items {
+		var delivery models.WebhookDelivery
+		if err := client.encryptor.unmarshalDelivery(ctx, item, &delivery); err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	return deliveries, nil
+}
+
+// deliveryKey returns the key of a webhook delivery
+func deliveryKey(delivery *models.WebhookDelivery) map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		"webhookId":  &types.AttributeValueMemberS{Value: delivery.WebhookID},
+		"deliveryId": &types.AttributeValueMemberS{Value: delivery.ID},
+	}
+}
+
+// ListWebhookDeliveries returns a page of a webhook's deliveries, newest
+// first. An empty cursor starts from the newest delivery; the returned cursor
+// is empty on the last page.
+func ListWebhookDeliveries(ctx context.Context, client *Client, tableName, webhookID string, limit int64, cursor string) (*models.WebhookDeliveryPage, error) {
+	input := &dynamodb.QueryInput{
+		TableName:                aws.String(tableName),
+		KeyConditionExpression:   aws.String("#webhookId = :webhookId"),
+		ExpressionAttributeNames: map[string]string{"#webhookId": "webhookId"},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":webhookId": &types.AttributeValueMemberS{Value: webhookID},
+		},
+		ScanIndexForward: aws.Bool(false),
+		Limit:            aws.Int32(int32(limit)),
+	}
+
+	if cursor != "" {
+		deliveryID, err := decodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		input.ExclusiveStartKey = map[string]types.AttributeValue{
+			"webhookId":  &types.AttributeValueMemberS{Value: webhookID},
+			"deliveryId": &types.AttributeValueMemberS{Value: deliveryID},
+		}
+	}
+
+	result, err := client.Query(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webhook deliveries: %w", err)
+	}
+
+	// The log leaves out the events, so their snapshots aren't decrypted
+	page := &models.WebhookDeliveryPage{Items: []models.WebhookDelivery{}}
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &page.Items); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal webhook deliveries: %w", err)
+	}
+
+	if lastKey := attributeString(result.LastEvaluatedKey["deliveryId"]); lastKey != "" {
+		page.NextCursor = encodeCursor(lastKey)
+	}
+	return page, nil
+}
+
+// unmarshalWebhook converts an item to a webhook, decrypting its secret
+func (fe *FieldEncryptor) unmarshalWebhook(ctx context.Context, item map[string]types.AttributeValue, webhook *models.Webhook) error {
+	if item[envelopeAttribute] != nil {
+		if fe == nil {
+			return ErrEncryptionNotConfigured
+		}
+		if err := fe.decryptItem(ctx, item); err != nil {
+			return err
+		}
+	}
+	if err := attributevalue.UnmarshalMap(item, webhook); err != nil {
+		return fmt.Errorf("failed to unmarshal webhook: %w", err)
+	}
+	return nil
+}
+
+// marshalDelivery converts a delivery to an item, nesting its event
+func (fe *FieldEncryptor) marshalDelivery(ctx context.Context, delivery *models.WebhookDelivery) (map[string]types.AttributeValue, error) {
+	item, err := attributevalue.MarshalMap(delivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook delivery: %w", err)
+	}
+	if delivery.Event != nil {
+		event, err := fe.marshalEvent(ctx, delivery.Event)
+		if err != nil {
+			return nil, err
+		}
+		item[deliveryEventAttribute] = &types.AttributeValueMemberM{Value: event}
+	}
+	return item, nil
+}
+
+// unmarshalDelivery converts an item to a delivery, decrypting its event's customer snapshot
+func (fe *FieldEncryptor) unmarshalDelivery(ctx context.Context, item map[string]types.AttributeValue, delivery *models.WebhookDelivery) error {
+	if err := attributevalue.UnmarshalMap(item, delivery); err != nil {
+		return fmt.Errorf("failed to unmarshal webhook delivery: %w", err)
+	}
+	if event, ok := item[deliveryEventAttribute].(*types.AttributeValueMemberM); ok {
+		delivery.Event = &models.Event{}
+		if err := fe.unmarshalEvent(ctx, event.Value, delivery.Event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// expressionValues returns the expression attribute values of a request
+func expressionValues(body map[string]interface{}) []interface{} {
+	var values []interface{}
+	for _, value := range body["ExpressionAttributeValues"].(map[string]interface{}) {
+		values = append(values, value)
+	}
+	return values
+}
+
+func TestCreateWebhookDelivery_IgnoresRepeatedEvent(t *testing.T) {
+	var item map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "PutItem", operation)
+		assert.Equal(t, "attribute_not_exists(#deliveryId)", body["ConditionExpression"])
+		item = body["Item"].(map[string]interface{})
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	})
+
+	event := &models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated, Customer: &models.Customer{ID: "c1", Name: "John Doe"}}
+	err := CreateWebhookDelivery(context.Background(), client, "Deliveries", &models.WebhookDelivery{WebhookID: "w1", ID: "e1", Status: models.DeliveryPending, Event: event})
+
+	require.NoError(t, err)
+	nested := item["event"].(map[string]interface{})["M"].(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{"S": "e1"}, nested["eventId"])
+	assert.NotNil(t, nested["customer"])
+}
+
+func TestListDueWebhookDeliveries(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "Query", operation)
+		assert.Equal(t, deliveryDueIndexName, body["IndexName"])
+		assert.Equal(t, float64(10), body["Limit"])
+		values := expressionValues(body)
+		assert.Contains(t, values, map[string]interface{}{"S": models.DeliveryPending})
+		assert.Contains(t, values, map[string]interface{}{"N": "1700000000"})
+		return map[string]interface{}{
+			"Items": []map[string]interface{}{{
+				"webhookId":     map[string]string{"S": "w1"},
+				"deliveryId":    map[string]string{"S": "e1"},
+				"status":        map[string]string{"S": models.DeliveryPending},
+				"attempts":      map[string]string{"N": "2"},
+				"nextAttemptAt": map[string]string{"N": "1699999999"},
+				"event": map[string]interface{}{"M": map[string]interface{}{
+					"customerId": map[string]string{"S": "c1"},
+					"eventId":    map[string]string{"S": "e1"},
+					"type":       map[string]string{"S": models.EventCustomerUpdated},
+					"customer":   map[string]interface{}{"M": map[string]interface{}{"id": map[string]string{"S": "c1"}, "name": map[string]string{"S": "John Doe"}}},
+				}},
+			}},
+		}
+	})
+
+	deliveries, err := ListDueWebhookDeliveries(context.Background(), client, "Deliveries", now, 10)
+
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	assert.Equal(t, 2, deliveries[0].Attempts)
+	require.NotNil(t, deliveries[0].Event)
+	assert.Equal(t, models.EventCustomerUpdated, deliveries[0].Event.Type)
+	assert.Equal(t, "John Doe", deliveries[0].Event.Customer.Name)
+}
+
+func TestClaimWebhookDelivery(t *testing.T) {
+	var update map[string]interface{}
+	claimed := false
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "UpdateItem", operation)
+		update = body
+		if claimed {
+			return map[string]interface{}{
+				"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+				"message": "The conditional request failed",
+			}
+		}
+		claimed = true
+		return map[string]interface{}{}
+	})
+	until := time.Unix(1700000060, 0)
+	delivery := &models.WebhookDelivery{WebhookID: "w1", ID: "e1", NextAttemptAt: 1700000000, Owner: "a1"}
+
+	require.NoError(t, ClaimWebhookDelivery(context.Background(), client, "Deliveries", delivery, until))
+
+	assert.Equal(t, until.Unix(), delivery.NextAttemptAt)
+	values := expressionValues(update)
+	assert.Contains(t, values, map[string]interface{}{"S": "a1"})
+	assert.Contains(t, values, map[string]interface{}{"N": "1700000000"}, "conditioned on the next attempt read")
+	assert.Contains(t, values, map[string]interface{}{"N": "1700000060"})
+
+	// Another dispatcher claiming the same delivery loses
+	other := &models.WebhookDelivery{WebhookID: "w1", ID: "e1", NextAttemptAt: 1700000000, Owner: "a2"}
+	err := ClaimWebhookDelivery(context.Background(), client, "Deliveries", other, until)
+	assert.ErrorIs(t, err, ErrWebhookDeliveryClaimed)
+}
+
+func TestSaveWebhookDelivery_Lost(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "PutItem", operation)
+		assert.Equal(t, "#owner = :owner", body["ConditionExpression"])
+		return map[string]interface{}{
+			"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+			"message": "The conditional request failed",
+		}
+	})
+
+	err := SaveWebhookDelivery(context.Background(), client, "Deliveries", &models.WebhookDelivery{WebhookID: "w1", ID: "e1", Owner: "a1"})
+
+	assert.ErrorIs(t, err, ErrWebhookDeliveryLost)
+}
+
+func TestPutWebhook_EncryptsSecret(t *testing.T) {
+	var stored map[string]interface{}
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		if operation == "PutItem" {
+			stored = body["Item"].(map[string]interface{})
+			return map[string]interface{}{}
+		}
+		return map[string]interface{}{"Item": stored}
+	})
+	client.encryptor = testEncryptor(t, []string{"email"})
+
+	require.NoError(t, PutWebhook(context.Background(), client, "Webhooks", &models.Webhook{ID: "w1", URL: "https://partner.example.com/hooks", Secret: "0123456789abcdef"}))
+
+	assert.NotContains(t, stored["secret"], "S")
+	assert.NotNil(t, stored[envelopeAttribute])
+	webhook, err := GetWebhook(context.Background(), client, "Webhooks", "w1")
+	require.NoError(t, err)
+	assert.Equal(t, "0123456789abcdef", webhook.Secret)
+	assert.Equal(t, "https://partner.example.com/hooks", webhook.URL)
+
+	// Reading it back needs the encryptor
+	client.encryptor = nil
+	_, err = GetWebhook(context.Background(), client, "Webhooks", "w1")
+	assert.ErrorIs(t, err, ErrEncryptionNotConfigured)
+}
+
+func TestListWebhookDeliveries_Cursor(t *testing.T) {
+	client := fakeDynamoDB(t, func(operation string, body map[string]interface{}) interface{} {
+		require.Equal(t, "Query", operation)
+		assert.Equal(t, false, body["ScanIndexForward"])
+		start := body["ExclusiveStartKey"].(map[string]interface{})
+		assert.Equal(t, map[string]interface{}{"S": "e2"}, start["deliveryId"])
+		return map[string]interface{}{
+			"Items": []map[string]interface{}{{
+				"webhookId":  map[string]string{"S": "w1"},
+				"deliveryId": map[string]string{"S": "e1"},
+				"status":     map[string]string{"S": models.DeliveryDead},
+			}},
+			"LastEvaluatedKey": map[string]interface{}{
+				"webhookId":  map[string]string{"S": "w1"},
+				"deliveryId": map[string]string{"S": "e1"},
+			},
+		}
+	})
+
+	page, err := ListWebhookDeliveries(context.Background(), client, "Deliveries", "w1", 1, encodeCursor("e2"))
+
+	require.NoError(t, err)
+	require.Len(t, page.Items, 1)
+	assert.Equal(t, models.DeliveryDead, page.Items[0].Status)
+	assert.Equal(t, encodeCursor("e1"), page.NextCursor)
+}
@@ -0,0 +1,15 @@
+package encryption
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// BlindIndex computes a keyed hash of a value so it can be matched exactly
+// without storing or revealing the value itself
+func BlindIndex(key []byte, value string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,32 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/emiteze/tcc-ufu/internal/config"
+)
+
+// NewKeyProvider builds the key provider selected by the configuration.
+// It returns nil when field encryption is disabled.
+func NewKeyProvider(ctx context.Context, cfg *config.Config) (KeyProvider, error) {
+	switch cfg.EncryptionProvider {
+	case "":
+		return nil, nil
+	case "local":
+		return NewLocalKeyProvider(cfg.EncryptionKeyFile)
+	case "kms":
+		if cfg.EncryptionKMSKeyID == "" {
+			return nil, fmt.Errorf("ENCRYPTION_KMS_KEY_ID is required for the kms provider")
+		}
+		awsConfig, err := awsconfig.LoadDefaultConfig(ctx, awsconfig.WithRegion(cfg.AWSRegion))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load AWS configuration: %v", err)
+		}
+		return NewKMSKeyProvider(kms.NewFromConfig(awsConfig), cfg.EncryptionKMSKeyID), nil
+	default:
+		return nil, fmt.Errorf("unknown encryption provider %q", cfg.EncryptionProvider)
+	}
+}
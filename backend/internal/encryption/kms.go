@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// KMSClient is the subset of the AWS KMS API used by KMSKeyProvider. Any
+// KMS-compatible service exposing these calls can be used.
+type KMSClient interface {
+	GenerateDataKey(ctx context.Context, params *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error)
+	Decrypt(ctx context.Context, params *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error)
+}
+
+// KMSKeyProvider issues data keys from a KMS key. Rotation is handled by KMS;
+// the key ID returned with each data key is the full key ARN.
+type KMSKeyProvider struct {
+	client KMSClient
+	keyID  string
+}
+
+// NewKMSKeyProvider creates a provider that generates data keys under keyID
+func NewKMSKeyProvider(client KMSClient, keyID string) *KMSKeyProvider {
+	return &KMSKeyProvider{client: client, keyID: keyID}
+}
+
+// GenerateDataKey implements KeyProvider
+func (p *KMSKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	output, err := p.client.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:   aws.String(p.keyID),
+		KeySpec: types.DataKeySpecAes256,
+	})
+	if err != nil {
+		return nil, nil, "", fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	return output.Plaintext, output.CiphertextBlob, aws.ToString(output.KeyId), nil
+}
+
+// DecryptDataKey implements KeyProvider
+func (p *KMSKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	output, err := p.client.Decrypt(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrapped,
+		KeyId:          aws.String(keyID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt data key: %w", err)
+	}
+
+	return output.Plaintext, nil
+}
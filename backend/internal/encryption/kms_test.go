@@ -0,0 +1,71 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKMS wraps data keys by reversing them, which is enough to check the provider's plumbing
+type fakeKMS struct {
+	requestedKeyID string
+}
+
+func (f *fakeKMS) GenerateDataKey(ctx context.Context, input *kms.GenerateDataKeyInput, optFns ...func(*kms.Options)) (*kms.GenerateDataKeyOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.requestedKeyID = aws.ToString(input.KeyId)
+	plaintext := bytes.Repeat([]byte{3}, DataKeySize)
+	plaintext[0] = 9
+	return &kms.GenerateDataKeyOutput{
+		Plaintext:      plaintext,
+		CiphertextBlob: reverse(plaintext),
+		KeyId:          aws.String("arn:aws:kms:us-east-1:123456789012:key/abc"),
+	}, nil
+}
+
+func (f *fakeKMS) Decrypt(ctx context.Context, input *kms.DecryptInput, optFns ...func(*kms.Options)) (*kms.DecryptOutput, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &kms.DecryptOutput{Plaintext: reverse(input.CiphertextBlob)}, nil
+}
+
+func reverse(b []byte) []byte {
+	out := make([]byte, len(b))
+	for i := range b {
+		out[len(b)-1-i] = b[i]
+	}
+	return out
+}
+
+func TestKMSKeyProvider(t *testing.T) {
+	client := &fakeKMS{}
+	provider := NewKMSKeyProvider(client, "alias/customers")
+
+	dataKey, wrapped, keyID, err := provider.GenerateDataKey(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "alias/customers", client.requestedKeyID)
+	assert.Equal(t, "arn:aws:kms:us-east-1:123456789012:key/abc", keyID)
+
+	unwrapped, err := provider.DecryptDataKey(context.Background(), wrapped, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+}
+
+func TestKMSKeyProvider_Canceled(t *testing.T) {
+	provider := NewKMSKeyProvider(&fakeKMS{}, "alias/customers")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, _, _, err := provider.GenerateDataKey(ctx)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = provider.DecryptDataKey(ctx, []byte("wrapped"), "arn:aws:kms:us-east-1:123456789012:key/abc")
+	assert.ErrorIs(t, err, context.Canceled)
+}
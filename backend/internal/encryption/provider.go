@@ -0,0 +1,155 @@
+package encryption
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// DataKeySize is the size in bytes of the AES-256 data keys used to encrypt fields
+const DataKeySize = 32
+
+// KeyProvider issues and unwraps the data keys used for envelope
+// encryption. Calls stop when ctx is done.
+type KeyProvider interface {
+	// GenerateDataKey returns a new data key in plaintext, the same key wrapped
+	// by the provider's current key encryption key, and that key's ID
+	GenerateDataKey(ctx context.Context) (plaintext []byte, wrapped []byte, keyID string, err error)
+	// DecryptDataKey unwraps a data key previously wrapped under keyID
+	DecryptDataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error)
+}
+
+// ErrUnknownKey is returned when a data key was wrapped by a key the provider doesn't hold
+var ErrUnknownKey = errors.New("unknown key encryption key")
+
+// keyFile is the on-disk format of a local keyring. Old keys stay in the file
+// after rotation so existing items can still be decrypted.
+type keyFile struct {
+	CurrentKeyID string            `json:"currentKeyId"`
+	Keys         map[string]string `json:"keys"`
+}
+
+// LocalKeyProvider wraps data keys with AES-GCM master keys read from a file.
+// It is meant for development and tests.
+type LocalKeyProvider struct {
+	currentKeyID string
+	keys         map[string][]byte
+}
+
+// NewLocalKeyProvider loads a keyring file of the form
+// {"currentKeyId": "k2", "keys": {"k1": "<base64>", "k2": "<base64>"}}
+// where each key is 32 random bytes
+func NewLocalKeyProvider(path string) (*LocalKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %v", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse key file: %v", err)
+	}
+
+	keys := map[string][]byte{}
+	for id, encoded := range file.Keys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != DataKeySize {
+			return nil, fmt.Errorf("key %q must be %d base64-encoded bytes", id, DataKeySize)
+		}
+		keys[id] = key
+	}
+
+	return NewLocalKeyProviderFromKeys(file.CurrentKeyID, keys)
+}
+
+// NewLocalKeyProviderFromKeys builds a provider from in-memory master keys
+func NewLocalKeyProviderFromKeys(currentKeyID string, keys map[string][]byte) (*LocalKeyProvider, error) {
+	if _, ok := keys[currentKeyID]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the keyring", currentKeyID)
+	}
+	return &LocalKeyProvider{currentKeyID: currentKeyID, keys: keys}, nil
+}
+
+// GenerateDataKey implements KeyProvider
+func (p *LocalKeyProvider) GenerateDataKey(ctx context.Context) ([]byte, []byte, string, error) {
+	plaintext, err := randomBytes(DataKeySize)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	wrapped, err := Seal(p.keys[p.currentKeyID], plaintext, []byte(p.currentKeyID))
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	return plaintext, wrapped, p.currentKeyID, nil
+}
+
+// DecryptDataKey implements KeyProvider
+func (p *LocalKeyProvider) DecryptDataKey(ctx context.Context, wrapped []byte, keyID string) ([]byte, error) {
+	key, ok := p.keys[keyID]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	return Open(key, wrapped, []byte(keyID))
+}
+
+// Seal encrypts plaintext with AES-GCM, binding it to additionalData. The
+// random nonce is prepended to the returned ciphertext.
+func Seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+// Open decrypts a ciphertext produced by Seal with the same additionalData
+func Open(key, ciphertext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return nil, errors.New("ciphertext too short")
+	}
+
+	nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+	plaintext, err := gcm.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %v", err)
+	}
+	return plaintext, nil
+}
+
+// newGCM creates an AES-GCM cipher for a 256-bit key
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// randomBytes reads n bytes from the system's secure random source
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return nil, fmt.Errorf("failed to read random bytes: %v", err)
+	}
+	return b, nil
+}
@@ -0,0 +1,108 @@
+package encryption
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeKeyFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestSealOpen_RoundTrip(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, DataKeySize)
+
+	ciphertext, err := Seal(key, []byte("john.doe@example.com"), []byte("123/email"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(ciphertext), "john.doe")
+
+	plaintext, err := Open(key, ciphertext, []byte("123/email"))
+	require.NoError(t, err)
+	assert.Equal(t, "john.doe@example.com", string(plaintext))
+
+	// Ciphertexts are bound to their additional data
+	_, err = Open(key, ciphertext, []byte("456/email"))
+	assert.Error(t, err)
+
+	_, err = Open(key, []byte("short"), nil)
+	assert.Error(t, err)
+}
+
+func TestLocalKeyProvider_Rotation(t *testing.T) {
+	oldKey := bytes.Repeat([]byte{1}, DataKeySize)
+	newKey := bytes.Repeat([]byte{2}, DataKeySize)
+
+	before, err := NewLocalKeyProviderFromKeys("k1", map[string][]byte{"k1": oldKey})
+	require.NoError(t, err)
+
+	dataKey, wrapped, keyID, err := before.GenerateDataKey(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "k1", keyID)
+	assert.Len(t, dataKey, DataKeySize)
+
+	// After rotation new keys are wrapped by k2, but k1 keys still unwrap
+	after, err := NewLocalKeyProviderFromKeys("k2", map[string][]byte{"k1": oldKey, "k2": newKey})
+	require.NoError(t, err)
+
+	unwrapped, err := after.DecryptDataKey(context.Background(), wrapped, keyID)
+	require.NoError(t, err)
+	assert.Equal(t, dataKey, unwrapped)
+
+	_, _, keyID, err = after.GenerateDataKey(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "k2", keyID)
+
+	_, err = before.DecryptDataKey(context.Background(), wrapped, "k3")
+	assert.ErrorIs(t, err, ErrUnknownKey)
+}
+
+func TestNewLocalKeyProvider_FromFile(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, DataKeySize))
+	path := writeKeyFile(t, `{"currentKeyId":"dev","keys":{"dev":"`+key+`"}}`)
+
+	provider, err := NewLocalKeyProvider(path)
+	require.NoError(t, err)
+
+	_, _, keyID, err := provider.GenerateDataKey(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "dev", keyID)
+}
+
+func TestNewLocalKeyProvider_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "not JSON", content: "nope"},
+		{name: "short key", content: `{"currentKeyId":"dev","keys":{"dev":"c2hvcnQ="}}`},
+		{name: "missing current key", content: `{"currentKeyId":"other","keys":{}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLocalKeyProvider(writeKeyFile(t, tt.content))
+			assert.Error(t, err)
+		})
+	}
+
+	_, err := NewLocalKeyProvider(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestBlindIndex(t *testing.T) {
+	key := []byte("0123456789abcdef")
+
+	assert.Equal(t, BlindIndex(key, "john@example.com"), BlindIndex(key, "john@example.com"))
+	assert.NotEqual(t, BlindIndex(key, "john@example.com"), BlindIndex(key, "jane@example.com"))
+	assert.NotEqual(t, BlindIndex(key, "john@example.com"), BlindIndex([]byte("fedcba9876543210"), "john@example.com"))
+	assert.Len(t, BlindIndex(key, "john@example.com"), 64)
+}
@@ -0,0 +1,150 @@
+package events
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+const (
+	// relayInterval is how often the relay looks for pending events
+	relayInterval = time.Second
+	// relayBatchSize is the most events the relay reads from the outbox at once
+	relayBatchSize = 100
+)
+
+var (
+	// relayRetryDelay is how long after its first failure an event is
+	// published again. The delay doubles with every failure up to
+	// relayMaxRetryDelay.
+	relayRetryDelay    = time.Second
+	relayMaxRetryDelay = 5 * time.Minute
+)
+
+// Relay publishes the events written to the outbox and removes them once the
+// sink accepts them. Events are removed only after publishing, so delivery is
+// at least once, and a customer's events are published in order: a failed
+// event is retried with a growing delay while the customer's later events
+// wait, until it has failed maxAttempts times and is dead-lettered.
+//
+// Each pass reads the next page of the outbox, so customers waiting on a
+// failed event don't hold back the events of customers after them.
+type Relay struct {
+	client      *db.Client
+	outboxTable string
+	sink        Sink
+	maxAttempts int
+
+	// cursor is where the next pass starts, "" at the start of the outbox
+	cursor string
+	// blocked holds the customers with an undelivered event in the current
+	// sweep through the outbox
+	blocked map[string]bool
+}
+
+// NewRelay creates a relay publishing the events of outboxTable to sink,
+// dead-lettering an event once it has failed maxAttempts times
+func NewRelay(client *db.Client, outboxTable string, sink Sink, maxAttempts int) *Relay {
+	return &Relay{client: client, outboxTable: outboxTable, sink: sink, maxAttempts: maxAttempts}
+}
+
+// Run publishes pending events until ctx is cancelled
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going without waiting until the sweep reaches the end of the outbox
+		for {
+			_, err := r.Flush(ctx)
+			if err != nil {
+				log.Printf("Failed to relay events: %v", err)
+			}
+			if err != nil || r.cursor == "" || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Flush publishes the next page of the outbox and returns the number of
+// events published. A failed event is logged and blocks its customer's later
+// events until it is retried. The error is only for failures to read the outbox.
+func (r *Relay) Flush(ctx context.Context) (int, error) {
+	if r.cursor == "" {
+		r.blocked = map[string]bool{}
+	}
+	events, next, err := db.ListOutboxEvents(ctx, r.client, r.outboxTable, r.cursor, relayBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	now := time.Now()
+	published := 0
+	for _, event := range events {
+		if ctx.Err() != nil {
+			return published, nil
+		}
+		if r.blocked[event.CustomerID] {
+			continue
+		}
+		if retryAt, err := time.Parse(time.RFC3339Nano, event.NextAttemptAt); err == nil && now.Before(retryAt) {
+			r.blocked[event.CustomerID] = true
+			continue
+		}
+
+		if err := r.sink.Publish(ctx, event); err != nil {
+			r.fail(ctx, event, err, now)
+			continue
+		}
+		published++
+
+		// An event that can't be removed would be published again after the
+		// customer's later events, so they wait until it is gone
+		if err := db.DeleteOutboxEvent(ctx, r.client, r.outboxTable, event); err != nil {
+			log.Printf("Failed to remove published event %s: %v", event.ID, err)
+			r.blocked[event.CustomerID] = true
+		}
+	}
+	r.cursor = next
+	return published, nil
+}
+
+// fail records a failed publish, scheduling the event's retry or
+// dead-lettering it once it has failed maxAttempts times
+func (r *Relay) fail(ctx context.Context, event models.Event, cause error, now time.Time) {
+	attempts := event.Attempts + 1
+	if r.maxAttempts > 0 && attempts >= r.maxAttempts {
+		log.Printf("Dead-lettering event %s for customer %s after %d failed attempts: %v", event.ID, event.CustomerID, attempts, cause)
+		if err := db.DeadLetterOutboxEvent(ctx, r.client, r.outboxTable, event, now); err != nil {
+			log.Printf("Failed to dead-letter event %s: %v", event.ID, err)
+			r.blocked[event.CustomerID] = true
+		}
+		return
+	}
+
+	log.Printf("Failed to publish event %s for customer %s (attempt %d): %v", event.ID, event.CustomerID, attempts, cause)
+	r.blocked[event.CustomerID] = true
+	if err := db.RetryOutboxEvent(ctx, r.client, r.outboxTable, event, now.Add(retryDelay(attempts))); err != nil {
+		log.Printf("Failed to schedule retry of event %s: %v", event.ID, err)
+	}
+}
+
+// retryDelay returns how long to wait before publishing an event again after
+// its given number of failures
+func retryDelay(attempts int) time.Duration {
+	delay := relayRetryDelay
+	for i := 1; i < attempts && delay < relayMaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, relayMaxRetryDelay)
+}
@@ -0,0 +1,278 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeOutbox serves an outbox table holding events as (customerId, eventId)
+// pairs, in sort order, and removes them when they are deleted. Updated
+// attributes, such as attempts, are kept by event ID.
+type fakeOutbox struct {
+	mu         sync.Mutex
+	events     [][2]string
+	attributes map[string]map[string]interface{}
+}
+
+// updatePattern matches the actions of an update expression, such as
+// "ADD #0 :0" and "SET #1 = :1"
+var updatePattern = regexp.MustCompile(`(#\d+) (?:= )?(:\d+)`)
+
+func (f *fakeOutbox) client(t *testing.T) *db.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var response interface{} = map[string]interface{}{}
+		switch operation {
+		case "Scan":
+			start := 0
+			if startKey, ok := body["ExclusiveStartKey"].(map[string]interface{}); ok {
+				eventID := startKey["eventId"].(map[string]interface{})["S"]
+				for i, event := range f.events {
+					if event[1] == eventID {
+						start = i + 1
+					}
+				}
+			}
+			end := len(f.events)
+			if limit, ok := body["Limit"].(float64); ok && start+int(limit) < end {
+				end = start + int(limit)
+			}
+
+			items := []interface{}{}
+			for _, event := range f.events[start:end] {
+				item := map[string]interface{}{
+					"customerId": map[string]string{"S": event[0]},
+					"eventId":    map[string]string{"S": event[1]},
+					"type":       map[string]string{"S": models.EventCustomerUpdated},
+				}
+				for name, value := range f.attributes[event[1]] {
+					item[name] = value
+				}
+				if _, ok := item["deadLetteredAt"]; !ok {
+					items = append(items, item)
+				}
+			}
+			page := map[string]interface{}{"Items": items}
+			if end < len(f.events) {
+				page["LastEvaluatedKey"] = map[string]interface{}{
+					"customerId": map[string]string{"S": f.events[end-1][0]},
+					"eventId":    map[string]string{"S": f.events[end-1][1]},
+				}
+			}
+			response = page
+		case "UpdateItem":
+			key := body["Key"].(map[string]interface{})
+			eventID := key["eventId"].(map[string]interface{})["S"].(string)
+			names := body["ExpressionAttributeNames"].(map[string]interface{})
+			values := body["ExpressionAttributeValues"].(map[string]interface{})
+			if f.attributes == nil {
+				f.attributes = map[string]map[string]interface{}{}
+			}
+			if f.attributes[eventID] == nil {
+				f.attributes[eventID] = map[string]interface{}{}
+			}
+			for _, action := range updatePattern.FindAllStringSubmatch(body["UpdateExpression"].(string), -1) {
+				name, value := names[action[1]].(string), values[action[2]].(map[string]interface{})
+				if name == "attempts" {
+					attempts := 0
+					if current, ok := f.attributes[eventID][name].(map[string]interface{}); ok {
+						attempts, _ = strconv.Atoi(current["N"].(string))
+					}
+					value = map[string]interface{}{"N": strconv.Itoa(attempts + 1)}
+				}
+				f.attributes[eventID][name] = value
+			}
+		case "DeleteItem":
+			key := body["Key"].(map[string]interface{})
+			eventID := key["eventId"].(map[string]interface{})["S"]
+			for i, event := range f.events {
+				if event[1] == eventID {
+					f.events = append(f.events[:i], f.events[i+1:]...)
+					delete(f.attributes, eventID.(string))
+					break
+				}
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// publishedIDs returns the IDs of the events a sink received, in order
+func publishedIDs(sink *MemorySink) []string {
+	var ids []string
+	for _, event := range sink.Events() {
+		ids = append(ids, event.ID)
+	}
+	return ids
+}
+
+func TestRelay_FlushPublishesAndRemovesEvents(t *testing.T) {
+	outbox := &fakeOutbox{events: [][2]string{{"c1", "1"}, {"c1", "2"}, {"c2", "3"}}}
+	sink := &MemorySink{}
+	relay := NewRelay(outbox.client(t), "Outbox", sink, 20)
+
+	published, err := relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 3, published)
+	assert.Equal(t, []string{"1", "2", "3"}, publishedIDs(sink))
+	assert.Empty(t, outbox.events)
+}
+
+func TestRelay_FailureHoldsBackCustomersLaterEvents(t *testing.T) {
+	outbox := &fakeOutbox{events: [][2]string{{"c1", "1"}, {"c1", "2"}, {"c2", "3"}}}
+	failing := true
+	sink := &MemorySink{Fail: func(event models.Event) error {
+		if failing && event.ID == "1" {
+			return errors.New("unavailable")
+		}
+		return nil
+	}}
+	relay := NewRelay(outbox.client(t), "Outbox", sink, 20)
+	withRetryDelay(t, 0)
+
+	published, err := relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []string{"3"}, publishedIDs(sink))
+	assert.Equal(t, [][2]string{{"c1", "1"}, {"c1", "2"}}, outbox.events)
+	assert.Equal(t, map[string]interface{}{"N": "1"}, outbox.attributes["1"]["attempts"])
+
+	// The next pass delivers the held events in order
+	failing = false
+	_, err = relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"3", "1", "2"}, publishedIDs(sink))
+	assert.Empty(t, outbox.events)
+}
+
+// withRetryDelay sets the delay before a failed event is retried for the
+// duration of a test
+func withRetryDelay(t *testing.T, delay time.Duration) {
+	previous := relayRetryDelay
+	relayRetryDelay = delay
+	t.Cleanup(func() { relayRetryDelay = previous })
+}
+
+func TestRelay_FailedEventWaitsForRetryDelay(t *testing.T) {
+	outbox := &fakeOutbox{events: [][2]string{{"c1", "1"}, {"c1", "2"}}}
+	failing := true
+	sink := &MemorySink{Fail: func(event models.Event) error {
+		if failing {
+			return errors.New("unavailable")
+		}
+		return nil
+	}}
+	relay := NewRelay(outbox.client(t), "Outbox", sink, 20)
+
+	_, err := relay.Flush(context.Background())
+	require.NoError(t, err)
+
+	// The sink has recovered but the event isn't due yet
+	failing = false
+	published, err := relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 0, published)
+	assert.Len(t, outbox.events, 2)
+	assert.Contains(t, outbox.attributes["1"], "nextAttemptAt")
+}
+
+func TestRelay_PagesPastBlockedCustomers(t *testing.T) {
+	// The failing customer's events fill more than a page
+	outbox := &fakeOutbox{}
+	for i := 0; i < relayBatchSize+20; i++ {
+		outbox.events = append(outbox.events, [2]string{"c1", fmt.Sprintf("a%03d", i)})
+	}
+	outbox.events = append(outbox.events, [2]string{"c2", "b000"})
+	sink := &MemorySink{Fail: func(event models.Event) error {
+		if event.CustomerID == "c1" {
+			return errors.New("unavailable")
+		}
+		return nil
+	}}
+	relay := NewRelay(outbox.client(t), "Outbox", sink, 20)
+
+	_, err := relay.Flush(context.Background())
+	require.NoError(t, err)
+	published, err := relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []string{"b000"}, publishedIDs(sink))
+	assert.Len(t, outbox.events, relayBatchSize+20)
+	assert.Len(t, outbox.attributes, 1, "only the customer's first event was attempted")
+}
+
+func TestRelay_DeadLettersAfterMaxAttempts(t *testing.T) {
+	outbox := &fakeOutbox{events: [][2]string{{"c1", "1"}, {"c1", "2"}}}
+	sink := &MemorySink{Fail: func(event models.Event) error {
+		if event.ID == "1" {
+			return errors.New("rejected")
+		}
+		return nil
+	}}
+	relay := NewRelay(outbox.client(t), "Outbox", sink, 3)
+	withRetryDelay(t, 0)
+
+	for i := 0; i < 2; i++ {
+		published, err := relay.Flush(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 0, published)
+	}
+
+	// The third failure dead-letters the event and the customer's later
+	// events go out without it
+	published, err := relay.Flush(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, published)
+	assert.Equal(t, []string{"2"}, publishedIDs(sink))
+	assert.Equal(t, [][2]string{{"c1", "1"}}, outbox.events)
+	assert.Contains(t, outbox.attributes["1"], "deadLetteredAt")
+
+	published, err = relay.Flush(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, published)
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(1))
+	assert.Equal(t, 2*time.Second, retryDelay(2))
+	assert.Equal(t, 256*time.Second, retryDelay(9))
+	assert.Equal(t, 5*time.Minute, retryDelay(20))
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// webhookTimeout bounds a single webhook delivery
+const webhookTimeout = 10 * time.Second
+
+// Sink publishes customer events. Publish returns an error when the event
+// may not have been delivered, in which case it is published again later.
+type Sink interface {
+	Publish(ctx context.Context, event models.Event) error
+}
+
+// NewSink creates the sink selected by cfg. It returns nil when events are disabled.
+func NewSink(cfg *config.Config) (Sink, error) {
+	switch cfg.EventSink {
+	case "none":
+		return nil, nil
+	case "log":
+		return LogSink{}, nil
+	case "webhook":
+		if cfg.EventWebhookURL == "" {
+			return nil, errors.New("EVENT_WEBHOOK_URL is required by the webhook event sink")
+		}
+		return NewWebhookSink(cfg.EventWebhookURL), nil
+	default:
+		return nil, fmt.Errorf("unknown event sink %q", cfg.EventSink)
+	}
+}
+
+// MultiSink publishes events to several sinks. An event that fails on any
+// of them is published to all of them again, so each sink must tolerate
+// repeated events.
+type MultiSink []Sink
+
+// Publish publishes the event to every sink, returning the first error
+func (m MultiSink) Publish(ctx context.Context, event models.Event) error {
+	var first error
+	for _, sink := range m {
+		if err := sink.Publish(ctx, event); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// LogSink writes events to the log. Customer data is left out so the log
+// never holds PII.
+type LogSink struct{}
+
+// Publish logs the event
+func (LogSink) Publish(ctx context.Context, event models.Event) error {
+	log.Printf("Event %s %s for customer %s %v", event.ID, event.Type, event.CustomerID, event.Data)
+	return nil
+}
+
+// WebhookSink posts events as JSON to a URL. Any response other than 2xx
+// counts as a failed delivery.
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting to url
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Publish posts the event. Receivers can use the X-Event-Id header to
+// discard events delivered more than once.
+func (s *WebhookSink) Publish(ctx context.Context, event models.Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to build webhook request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", event.ID)
+	req.Header.Set("X-Event-Type", event.Type)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// MemorySink keeps published events in memory, for tests
+type MemorySink struct {
+	mu     sync.Mutex
+	events []models.Event
+	// Fail, when set, decides which events fail to publish
+	Fail func(event models.Event) error
+}
+
+// Publish records the event unless Fail rejects it
+func (s *MemorySink) Publish(ctx context.Context, event models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.Fail != nil {
+		if err := s.Fail(event); err != nil {
+			return err
+		}
+	}
+	s.events = append(s.events, event)
+	return nil
+}
+
+// Events returns the events published so far, in publishing order
+func (s *MemorySink) Events() []models.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]models.Event(nil), s.events...)
+}
@@ -0,0 +1,75 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewSink(t *testing.T) {
+	sink, err := NewSink(&config.Config{EventSink: "log"})
+	require.NoError(t, err)
+	assert.IsType(t, LogSink{}, sink)
+
+	sink, err = NewSink(&config.Config{EventSink: "none"})
+	require.NoError(t, err)
+	assert.Nil(t, sink)
+
+	sink, err = NewSink(&config.Config{EventSink: "webhook", EventWebhookURL: "https://example.com"})
+	require.NoError(t, err)
+	assert.IsType(t, &WebhookSink{}, sink)
+
+	_, err = NewSink(&config.Config{EventSink: "webhook"})
+	assert.Error(t, err)
+
+	_, err = NewSink(&config.Config{EventSink: "kafka"})
+	assert.Error(t, err)
+}
+
+func TestMultiSink_Publish(t *testing.T) {
+	ok := &MemorySink{}
+	failing := &MemorySink{Fail: func(models.Event) error { return errors.New("unavailable") }}
+
+	err := MultiSink{failing, ok}.Publish(context.Background(), models.Event{ID: "e1"})
+
+	assert.EqualError(t, err, "unavailable")
+	assert.Len(t, ok.Events(), 1)
+}
+
+func TestWebhookSink_Publish(t *testing.T) {
+	var received models.Event
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	event := models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerTagged, Data: map[string]string{"tag": "vip"}}
+	err := NewWebhookSink(server.URL).Publish(context.Background(), event)
+
+	require.NoError(t, err)
+	assert.Equal(t, event, received)
+	assert.Equal(t, "e1", headers.Get("X-Event-Id"))
+	assert.Equal(t, models.EventCustomerTagged, headers.Get("X-Event-Type"))
+}
+
+func TestWebhookSink_PublishRejected(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := NewWebhookSink(server.URL).Publish(context.Background(), models.Event{ID: "e1"})
+
+	assert.EqualError(t, err, "webhook responded 503")
+}
@@ -0,0 +1,188 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/google/uuid"
+)
+
+const (
+	// leaseDuration is how long a claimed job stays with its worker without
+	// progress being saved. A job whose worker stops is resumed elsewhere
+	// once its lease expires.
+	leaseDuration = 2 * time.Minute
+	// pollInterval is how often the runner looks for jobs to run
+	pollInterval = 5 * time.Second
+)
+
+// Executor runs a job to completion, saving progress through the task as it
+// goes. It must be able to resume from the job's checkpoint. Returning an
+// error fails the job unless the runner is shutting down or lost the lease.
+type Executor func(ctx context.Context, task *Task) error
+
+// Runner claims queued jobs, and jobs abandoned by a stopped worker, and runs
+// them with a bounded number of workers
+type Runner struct {
+	client    *db.Client
+	jobsTable string
+	dataTable string
+	workers   int
+	owner     string
+	executors map[string]Executor
+	wake      chan struct{}
+	slots     chan struct{}
+	running   sync.WaitGroup
+}
+
+// NewRunner creates a runner for the jobs tables in cfg
+func NewRunner(client *db.Client, cfg *config.Config) *Runner {
+	workers := max(cfg.JobWorkers, 1)
+	return &Runner{
+		client:    client,
+		jobsTable: cfg.JobsTableName,
+		dataTable: cfg.JobDataTableName,
+		workers:   workers,
+		owner:     uuid.New().String(),
+		executors: map[string]Executor{},
+		wake:      make(chan struct{}, 1),
+		slots:     make(chan struct{}, workers),
+	}
+}
+
+// Register sets the executor for a job type. Executors must be registered before Run.
+func (r *Runner) Register(jobType string, executor Executor) {
+	r.executors[jobType] = executor
+}
+
+// Notify wakes the runner to look for jobs without waiting for the next poll
+func (r *Runner) Notify() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run claims and executes jobs until ctx is cancelled, then waits for
+// running jobs to stop. Jobs interrupted by the shutdown keep their
+// checkpoint and are resumed once their lease expires.
+func (r *Runner) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		r.claimJobs(ctx)
+
+		select {
+		case <-ctx.Done():
+			r.running.Wait()
+			return
+		case <-ticker.C:
+		case <-r.wake:
+		}
+	}
+}
+
+// claimJobs starts as many claimable jobs as there are free workers
+func (r *Runner) claimJobs(ctx context.Context) {
+	if len(r.slots) == cap(r.slots) {
+		return
+	}
+
+	now := time.Now()
+	ids, err := db.ListClaimableJobIDs(ctx, r.client, r.jobsTable, now)
+	if err != nil {
+		log.Printf("Failed to list jobs: %v", err)
+		return
+	}
+
+	for _, id := range ids {
+		select {
+		case r.slots <- struct{}{}:
+		default:
+			return
+		}
+
+		job, err := db.ClaimJob(ctx, r.client, r.jobsTable, id, r.owner, now, now.Add(leaseDuration))
+		if err != nil {
+			<-r.slots
+			if !errors.Is(err, db.ErrJobNotClaimable) {
+				log.Printf("Failed to claim job %s: %v", id, err)
+			}
+			continue
+		}
+
+		r.running.Add(1)
+		go func() {
+			defer r.running.Done()
+			defer func() { <-r.slots }()
+			r.execute(ctx, job)
+		}()
+	}
+}
+
+// execute runs a claimed job and records how it finished
+func (r *Runner) execute(ctx context.Context, job *models.Job) {
+	var err error
+	if executor, ok := r.executors[job.Type]; ok {
+		err = executor(ctx, &Task{Job: job, runner: r, ctx: ctx})
+	} else {
+		err = fmt.Errorf("unknown job type %q", job.Type)
+	}
+
+	if errors.Is(err, db.ErrJobLeaseLost) {
+		log.Printf("Job %s was taken over by another worker", job.ID)
+		return
+	}
+	if ctx.Err() != nil {
+		log.Printf("Job %s interrupted by shutdown, it will be resumed", job.ID)
+		return
+	}
+
+	job.Status = models.JobSucceeded
+	if err != nil {
+		job.Status = models.JobFailed
+		job.Error = err.Error()
+	}
+	if err := db.FinishJob(ctx, r.client, r.jobsTable, job, r.owner); err != nil {
+		log.Printf("Failed to finish job %s: %v", job.ID, err)
+	}
+}
+
+// Task is a claimed job handed to an executor. Its reads and writes stop
+// when the runner shuts down.
+type Task struct {
+	Job    *models.Job
+	runner *Runner
+	ctx    context.Context
+}
+
+// Save stores the job's progress and checkpoint and extends the lease.
+// Executors should save at least once per lease duration.
+func (t *Task) Save() error {
+	return db.SaveJobProgress(t.ctx, t.runner.client, t.runner.jobsTable, t.Job, t.runner.owner, time.Now().Add(leaseDuration))
+}
+
+// WriteResult stores the next chunk of the job's result. The chunk only
+// counts once the job is saved, so a chunk written after the last checkpoint
+// is overwritten when the job resumes.
+func (t *Task) WriteResult(data []byte) error {
+	if err := db.PutJobChunk(t.ctx, t.runner.client, t.runner.dataTable, t.Job, db.JobResult, t.Job.ResultChunks, data); err != nil {
+		return err
+	}
+	t.Job.ResultChunks++
+	return nil
+}
+
+// Input returns a reader over the data uploaded with the job
+func (t *Task) Input() io.Reader {
+	return db.NewJobChunkReader(t.ctx, t.runner.client, t.runner.dataTable, t.Job.ID, db.JobInput, t.Job.InputChunks)
+}
@@ -0,0 +1,147 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeJobsTable serves a jobs table holding one claimable job of the given
+// type and records the updates made to it
+type fakeJobsTable struct {
+	mu      sync.Mutex
+	jobType string
+	updates []map[string]interface{}
+}
+
+func (f *fakeJobsTable) client(t *testing.T) *db.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		var response interface{} = map[string]interface{}{}
+		switch {
+		case operation == "Scan":
+			response = map[string]interface{}{"Items": []interface{}{map[string]interface{}{"id": map[string]string{"S": "job-1"}}}}
+		case operation == "UpdateItem" && body["ReturnValues"] == "ALL_NEW":
+			response = map[string]interface{}{"Attributes": map[string]interface{}{
+				"id":     map[string]string{"S": "job-1"},
+				"type":   map[string]string{"S": f.jobType},
+				"status": map[string]string{"S": models.JobRunning},
+			}}
+		case operation == "UpdateItem":
+			f.mu.Lock()
+			f.updates = append(f.updates, body)
+			f.mu.Unlock()
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// finalStatus returns the status set by the last update of the job
+func (f *fakeJobsTable) finalStatus(t *testing.T) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	require.NotEmpty(t, f.updates)
+	last := f.updates[len(f.updates)-1]
+	for _, value := range last["ExpressionAttributeValues"].(map[string]interface{}) {
+		if s, ok := value.(map[string]interface{})["S"].(string); ok && (s == models.JobSucceeded || s == models.JobFailed) {
+			return s
+		}
+	}
+	return ""
+}
+
+func newTestRunner(t *testing.T, table *fakeJobsTable) *Runner {
+	return NewRunner(table.client(t), &config.Config{JobsTableName: "CustomerJobs", JobDataTableName: "CustomerJobData", JobWorkers: 1})
+}
+
+func TestRunner_RunsJob(t *testing.T) {
+	table := &fakeJobsTable{jobType: models.JobExport}
+	runner := newTestRunner(t, table)
+	var ran []string
+	runner.Register(models.JobExport, func(ctx context.Context, task *Task) error {
+		ran = append(ran, task.Job.ID)
+		task.Job.Processed = 3
+		return task.Save()
+	})
+
+	runner.claimJobs(context.Background())
+	runner.running.Wait()
+
+	assert.Equal(t, []string{"job-1"}, ran)
+	assert.Len(t, table.updates, 2, "progress is saved and the job finished")
+	assert.Equal(t, models.JobSucceeded, table.finalStatus(t))
+}
+
+func TestRunner_FailsJob(t *testing.T) {
+	table := &fakeJobsTable{jobType: models.JobImport}
+	runner := newTestRunner(t, table)
+	runner.Register(models.JobImport, func(ctx context.Context, task *Task) error {
+		return errors.New("bad input")
+	})
+
+	runner.claimJobs(context.Background())
+	runner.running.Wait()
+
+	assert.Equal(t, models.JobFailed, table.finalStatus(t))
+}
+
+func TestRunner_UnknownJobType(t *testing.T) {
+	table := &fakeJobsTable{jobType: "unknown"}
+	runner := newTestRunner(t, table)
+
+	runner.claimJobs(context.Background())
+	runner.running.Wait()
+
+	assert.Equal(t, models.JobFailed, table.finalStatus(t))
+}
+
+func TestRunner_InterruptedJobIsNotFinished(t *testing.T) {
+	table := &fakeJobsTable{jobType: models.JobExport}
+	runner := newTestRunner(t, table)
+	ctx, cancel := context.WithCancel(context.Background())
+	runner.Register(models.JobExport, func(ctx context.Context, task *Task) error {
+		cancel()
+		return ctx.Err()
+	})
+
+	runner.claimJobs(ctx)
+	runner.running.Wait()
+
+	assert.Empty(t, table.updates, "the job keeps its lease and checkpoint to be resumed")
+}
+
+func TestRunner_Notify(t *testing.T) {
+	runner := NewRunner(nil, &config.Config{})
+
+	// Notifications coalesce while the runner is busy
+	runner.Notify()
+	runner.Notify()
+
+	assert.Len(t, runner.wake, 1)
+}
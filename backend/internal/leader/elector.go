@@ -0,0 +1,107 @@
+// Package leader runs work in one process at a time among the processes
+// sharing the settings table
+package leader
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/google/uuid"
+)
+
+var (
+	// leaseDuration is how long a leader keeps its lease without renewing
+	// it. Another process takes over once it expires.
+	leaseDuration = 30 * time.Second
+	// renewInterval is how often the leader renews its lease and the other
+	// processes try to take it
+	renewInterval = 10 * time.Second
+)
+
+// Elector runs work only while its process holds a named lease in the
+// settings table
+type Elector struct {
+	client        *db.Client
+	settingsTable string
+	name          string
+	owner         string
+}
+
+// NewElector creates an elector for the named lease
+func NewElector(client *db.Client, settingsTable, name string) *Elector {
+	return &Elector{
+		client:        client,
+		settingsTable: settingsTable,
+		name:          name,
+		owner:         uuid.New().String(),
+	}
+}
+
+// Run calls work each time this process takes the lease, with a context
+// cancelled once it loses it, until ctx is cancelled. Work must return when
+// its context is cancelled. The lease is released on the way out so another
+// process takes over without waiting for it to expire.
+func (e *Elector) Run(ctx context.Context, work func(ctx context.Context)) {
+	ticker := time.NewTicker(renewInterval)
+	defer ticker.Stop()
+
+	var (
+		stopWork  context.CancelFunc
+		workDone  chan struct{}
+		heldUntil time.Time
+	)
+	stop := func() {
+		if stopWork != nil {
+			stopWork()
+			<-workDone
+			stopWork = nil
+		}
+	}
+	defer func() {
+		if stopWork == nil {
+			return
+		}
+		stop()
+		if err := db.ReleaseLease(context.WithoutCancel(ctx), e.client, e.settingsTable, e.name, e.owner); err != nil {
+			log.Printf("Failed to release the %s lease: %v", e.name, err)
+		}
+	}()
+
+	for {
+		now := time.Now()
+		err := db.AcquireLease(ctx, e.client, e.settingsTable, e.name, e.owner, now, now.Add(leaseDuration))
+		switch {
+		case err == nil:
+			heldUntil = now.Add(leaseDuration)
+			if stopWork == nil {
+				log.Printf("Took the %s lease", e.name)
+				workCtx, cancel := context.WithCancel(ctx)
+				stopWork, workDone = cancel, make(chan struct{})
+				go func() {
+					defer close(workDone)
+					work(workCtx)
+				}()
+			}
+		case errors.Is(err, db.ErrLeaseHeld):
+			if stopWork != nil {
+				log.Printf("The %s lease was taken over by another process", e.name)
+				stop()
+			}
+		case ctx.Err() == nil:
+			log.Printf("Failed to renew the %s lease: %v", e.name, err)
+			// Stop before the lease can expire and be taken over
+			if stopWork != nil && time.Now().Add(renewInterval).After(heldUntil) {
+				stop()
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package leader
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeLeaseTable serves a lease item of a settings table, applying the
+// acquire and release conditions
+type fakeLeaseTable struct {
+	mu        sync.Mutex
+	owner     string
+	expiresAt int64
+}
+
+func (f *fakeLeaseTable) client(t *testing.T) *db.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			ExpressionAttributeValues map[string]map[string]string
+		}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+		owner := body.ExpressionAttributeValues[":owner"]["S"]
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		granted := true
+		switch operation {
+		case "UpdateItem":
+			now, _ := strconv.ParseInt(body.ExpressionAttributeValues[":now"]["N"], 10, 64)
+			if granted = f.owner == "" || f.expiresAt < now || f.owner == owner; granted {
+				f.owner = owner
+				f.expiresAt, _ = strconv.ParseInt(body.ExpressionAttributeValues[":until"]["N"], 10, 64)
+			}
+		case "DeleteItem":
+			if granted = f.owner == owner; granted {
+				f.owner = ""
+			}
+		}
+		if !granted {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{})
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// fastLeases shortens the lease for the duration of a test
+func fastLeases(t *testing.T) {
+	duration, interval := leaseDuration, renewInterval
+	leaseDuration, renewInterval = 150*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(func() { leaseDuration, renewInterval = duration, interval })
+}
+
+// leading counts the electors running their work at once
+type leading struct {
+	mu      sync.Mutex
+	current int
+	most    int
+	started map[string]int
+}
+
+func (l *leading) work(name string) func(ctx context.Context) {
+	return func(ctx context.Context) {
+		l.mu.Lock()
+		l.current++
+		l.most = max(l.most, l.current)
+		l.started[name]++
+		l.mu.Unlock()
+
+		<-ctx.Done()
+
+		l.mu.Lock()
+		l.current--
+		l.mu.Unlock()
+	}
+}
+
+func (l *leading) count(name string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.started[name]
+}
+
+func TestElector_OneLeaderTakesOverFromAnother(t *testing.T) {
+	fastLeases(t)
+	table := &fakeLeaseTable{}
+	client := table.client(t)
+	l := &leading{started: map[string]int{}}
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	firstDone := make(chan struct{})
+	go func() {
+		defer close(firstDone)
+		NewElector(client, "Settings", "events").Run(firstCtx, l.work("first"))
+	}()
+	require.Eventually(t, func() bool { return l.count("first") == 1 }, time.Second, time.Millisecond)
+
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	secondDone := make(chan struct{})
+	go func() {
+		defer close(secondDone)
+		NewElector(client, "Settings", "events").Run(secondCtx, l.work("second"))
+	}()
+	time.Sleep(5 * renewInterval)
+	assert.Equal(t, 0, l.count("second"), "the lease is held")
+
+	// Stopping the leader releases the lease to the other elector
+	stopFirst()
+	<-firstDone
+	require.Eventually(t, func() bool { return l.count("second") == 1 }, time.Second, time.Millisecond)
+
+	stopSecond()
+	<-secondDone
+	assert.Equal(t, 1, l.most, "work never ran in two electors at once")
+	assert.Empty(t, table.owner)
+}
+
+func TestElector_StopsWhenLeaseTakenOver(t *testing.T) {
+	fastLeases(t)
+	table := &fakeLeaseTable{}
+	client := table.client(t)
+	l := &leading{started: map[string]int{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go NewElector(client, "Settings", "events").Run(ctx, l.work("first"))
+	require.Eventually(t, func() bool { return l.count("first") == 1 }, time.Second, time.Millisecond)
+
+	// Another process takes the lease, as if this one had stalled past its expiry
+	table.mu.Lock()
+	table.owner, table.expiresAt = "other", time.Now().Add(time.Hour).UnixMilli()
+	table.mu.Unlock()
+
+	require.Eventually(t, func() bool {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		return l.current == 0
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,78 @@
+package migrations
+
+import (
+	"context"
+	"log"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+)
+
+// Customers returns the migrations bringing a customers table, and the tables
+// created with it, from an earlier version up to date. Each checks its own
+// table for what it adds, so tables created by this version are baselined.
+func Customers(client *db.Client, cfg *config.Config) []Migration {
+	return []Migration{
+		{
+			Version: 1,
+			Name:    "enable customers stream",
+			Up: func(ctx context.Context, progress *Progress) error {
+				return db.EnableCustomerStream(ctx, client, cfg.TableName)
+			},
+			Applied: func(ctx context.Context) (bool, error) {
+				return db.HasCustomerStream(ctx, client, cfg.TableName)
+			},
+		},
+		{
+			Version: 2,
+			Name:    "add email index",
+			Up: func(ctx context.Context, progress *Progress) error {
+				return db.AddEmailIndex(ctx, client, cfg.TableName)
+			},
+			Applied: func(ctx context.Context) (bool, error) {
+				return db.HasEmailIndex(ctx, client, cfg.TableName)
+			},
+		},
+		{
+			Version: 3,
+			Name:    "backfill email index",
+			Up: func(ctx context.Context, progress *Progress) error {
+				count, err := db.BackfillEmailIndex(ctx, client, cfg.TableName, progress.Checkpoint(), progress.BatchSize(), progress.Save)
+				if err != nil {
+					return err
+				}
+				log.Printf("Indexed the email of %d customers", count)
+				return nil
+			},
+			// Customers written by this version are indexed as they are written
+			Applied: func(ctx context.Context) (bool, error) {
+				return db.IsTableEmpty(ctx, client, cfg.TableName)
+			},
+		},
+		{
+			Version: 4,
+			Name:    "backfill customer creation time",
+			Up: func(ctx context.Context, progress *Progress) error {
+				count, err := db.BackfillCreatedAt(ctx, client, cfg.TableName, progress.Checkpoint(), progress.BatchSize(), progress.Save)
+				if err != nil {
+					return err
+				}
+				log.Printf("Set the creation time of %d customers", count)
+				return nil
+			},
+			Applied: func(ctx context.Context) (bool, error) {
+				return db.IsTableEmpty(ctx, client, cfg.TableName)
+			},
+		},
+		{
+			Version: 5,
+			Name:    "add webhook delivery due index",
+			Up: func(ctx context.Context, progress *Progress) error {
+				return db.AddWebhookDeliveryDueIndex(ctx, client, cfg.WebhookDeliveriesTableName)
+			},
+			Applied: func(ctx context.Context) (bool, error) {
+				return db.HasWebhookDeliveryDueIndex(ctx, client, cfg.WebhookDeliveriesTableName)
+			},
+		},
+	}
+}
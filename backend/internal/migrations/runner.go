@@ -0,0 +1,285 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/google/uuid"
+)
+
+// leaseDuration is how long the migration lease stays with a process without
+// being extended. Another process only takes over once it expires.
+const leaseDuration = time.Minute
+
+// lockedPollInterval is how often a process waiting for another one's
+// migrations checks whether they are done
+var lockedPollInterval = 5 * time.Second
+
+// Migration is a versioned change to the tables. Migrations are applied once,
+// in version order. Up must be safe to run again: a migration interrupted
+// before it was recorded is run again, from its last checkpoint.
+type Migration struct {
+	Version int
+	Name    string
+	Up      func(ctx context.Context, progress *Progress) error
+	// Applied reports whether the tables already have what Up adds, for
+	// example because they were just created, so Baseline can record the
+	// migration without running it. Nil means they never do.
+	Applied func(ctx context.Context) (bool, error)
+}
+
+// Runner applies the registered migrations that weren't applied yet,
+// recording them in the settings table. A lease on the record keeps
+// processes starting together from migrating at once.
+type Runner struct {
+	client        *db.Client
+	settingsTable string
+	batchSize     int
+	owner         string
+	migrations    []Migration
+}
+
+// NewRunner creates a runner recording migrations in the settings table of cfg
+func NewRunner(client *db.Client, cfg *config.Config) *Runner {
+	return &Runner{
+		client:        client,
+		settingsTable: cfg.SettingsTableName,
+		batchSize:     max(cfg.MigrationBatchSize, 1),
+		owner:         uuid.New().String(),
+	}
+}
+
+// Register adds migrations. Migrations must be registered before Run.
+func (r *Runner) Register(migrations ...Migration) {
+	r.migrations = append(r.migrations, migrations...)
+}
+
+// Status returns the recorded migration state and the migrations pending
+func (r *Runner) Status(ctx context.Context) (*models.MigrationState, []Migration, error) {
+	state, err := db.GetMigrationState(ctx, r.client, r.settingsTable)
+	if err != nil {
+		return nil, nil, err
+	}
+	pending, err := r.pending(state)
+	if err != nil {
+		return nil, nil, err
+	}
+	return state, pending, nil
+}
+
+// Run applies the pending migrations in version order, waiting while another
+// process applies them. It stops at the first migration that fails, which is
+// resumed from its checkpoint by the next run.
+func (r *Runner) Run(ctx context.Context) error {
+	_, pending, err := r.Status(ctx)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	state, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	// The lease is extended in the background while migrations run, and
+	// they are stopped if it is lost
+	migrateCtx, stopMigrating := context.WithCancel(ctx)
+	leaseCtx, stopExtending := context.WithCancel(ctx)
+	var extending sync.WaitGroup
+	extending.Add(1)
+	go func() {
+		defer extending.Done()
+		r.extendLease(leaseCtx, stopMigrating)
+	}()
+	defer func() {
+		stopExtending()
+		extending.Wait()
+		stopMigrating()
+		if err := db.ReleaseMigrationLease(context.WithoutCancel(ctx), r.client, r.settingsTable, r.owner); err != nil && !errors.Is(err, db.ErrMigrationLeaseLost) {
+			log.Printf("Failed to release the migration lease: %v", err)
+		}
+	}()
+
+	// Another process may have applied some while this one waited
+	if pending, err = r.pending(state); err != nil {
+		return err
+	}
+	for _, migration := range pending {
+		err := r.apply(migrateCtx, state, migration)
+		if err != nil && migrateCtx.Err() != nil && ctx.Err() == nil {
+			err = db.ErrMigrationLeaseLost
+		}
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// Baseline records the pending migrations whose tables already have what they
+// add as applied without running them. Versions are recorded in order, so it
+// stops at the first migration still needed, which is left to Run. It waits
+// while another process applies them.
+func (r *Runner) Baseline(ctx context.Context) error {
+	_, pending, err := r.Status(ctx)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	state, err := r.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := db.ReleaseMigrationLease(context.WithoutCancel(ctx), r.client, r.settingsTable, r.owner); err != nil && !errors.Is(err, db.ErrMigrationLeaseLost) {
+			log.Printf("Failed to release the migration lease: %v", err)
+		}
+	}()
+
+	if pending, err = r.pending(state); err != nil || len(pending) == 0 {
+		return err
+	}
+	baselined := 0
+	for _, migration := range pending {
+		if migration.Applied == nil {
+			break
+		}
+		applied, err := migration.Applied(ctx)
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+		}
+		if !applied {
+			break
+		}
+		record(state, migration)
+		baselined++
+	}
+	if baselined == 0 {
+		return nil
+	}
+	log.Printf("Recorded %d schema migrations as already applied to the tables", baselined)
+	return db.SaveMigrationState(ctx, r.client, r.settingsTable, state, r.owner, time.Now().Add(leaseDuration))
+}
+
+// pending returns the registered migrations after the state's version, in
+// version order
+func (r *Runner) pending(state *models.MigrationState) ([]Migration, error) {
+	migrations := append([]Migration(nil), r.migrations...)
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	var pending []Migration
+	for i, migration := range migrations {
+		if migration.Version <= 0 {
+			return nil, fmt.Errorf("migration %q has version %d, versions start at 1", migration.Name, migration.Version)
+		}
+		if i > 0 && migrations[i-1].Version == migration.Version {
+			return nil, fmt.Errorf("migrations %q and %q share version %d", migrations[i-1].Name, migration.Name, migration.Version)
+		}
+		if migration.Version > state.Version {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// acquire takes the migration lease, waiting for another process holding it
+// to finish
+func (r *Runner) acquire(ctx context.Context) (*models.MigrationState, error) {
+	for {
+		now := time.Now()
+		state, err := db.AcquireMigrationLease(ctx, r.client, r.settingsTable, r.owner, now, now.Add(leaseDuration))
+		if !errors.Is(err, db.ErrMigrationLocked) {
+			return state, err
+		}
+
+		log.Printf("Waiting for schema migrations being applied by another process")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(lockedPollInterval):
+		}
+	}
+}
+
+// extendLease keeps the lease until ctx is cancelled, calling lost when it
+// can no longer be extended
+func (r *Runner) extendLease(ctx context.Context, lost func()) {
+	ticker := time.NewTicker(leaseDuration / 3)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := db.ExtendMigrationLease(ctx, r.client, r.settingsTable, r.owner, time.Now().Add(leaseDuration))
+		if errors.Is(err, db.ErrMigrationLeaseLost) {
+			log.Printf("The migration lease was taken over by another process")
+			lost()
+			return
+		}
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to extend the migration lease: %v", err)
+		}
+	}
+}
+
+// apply runs a migration and records it as applied
+func (r *Runner) apply(ctx context.Context, state *models.MigrationState, migration Migration) error {
+	if state.Checkpoint != "" {
+		log.Printf("Resuming migration %d (%s)", migration.Version, migration.Name)
+	} else {
+		log.Printf("Applying migration %d (%s)", migration.Version, migration.Name)
+	}
+	if err := migration.Up(ctx, &Progress{runner: r, state: state, ctx: ctx}); err != nil {
+		return err
+	}
+
+	record(state, migration)
+	return db.SaveMigrationState(ctx, r.client, r.settingsTable, state, r.owner, time.Now().Add(leaseDuration))
+}
+
+// record adds a migration to the state's applied migrations
+func record(state *models.MigrationState, migration Migration) {
+	state.Version = migration.Version
+	state.Applied = append(state.Applied, models.AppliedMigration{
+		Version:   migration.Version,
+		Name:      migration.Name,
+		AppliedAt: time.Now().UTC().Format(time.RFC3339),
+	})
+	state.Checkpoint = ""
+}
+
+// Progress is handed to a running migration to record how far it got
+type Progress struct {
+	runner *Runner
+	state  *models.MigrationState
+	ctx    context.Context
+}
+
+// Checkpoint returns the checkpoint saved by an interrupted run of the
+// migration, "" when it starts from the beginning
+func (p *Progress) Checkpoint() string {
+	return p.state.Checkpoint
+}
+
+// Save records the migration's checkpoint, which a later run resumes from
+func (p *Progress) Save(checkpoint string) error {
+	p.state.Checkpoint = checkpoint
+	return db.SaveMigrationState(p.ctx, p.runner.client, p.runner.settingsTable, p.state, p.runner.owner, time.Now().Add(leaseDuration))
+}
+
+// BatchSize is the number of items a migration should rewrite between checkpoints
+func (p *Progress) BatchSize() int {
+	return p.runner.batchSize
+}
@@ -0,0 +1,263 @@
+package migrations
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSettingsTable serves the migration state item of a settings table and
+// records the updates made to it. The lease is refused the first locked times.
+type fakeSettingsTable struct {
+	mu      sync.Mutex
+	state   map[string]interface{}
+	locked  int
+	updates []string
+	// tables are the descriptions of the other tables, which are empty
+	tables map[string]interface{}
+}
+
+func (f *fakeSettingsTable) client(t *testing.T) *db.Client {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var response interface{} = map[string]interface{}{}
+		switch {
+		case operation == "GetItem" && f.state != nil:
+			response = map[string]interface{}{"Item": f.state}
+		case operation == "UpdateItem" && body["ReturnValues"] == "ALL_NEW" && f.locked > 0:
+			f.locked--
+			w.WriteHeader(http.StatusBadRequest)
+			response = map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException", "message": "leased"}
+		case operation == "UpdateItem" && body["ReturnValues"] == "ALL_NEW":
+			response = map[string]interface{}{"Attributes": f.state}
+		case operation == "UpdateItem":
+			f.updates = append(f.updates, body["UpdateExpression"].(string))
+		case operation == "DescribeTable":
+			response = map[string]interface{}{"Table": f.tables[body["TableName"].(string)]}
+		case operation == "Scan":
+			response = map[string]interface{}{"Items": []interface{}{}, "Count": 0}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// stateAt returns a migration state item at a version, with a checkpoint
+func stateAt(version string, checkpoint string) map[string]interface{} {
+	state := map[string]interface{}{
+		"key":     map[string]string{"S": "schema-migrations"},
+		"version": map[string]string{"N": version},
+	}
+	if checkpoint != "" {
+		state["checkpoint"] = map[string]string{"S": checkpoint}
+	}
+	return state
+}
+
+func newTestRunner(t *testing.T, table *fakeSettingsTable) *Runner {
+	return NewRunner(table.client(t), &config.Config{SettingsTableName: "CustomerSettings", MigrationBatchSize: 10})
+}
+
+// recording returns a migration appending its version to ran
+func recording(version int, ran *[]int) Migration {
+	return Migration{Version: version, Name: "test", Up: func(ctx context.Context, progress *Progress) error {
+		*ran = append(*ran, version)
+		return nil
+	}}
+}
+
+func TestRunner_AppliesPendingInOrder(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("1", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(3, &ran), recording(1, &ran), recording(2, &ran))
+
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Equal(t, []int{2, 3}, ran)
+	require.Len(t, table.updates, 3, "each migration is recorded, then the lease released")
+	assert.True(t, strings.HasPrefix(table.updates[2], "REMOVE"))
+}
+
+func TestRunner_NothingPending(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("2", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(1, &ran), recording(2, &ran))
+
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Empty(t, ran)
+	assert.Empty(t, table.updates, "the lease isn't taken")
+}
+
+// applied marks a migration as already applied to the tables, or not
+func applied(migration Migration, ok bool) Migration {
+	migration.Applied = func(ctx context.Context) (bool, error) { return ok, nil }
+	return migration
+}
+
+func TestRunner_Baseline(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("1", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(applied(recording(1, &ran), true), applied(recording(2, &ran), true), applied(recording(3, &ran), true))
+
+	require.NoError(t, runner.Baseline(context.Background()))
+
+	assert.Empty(t, ran, "the migrations aren't run")
+	require.Len(t, table.updates, 2, "the migrations are recorded at once, then the lease released")
+	assert.True(t, strings.HasPrefix(table.updates[1], "REMOVE"))
+}
+
+func TestRunner_BaselineStopsAtMigrationStillNeeded(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("0", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(applied(recording(1, &ran), true), applied(recording(2, &ran), false), applied(recording(3, &ran), true))
+
+	require.NoError(t, runner.Baseline(context.Background()))
+	require.Len(t, table.updates, 2)
+	table.state = stateAt("1", "")
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Equal(t, []int{2, 3}, ran, "a migration after one still needed runs too")
+}
+
+func TestRunner_BaselineWithoutAppliedCheck(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("0", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(1, &ran))
+
+	require.NoError(t, runner.Baseline(context.Background()))
+
+	assert.Empty(t, ran)
+	require.Len(t, table.updates, 1, "nothing is recorded before the lease is released")
+	assert.True(t, strings.HasPrefix(table.updates[0], "REMOVE"))
+}
+
+func TestRunner_ResumesFromCheckpoint(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("0", "c1")}
+	runner := newTestRunner(t, table)
+	var checkpoints []string
+	runner.Register(Migration{Version: 1, Name: "rewrite", Up: func(ctx context.Context, progress *Progress) error {
+		checkpoints = append(checkpoints, progress.Checkpoint())
+		assert.Equal(t, 10, progress.BatchSize())
+		return progress.Save("c2")
+	}}, Migration{Version: 2, Name: "next", Up: func(ctx context.Context, progress *Progress) error {
+		checkpoints = append(checkpoints, progress.Checkpoint())
+		return nil
+	}})
+
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Equal(t, []string{"c1", ""}, checkpoints, "a migration's checkpoint doesn't carry over to the next")
+}
+
+func TestRunner_StopsAtFailure(t *testing.T) {
+	table := &fakeSettingsTable{state: stateAt("0", "")}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(1, &ran), Migration{Version: 2, Name: "broken", Up: func(ctx context.Context, progress *Progress) error {
+		return errors.New("boom")
+	}}, recording(3, &ran))
+
+	err := runner.Run(context.Background())
+
+	assert.ErrorContains(t, err, "migration 2 (broken): boom")
+	assert.Equal(t, []int{1}, ran)
+	require.Len(t, table.updates, 2, "the applied migration is recorded and the lease released")
+	assert.True(t, strings.HasPrefix(table.updates[1], "REMOVE"))
+}
+
+func TestRunner_WaitsForLease(t *testing.T) {
+	interval := lockedPollInterval
+	lockedPollInterval = time.Millisecond
+	t.Cleanup(func() { lockedPollInterval = interval })
+
+	table := &fakeSettingsTable{state: stateAt("0", ""), locked: 2}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(1, &ran))
+
+	require.NoError(t, runner.Run(context.Background()))
+
+	assert.Equal(t, []int{1}, ran)
+	assert.Zero(t, table.locked)
+}
+
+func TestRunner_RejectsDuplicateVersions(t *testing.T) {
+	table := &fakeSettingsTable{}
+	runner := newTestRunner(t, table)
+	var ran []int
+	runner.Register(recording(1, &ran), recording(1, &ran))
+
+	assert.ErrorContains(t, runner.Run(context.Background()), "share version 1")
+	assert.Empty(t, ran)
+}
+
+func TestCustomers_Versions(t *testing.T) {
+	runner := newTestRunner(t, &fakeSettingsTable{})
+	runner.Register(Customers(nil, &config.Config{})...)
+
+	pending, err := runner.pending(&models.MigrationState{})
+
+	require.NoError(t, err)
+	assert.Len(t, pending, 5)
+}
+
+func TestCustomers_BaselineChecksEachTable(t *testing.T) {
+	active := func(indexName string) map[string]interface{} {
+		return map[string]interface{}{"IndexName": indexName, "IndexStatus": "ACTIVE"}
+	}
+	table := &fakeSettingsTable{
+		tables: map[string]interface{}{
+			"Customers": map[string]interface{}{
+				"StreamSpecification":    map[string]interface{}{"StreamEnabled": true, "StreamViewType": "NEW_AND_OLD_IMAGES"},
+				"GlobalSecondaryIndexes": []interface{}{active("emailIndex-index")},
+			},
+			// An earlier version created the deliveries table without the due index
+			"Deliveries": map[string]interface{}{},
+		},
+	}
+	cfg := &config.Config{TableName: "Customers", WebhookDeliveriesTableName: "Deliveries"}
+
+	var results []bool
+	for _, migration := range Customers(table.client(t), cfg) {
+		require.NotNil(t, migration.Applied, "migration %d", migration.Version)
+		ok, err := migration.Applied(context.Background())
+		require.NoError(t, err)
+		results = append(results, ok)
+	}
+
+	assert.Equal(t, []bool{true, true, true, true, false}, results, "the due index is still added to the existing deliveries table")
+}
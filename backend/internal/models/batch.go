@@ -0,0 +1,36 @@
+package models
+
+import "encoding/json"
+
+// Batch operation types
+const (
+	BatchCreate = "create"
+	BatchUpdate = "update"
+	BatchDelete = "delete"
+)
+
+// BatchRequest is the body of a bulk customer write
+type BatchRequest struct {
+	Operations []BatchOperation `json:"operations" binding:"required"`
+}
+
+// BatchOperation creates, updates or deletes a single customer. Creates may
+// omit the ID, updates and deletes must give it. Customer is validated on its
+// own so one invalid entry doesn't reject the whole batch.
+type BatchOperation struct {
+	Op       string          `json:"op"`
+	ID       string          `json:"id,omitempty"`
+	Customer json.RawMessage `json:"customer,omitempty"`
+}
+
+// BatchResult reports the outcome of the operation at Index in a batch.
+// Status is the HTTP status the operation would have had on its own.
+type BatchResult struct {
+	Index    int       `json:"index"`
+	Op       string    `json:"op"`
+	ID       string    `json:"id,omitempty"`
+	Status   int       `json:"status"`
+	Error    string    `json:"error,omitempty"`
+	Details  string    `json:"details,omitempty"`
+	Customer *Customer `json:"customer,omitempty"`
+}
@@ -0,0 +1,148 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// csvMetadataPrefix marks a CSV column holding a metadata value, as in "metadata.loyaltyTier"
+const csvMetadataPrefix = "metadata."
+
+// CSVHeader maps the columns of a customer CSV file to customer fields
+type CSVHeader struct {
+	// fields holds the customer field of each column, "" for blank columns
+	fields []string
+}
+
+// ParseCSVHeader maps a CSV header row to customer fields. Column names match
+// the JSON field names name, email, telephone and status, ignoring case, or
+// metadata.<key> for a metadata value. mapping renames columns first, so a
+// "Full Name" column can be imported with mapping["Full Name"] = "name".
+func ParseCSVHeader(header []string, mapping map[string]string) (CSVHeader, error) {
+	fields := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, column := range header {
+		column = strings.TrimSpace(strings.TrimPrefix(column, "\ufeff"))
+		if mapped, ok := mapping[column]; ok {
+			column = mapped
+		}
+		if column == "" {
+			continue
+		}
+
+		field, err := csvField(column)
+		if err != nil {
+			return CSVHeader{}, err
+		}
+		if seen[field] {
+			return CSVHeader{}, fmt.Errorf("column %q appears more than once", column)
+		}
+		seen[field] = true
+		fields[i] = field
+	}
+
+	if !seen["name"] || !seen["email"] {
+		return CSVHeader{}, fmt.Errorf("header must include name and email columns")
+	}
+	return CSVHeader{fields: fields}, nil
+}
+
+// csvField normalizes a column name to the customer field it holds
+func csvField(column string) (string, error) {
+	if len(column) > len(csvMetadataPrefix) && strings.EqualFold(column[:len(csvMetadataPrefix)], csvMetadataPrefix) {
+		key := column[len(csvMetadataPrefix):]
+		if err := ValidateMetadataKey(key); err != nil {
+			return "", err
+		}
+		return csvMetadataPrefix + key, nil
+	}
+
+	switch field := strings.ToLower(column); field {
+	case "name", "email", "telephone", "status":
+		return field, nil
+	}
+	return "", fmt.Errorf("unknown column %q: use name, email, telephone, status or metadata.<key>", column)
+}
+
+// Customer builds a customer from a CSV record. Values are trimmed, and empty
+// metadata values are left out.
+func (h CSVHeader) Customer(record []string) Customer {
+	var customer Customer
+	for i, value := range record {
+		if i >= len(h.fields) || h.fields[i] == "" {
+			continue
+		}
+		value = strings.TrimSpace(value)
+
+		switch field := h.fields[i]; field {
+		case "name":
+			customer.Name = value
+		case "email":
+			customer.Email = value
+		case "telephone":
+			customer.Telephone = value
+		case "status":
+			customer.Status = value
+		default:
+			if value == "" {
+				continue
+			}
+			if customer.Metadata == nil {
+				customer.Metadata = map[string]interface{}{}
+			}
+			customer.Metadata[strings.TrimPrefix(field, csvMetadataPrefix)] = value
+		}
+	}
+	return customer
+}
+
+// CSVExportColumns is the fixed column order of customer CSV exports
+var CSVExportColumns = []string{"id", "name", "email", "telephone", "status", "tags", "metadata", "createdAt", "erasedAt"}
+
+// CSVRecord returns the customer's values in CSVExportColumns order. Tags are
+// sorted and joined with ';', and metadata is encoded as a JSON object.
+func (c Customer) CSVRecord() ([]string, error) {
+	tags := append([]string{}, c.Tags...)
+	sort.Strings(tags)
+
+	var metadata string
+	if len(c.Metadata) > 0 {
+		encoded, err := json.Marshal(c.Metadata)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode metadata: %v", err)
+		}
+		metadata = string(encoded)
+	}
+
+	return []string{
+		c.ID,
+		c.Name,
+		c.Email,
+		c.Telephone,
+		c.CurrentStatus(),
+		strings.Join(tags, ";"),
+		metadata,
+		c.CreatedAt,
+		c.ErasedAt,
+	}, nil
+}
+
+// ImportRowError explains why a row of an import was rejected. Rows are
+// numbered from 1, the header being row 1.
+type ImportRowError struct {
+	Row   int    `json:"row"`
+	Email string `json:"email,omitempty"`
+	Error string `json:"error"`
+}
+
+// ImportReport summarizes a CSV import. In a dry run Imported counts the rows
+// that would have been imported.
+type ImportReport struct {
+	DryRun   bool             `json:"dryRun"`
+	Rows     int              `json:"rows"`
+	Imported int              `json:"imported"`
+	Failed   int              `json:"failed"`
+	Errors   []ImportRowError `json:"errors"`
+}
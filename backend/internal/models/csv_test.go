@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCSVHeader(t *testing.T) {
+	header, err := ParseCSVHeader([]string{"\ufeffName", " EMAIL ", "Phone", "", "metadata.loyaltyTier"}, map[string]string{"Phone": "telephone"})
+	require.NoError(t, err)
+
+	customer := header.Customer([]string{" John Doe ", "john@example.com", "555-0123", "ignored", "gold"})
+	assert.Equal(t, Customer{
+		Name:      "John Doe",
+		Email:     "john@example.com",
+		Telephone: "555-0123",
+		Metadata:  map[string]interface{}{"loyaltyTier": "gold"},
+	}, customer)
+}
+
+func TestParseCSVHeader_Invalid(t *testing.T) {
+	tests := []struct {
+		name      string
+		header    []string
+		expectErr string
+	}{
+		{name: "unknown column", header: []string{"name", "email", "age"}, expectErr: `unknown column "age"`},
+		{name: "duplicate column", header: []string{"name", "email", "Email"}, expectErr: "more than once"},
+		{name: "missing email", header: []string{"name", "telephone"}, expectErr: "name and email"},
+		{name: "invalid metadata key", header: []string{"name", "email", "metadata.a b"}, expectErr: "metadata key"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseCSVHeader(tt.header, nil)
+			assert.ErrorContains(t, err, tt.expectErr)
+		})
+	}
+}
+
+func TestCSVHeader_CustomerSkipsEmptyMetadata(t *testing.T) {
+	header, err := ParseCSVHeader([]string{"name", "email", "metadata.tier"}, nil)
+	require.NoError(t, err)
+
+	assert.Nil(t, header.Customer([]string{"John", "john@example.com", " "}).Metadata)
+}
+
+func TestCustomer_CSVRecord(t *testing.T) {
+	customer := Customer{
+		ID:        "1",
+		Name:      "John Doe",
+		Email:     "john@example.com",
+		Tags:      []string{"vip", "beta"},
+		Metadata:  map[string]interface{}{"tier": "gold", "points": 10},
+		CreatedAt: "2024-01-01T00:00:00Z",
+	}
+
+	record, err := customer.CSVRecord()
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "John Doe", "john@example.com", "", DefaultStatus, "beta;vip", `{"points":10,"tier":"gold"}`, "2024-01-01T00:00:00Z", ""}, record)
+	assert.Len(t, record, len(CSVExportColumns))
+	// Sorting the tags for output leaves the customer untouched
+	assert.Equal(t, []string{"vip", "beta"}, customer.Tags)
+}
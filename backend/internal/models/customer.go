@@ -2,8 +2,16 @@ package models
 
 // Customer represents the customer entity
 type Customer struct {
-	ID        string `json:"id"`
-	Name      string `json:"name" binding:"required"`
-	Email     string `json:"email" binding:"required,email"`
-	Telephone string `json:"telephone"`
+	ID         string                 `json:"id" dynamodbav:"id"`
+	Name       string                 `json:"name" binding:"required" dynamodbav:"name"`
+	Email      string                 `json:"email" binding:"required,email" dynamodbav:"email"`
+	Telephone  string                 `json:"telephone" dynamodbav:"telephone"`
+	Metadata   map[string]interface{} `json:"metadata,omitempty" dynamodbav:"metadata,omitempty"`
+	Tags       []string               `json:"tags,omitempty" dynamodbav:"tags,stringset,omitempty"`
+	Status     string                 `json:"status,omitempty" dynamodbav:"status,omitempty"`
+	ErasedAt   string                 `json:"erasedAt,omitempty" dynamodbav:"erasedAt,omitempty"`
+	CreatedAt  string                 `json:"createdAt,omitempty" dynamodbav:"createdAt,omitempty"`
+	MergedInto string                 `json:"mergedInto,omitempty" dynamodbav:"mergedInto,omitempty"`
+	// Version counts the writes made to the customer, 0 for customers stored before it was kept
+	Version int64 `json:"-" dynamodbav:"version,omitempty"`
 }
@@ -0,0 +1,31 @@
+package models
+
+// Customer event types
+const (
+	EventCustomerCreated       = "customer.created"
+	EventCustomerUpdated       = "customer.updated"
+	EventCustomerDeleted       = "customer.deleted"
+	EventCustomerTagged        = "customer.tagged"
+	EventCustomerUntagged      = "customer.untagged"
+	EventCustomerStatusChanged = "customer.status_changed"
+	EventCustomerErased        = "customer.erased"
+	EventCustomerMerged        = "customer.merged"
+)
+
+// Event is a change to a customer published to downstream services. Events
+// of the same customer are delivered in the order they happened, at least once.
+type Event struct {
+	CustomerID string `json:"customerId" dynamodbav:"customerId"`
+	ID         string `json:"id" dynamodbav:"eventId"`
+	Type       string `json:"type" dynamodbav:"type"`
+	OccurredAt string `json:"occurredAt" dynamodbav:"occurredAt"`
+	// Customer is the customer as written, for creates and updates
+	Customer *Customer `json:"customer,omitempty" dynamodbav:"-"`
+	// Data describes other changes, such as the tag added or the new status
+	Data map[string]string `json:"data,omitempty" dynamodbav:"data,omitempty"`
+
+	// Attempts counts the failed publishes of an event in the outbox, retried
+	// from NextAttemptAt on
+	Attempts      int    `json:"-" dynamodbav:"attempts,omitempty"`
+	NextAttemptAt string `json:"-" dynamodbav:"nextAttemptAt,omitempty"`
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// CustomerFields lists the customer attributes that can be selected with a sparse fieldset
+var CustomerFields = []string{"id", "name", "email", "telephone", "metadata", "tags", "status", "erasedAt", "createdAt", "mergedInto"}
+
+// ParseCustomerFields splits a comma-separated fieldset such as "id,name,email"
+// and validates each entry against CustomerFields. Duplicates are dropped.
+func ParseCustomerFields(raw string) ([]string, error) {
+	var fields []string
+	seen := map[string]bool{}
+	for _, field := range strings.Split(raw, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" || seen[field] {
+			continue
+		}
+		if !isCustomerField(field) {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		seen[field] = true
+		fields = append(fields, field)
+	}
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("fields must list at least one of: %s", strings.Join(CustomerFields, ", "))
+	}
+	return fields, nil
+}
+
+// Fields returns the selected attributes of the customer keyed by their JSON names
+func (c Customer) Fields(fields []string) map[string]interface{} {
+	selected := make(map[string]interface{}, len(fields))
+	for _, field := range fields {
+		switch field {
+		case "id":
+			selected[field] = c.ID
+		case "name":
+			selected[field] = c.Name
+		case "email":
+			selected[field] = c.Email
+		case "telephone":
+			selected[field] = c.Telephone
+		case "metadata":
+			selected[field] = c.Metadata
+		case "tags":
+			selected[field] = c.Tags
+		case "status":
+			selected[field] = c.CurrentStatus()
+		case "erasedAt":
+			selected[field] = c.ErasedAt
+		case "createdAt":
+			selected[field] = c.CreatedAt
+		case "mergedInto":
+			selected[field] = c.MergedInto
+		}
+	}
+	return selected
+}
+
+// isCustomerField reports whether field is one of CustomerFields
+func isCustomerField(field string) bool {
+	for _, f := range CustomerFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCustomerFields(t *testing.T) {
+	fields, err := ParseCustomerFields(" id,name , email,name,")
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"id", "name", "email"}, fields)
+}
+
+func TestParseCustomerFields_Invalid(t *testing.T) {
+	_, err := ParseCustomerFields("id,password")
+	assert.ErrorContains(t, err, `unknown field "password"`)
+
+	_, err = ParseCustomerFields(" , ")
+	assert.Error(t, err)
+}
+
+func TestCustomer_Fields(t *testing.T) {
+	customer := Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com", Telephone: "555-0123"}
+
+	selected := customer.Fields([]string{"id", "email", "status"})
+
+	assert.Equal(t, map[string]interface{}{
+		"id":     "123",
+		"email":  "john.doe@example.com",
+		"status": DefaultStatus,
+	}, selected)
+}
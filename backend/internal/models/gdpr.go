@@ -0,0 +1,28 @@
+package models
+
+// ErasedName replaces the name of an erased customer
+const ErasedName = "[erased]"
+
+// ErasedEmail returns the placeholder email of an erased customer. It stays
+// unique per customer and uses a reserved domain that can never receive mail.
+func ErasedEmail(id string) string {
+	return "erased-" + id + "@erased.invalid"
+}
+
+// EraseRequest is the body of a data subject erasure
+type EraseRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// CustomerExport bundles everything stored about a customer for a data subject access request
+type CustomerExport struct {
+	ExportedAt string         `json:"exportedAt"`
+	Customer   Customer       `json:"customer"`
+	History    []HistoryEntry `json:"history"`
+	Notes      []Note         `json:"notes"`
+}
+
+// IsErased reports whether the customer's personal data has been erased
+func (c *Customer) IsErased() bool {
+	return c.ErasedAt != ""
+}
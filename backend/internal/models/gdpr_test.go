@@ -0,0 +1,17 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErasedEmail(t *testing.T) {
+	assert.Equal(t, "erased-123@erased.invalid", ErasedEmail("123"))
+	assert.NotEqual(t, ErasedEmail("123"), ErasedEmail("456"))
+}
+
+func TestCustomer_IsErased(t *testing.T) {
+	assert.False(t, (&Customer{}).IsErased())
+	assert.True(t, (&Customer{ErasedAt: "2024-01-01T00:00:00Z"}).IsErased())
+}
@@ -0,0 +1,22 @@
+package models
+
+// History entry types
+const (
+	HistoryStatusTransition = "status_transition"
+	HistoryErasure          = "erasure"
+	HistoryMerge            = "merge"
+)
+
+// HistoryEntry is an audit record of a change made to a customer
+type HistoryEntry struct {
+	CustomerID string `json:"customerId" dynamodbav:"customerId"`
+	EventID    string `json:"eventId" dynamodbav:"eventId"`
+	Type       string `json:"type" dynamodbav:"type"`
+	From       string `json:"from,omitempty" dynamodbav:"from,omitempty"`
+	To         string `json:"to,omitempty" dynamodbav:"to,omitempty"`
+	Reason     string `json:"reason,omitempty" dynamodbav:"reason,omitempty"`
+	Actor      string `json:"actor,omitempty" dynamodbav:"actor,omitempty"`
+	At         string `json:"at" dynamodbav:"at"`
+	// RelatedCustomerID is the other customer of a merge
+	RelatedCustomerID string `json:"relatedCustomerId,omitempty" dynamodbav:"relatedCustomerId,omitempty"`
+}
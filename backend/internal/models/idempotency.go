@@ -0,0 +1,28 @@
+package models
+
+// Idempotency record statuses
+const (
+	IdempotencyPending  = "pending"
+	IdempotencyComplete = "complete"
+)
+
+// IdempotencyRecord remembers a request made with an Idempotency-Key so a
+// retry is answered with the first response instead of being applied again
+type IdempotencyRecord struct {
+	// ID scopes the client's key to the caller and the endpoint
+	ID string `dynamodbav:"id"`
+	// RequestHash tells a retry from a different request reusing the key
+	RequestHash string `dynamodbav:"requestHash"`
+	Status      string `dynamodbav:"status"`
+	// Owner identifies the request holding a pending key, and LockedUntil
+	// (unix seconds) when another may take over if its process died
+	Owner       string `dynamodbav:"owner"`
+	LockedUntil int64  `dynamodbav:"lockedUntil"`
+	// The response of a complete request
+	ResponseStatus      int    `dynamodbav:"responseStatus,omitempty"`
+	ResponseContentType string `dynamodbav:"responseContentType,omitempty"`
+	ResponseBody        string `dynamodbav:"responseBody,omitempty"`
+	CreatedAt           string `dynamodbav:"createdAt"`
+	// ExpiresAt is the unix time after which the key may be reused
+	ExpiresAt int64 `dynamodbav:"expiresAt"`
+}
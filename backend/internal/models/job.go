@@ -0,0 +1,80 @@
+package models
+
+// Job types
+const (
+	JobImport = "import"
+	JobExport = "export"
+)
+
+// Job statuses
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+)
+
+// MaxJobErrors bounds the row errors kept on a job record, well below
+// DynamoDB's item size limit. Failed still counts every rejected row.
+const MaxJobErrors = 1000
+
+// Job is a long-running import or export executed in the background. The
+// lease fields let a worker claim the job, and the checkpoint lets another
+// worker resume it if the first one stops before finishing.
+type Job struct {
+	ID     string            `json:"id" dynamodbav:"id"`
+	Type   string            `json:"type" dynamodbav:"type"`
+	Status string            `json:"status" dynamodbav:"status"`
+	Owner  string            `json:"owner,omitempty" dynamodbav:"owner,omitempty"`
+	Params map[string]string `json:"params,omitempty" dynamodbav:"params,omitempty"`
+
+	// Total is the number of rows to process, approximate for exports
+	Total     int              `json:"total" dynamodbav:"total"`
+	Processed int              `json:"processed" dynamodbav:"processed"`
+	Failed    int              `json:"failed" dynamodbav:"failed"`
+	Percent   int              `json:"percent" dynamodbav:"-"`
+	Errors    []ImportRowError `json:"errors,omitempty" dynamodbav:"errors,omitempty"`
+	// Error explains why a failed job stopped
+	Error string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+
+	// InputChunks and ResultChunks count the data chunks stored for the job
+	InputChunks  int `json:"-" dynamodbav:"inputChunks"`
+	ResultChunks int `json:"-" dynamodbav:"resultChunks"`
+	// Checkpoint is the executor's record of how far the job got
+	Checkpoint string `json:"-" dynamodbav:"checkpoint,omitempty"`
+
+	LeaseOwner     string `json:"-" dynamodbav:"leaseOwner,omitempty"`
+	LeaseExpiresAt int64  `json:"-" dynamodbav:"leaseExpiresAt,omitempty"`
+	// ExpiresAt is the Unix time after which the job and its data are deleted
+	ExpiresAt int64 `json:"-" dynamodbav:"expiresAt"`
+
+	CreatedAt  string `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt  string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+	FinishedAt string `json:"finishedAt,omitempty" dynamodbav:"finishedAt,omitempty"`
+}
+
+// IsFinished reports whether the job has stopped for good
+func (j *Job) IsFinished() bool {
+	return j.Status == JobSucceeded || j.Status == JobFailed
+}
+
+// AddError records a rejected row, keeping at most MaxJobErrors of them
+func (j *Job) AddError(rowErr ImportRowError) {
+	j.Failed++
+	if len(j.Errors) < MaxJobErrors {
+		j.Errors = append(j.Errors, rowErr)
+	}
+}
+
+// ComputePercent sets Percent from the progress. Unfinished jobs stay below
+// 100 since totals can be estimates.
+func (j *Job) ComputePercent() {
+	switch {
+	case j.Status == JobSucceeded:
+		j.Percent = 100
+	case j.Total <= 0:
+		j.Percent = 0
+	default:
+		j.Percent = min(j.Processed*100/j.Total, 99)
+	}
+}
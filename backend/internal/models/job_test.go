@@ -0,0 +1,44 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJob_IsFinished(t *testing.T) {
+	assert.False(t, (&Job{Status: JobQueued}).IsFinished())
+	assert.False(t, (&Job{Status: JobRunning}).IsFinished())
+	assert.True(t, (&Job{Status: JobSucceeded}).IsFinished())
+	assert.True(t, (&Job{Status: JobFailed}).IsFinished())
+}
+
+func TestJob_AddError(t *testing.T) {
+	job := &Job{}
+	for row := 0; row < MaxJobErrors+5; row++ {
+		job.AddError(ImportRowError{Row: row, Error: "invalid"})
+	}
+
+	assert.Equal(t, MaxJobErrors+5, job.Failed)
+	assert.Len(t, job.Errors, MaxJobErrors)
+}
+
+func TestJob_ComputePercent(t *testing.T) {
+	tests := []struct {
+		name     string
+		job      Job
+		expected int
+	}{
+		{"no total", Job{Status: JobRunning}, 0},
+		{"halfway", Job{Status: JobRunning, Total: 200, Processed: 100}, 50},
+		{"estimate exceeded", Job{Status: JobRunning, Total: 10, Processed: 12}, 99},
+		{"succeeded", Job{Status: JobSucceeded, Total: 10, Processed: 8}, 100},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.job.ComputePercent()
+			assert.Equal(t, tt.expected, tt.job.Percent)
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import (
+	"strings"
+	"unicode"
+)
+
+// MaskEmail hides all but the first character of an email's local part,
+// e.g. "john.doe@example.com" becomes "j***@example.com"
+func MaskEmail(email string) string {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 {
+		return strings.Repeat("*", len(email))
+	}
+	return email[:1] + "***" + email[at:]
+}
+
+// MaskTelephone keeps an international prefix and the last four digits of a
+// telephone number, e.g. "+55 11 98765-1234" becomes "+55*******1234".
+// Formatting characters are dropped.
+func MaskTelephone(telephone string) string {
+	var digits []rune
+	for _, r := range telephone {
+		if unicode.IsDigit(r) {
+			digits = append(digits, r)
+		}
+	}
+	if len(digits) == 0 {
+		return telephone
+	}
+
+	prefix := ""
+	if strings.HasPrefix(strings.TrimSpace(telephone), "+") && len(digits) > 8 {
+		prefix = "+" + string(digits[:2])
+		digits = digits[2:]
+	}
+
+	if len(digits) <= 4 {
+		return prefix + strings.Repeat("*", len(digits))
+	}
+
+	return prefix + strings.Repeat("*", len(digits)-4) + string(digits[len(digits)-4:])
+}
+
+// Masked returns a copy of the customer with its email and telephone masked
+func (c Customer) Masked() Customer {
+	if c.Email != "" {
+		c.Email = MaskEmail(c.Email)
+	}
+	if c.Telephone != "" {
+		c.Telephone = MaskTelephone(c.Telephone)
+	}
+	return c
+}
@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaskEmail(t *testing.T) {
+	tests := []struct {
+		email    string
+		expected string
+	}{
+		{email: "john.doe@example.com", expected: "j***@example.com"},
+		{email: "j@example.com", expected: "j***@example.com"},
+		{email: "jose.maria+test@example.com", expected: "j***@example.com"},
+		{email: "not-an-email", expected: "************"},
+		{email: "", expected: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.email, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MaskEmail(tt.email))
+		})
+	}
+}
+
+func TestMaskTelephone(t *testing.T) {
+	tests := []struct {
+		telephone string
+		expected  string
+	}{
+		{telephone: "+55 11 98765-1234", expected: "+55*******1234"},
+		{telephone: "+1-555-0123", expected: "****0123"},
+		{telephone: "(555) 123-4567", expected: "******4567"},
+		{telephone: "5550123", expected: "***0123"},
+		{telephone: "123", expected: "***"},
+		{telephone: "n/a", expected: "n/a"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.telephone, func(t *testing.T) {
+			assert.Equal(t, tt.expected, MaskTelephone(tt.telephone))
+		})
+	}
+}
+
+func TestCustomer_Masked(t *testing.T) {
+	customer := Customer{ID: "123", Name: "John Doe", Email: "john.doe@example.com", Telephone: "+55 11 98765-1234"}
+
+	masked := customer.Masked()
+
+	assert.Equal(t, "j***@example.com", masked.Email)
+	assert.Equal(t, "+55*******1234", masked.Telephone)
+	assert.Equal(t, "John Doe", masked.Name)
+	// The original is untouched
+	assert.Equal(t, "john.doe@example.com", customer.Email)
+
+	assert.Equal(t, "", Customer{}.Masked().Telephone)
+}
@@ -0,0 +1,137 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Merge strategies decide which customer's values win when both have one
+const (
+	// MergeKeepSurvivor keeps the survivor's values, filling blanks from the loser
+	MergeKeepSurvivor = "survivor"
+	// MergeKeepNewest keeps the values of the most recently created customer,
+	// filling blanks from the other
+	MergeKeepNewest = "newest"
+)
+
+// Sources a merged field can be forced to come from
+const (
+	MergeFromSurvivor = "survivor"
+	MergeFromLoser    = "loser"
+)
+
+// MergeFields lists the fields whose source can be chosen in a merge request.
+// Tags are always combined and metadata keys are combined, conflicting keys
+// following the strategy.
+var MergeFields = []string{"name", "email", "telephone", "status"}
+
+// MergeRequest is the body of a customer merge. The actor recorded is the
+// caller's principal.
+type MergeRequest struct {
+	SurvivorID string `json:"survivorId" binding:"required"`
+	LoserID    string `json:"loserId" binding:"required,nefield=SurvivorID"`
+	Strategy   string `json:"strategy"`
+	// Fields forces the source of individual fields, e.g. {"email": "loser"}
+	Fields map[string]string `json:"fields"`
+	Reason string            `json:"reason"`
+}
+
+// Validate checks the strategy and field sources, defaulting the strategy
+func (r *MergeRequest) Validate() error {
+	if r.Strategy == "" {
+		r.Strategy = MergeKeepSurvivor
+	}
+	if r.Strategy != MergeKeepSurvivor && r.Strategy != MergeKeepNewest {
+		return fmt.Errorf("strategy must be '%s' or '%s'", MergeKeepSurvivor, MergeKeepNewest)
+	}
+
+	for field, source := range r.Fields {
+		if !isMergeField(field) {
+			return fmt.Errorf("field %q can't be chosen, use one of: %s", field, strings.Join(MergeFields, ", "))
+		}
+		if source != MergeFromSurvivor && source != MergeFromLoser {
+			return fmt.Errorf("source of %s must be '%s' or '%s'", field, MergeFromSurvivor, MergeFromLoser)
+		}
+	}
+	return nil
+}
+
+func isMergeField(field string) bool {
+	for _, f := range MergeFields {
+		if f == field {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeCustomers combines loser into survivor field by field according to
+// the request, returning the survivor's new state
+func MergeCustomers(survivor, loser Customer, request MergeRequest) Customer {
+	// preferred provides values by default, other fills in the blanks
+	preferred, other := survivor, loser
+	if request.Strategy == MergeKeepNewest && loser.CreatedAt > survivor.CreatedAt {
+		preferred, other = loser, survivor
+	}
+
+	pick := func(field string, value func(Customer) string) string {
+		switch request.Fields[field] {
+		case MergeFromSurvivor:
+			return value(survivor)
+		case MergeFromLoser:
+			return value(loser)
+		}
+		if v := value(preferred); v != "" {
+			return v
+		}
+		return value(other)
+	}
+
+	merged := Customer{
+		ID:        survivor.ID,
+		Name:      pick("name", func(c Customer) string { return c.Name }),
+		Email:     pick("email", func(c Customer) string { return c.Email }),
+		Telephone: pick("telephone", func(c Customer) string { return c.Telephone }),
+		Status:    pick("status", func(c Customer) string { return c.CurrentStatus() }),
+		CreatedAt: survivor.CreatedAt,
+	}
+	// The merged customer has existed since the older record was created
+	if loser.CreatedAt != "" && (merged.CreatedAt == "" || loser.CreatedAt < merged.CreatedAt) {
+		merged.CreatedAt = loser.CreatedAt
+	}
+
+	if len(preferred.Metadata) > 0 || len(other.Metadata) > 0 {
+		merged.Metadata = map[string]interface{}{}
+		for key, value := range other.Metadata {
+			merged.Metadata[key] = value
+		}
+		for key, value := range preferred.Metadata {
+			merged.Metadata[key] = value
+		}
+	}
+
+	tags := map[string]bool{}
+	for _, tag := range append(append([]string{}, survivor.Tags...), loser.Tags...) {
+		if !tags[tag] {
+			tags[tag] = true
+			merged.Tags = append(merged.Tags, tag)
+		}
+	}
+	sort.Strings(merged.Tags)
+
+	return merged
+}
+
+// IsMerged reports whether the customer was merged into another and is kept as a tombstone
+func (c *Customer) IsMerged() bool {
+	return c.MergedInto != ""
+}
+
+// DuplicatePair is two customers that probably describe the same person.
+// Signals holds the similarity of each compared field between 0 and 1.
+type DuplicatePair struct {
+	Score     float64            `json:"score"`
+	Signals   map[string]float64 `json:"signals"`
+	Customers []Customer         `json:"customers"`
+}
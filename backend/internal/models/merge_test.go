@@ -0,0 +1,87 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMergeRequest_Validate(t *testing.T) {
+	request := MergeRequest{}
+	assert.NoError(t, request.Validate())
+	assert.Equal(t, MergeKeepSurvivor, request.Strategy)
+
+	assert.Error(t, (&MergeRequest{Strategy: "oldest"}).Validate())
+	assert.Error(t, (&MergeRequest{Fields: map[string]string{"id": MergeFromLoser}}).Validate())
+	assert.Error(t, (&MergeRequest{Fields: map[string]string{"email": "both"}}).Validate())
+	assert.NoError(t, (&MergeRequest{Strategy: MergeKeepNewest, Fields: map[string]string{"email": MergeFromLoser}}).Validate())
+}
+
+func mergeFixtures() (Customer, Customer) {
+	survivor := Customer{
+		ID:        "survivor",
+		Name:      "John Smith",
+		Email:     "john@work.com",
+		Status:    StatusActive,
+		Tags:      []string{"vip"},
+		Metadata:  map[string]interface{}{"plan": "pro", "source": "web"},
+		CreatedAt: "2024-01-01T00:00:00Z",
+	}
+	loser := Customer{
+		ID:        "loser",
+		Name:      "Jon Smith",
+		Email:     "JOHN@work.com",
+		Telephone: "555 123 4567",
+		Status:    StatusLead,
+		Tags:      []string{"newsletter", "vip"},
+		Metadata:  map[string]interface{}{"plan": "free", "locale": "en"},
+		CreatedAt: "2023-06-01T00:00:00Z",
+	}
+	return survivor, loser
+}
+
+func TestMergeCustomers_KeepSurvivor(t *testing.T) {
+	survivor, loser := mergeFixtures()
+
+	merged := MergeCustomers(survivor, loser, MergeRequest{Strategy: MergeKeepSurvivor})
+
+	assert.Equal(t, "survivor", merged.ID)
+	assert.Equal(t, "John Smith", merged.Name)
+	assert.Equal(t, "john@work.com", merged.Email)
+	assert.Equal(t, "555 123 4567", merged.Telephone, "blanks are filled from the loser")
+	assert.Equal(t, StatusActive, merged.Status)
+	assert.Equal(t, []string{"newsletter", "vip"}, merged.Tags)
+	assert.Equal(t, map[string]interface{}{"plan": "pro", "source": "web", "locale": "en"}, merged.Metadata)
+	assert.Equal(t, "2023-06-01T00:00:00Z", merged.CreatedAt, "the older creation time is kept")
+}
+
+func TestMergeCustomers_KeepNewest(t *testing.T) {
+	survivor, loser := mergeFixtures()
+	loser.CreatedAt = "2024-06-01T00:00:00Z"
+
+	merged := MergeCustomers(survivor, loser, MergeRequest{Strategy: MergeKeepNewest})
+
+	assert.Equal(t, "survivor", merged.ID)
+	assert.Equal(t, "Jon Smith", merged.Name)
+	assert.Equal(t, StatusLead, merged.Status)
+	assert.Equal(t, "free", merged.Metadata["plan"])
+	assert.Equal(t, "2024-01-01T00:00:00Z", merged.CreatedAt)
+}
+
+func TestMergeCustomers_FieldOverrides(t *testing.T) {
+	survivor, loser := mergeFixtures()
+
+	merged := MergeCustomers(survivor, loser, MergeRequest{
+		Strategy: MergeKeepSurvivor,
+		Fields:   map[string]string{"name": MergeFromLoser, "telephone": MergeFromSurvivor},
+	})
+
+	assert.Equal(t, "Jon Smith", merged.Name)
+	assert.Empty(t, merged.Telephone, "a forced source is used even when blank")
+	assert.Equal(t, "john@work.com", merged.Email)
+}
+
+func TestCustomer_IsMerged(t *testing.T) {
+	assert.False(t, (&Customer{}).IsMerged())
+	assert.True(t, (&Customer{MergedInto: "other"}).IsMerged())
+}
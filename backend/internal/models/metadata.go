@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	// MaxMetadataKeys is the maximum number of top-level keys allowed in customer metadata
+	MaxMetadataKeys = 50
+	// MaxMetadataKeyLength is the maximum length of a metadata key
+	MaxMetadataKeyLength = 64
+)
+
+// metadataKeyPattern restricts metadata keys to characters that are safe to use
+// in DynamoDB document paths and query strings
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// MetadataSchema is the admin-managed JSON Schema that customer metadata must satisfy
+type MetadataSchema struct {
+	Schema    json.RawMessage `json:"schema" binding:"required"`
+	Version   int             `json:"version"`
+	UpdatedAt string          `json:"updatedAt"`
+}
+
+// ValidateMetadataKey checks that a metadata key is non-empty and uses allowed characters
+func ValidateMetadataKey(key string) error {
+	if len(key) > MaxMetadataKeyLength {
+		return fmt.Errorf("metadata key %q exceeds %d characters", key, MaxMetadataKeyLength)
+	}
+	if !metadataKeyPattern.MatchString(key) {
+		return fmt.Errorf("metadata key %q must contain only letters, digits, '_' or '-'", key)
+	}
+	return nil
+}
+
+// ValidateMetadata checks the keys and the encoded size of customer metadata.
+// The size limit keeps customer items well below DynamoDB's 400 KB item cap.
+func ValidateMetadata(metadata map[string]interface{}, maxBytes int) error {
+	if len(metadata) > MaxMetadataKeys {
+		return fmt.Errorf("metadata has %d keys, maximum is %d", len(metadata), MaxMetadataKeys)
+	}
+	for key := range metadata {
+		if err := ValidateMetadataKey(key); err != nil {
+			return err
+		}
+	}
+
+	encoded, err := json.Marshal(metadata)
+	if err != nil {
+		return fmt.Errorf("metadata is not valid JSON: %v", err)
+	}
+	if len(encoded) > maxBytes {
+		return fmt.Errorf("metadata is %d bytes, maximum is %d", len(encoded), maxBytes)
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateMetadataKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "camel case", key: "loyaltyTier", wantErr: false},
+		{name: "snake case with digits", key: "crm_id_2", wantErr: false},
+		{name: "dashes", key: "crm-id", wantErr: false},
+		{name: "empty key", key: "", wantErr: true},
+		{name: "dot", key: "loyalty.tier", wantErr: true},
+		{name: "brackets", key: "tier[0]", wantErr: true},
+		{name: "too long", key: strings.Repeat("a", MaxMetadataKeyLength+1), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateMetadataKey(tt.key)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	assert.NoError(t, ValidateMetadata(nil, 100))
+	assert.NoError(t, ValidateMetadata(map[string]interface{}{"loyaltyTier": "gold", "points": 120}, 100))
+
+	err := ValidateMetadata(map[string]interface{}{"notes": strings.Repeat("x", 200)}, 100)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "maximum is 100")
+
+	tooMany := map[string]interface{}{}
+	for i := 0; i <= MaxMetadataKeys; i++ {
+		tooMany[strings.Repeat("k", i+1)] = true
+	}
+	assert.Error(t, ValidateMetadata(tooMany, 1<<20))
+
+	assert.Error(t, ValidateMetadata(map[string]interface{}{"bad key": "x"}, 100))
+}
@@ -0,0 +1,22 @@
+package models
+
+// MigrationState records the schema migrations applied to the tables. The
+// lease fields keep two processes from migrating at once, and the checkpoint
+// lets the migration after Version resume where an interrupted run stopped.
+type MigrationState struct {
+	// Version is the version of the last migration applied, 0 before any
+	Version int                `json:"version" dynamodbav:"version"`
+	Applied []AppliedMigration `json:"applied" dynamodbav:"applied,omitempty"`
+	// Checkpoint is the record of how far the next migration got
+	Checkpoint string `json:"-" dynamodbav:"checkpoint,omitempty"`
+
+	LeaseOwner     string `json:"-" dynamodbav:"leaseOwner,omitempty"`
+	LeaseExpiresAt int64  `json:"-" dynamodbav:"leaseExpiresAt,omitempty"`
+}
+
+// AppliedMigration is a migration recorded as applied
+type AppliedMigration struct {
+	Version   int    `json:"version" dynamodbav:"version"`
+	Name      string `json:"name" dynamodbav:"name"`
+	AppliedAt string `json:"appliedAt" dynamodbav:"appliedAt"`
+}
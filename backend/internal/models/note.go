@@ -0,0 +1,23 @@
+package models
+
+// Note is a timestamped note attached to a customer by a support agent
+type Note struct {
+	CustomerID string `json:"customerId" dynamodbav:"customerId"`
+	ID         string `json:"id" dynamodbav:"noteId"`
+	Author     string `json:"author" dynamodbav:"author"`
+	Body       string `json:"body" dynamodbav:"body"`
+	CreatedAt  string `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt  string `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+}
+
+// NoteRequest is the body used to create or edit a note. The author is the
+// caller's principal.
+type NoteRequest struct {
+	Body string `json:"body" binding:"required,max=4000"`
+}
+
+// NotePage is one page of a customer's notes
+type NotePage struct {
+	Items      []Note `json:"items"`
+	NextCursor string `json:"nextCursor,omitempty"`
+}
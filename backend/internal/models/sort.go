@@ -0,0 +1,50 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CustomerSortFields lists the fields customers can be sorted by
+var CustomerSortFields = []string{"name", "createdAt"}
+
+// CustomerSort orders a list of customers by a single field
+type CustomerSort struct {
+	Field      string
+	Descending bool
+}
+
+// ParseCustomerSort parses a sort such as "name" or "-createdAt", where a
+// leading '-' sorts in descending order
+func ParseCustomerSort(raw string) (CustomerSort, error) {
+	s := CustomerSort{Field: strings.TrimPrefix(raw, "-"), Descending: strings.HasPrefix(raw, "-")}
+	for _, field := range CustomerSortFields {
+		if s.Field == field {
+			return s, nil
+		}
+	}
+	return CustomerSort{}, fmt.Errorf("sort must be one of: %s, optionally prefixed with '-'", strings.Join(CustomerSortFields, ", "))
+}
+
+// SortCustomers orders customers in place. Names compare without case and
+// ties are broken by ID so the order is stable across requests.
+func SortCustomers(customers []Customer, s CustomerSort) {
+	key := func(c Customer) string {
+		if s.Field == "createdAt" {
+			return c.CreatedAt
+		}
+		return strings.ToLower(c.Name)
+	}
+
+	sort.SliceStable(customers, func(i, j int) bool {
+		a, b := key(customers[i]), key(customers[j])
+		if a == b {
+			a, b = customers[i].ID, customers[j].ID
+		}
+		if s.Descending {
+			return a > b
+		}
+		return a < b
+	})
+}
@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCustomerSort(t *testing.T) {
+	s, err := ParseCustomerSort("name")
+	require.NoError(t, err)
+	assert.Equal(t, CustomerSort{Field: "name"}, s)
+
+	s, err = ParseCustomerSort("-createdAt")
+	require.NoError(t, err)
+	assert.Equal(t, CustomerSort{Field: "createdAt", Descending: true}, s)
+
+	_, err = ParseCustomerSort("email")
+	assert.Error(t, err)
+}
+
+func TestSortCustomers(t *testing.T) {
+	customers := []Customer{
+		{ID: "3", Name: "bob", CreatedAt: "2024-01-02T00:00:00Z"},
+		{ID: "1", Name: "Alice", CreatedAt: "2024-01-03T00:00:00Z"},
+		{ID: "2", Name: "alice", CreatedAt: "2024-01-01T00:00:00Z"},
+	}
+
+	ids := func() []string {
+		var ids []string
+		for _, c := range customers {
+			ids = append(ids, c.ID)
+		}
+		return ids
+	}
+
+	SortCustomers(customers, CustomerSort{Field: "name"})
+	assert.Equal(t, []string{"1", "2", "3"}, ids())
+
+	SortCustomers(customers, CustomerSort{Field: "createdAt", Descending: true})
+	assert.Equal(t, []string{"1", "3", "2"}, ids())
+}
@@ -0,0 +1,57 @@
+package models
+
+// Customer lifecycle statuses
+const (
+	StatusLead      = "lead"
+	StatusActive    = "active"
+	StatusSuspended = "suspended"
+	StatusClosed    = "closed"
+)
+
+// DefaultStatus is assigned to new customers and assumed for items stored
+// before statuses existed
+const DefaultStatus = StatusLead
+
+// statusTransitions lists the statuses each status may move to
+var statusTransitions = map[string][]string{
+	StatusLead:      {StatusActive, StatusClosed},
+	StatusActive:    {StatusSuspended, StatusClosed},
+	StatusSuspended: {StatusActive, StatusClosed},
+	StatusClosed:    {},
+}
+
+// TransitionRequest is the body of a status transition. The actor recorded
+// is the caller's principal.
+type TransitionRequest struct {
+	To     string `json:"to" binding:"required"`
+	Reason string `json:"reason" binding:"required"`
+}
+
+// IsValidStatus reports whether status is a known lifecycle status
+func IsValidStatus(status string) bool {
+	_, ok := statusTransitions[status]
+	return ok
+}
+
+// CanTransition reports whether a customer may move from one status to another
+func CanTransition(from, to string) bool {
+	for _, allowed := range statusTransitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowedTransitions returns the statuses reachable from status
+func AllowedTransitions(status string) []string {
+	return append([]string{}, statusTransitions[status]...)
+}
+
+// CurrentStatus returns the customer's status, falling back to DefaultStatus
+func (c *Customer) CurrentStatus() string {
+	if c.Status == "" {
+		return DefaultStatus
+	}
+	return c.Status
+}
@@ -0,0 +1,52 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsValidStatus(t *testing.T) {
+	for _, status := range []string{StatusLead, StatusActive, StatusSuspended, StatusClosed} {
+		assert.True(t, IsValidStatus(status), status)
+	}
+	assert.False(t, IsValidStatus(""))
+	assert.False(t, IsValidStatus("archived"))
+}
+
+func TestCanTransition(t *testing.T) {
+	tests := []struct {
+		from    string
+		to      string
+		allowed bool
+	}{
+		{from: StatusLead, to: StatusActive, allowed: true},
+		{from: StatusLead, to: StatusClosed, allowed: true},
+		{from: StatusLead, to: StatusSuspended, allowed: false},
+		{from: StatusActive, to: StatusSuspended, allowed: true},
+		{from: StatusActive, to: StatusLead, allowed: false},
+		{from: StatusSuspended, to: StatusActive, allowed: true},
+		{from: StatusClosed, to: StatusActive, allowed: false},
+		{from: StatusActive, to: StatusActive, allowed: false},
+		{from: "unknown", to: StatusActive, allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.from+"->"+tt.to, func(t *testing.T) {
+			assert.Equal(t, tt.allowed, CanTransition(tt.from, tt.to))
+		})
+	}
+}
+
+func TestAllowedTransitions_ReturnsCopy(t *testing.T) {
+	allowed := AllowedTransitions(StatusLead)
+	allowed[0] = StatusClosed
+
+	assert.Equal(t, []string{StatusActive, StatusClosed}, AllowedTransitions(StatusLead))
+	assert.Empty(t, AllowedTransitions(StatusClosed))
+}
+
+func TestCustomer_CurrentStatus(t *testing.T) {
+	assert.Equal(t, DefaultStatus, (&Customer{}).CurrentStatus())
+	assert.Equal(t, StatusActive, (&Customer{Status: StatusActive}).CurrentStatus())
+}
@@ -0,0 +1,13 @@
+package models
+
+// StreamCheckpoint records how far a stream consumer has read a shard of the
+// customer table's stream
+type StreamCheckpoint struct {
+	Consumer string `json:"consumer" dynamodbav:"consumer"`
+	ShardID  string `json:"shardId" dynamodbav:"shardId"`
+	// SequenceNumber is the last record processed, empty before the first one
+	SequenceNumber string `json:"sequenceNumber,omitempty" dynamodbav:"sequenceNumber,omitempty"`
+	// Finished is set once a closed shard has been read to its end
+	Finished  bool   `json:"finished" dynamodbav:"finished"`
+	UpdatedAt string `json:"updatedAt" dynamodbav:"updatedAt"`
+}
@@ -0,0 +1,38 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// MaxTagsPerCustomer is the maximum number of tags a single customer can carry
+const MaxTagsPerCustomer = 50
+
+// tagPattern allows short lowercase labels such as "vip" or "churn-risk"
+var tagPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,49}$`)
+
+// TagCount reports how many customers carry a tag
+type TagCount struct {
+	Tag   string `json:"tag" dynamodbav:"tag"`
+	Count int    `json:"count" dynamodbav:"count"`
+}
+
+// NormalizeTag lowercases and validates a tag
+func NormalizeTag(tag string) (string, error) {
+	normalized := strings.ToLower(strings.TrimSpace(tag))
+	if !tagPattern.MatchString(normalized) {
+		return "", fmt.Errorf("invalid tag %q: use up to 50 letters, digits, '_' or '-'", tag)
+	}
+	return normalized, nil
+}
+
+// HasTag reports whether the customer carries the given tag
+func (c *Customer) HasTag(tag string) bool {
+	for _, t := range c.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeTag(t *testing.T) {
+	tests := []struct {
+		name     string
+		tag      string
+		expected string
+		wantErr  bool
+	}{
+		{name: "simple", tag: "vip", expected: "vip"},
+		{name: "uppercase is lowered", tag: "VIP", expected: "vip"},
+		{name: "dash", tag: "churn-risk", expected: "churn-risk"},
+		{name: "surrounding spaces", tag: " vip ", expected: "vip"},
+		{name: "empty", tag: "", wantErr: true},
+		{name: "leading dash", tag: "-vip", wantErr: true},
+		{name: "inner space", tag: "churn risk", wantErr: true},
+		{name: "too long", tag: strings.Repeat("a", 51), wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tag, err := NormalizeTag(tt.tag)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, tag)
+		})
+	}
+}
+
+func TestCustomer_HasTag(t *testing.T) {
+	customer := Customer{Tags: []string{"vip", "churn-risk"}}
+
+	assert.True(t, customer.HasTag("vip"))
+	assert.False(t, customer.HasTag("lead"))
+	assert.False(t, (&Customer{}).HasTag("vip"))
+}
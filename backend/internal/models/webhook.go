@@ -0,0 +1,120 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	// DeliveryDead marks a delivery that failed every attempt
+	DeliveryDead = "dead"
+)
+
+// MinWebhookSecretLength is the shortest secret accepted for signing deliveries
+const MinWebhookSecretLength = 16
+
+// EventTypes lists every customer event type
+var EventTypes = []string{
+	EventCustomerCreated,
+	EventCustomerUpdated,
+	EventCustomerDeleted,
+	EventCustomerTagged,
+	EventCustomerUntagged,
+	EventCustomerStatusChanged,
+	EventCustomerErased,
+	EventCustomerMerged,
+}
+
+// Webhook is a partner's subscription to customer events. Events are posted
+// to URL and signed with Secret, which is only returned when it is created.
+type Webhook struct {
+	ID     string `json:"id" dynamodbav:"id"`
+	URL    string `json:"url" dynamodbav:"url"`
+	Secret string `json:"secret,omitempty" dynamodbav:"secret"`
+	// Events are the event types delivered, every type when empty
+	Events    []string `json:"events" dynamodbav:"events,stringset,omitempty"`
+	CreatedAt string   `json:"createdAt" dynamodbav:"createdAt"`
+	UpdatedAt string   `json:"updatedAt,omitempty" dynamodbav:"updatedAt,omitempty"`
+}
+
+// Subscribes reports whether the webhook receives events of eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	if len(w.Events) == 0 {
+		return true
+	}
+	for _, subscribed := range w.Events {
+		if subscribed == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookRequest is the body used to create or replace a webhook. A secret
+// is generated when none is given.
+type WebhookRequest struct {
+	URL    string   `json:"url" binding:"required"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// Validate checks the URL, the secret's length and the event types
+func (r *WebhookRequest) Validate() error {
+	target, err := url.Parse(r.URL)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") || target.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	if r.Secret != "" && len(r.Secret) < MinWebhookSecretLength {
+		return fmt.Errorf("secret must be at least %d characters", MinWebhookSecretLength)
+	}
+	for _, eventType := range r.Events {
+		if !isEventType(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+// isEventType reports whether eventType is a customer event type
+func isEventType(eventType string) bool {
+	for _, known := range EventTypes {
+		if known == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookDelivery is one event's delivery to a webhook and the outcome of
+// its latest attempt. Failed deliveries are retried until they are dead.
+type WebhookDelivery struct {
+	WebhookID string `json:"webhookId" dynamodbav:"webhookId"`
+	// ID is the delivered event's ID, so an event is delivered to a webhook once
+	ID        string `json:"id" dynamodbav:"deliveryId"`
+	EventType string `json:"eventType" dynamodbav:"eventType"`
+	Status    string `json:"status" dynamodbav:"status"`
+	Attempts  int    `json:"attempts" dynamodbav:"attempts"`
+	// NextAttemptAt is the Unix time of the next attempt of a pending delivery
+	NextAttemptAt int64  `json:"-" dynamodbav:"nextAttemptAt,omitempty"`
+	LastAttemptAt string `json:"lastAttemptAt,omitempty" dynamodbav:"lastAttemptAt,omitempty"`
+	// ResponseStatus is the HTTP status of the latest attempt, 0 when no response arrived
+	ResponseStatus int    `json:"responseStatus,omitempty" dynamodbav:"responseStatus,omitempty"`
+	Error          string `json:"error,omitempty" dynamodbav:"error,omitempty"`
+	CreatedAt      string `json:"createdAt" dynamodbav:"createdAt"`
+	// ExpiresAt is the Unix time after which the delivery is deleted
+	ExpiresAt int64 `json:"-" dynamodbav:"expiresAt"`
+	// Owner identifies the attempt that claimed the delivery
+	Owner string `json:"-" dynamodbav:"owner,omitempty"`
+	// Event is the delivered event
+	Event *Event `json:"-" dynamodbav:"-"`
+}
+
+// WebhookDeliveryPage is one page of a webhook's deliveries
+type WebhookDeliveryPage struct {
+	Items      []WebhookDelivery `json:"items"`
+	NextCursor string            `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookRequest_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request WebhookRequest
+		valid   bool
+	}{
+		{name: "minimal", request: WebhookRequest{URL: "https://partner.example.com/hooks"}, valid: true},
+		{name: "with secret and events", request: WebhookRequest{URL: "http://localhost:9000", Secret: "0123456789abcdef", Events: []string{EventCustomerCreated}}, valid: true},
+		{name: "relative url", request: WebhookRequest{URL: "/hooks"}},
+		{name: "unsupported scheme", request: WebhookRequest{URL: "ftp://example.com"}},
+		{name: "short secret", request: WebhookRequest{URL: "https://example.com", Secret: "short"}},
+		{name: "unknown event", request: WebhookRequest{URL: "https://example.com", Events: []string{"customer.viewed"}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWebhook_Subscribes(t *testing.T) {
+	all := Webhook{}
+	assert.True(t, all.Subscribes(EventCustomerDeleted))
+
+	some := Webhook{Events: []string{EventCustomerCreated, EventCustomerMerged}}
+	assert.True(t, some.Subscribes(EventCustomerMerged))
+	assert.False(t, some.Subscribes(EventCustomerDeleted))
+}
@@ -0,0 +1,132 @@
+package pubsub
+
+import (
+	"sync"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// subscriberBuffer is the number of messages a subscriber can fall behind
+// before it is dropped. A dropped subscriber reconnects and resumes from the
+// replay buffer.
+const subscriberBuffer = 64
+
+// Message is a customer change broadcast to subscribers. Customer is nil for deletes.
+type Message struct {
+	ID         string
+	Type       string
+	CustomerID string
+	Customer   *models.Customer
+}
+
+// Subscription receives the messages published after it was created. C is
+// closed when the subscription ends or falls too far behind.
+type Subscription struct {
+	C <-chan Message
+	c chan Message
+	// after skips the messages the subscriber already received from another
+	// broker that is ahead of this one
+	after string
+}
+
+// Broker broadcasts customer changes to the subscribers of this process and
+// keeps the latest messages so subscribers can resume after reconnecting.
+// Message IDs are fixed-width decimal numbers increasing with every change,
+// such as the sequence numbers of the customers table's stream, so every
+// process gives a change the same ID and subscribers can resume on any of them.
+type Broker struct {
+	mu          sync.Mutex
+	replay      []Message
+	replaySize  int
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker keeping the latest replaySize messages
+func NewBroker(replaySize int) *Broker {
+	return &Broker{
+		replaySize:  max(replaySize, 0),
+		subscribers: map[*Subscription]struct{}{},
+	}
+}
+
+// Publish broadcasts a change of a customer
+func (b *Broker) Publish(message Message) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.replaySize > 0 {
+		if len(b.replay) == b.replaySize {
+			b.replay = append(b.replay[:0], b.replay[1:]...)
+		}
+		b.replay = append(b.replay, message)
+	}
+
+	for sub := range b.subscribers {
+		if message.ID <= sub.after {
+			continue
+		}
+		select {
+		case sub.c <- message:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Subscribe starts a subscription. When lastID is the ID of a message still
+// held, the messages published after it are returned to be sent first, and
+// when it is newer than every message held, the subscription starts after it.
+// complete is false when lastID is unknown or too old to resume from, in
+// which case the subscriber has missed messages.
+func (b *Broker) Subscribe(lastID string) (replay []Message, complete bool, sub *Subscription) {
+	c := make(chan Message, subscriberBuffer)
+	sub = &Subscription{C: c, c: c}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subscribers[sub] = struct{}{}
+
+	if lastID == "" {
+		return nil, true, sub
+	}
+	if len(b.replay) == 0 || !b.validID(lastID) {
+		return nil, false, sub
+	}
+
+	for i, message := range b.replay {
+		if message.ID == lastID {
+			return append(replay, b.replay[i+1:]...), true, sub
+		}
+	}
+	// The subscriber was connected to a process ahead of this one
+	if lastID > b.replay[len(b.replay)-1].ID {
+		sub.after = lastID
+		return nil, true, sub
+	}
+	return nil, false, sub
+}
+
+// Unsubscribe ends a subscription
+func (b *Broker) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subscribers[sub]; ok {
+		delete(b.subscribers, sub)
+		close(sub.c)
+	}
+}
+
+// validID reports whether id has the form of the IDs held
+func (b *Broker) validID(id string) bool {
+	if len(id) != len(b.replay[0].ID) {
+		return false
+	}
+	for _, r := range id {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,122 @@
+package pubsub
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// messageIDs returns the IDs of messages
+func messageIDs(messages []Message) []string {
+	var ids []string
+	for _, message := range messages {
+		ids = append(ids, message.ID)
+	}
+	return ids
+}
+
+// change builds the message of the seq-th change, to customerID
+func change(seq int, eventType, customerID string) Message {
+	return Message{ID: fmt.Sprintf("%05d", seq), Type: eventType, CustomerID: customerID}
+}
+
+func TestBroker_PublishReachesSubscribers(t *testing.T) {
+	broker := NewBroker(10)
+	_, complete, sub := broker.Subscribe("")
+	require.True(t, complete)
+
+	broker.Publish(change(1, models.EventCustomerCreated, "c1"))
+
+	message := <-sub.C
+	assert.Equal(t, models.EventCustomerCreated, message.Type)
+	assert.Equal(t, "c1", message.CustomerID)
+
+	broker.Unsubscribe(sub)
+	_, open := <-sub.C
+	assert.False(t, open)
+}
+
+func TestBroker_ResumeFromReplay(t *testing.T) {
+	broker := NewBroker(10)
+	_, _, first := broker.Subscribe("")
+	for i, id := range []string{"c1", "c2", "c3"} {
+		broker.Publish(change(i+1, models.EventCustomerUpdated, id))
+	}
+	seen := <-first.C
+
+	replay, complete, _ := broker.Subscribe(seen.ID)
+
+	assert.True(t, complete)
+	require.Len(t, replay, 2)
+	assert.Equal(t, "c2", replay[0].CustomerID)
+	assert.Equal(t, "c3", replay[1].CustomerID)
+}
+
+func TestBroker_ResumeTooOld(t *testing.T) {
+	broker := NewBroker(2)
+	for i, id := range []string{"c1", "c2", "c3", "c4"} {
+		broker.Publish(change(i+1, models.EventCustomerDeleted, id))
+	}
+
+	replay, complete, _ := broker.Subscribe(change(1, "", "").ID)
+
+	assert.False(t, complete)
+	assert.Empty(t, replay)
+
+	// The oldest message held still resumes
+	replay, complete, _ = broker.Subscribe(change(3, "", "").ID)
+	assert.True(t, complete)
+	assert.Equal(t, []string{"00004"}, messageIDs(replay))
+}
+
+func TestBroker_ResumeFromBrokerAhead(t *testing.T) {
+	// Another process has already published the next two changes
+	broker := NewBroker(10)
+	broker.Publish(change(1, models.EventCustomerUpdated, "c1"))
+
+	replay, complete, sub := broker.Subscribe(change(3, "", "").ID)
+
+	assert.True(t, complete)
+	assert.Empty(t, replay)
+
+	// Changes are sent once this broker catches up
+	for i := 2; i <= 4; i++ {
+		broker.Publish(change(i, models.EventCustomerUpdated, "c1"))
+	}
+	assert.Equal(t, "00004", (<-sub.C).ID)
+}
+
+func TestBroker_ResumeUnknownID(t *testing.T) {
+	broker := NewBroker(10)
+	_, complete, _ := broker.Subscribe("00001")
+	assert.False(t, complete, "nothing published yet")
+
+	broker.Publish(change(2, models.EventCustomerCreated, "c1"))
+	broker.Publish(change(4, models.EventCustomerCreated, "c2"))
+
+	for _, id := range []string{"garbage", "0000x", "99", "00003"} {
+		_, complete, _ := broker.Subscribe(id)
+		assert.False(t, complete, id)
+	}
+}
+
+func TestBroker_DropsSlowSubscribers(t *testing.T) {
+	broker := NewBroker(0)
+	_, _, sub := broker.Subscribe("")
+
+	for i := 0; i <= subscriberBuffer; i++ {
+		broker.Publish(change(i+1, models.EventCustomerUpdated, "c1"))
+	}
+
+	var received []Message
+	for message := range sub.C {
+		received = append(received, message)
+	}
+	assert.Len(t, received, subscriberBuffer)
+	assert.Equal(t, "00001", messageIDs(received)[0])
+	// Unsubscribing a dropped subscription is harmless
+	broker.Unsubscribe(sub)
+}
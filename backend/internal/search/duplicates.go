@@ -0,0 +1,200 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// Signal weights combine as independent evidence, so a matching email alone
+// is enough to flag a pair while a name alone is not
+var signalWeights = struct{ email, telephone, name float64 }{email: 0.9, telephone: 0.7, name: 0.6}
+
+const (
+	// minNameSimilarity is the name similarity below which names count as different
+	minNameSimilarity = 0.75
+	// minTelephoneDigits is the shortest telephone number compared, so
+	// extensions and placeholders don't pair unrelated customers
+	minTelephoneDigits = 7
+	// telephoneDigits is the number of trailing digits compared, dropping
+	// country and trunk prefixes that are written inconsistently
+	telephoneDigits = 10
+	// maxBlockSize skips name blocks so common that comparing every pair in
+	// them would be quadratic in the size of the customer base
+	maxBlockSize = 200
+)
+
+// DuplicatePair is two documents that probably describe the same customer,
+// with the similarity of each attribute between 0 and 1
+type DuplicatePair struct {
+	IDs       [2]string
+	Score     float64
+	Email     float64
+	Telephone float64
+	Name      float64
+}
+
+// NormalizeEmail lowercases an email address and drops any "+tag" from its
+// local part
+func NormalizeEmail(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	local, domain, ok := strings.Cut(email, "@")
+	if !ok {
+		return email
+	}
+	local, _, _ = strings.Cut(local, "+")
+	return local + "@" + domain
+}
+
+// NormalizeTelephone keeps the trailing digits of a telephone number, or
+// returns "" when it has too few digits to compare
+func NormalizeTelephone(telephone string) string {
+	var digits strings.Builder
+	for _, r := range telephone {
+		if r >= '0' && r <= '9' {
+			digits.WriteRune(r)
+		}
+	}
+
+	normalized := digits.String()
+	if len(normalized) < minTelephoneDigits {
+		return ""
+	}
+	return normalized[max(len(normalized)-telephoneDigits, 0):]
+}
+
+// normalizeName sorts the tokens of a name so word order doesn't matter
+func normalizeName(name string) string {
+	tokens := Tokenize(name)
+	sort.Strings(tokens)
+	return strings.Join(tokens, " ")
+}
+
+// NameSimilarity compares two names regardless of case, punctuation and
+// word order, returning 1 for identical names
+func NameSimilarity(a, b string) float64 {
+	return nameSimilarity(normalizeName(a), normalizeName(b))
+}
+
+func nameSimilarity(a, b string) float64 {
+	if a == "" || b == "" {
+		return 0
+	}
+	if a == b {
+		return 1
+	}
+
+	ra, rb := []rune(a), []rune(b)
+	longest := max(len(ra), len(rb))
+	return 1 - float64(editDistance(ra, rb, longest))/float64(longest)
+}
+
+// duplicateCandidate is a document with its normalized attributes
+type duplicateCandidate struct {
+	id        string
+	email     string
+	telephone string
+	name      string
+}
+
+func newDuplicateCandidate(doc Document) duplicateCandidate {
+	return duplicateCandidate{
+		id:        doc.ID,
+		email:     NormalizeEmail(doc.Email),
+		telephone: NormalizeTelephone(doc.Telephone),
+		name:      normalizeName(doc.Name),
+	}
+}
+
+// ScorePair scores how likely two documents are to describe the same customer
+func ScorePair(a, b Document) DuplicatePair {
+	return scoreCandidates(newDuplicateCandidate(a), newDuplicateCandidate(b))
+}
+
+func scoreCandidates(a, b duplicateCandidate) DuplicatePair {
+	pair := DuplicatePair{IDs: [2]string{a.id, b.id}}
+	if a.email != "" && a.email == b.email {
+		pair.Email = 1
+	}
+	if a.telephone != "" && a.telephone == b.telephone {
+		pair.Telephone = 1
+	}
+	pair.Name = nameSimilarity(a.name, b.name)
+
+	name := pair.Name
+	if name < minNameSimilarity {
+		name = 0
+	}
+	pair.Score = 1 - (1-signalWeights.email*pair.Email)*
+		(1-signalWeights.telephone*pair.Telephone)*
+		(1-signalWeights.name*name)
+	return pair
+}
+
+// FindDuplicates returns the pairs of documents scoring at least minScore,
+// best first. Only documents sharing an email, a telephone number or a name
+// token are compared.
+func FindDuplicates(docs []Document, minScore float64) []DuplicatePair {
+	candidates := make([]duplicateCandidate, len(docs))
+	blocks := map[string][]int{}
+	for i, doc := range docs {
+		candidate := newDuplicateCandidate(doc)
+		candidates[i] = candidate
+
+		if candidate.email != "" {
+			blocks["email:"+candidate.email] = append(blocks["email:"+candidate.email], i)
+		}
+		if candidate.telephone != "" {
+			blocks["telephone:"+candidate.telephone] = append(blocks["telephone:"+candidate.telephone], i)
+		}
+		for _, token := range uniqueTokens(candidate.name) {
+			blocks["name:"+token] = append(blocks["name:"+token], i)
+		}
+	}
+
+	compared := map[[2]int]bool{}
+	var pairs []DuplicatePair
+	for key, members := range blocks {
+		if strings.HasPrefix(key, "name:") && len(members) > maxBlockSize {
+			continue
+		}
+		for x := 0; x < len(members); x++ {
+			for y := x + 1; y < len(members); y++ {
+				i, j := members[x], members[y]
+				if compared[[2]int{i, j}] {
+					continue
+				}
+				compared[[2]int{i, j}] = true
+
+				pair := scoreCandidates(candidates[i], candidates[j])
+				if pair.Score >= minScore {
+					if pair.IDs[1] < pair.IDs[0] {
+						pair.IDs[0], pair.IDs[1] = pair.IDs[1], pair.IDs[0]
+					}
+					pairs = append(pairs, pair)
+				}
+			}
+		}
+	}
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].Score != pairs[j].Score {
+			return pairs[i].Score > pairs[j].Score
+		}
+		if pairs[i].IDs[0] != pairs[j].IDs[0] {
+			return pairs[i].IDs[0] < pairs[j].IDs[0]
+		}
+		return pairs[i].IDs[1] < pairs[j].IDs[1]
+	})
+	return pairs
+}
+
+// uniqueTokens splits a normalized name into its distinct tokens
+func uniqueTokens(name string) []string {
+	var tokens []string
+	for _, token := range strings.Fields(name) {
+		if len(tokens) == 0 || tokens[len(tokens)-1] != token {
+			tokens = append(tokens, token)
+		}
+	}
+	return tokens
+}
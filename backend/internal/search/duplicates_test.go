@@ -0,0 +1,69 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeEmail(t *testing.T) {
+	assert.Equal(t, "john.doe@example.com", NormalizeEmail(" John.Doe@Example.com "))
+	assert.Equal(t, "john@example.com", NormalizeEmail("john+newsletter@example.com"))
+	assert.Equal(t, "not-an-email", NormalizeEmail("not-an-email"))
+}
+
+func TestNormalizeTelephone(t *testing.T) {
+	assert.Equal(t, "3499991234", NormalizeTelephone("+55 34 9999-1234"))
+	assert.Equal(t, NormalizeTelephone("(555) 123-4567"), NormalizeTelephone("+1 555 123 4567"))
+	assert.Empty(t, NormalizeTelephone("ext. 12"))
+}
+
+func TestNameSimilarity(t *testing.T) {
+	assert.Equal(t, 1.0, NameSimilarity("Doe, John", "john doe"))
+	assert.Greater(t, NameSimilarity("John Smith", "Jon Smith"), 0.85)
+	assert.Less(t, NameSimilarity("John Smith", "Alice Walker"), 0.5)
+	assert.Equal(t, 0.0, NameSimilarity("", "John"))
+}
+
+func TestScorePair(t *testing.T) {
+	sameEmail := ScorePair(
+		Document{ID: "1", Name: "John Smith", Email: "John.Smith@example.com"},
+		Document{ID: "2", Name: "J. Smith", Email: "john.smith@example.com"},
+	)
+	assert.Equal(t, 1.0, sameEmail.Email)
+	assert.GreaterOrEqual(t, sameEmail.Score, 0.9)
+
+	phoneAndName := ScorePair(
+		Document{ID: "1", Name: "John Smith", Email: "john@work.com", Telephone: "(555) 123-4567"},
+		Document{ID: "2", Name: "Jon Smith", Email: "johnny@home.com", Telephone: "+1 555 123 4567"},
+	)
+	assert.Equal(t, 0.0, phoneAndName.Email)
+	assert.Equal(t, 1.0, phoneAndName.Telephone)
+	assert.Greater(t, phoneAndName.Score, 0.8)
+
+	// A shared household telephone with a different name is weaker evidence
+	household := ScorePair(
+		Document{ID: "1", Name: "John Smith", Telephone: "555 123 4567"},
+		Document{ID: "2", Name: "Mary Smith", Telephone: "555 123 4567"},
+	)
+	assert.Less(t, household.Score, phoneAndName.Score)
+}
+
+func TestFindDuplicates(t *testing.T) {
+	docs := []Document{
+		{ID: "a", Name: "John Smith", Email: "john.smith@example.com", Telephone: "555 123 4567"},
+		{ID: "b", Name: "Alice Walker", Email: "alice@example.com"},
+		{ID: "c", Name: "Smith John", Email: "JOHN.SMITH@example.com"},
+		{ID: "d", Name: "Jon Smith", Email: "jsmith@other.com", Telephone: "+1 (555) 123-4567"},
+		{ID: "e", Name: "Alice Cooper", Email: "cooper@example.com"},
+	}
+
+	pairs := FindDuplicates(docs, 0.75)
+
+	// c and d only share a similar name, which isn't enough on its own
+	require.Len(t, pairs, 2)
+	assert.Equal(t, [2]string{"a", "c"}, pairs[0].IDs)
+	assert.Equal(t, [2]string{"a", "d"}, pairs[1].IDs)
+	assert.Greater(t, pairs[0].Score, pairs[1].Score)
+}
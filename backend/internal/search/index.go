@@ -0,0 +1,483 @@
+package search
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Field identifies the customer attribute a token was found in
+type Field uint8
+
+const (
+	// FieldName, FieldEmail and FieldTelephone are the indexed attributes
+	FieldName Field = 1 << iota
+	FieldEmail
+	FieldTelephone
+
+	// AllFields searches every indexed attribute
+	AllFields = FieldName | FieldEmail | FieldTelephone
+)
+
+// fieldWeights favours matches on the name over matches on contact details
+var fieldWeights = map[Field]float64{FieldName: 1, FieldEmail: 0.8, FieldTelephone: 0.8}
+
+const (
+	exactScore  = 1.0
+	prefixScore = 0.8
+	fuzzyScore  = 0.6
+	// minFuzzyLength is the shortest query token matched with typos, since
+	// nearly every short token is within one edit of another
+	minFuzzyLength = 3
+	// termMergeThreshold is the number of tokens added or removed since the
+	// sorted token list was rebuilt above which a search rebuilds it
+	termMergeThreshold = 256
+)
+
+// Document holds the searchable attributes of a customer
+type Document struct {
+	ID        string
+	Name      string
+	Email     string
+	Telephone string
+}
+
+// Result is a matching document ID and its relevance, higher being better
+type Result struct {
+	ID    string
+	Score float64
+}
+
+// Index is an in-memory inverted index over customer names, emails and
+// telephones. It is safe for concurrent use.
+type Index struct {
+	mu sync.RWMutex
+	// postings maps each token to the documents containing it and the fields it was found in
+	postings map[string]map[string]Field
+	// terms lists the tokens in order, so prefix matches are a contiguous run
+	// and fuzzy matching can skip every token sharing a prefix too far from the
+	// query. Tokens added since the last merge are kept in added, and tokens
+	// removed are only dropped from terms by the next merge.
+	terms   []string
+	added   []string
+	removed int
+	// docs keeps each document's name for tie-breaking and its tokens for removal
+	docs map[string]indexedDocument
+}
+
+type indexedDocument struct {
+	name   string
+	tokens []string
+}
+
+// NewIndex creates an empty index
+func NewIndex() *Index {
+	return &Index{
+		postings: map[string]map[string]Field{},
+		docs:     map[string]indexedDocument{},
+	}
+}
+
+// Put adds a document to the index, replacing any previous version of it
+func (idx *Index) Put(doc Document) {
+	fields := map[string]Field{}
+	for _, token := range Tokenize(doc.Name) {
+		fields[token] |= FieldName
+	}
+	for _, token := range Tokenize(doc.Email) {
+		fields[token] |= FieldEmail
+	}
+	for _, token := range telephoneTokens(doc.Telephone) {
+		fields[token] |= FieldTelephone
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(doc.ID)
+	indexed := indexedDocument{name: strings.ToLower(doc.Name)}
+	for token, field := range fields {
+		if idx.postings[token] == nil {
+			idx.postings[token] = map[string]Field{}
+			idx.added = append(idx.added, token)
+		}
+		idx.postings[token][doc.ID] = field
+		indexed.tokens = append(indexed.tokens, token)
+	}
+	idx.docs[doc.ID] = indexed
+
+	// Writes only rebuild the list once it has changed by as many tokens as
+	// it holds, so loading the index doesn't rebuild it for every few documents
+	if len(idx.added)+idx.removed >= max(termMergeThreshold, len(idx.terms)) {
+		idx.mergeTerms()
+	}
+}
+
+// Remove drops a document from the index
+func (idx *Index) Remove(id string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.remove(id)
+}
+
+func (idx *Index) remove(id string) {
+	doc, ok := idx.docs[id]
+	if !ok {
+		return
+	}
+	for _, token := range doc.tokens {
+		delete(idx.postings[token], id)
+		if len(idx.postings[token]) == 0 {
+			delete(idx.postings, token)
+			idx.removed++
+		}
+	}
+	delete(idx.docs, id)
+}
+
+// rLockMerged read locks the index, first merging the tokens changed since
+// the last merge when there are too many to compare one by one
+func (idx *Index) rLockMerged() {
+	idx.mu.RLock()
+	if len(idx.added)+idx.removed <= termMergeThreshold {
+		return
+	}
+	idx.mu.RUnlock()
+
+	idx.mu.Lock()
+	// Another search may have merged them while the lock was released
+	if len(idx.added)+idx.removed > termMergeThreshold {
+		idx.mergeTerms()
+	}
+	idx.mu.Unlock()
+	idx.mu.RLock()
+}
+
+// mergeTerms sorts the added tokens into terms and drops the removed ones
+func (idx *Index) mergeTerms() {
+	sort.Strings(idx.added)
+
+	merged := make([]string, 0, len(idx.terms)+len(idx.added))
+	i, j := 0, 0
+	for i < len(idx.terms) || j < len(idx.added) {
+		var term string
+		if j == len(idx.added) || (i < len(idx.terms) && idx.terms[i] < idx.added[j]) {
+			term, i = idx.terms[i], i+1
+		} else {
+			term, j = idx.added[j], j+1
+		}
+		// A token removed and added again before the merge appears in both lists
+		if idx.postings[term] == nil || (len(merged) > 0 && merged[len(merged)-1] == term) {
+			continue
+		}
+		merged = append(merged, term)
+	}
+
+	idx.terms, idx.added, idx.removed = merged, nil, 0
+}
+
+// Len returns the number of indexed documents
+func (idx *Index) Len() int {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return len(idx.docs)
+}
+
+// Search returns up to limit documents matching every token of the query in
+// the given fields, best matches first. Tokens match exactly, as a prefix of
+// an indexed token, or within a small edit distance, so "jon smth" finds
+// "John Smith".
+func (idx *Index) Search(query string, fields Field, limit int) []Result {
+	queryTokens := Tokenize(query)
+	if len(queryTokens) == 0 || limit < 1 {
+		return nil
+	}
+
+	idx.rLockMerged()
+	defer idx.mu.RUnlock()
+
+	// scores accumulates the best match of each query token per document. A
+	// document stays a candidate only while every token so far has matched.
+	var scores map[string]float64
+	for i, queryToken := range queryTokens {
+		best := map[string]float64{}
+		for token, score := range idx.matchTokens(queryToken) {
+			for id, found := range idx.postings[token] {
+				if found&fields == 0 {
+					continue
+				}
+				if weighted := score * bestWeight(found&fields); weighted > best[id] {
+					best[id] = weighted
+				}
+			}
+		}
+
+		if i == 0 {
+			scores = best
+			continue
+		}
+		for id, score := range scores {
+			if matched, ok := best[id]; ok {
+				scores[id] = score + matched
+			} else {
+				delete(scores, id)
+			}
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		a, b := idx.docs[results[i].ID].name, idx.docs[results[j].ID].name
+		if a != b {
+			return a < b
+		}
+		return results[i].ID < results[j].ID
+	})
+
+	if len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// matchTokens scores the indexed tokens matching a query token. Exact and
+// prefix matches are looked up in the sorted tokens, then typos are matched
+// by walking them with a bounded edit distance.
+func (idx *Index) matchTokens(queryToken string) map[string]float64 {
+	scores := map[string]float64{}
+	for i := sort.SearchStrings(idx.terms, queryToken); i < len(idx.terms) && strings.HasPrefix(idx.terms[i], queryToken); i++ {
+		if idx.terms[i] == queryToken {
+			scores[idx.terms[i]] = exactScore
+		} else {
+			scores[idx.terms[i]] = prefixScore
+		}
+	}
+
+	if query := []rune(queryToken); len(query) >= minFuzzyLength {
+		walkWithinDistance(idx.terms, query, maxEditDistance(len(query)), func(token string, distance int) {
+			if _, ok := scores[token]; !ok {
+				scores[token] = fuzzyMatchScore(distance, len(query))
+			}
+		})
+	}
+
+	// Tokens added since the last merge are few enough to compare one by one
+	for _, token := range idx.added {
+		if score := matchScore(queryToken, token); score > scores[token] {
+			scores[token] = score
+		}
+	}
+	return scores
+}
+
+// walkWithinDistance calls visit with every sorted term within maxDistance
+// edits of query, or whose start is. The terms are walked like a trie: the
+// distance rows of a shared prefix are reused, and once every alignment of a
+// prefix is too far the terms starting with it are skipped.
+func walkWithinDistance(terms []string, query []rune, maxDistance int, visit func(term string, distance int)) {
+	// rows[d][j] is the distance between the first d runes of the current term and the first j of query
+	first := make([]int, len(query)+1)
+	for j := range first {
+		first[j] = j
+	}
+	rows := [][]int{first}
+
+	// The runes of the current and previous terms, swapped to avoid allocating for each term
+	var term, previous []rune
+	for i := 0; i < len(terms); {
+		term, previous = append(previous[:0], []rune(terms[i])...), term
+		depth := min(commonPrefixLength(previous, term), len(rows)-1)
+		rows = rows[:depth+1]
+
+		skipped := false
+		for ; depth < len(term); depth++ {
+			// Rows beyond the shared prefix are overwritten rather than allocated again
+			if len(rows) < cap(rows) {
+				rows = rows[:len(rows)+1]
+			} else {
+				rows = append(rows, nil)
+			}
+			if rows[depth+1] == nil {
+				rows[depth+1] = make([]int, len(query)+1)
+			}
+			row := rows[depth+1]
+			fillDistanceRow(rows, query, term, depth)
+
+			// The start of a longer term may still match once past the query's length
+			if rowMin(row) > maxDistance && (depth+1 <= len(query) || rows[len(query)][len(query)] > maxDistance) {
+				prefix := string(term[:depth+1])
+				next := i + 1
+				i = next + sort.Search(len(terms)-next, func(k int) bool {
+					return !strings.HasPrefix(terms[next+k], prefix)
+				})
+				skipped = true
+				break
+			}
+		}
+		if skipped {
+			continue
+		}
+
+		distance := rows[len(term)][len(query)]
+		if len(term) > len(query) {
+			distance = min(distance, rows[len(query)][len(query)])
+		}
+		if distance <= maxDistance {
+			visit(terms[i], distance)
+		}
+		i++
+	}
+}
+
+// fillDistanceRow computes rows[depth+1], the distance row of the first
+// depth+1 runes of term, from the rows of its shorter prefixes as editDistance does
+func fillDistanceRow(rows [][]int, query, term []rune, depth int) {
+	prev, row := rows[depth], rows[depth+1]
+	row[0] = depth + 1
+	for j := 1; j <= len(query); j++ {
+		cost := 1
+		if query[j-1] == term[depth] {
+			cost = 0
+		}
+		row[j] = min(prev[j]+1, row[j-1]+1, prev[j-1]+cost)
+		if depth > 0 && j > 1 && query[j-1] == term[depth-1] && query[j-2] == term[depth] {
+			row[j] = min(row[j], rows[depth-1][j-2]+1)
+		}
+	}
+}
+
+// commonPrefixLength returns the number of leading runes a and b share
+func commonPrefixLength(a, b []rune) int {
+	n := 0
+	for n < len(a) && n < len(b) && a[n] == b[n] {
+		n++
+	}
+	return n
+}
+
+// rowMin returns the smallest value of a non-empty row
+func rowMin(row []int) int {
+	smallest := row[0]
+	for _, value := range row[1:] {
+		smallest = min(smallest, value)
+	}
+	return smallest
+}
+
+// bestWeight returns the highest weight among the fields a token was found in
+func bestWeight(found Field) float64 {
+	var weight float64
+	for field, w := range fieldWeights {
+		if found&field != 0 && w > weight {
+			weight = w
+		}
+	}
+	return weight
+}
+
+// matchScore scores how well a query token matches an indexed token, 0 meaning no match
+func matchScore(queryToken, token string) float64 {
+	if queryToken == token {
+		return exactScore
+	}
+	if strings.HasPrefix(token, queryToken) {
+		return prefixScore
+	}
+
+	query, target := []rune(queryToken), []rune(token)
+	if len(query) < minFuzzyLength {
+		return 0
+	}
+
+	maxDistance := maxEditDistance(len(query))
+	// Also compare against the start of longer tokens so typos in a prefix still match
+	distance := editDistance(query, target, maxDistance)
+	if len(target) > len(query) {
+		if d := editDistance(query, target[:len(query)], maxDistance); d < distance {
+			distance = d
+		}
+	}
+	if distance > maxDistance {
+		return 0
+	}
+	return fuzzyMatchScore(distance, len(query))
+}
+
+// maxEditDistance returns the number of typos tolerated in a query token of length runes
+func maxEditDistance(length int) int {
+	if length > 5 {
+		return 2
+	}
+	return 1
+}
+
+// fuzzyMatchScore scores a typo match, lower the more edits it took
+func fuzzyMatchScore(distance, length int) float64 {
+	return fuzzyScore * (1 - float64(distance)/float64(length+1))
+}
+
+// editDistance returns the optimal string alignment distance between a and b,
+// counting insertions, deletions, substitutions and adjacent transpositions.
+// It returns max+1 as soon as the distance is known to exceed max.
+func editDistance(a, b []rune, max int) int {
+	if diff := len(a) - len(b); diff > max || -diff > max {
+		return max + 1
+	}
+
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				curr[j] = min(curr[j], prev2[j-2]+1)
+			}
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > max {
+			return max + 1
+		}
+		prev2, prev, curr = prev, curr, prev2
+	}
+
+	return prev[len(b)]
+}
+
+// Tokenize lowercases text and splits it into runs of letters and digits
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// telephoneTokens indexes a telephone number both as its groups and as all of
+// its digits, so "555 0123" is found by "0123" and by "5550123"
+func telephoneTokens(telephone string) []string {
+	tokens := Tokenize(telephone)
+	var digits strings.Builder
+	for _, token := range tokens {
+		digits.WriteString(token)
+	}
+	if len(tokens) > 1 {
+		tokens = append(tokens, digits.String())
+	}
+	return tokens
+}
@@ -0,0 +1,126 @@
+package search
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testIndex() *Index {
+	idx := NewIndex()
+	idx.Put(Document{ID: "1", Name: "John Smith", Email: "john.smith@example.com", Telephone: "+1 555 0123"})
+	idx.Put(Document{ID: "2", Name: "Jane Smithers", Email: "jane@example.org", Telephone: "555-0456"})
+	idx.Put(Document{ID: "3", Name: "Bob Jones", Email: "bob@jones.dev"})
+	return idx
+}
+
+func ids(results []Result) []string {
+	var ids []string
+	for _, r := range results {
+		ids = append(ids, r.ID)
+	}
+	return ids
+}
+
+func TestSearch_Fuzzy(t *testing.T) {
+	results := testIndex().Search("jon smth", AllFields, 10)
+
+	require.NotEmpty(t, results)
+	assert.Equal(t, "1", results[0].ID)
+}
+
+func TestSearch_PrefixRanksBelowExact(t *testing.T) {
+	results := testIndex().Search("smith", AllFields, 10)
+
+	assert.Equal(t, []string{"1", "2"}, ids(results))
+	assert.Greater(t, results[0].Score, results[1].Score)
+}
+
+func TestSearch_AllTokensMustMatch(t *testing.T) {
+	assert.Empty(t, testIndex().Search("jane walker", AllFields, 10))
+}
+
+func TestSearch_Fields(t *testing.T) {
+	idx := testIndex()
+
+	assert.Equal(t, []string{"3"}, ids(idx.Search("jones.dev", AllFields, 10)))
+	// "dev" only appears in an email
+	assert.Empty(t, idx.Search("dev", FieldName, 10))
+}
+
+func TestSearch_Telephone(t *testing.T) {
+	idx := testIndex()
+
+	assert.Equal(t, []string{"1"}, ids(idx.Search("0123", AllFields, 10)))
+	assert.Equal(t, []string{"1"}, ids(idx.Search("15550123", AllFields, 10)))
+}
+
+func TestSearch_Limit(t *testing.T) {
+	assert.Len(t, testIndex().Search("s", AllFields, 1), 1)
+}
+
+func TestIndex_PutReplacesAndRemove(t *testing.T) {
+	idx := testIndex()
+
+	idx.Put(Document{ID: "1", Name: "Johnny Walker"})
+	assert.Equal(t, []string{"2"}, ids(idx.Search("smith", AllFields, 10)))
+	assert.Equal(t, []string{"1"}, ids(idx.Search("walker", AllFields, 10)))
+
+	idx.Remove("1")
+	assert.Empty(t, idx.Search("walker", AllFields, 10))
+	assert.Equal(t, 2, idx.Len())
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"smth", "smith", 1},
+		{"jhon", "john", 1},
+		{"kitten", "sitting", 3},
+		{"abc", "abc", 0},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.expected, editDistance([]rune(tt.a), []rune(tt.b), 3), "%s -> %s", tt.a, tt.b)
+	}
+	assert.Equal(t, 2, editDistance([]rune("kitten"), []rune("sitting"), 1))
+}
+
+func TestIndex_MatchTokensAgreesWithMatchScore(t *testing.T) {
+	idx := NewIndex()
+	words := []string{"john", "jon", "joan", "johnson", "jonas", "smith", "smyth", "smithers", "smit", "mits", "ana", "anna", "hannah", "xohn", "ojhn"}
+	for i := 0; i < termMergeThreshold; i++ {
+		idx.Put(Document{ID: fmt.Sprint(i), Name: fmt.Sprintf("%s %s%d", words[i%len(words)], words[(i*7)%len(words)], i)})
+	}
+	idx.Put(Document{ID: "late", Name: "Jhon Smiths"})
+	require.NotEmpty(t, idx.terms, "tokens are merged into the sorted list")
+	require.NotEmpty(t, idx.added)
+
+	for _, query := range []string{"john", "jhon", "smth", "smithres", "an", "hanna", "jo", "smith1", "ohn"} {
+		expected := map[string]float64{}
+		for token := range idx.postings {
+			if score := matchScore(query, token); score > 0 {
+				expected[token] = score
+			}
+		}
+		assert.Equal(t, expected, idx.matchTokens(query), query)
+	}
+}
+
+func TestIndex_MergeDropsRemovedTokens(t *testing.T) {
+	idx := NewIndex()
+	for i := 0; i < termMergeThreshold; i++ {
+		idx.Put(Document{ID: fmt.Sprint(i), Name: fmt.Sprintf("name%d", i)})
+	}
+	for i := 0; i < termMergeThreshold; i++ {
+		idx.Remove(fmt.Sprint(i))
+	}
+	idx.Put(Document{ID: "1", Name: "name1"})
+
+	assert.Equal(t, []string{"name1"}, idx.terms)
+	assert.Equal(t, []string{"1"}, ids(idx.Search("name1", AllFields, 10)))
+}
@@ -0,0 +1,334 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+const (
+	// pollInterval is how often the consumer reads shards once it has caught up
+	pollInterval = time.Second
+	// recordsLimit is the most records read from a shard at once
+	recordsLimit = 1000
+)
+
+// Handler processes a change to a customer. An error stops the shard at the
+// record, which is passed to every handler again on a later pass, so handlers
+// must tolerate seeing a change more than once.
+type Handler func(ctx context.Context, record Record) error
+
+type namedHandler struct {
+	name   string
+	handle Handler
+}
+
+// Consumer reads the stream of the customers table and passes every change to
+// its handlers, whatever process made it. Shards are read after their parents
+// so a customer's changes arrive in order.
+//
+// How far each shard was read is checkpointed under the consumer's name,
+// either in a table, so a restart resumes where it stopped, or in memory.
+// A consumer without checkpoints starts at the latest change.
+type Consumer struct {
+	client           *db.Client
+	streams          *dynamodbstreams.Client
+	tableName        string
+	name             string
+	checkpointsTable string
+	handlers         []namedHandler
+
+	streamARN   string
+	checkpoints map[string]models.StreamCheckpoint
+	// iterators continue the shards being read, latest marks the new shards
+	// to start at their end and retries holds the sequence number of a record
+	// a handler failed, read again before anything after it
+	iterators map[string]string
+	latest    map[string]bool
+	retries   map[string]string
+}
+
+// NewConsumer creates a consumer of the stream of tableName. Checkpoints are
+// kept in memory when checkpointsTable is empty.
+func NewConsumer(client *db.Client, streams *dynamodbstreams.Client, tableName, name, checkpointsTable string) *Consumer {
+	return &Consumer{
+		client:           client,
+		streams:          streams,
+		tableName:        tableName,
+		name:             name,
+		checkpointsTable: checkpointsTable,
+		iterators:        map[string]string{},
+		latest:           map[string]bool{},
+		retries:          map[string]string{},
+	}
+}
+
+// Register adds a handler, called after the ones registered before it
+func (c *Consumer) Register(name string, handler Handler) {
+	c.handlers = append(c.handlers, namedHandler{name: name, handle: handler})
+}
+
+// Run reads the stream until ctx is cancelled
+func (c *Consumer) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		// Keep going without waiting while records are coming in
+		for {
+			processed, err := c.Poll(ctx)
+			if err != nil {
+				log.Printf("Failed to read the %s stream: %v", c.tableName, err)
+			}
+			if err != nil || processed == 0 || ctx.Err() != nil {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Poll reads the next records of every shard ready to be read and returns the
+// number processed. A shard that fails is logged and retried on the next
+// pass. The error is only for failures to find the stream's shards.
+func (c *Consumer) Poll(ctx context.Context) (int, error) {
+	if c.streamARN == "" {
+		arn, err := db.CustomerStreamARN(ctx, c.client, c.tableName)
+		if err != nil {
+			return 0, err
+		}
+		c.streamARN = arn
+	}
+
+	shards, err := c.listShards(ctx)
+	if err != nil {
+		// The table may have been recreated with a new stream
+		c.streamARN = ""
+		return 0, err
+	}
+
+	if c.checkpoints == nil {
+		if err := c.loadCheckpoints(ctx, shards); err != nil {
+			return 0, err
+		}
+	}
+
+	listed := map[string]bool{}
+	for _, shard := range shards {
+		listed[aws.ToString(shard.ShardId)] = true
+	}
+
+	processed := 0
+	for _, shard := range shards {
+		if ctx.Err() != nil {
+			break
+		}
+		id := aws.ToString(shard.ShardId)
+		if c.checkpoints[id].Finished {
+			continue
+		}
+		// A parent past the stream's retention is no longer listed
+		if parent := aws.ToString(shard.ParentShardId); listed[parent] && !c.checkpoints[parent].Finished {
+			continue
+		}
+
+		count, err := c.readShard(ctx, id)
+		processed += count
+		if err != nil {
+			log.Printf("Failed to read shard %s of the %s stream: %v", id, c.tableName, err)
+		}
+	}
+	return processed, nil
+}
+
+// listShards returns every shard of the stream, parents before their children
+func (c *Consumer) listShards(ctx context.Context) ([]types.Shard, error) {
+	var shards []types.Shard
+	input := &dynamodbstreams.DescribeStreamInput{StreamArn: aws.String(c.streamARN)}
+	for {
+		result, err := c.streams.DescribeStream(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe stream: %v", err)
+		}
+		shards = append(shards, result.StreamDescription.Shards...)
+		if result.StreamDescription.LastEvaluatedShardId == nil {
+			return shards, nil
+		}
+		input.ExclusiveStartShardId = result.StreamDescription.LastEvaluatedShardId
+	}
+}
+
+// loadCheckpoints reads the saved checkpoints. Without any, the consumer is
+// new: closed shards are skipped and open ones are read from their end.
+func (c *Consumer) loadCheckpoints(ctx context.Context, shards []types.Shard) error {
+	checkpoints := map[string]models.StreamCheckpoint{}
+	if c.checkpointsTable != "" {
+		var err error
+		if checkpoints, err = db.ListStreamCheckpoints(ctx, c.client, c.checkpointsTable, c.name); err != nil {
+			return err
+		}
+	}
+
+	if len(checkpoints) == 0 {
+		for _, shard := range shards {
+			id := aws.ToString(shard.ShardId)
+			if shard.SequenceNumberRange != nil && shard.SequenceNumberRange.EndingSequenceNumber != nil {
+				checkpoints[id] = models.StreamCheckpoint{Consumer: c.name, ShardID: id, Finished: true}
+			} else {
+				c.latest[id] = true
+			}
+		}
+	}
+	c.checkpoints = checkpoints
+	return nil
+}
+
+// readShard processes the next records of a shard, checkpointing the ones
+// handled. It stops at the first record a handler fails.
+func (c *Consumer) readShard(ctx context.Context, shardID string) (int, error) {
+	iterator, err := c.iterator(ctx, shardID)
+	if err != nil {
+		return 0, err
+	}
+
+	result, err := c.streams.GetRecords(ctx, &dynamodbstreams.GetRecordsInput{
+		ShardIterator: aws.String(iterator),
+		Limit:         aws.Int32(recordsLimit),
+	})
+	if err != nil {
+		// An expired iterator is requested again from the checkpoint
+		var expired *types.ExpiredIteratorException
+		if errors.As(err, &expired) {
+			delete(c.iterators, shardID)
+			return 0, nil
+		}
+		// A shard opened at its end has no position to reopen it at
+		// without skipping the changes since, so its iterator is kept
+		if !c.latest[shardID] {
+			delete(c.iterators, shardID)
+		}
+		return 0, fmt.Errorf("failed to get records: %v", err)
+	}
+	if len(result.Records) > 0 {
+		// The shard is now reopened after its checkpoint or at a failed record
+		delete(c.latest, shardID)
+	}
+
+	checkpoint := c.checkpoints[shardID]
+	checkpoint.Consumer, checkpoint.ShardID = c.name, shardID
+
+	processed := 0
+	for _, r := range result.Records {
+		record, err := newRecord(ctx, c.client, r)
+		if err == nil {
+			err = c.dispatch(ctx, record)
+		}
+		if err != nil {
+			// The next pass starts again at this record
+			delete(c.iterators, shardID)
+			if r.Dynamodb != nil {
+				c.retries[shardID] = aws.ToString(r.Dynamodb.SequenceNumber)
+			}
+			if processed > 0 {
+				if saveErr := c.saveCheckpoint(ctx, checkpoint); saveErr != nil {
+					log.Printf("Failed to checkpoint shard %s: %v", shardID, saveErr)
+				}
+			}
+			return processed, fmt.Errorf("record %s: %v", aws.ToString(r.EventID), err)
+		}
+		checkpoint.SequenceNumber = record.SequenceNumber
+		processed++
+	}
+	delete(c.retries, shardID)
+
+	// A closed shard has no next iterator once it has been read to its end
+	if result.NextShardIterator == nil {
+		delete(c.iterators, shardID)
+		checkpoint.Finished = true
+	} else {
+		c.iterators[shardID] = aws.ToString(result.NextShardIterator)
+	}
+
+	if processed > 0 || checkpoint.Finished {
+		if err := c.saveCheckpoint(ctx, checkpoint); err != nil {
+			// The records are handled again after a restart
+			return processed, err
+		}
+	}
+	return processed, nil
+}
+
+// iterator returns the iterator continuing a shard. A shard that isn't being
+// read yet starts at the record last failed, after its checkpoint, or for a
+// new shard at its end.
+func (c *Consumer) iterator(ctx context.Context, shardID string) (string, error) {
+	if iterator, ok := c.iterators[shardID]; ok {
+		return iterator, nil
+	}
+
+	input := &dynamodbstreams.GetShardIteratorInput{
+		StreamArn:         aws.String(c.streamARN),
+		ShardId:           aws.String(shardID),
+		ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+	}
+	checkpoint := c.checkpoints[shardID]
+	switch {
+	case c.retries[shardID] != "":
+		input.ShardIteratorType = types.ShardIteratorTypeAtSequenceNumber
+		input.SequenceNumber = aws.String(c.retries[shardID])
+	case checkpoint.SequenceNumber != "":
+		input.ShardIteratorType = types.ShardIteratorTypeAfterSequenceNumber
+		input.SequenceNumber = aws.String(checkpoint.SequenceNumber)
+	case c.latest[shardID]:
+		input.ShardIteratorType = types.ShardIteratorTypeLatest
+	}
+
+	result, err := c.streams.GetShardIterator(ctx, input)
+	if err != nil {
+		// Records past the stream's retention are gone, continue with the oldest left
+		var trimmed *types.TrimmedDataAccessException
+		if errors.As(err, &trimmed) {
+			log.Printf("Shard %s of the %s stream was trimmed past its checkpoint, changes were missed", shardID, c.tableName)
+			checkpoint.SequenceNumber = ""
+			c.checkpoints[shardID] = checkpoint
+			delete(c.retries, shardID)
+		}
+		return "", fmt.Errorf("failed to get shard iterator: %v", err)
+	}
+	return aws.ToString(result.ShardIterator), nil
+}
+
+// dispatch passes a record to every handler in order
+func (c *Consumer) dispatch(ctx context.Context, record Record) error {
+	for _, handler := range c.handlers {
+		if err := handler.handle(ctx, record); err != nil {
+			return fmt.Errorf("%s handler: %v", handler.name, err)
+		}
+	}
+	return nil
+}
+
+// saveCheckpoint records how far a shard was read, in the checkpoints table
+// when there is one
+func (c *Consumer) saveCheckpoint(ctx context.Context, checkpoint models.StreamCheckpoint) error {
+	if c.checkpointsTable != "" {
+		if err := db.PutStreamCheckpoint(ctx, c.client, c.checkpointsTable, &checkpoint); err != nil {
+			return err
+		}
+	}
+	c.checkpoints[checkpoint.ShardID] = checkpoint
+	return nil
+}
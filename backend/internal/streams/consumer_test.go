@@ -0,0 +1,396 @@
+package streams
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testStreamARN = "arn:aws:dynamodb:us-east-1:000000000000:table/Customers/stream/1"
+
+type fakeShard struct {
+	id      string
+	parent  string
+	closed  bool
+	records []string // customer IDs, numbered from sequenceBase
+}
+
+// fakeStream serves the customers table's stream and the checkpoints table.
+// Iterators are "<shard>|<position>".
+type fakeStream struct {
+	mu          sync.Mutex
+	shards      []*fakeShard
+	checkpoints map[string]map[string]interface{}
+	// getRecordsFailures is how many GetRecords calls fail before they succeed
+	getRecordsFailures int
+}
+
+// sequencePrefix pads the position of a record in its shard to a sequence
+// number, which has at least 21 digits
+const sequencePrefix = "1000000000000000"
+
+func sequenceNumber(position int) string {
+	return fmt.Sprintf("%s%06d", sequencePrefix, position)
+}
+
+func (f *fakeStream) clients(t *testing.T) (*db.Client, *dynamodbstreams.Client) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		target := r.Header.Get("X-Amz-Target")
+		operation := target[strings.Index(target, ".")+1:]
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		response := f.handle(operation, body)
+		if fault, ok := response.(map[string]interface{}); ok && fault["__type"] != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      func() aws.Retryer { return aws.NopRetryer{} },
+	}
+	return db.NewClient(dynamodb.NewFromConfig(awsConfig), nil), dynamodbstreams.NewFromConfig(awsConfig)
+}
+
+func (f *fakeStream) handle(operation string, body map[string]interface{}) interface{} {
+	switch operation {
+	case "DescribeTable":
+		return map[string]interface{}{"Table": map[string]interface{}{"LatestStreamArn": testStreamARN}}
+	case "DescribeStream":
+		shards := []interface{}{}
+		for _, shard := range f.shards {
+			numbers := map[string]interface{}{"StartingSequenceNumber": sequenceNumber(0)}
+			if shard.closed {
+				numbers["EndingSequenceNumber"] = sequenceNumber(len(shard.records) - 1)
+			}
+			description := map[string]interface{}{"ShardId": shard.id, "SequenceNumberRange": numbers}
+			if shard.parent != "" {
+				description["ParentShardId"] = shard.parent
+			}
+			shards = append(shards, description)
+		}
+		return map[string]interface{}{"StreamDescription": map[string]interface{}{"StreamArn": testStreamARN, "Shards": shards}}
+	case "GetShardIterator":
+		shard := f.shard(body["ShardId"].(string))
+		position := 0
+		switch body["ShardIteratorType"] {
+		case string(types.ShardIteratorTypeLatest):
+			position = len(shard.records)
+		case string(types.ShardIteratorTypeAfterSequenceNumber):
+			after, _ := strconv.Atoi(strings.TrimPrefix(body["SequenceNumber"].(string), sequencePrefix))
+			position = after + 1
+		case string(types.ShardIteratorTypeAtSequenceNumber):
+			position, _ = strconv.Atoi(strings.TrimPrefix(body["SequenceNumber"].(string), sequencePrefix))
+		}
+		return map[string]interface{}{"ShardIterator": fmt.Sprintf("%s|%d", shard.id, position)}
+	case "GetRecords":
+		if f.getRecordsFailures > 0 {
+			f.getRecordsFailures--
+			return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#InternalServerError", "message": "unavailable"}
+		}
+		parts := strings.Split(body["ShardIterator"].(string), "|")
+		shard := f.shard(parts[0])
+		position, _ := strconv.Atoi(parts[1])
+		records := []interface{}{}
+		for i := position; i < len(shard.records); i++ {
+			records = append(records, map[string]interface{}{
+				"eventID":   fmt.Sprintf("event-%s-%d", shard.id, i),
+				"eventName": types.OperationTypeModify,
+				"dynamodb": map[string]interface{}{
+					"SequenceNumber": sequenceNumber(i),
+					"Keys":           map[string]interface{}{"id": map[string]string{"S": shard.records[i]}},
+					"NewImage": map[string]interface{}{
+						"id":   map[string]string{"S": shard.records[i]},
+						"name": map[string]string{"S": "Customer " + shard.records[i]},
+					},
+				},
+			})
+		}
+		response := map[string]interface{}{"Records": records}
+		if !shard.closed {
+			response["NextShardIterator"] = fmt.Sprintf("%s|%d", shard.id, len(shard.records))
+		}
+		return response
+	case "Query":
+		items := []interface{}{}
+		for _, item := range f.checkpoints {
+			items = append(items, item)
+		}
+		return map[string]interface{}{"Items": items}
+	case "PutItem":
+		item := body["Item"].(map[string]interface{})
+		shardID := item["shardId"].(map[string]interface{})["S"].(string)
+		f.checkpoints[shardID] = item
+		return map[string]interface{}{}
+	}
+	return map[string]interface{}{}
+}
+
+func (f *fakeStream) shard(id string) *fakeShard {
+	for _, shard := range f.shards {
+		if shard.id == id {
+			return shard
+		}
+	}
+	return nil
+}
+
+func (f *fakeStream) add(shardID string, customerIDs ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	shard := f.shard(shardID)
+	shard.records = append(shard.records, customerIDs...)
+}
+
+func (f *fakeStream) checkpoint(shardID string) (string, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item := f.checkpoints[shardID]
+	if item == nil {
+		return "", false
+	}
+	var sequence string
+	if attribute, ok := item["sequenceNumber"].(map[string]interface{}); ok {
+		sequence = attribute["S"].(string)
+	}
+	return sequence, item["finished"].(map[string]interface{})["BOOL"].(bool)
+}
+
+// recordedCustomers returns a handler appending the customer of each record it sees
+func recordedCustomers(seen *[]string) Handler {
+	return func(ctx context.Context, record Record) error {
+		*seen = append(*seen, record.CustomerID)
+		return nil
+	}
+}
+
+func TestConsumer_ResumesFromCheckpointsParentsFirst(t *testing.T) {
+	stream := &fakeStream{
+		shards: []*fakeShard{
+			{id: "shardId-00000001700000000000-parent", closed: true, records: []string{"c1", "c2"}},
+			{id: "shardId-00000001700000000000-child", parent: "shardId-00000001700000000000-parent", records: []string{"c3"}},
+		},
+		checkpoints: map[string]map[string]interface{}{
+			"shardId-00000001700000000000-parent": {
+				"consumer":       map[string]interface{}{"S": "events"},
+				"shardId":        map[string]interface{}{"S": "shardId-00000001700000000000-parent"},
+				"sequenceNumber": map[string]interface{}{"S": sequenceNumber(0)},
+				"finished":       map[string]interface{}{"BOOL": false},
+			},
+		},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	consumer := NewConsumer(client, streamsClient, "Customers", "events", "Checkpoints")
+	consumer.Register("record", recordedCustomers(&seen))
+
+	processed, err := consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	assert.Equal(t, []string{"c2", "c3"}, seen)
+	sequence, finished := stream.checkpoint("shardId-00000001700000000000-parent")
+	assert.Equal(t, sequenceNumber(1), sequence)
+	assert.True(t, finished)
+	sequence, finished = stream.checkpoint("shardId-00000001700000000000-child")
+	assert.Equal(t, sequenceNumber(0), sequence)
+	assert.False(t, finished)
+}
+
+func TestConsumer_ChildWaitsForParent(t *testing.T) {
+	stream := &fakeStream{
+		shards: []*fakeShard{
+			{id: "shardId-00000001700000000000-child", parent: "shardId-00000001700000000000-parent", records: []string{"c2"}},
+			{id: "shardId-00000001700000000000-parent", records: []string{"c1"}},
+		},
+		checkpoints: map[string]map[string]interface{}{
+			"shardId-00000001700000000000-parent": {
+				"consumer": map[string]interface{}{"S": "events"},
+				"shardId":  map[string]interface{}{"S": "shardId-00000001700000000000-parent"},
+				"finished": map[string]interface{}{"BOOL": false},
+			},
+		},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	consumer := NewConsumer(client, streamsClient, "Customers", "events", "Checkpoints")
+	consumer.Register("record", recordedCustomers(&seen))
+
+	processed, err := consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	assert.Equal(t, []string{"c1"}, seen)
+}
+
+func TestConsumer_NewConsumerStartsAtLatest(t *testing.T) {
+	stream := &fakeStream{
+		shards: []*fakeShard{
+			{id: "shardId-00000001700000000000-old", closed: true, records: []string{"c1"}},
+			{id: "shardId-00000001700000000000-open", parent: "shardId-00000001700000000000-old", records: []string{"c2"}},
+		},
+		checkpoints: map[string]map[string]interface{}{},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	consumer := NewConsumer(client, streamsClient, "Customers", "search", "")
+	consumer.Register("record", recordedCustomers(&seen))
+
+	processed, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	stream.add("shardId-00000001700000000000-open", "c3")
+	processed, err = consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	assert.Equal(t, []string{"c3"}, seen)
+	// In-memory checkpoints are never written to a table
+	assert.Empty(t, stream.checkpoints)
+}
+
+func TestConsumer_FailedRecordIsRetried(t *testing.T) {
+	stream := &fakeStream{
+		shards:      []*fakeShard{{id: "shardId-00000001700000000000-open"}},
+		checkpoints: map[string]map[string]interface{}{},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	failures := 1
+	consumer := NewConsumer(client, streamsClient, "Customers", "events", "Checkpoints")
+	consumer.Register("record", func(ctx context.Context, record Record) error {
+		seen = append(seen, record.CustomerID)
+		if record.CustomerID == "c2" && failures > 0 {
+			failures--
+			return errors.New("sink unavailable")
+		}
+		return nil
+	})
+	_, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+
+	stream.add("shardId-00000001700000000000-open", "c1", "c2", "c3")
+	processed, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+	sequence, _ := stream.checkpoint("shardId-00000001700000000000-open")
+	assert.Equal(t, sequenceNumber(0), sequence)
+
+	processed, err = consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	assert.Equal(t, []string{"c1", "c2", "c2", "c3"}, seen)
+	sequence, _ = stream.checkpoint("shardId-00000001700000000000-open")
+	assert.Equal(t, sequenceNumber(2), sequence)
+}
+
+func TestConsumer_RecordsDecodeCustomerImages(t *testing.T) {
+	stream := &fakeStream{
+		shards:      []*fakeShard{{id: "shardId-00000001700000000000-open"}},
+		checkpoints: map[string]map[string]interface{}{},
+	}
+	client, streamsClient := stream.clients(t)
+	var records []Record
+	consumer := NewConsumer(client, streamsClient, "Customers", "search", "")
+	consumer.Register("record", func(ctx context.Context, record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	_, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+
+	stream.add("shardId-00000001700000000000-open", "c1")
+	_, err = consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, types.OperationTypeModify, records[0].Operation)
+	assert.Equal(t, "c1", records[0].CustomerID)
+	assert.Nil(t, records[0].Old)
+	require.NotNil(t, records[0].New)
+	assert.Equal(t, "Customer c1", records[0].New.Name)
+}
+
+func TestConsumer_NewShardFailedRecordIsRetried(t *testing.T) {
+	stream := &fakeStream{
+		shards:      []*fakeShard{{id: "shardId-00000001700000000000-open"}},
+		checkpoints: map[string]map[string]interface{}{},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	failures := 1
+	consumer := NewConsumer(client, streamsClient, "Customers", "search", "")
+	consumer.Register("record", func(ctx context.Context, record Record) error {
+		seen = append(seen, record.CustomerID)
+		if failures > 0 {
+			failures--
+			return errors.New("sink unavailable")
+		}
+		return nil
+	})
+	_, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+
+	// The first record of a shard opened at its end fails before any checkpoint
+	stream.add("shardId-00000001700000000000-open", "c1", "c2")
+	processed, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	processed, err = consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 2, processed)
+	assert.Equal(t, []string{"c1", "c1", "c2"}, seen)
+}
+
+func TestConsumer_NewShardKeepsPositionAfterReadError(t *testing.T) {
+	stream := &fakeStream{
+		shards:      []*fakeShard{{id: "shardId-00000001700000000000-open"}},
+		checkpoints: map[string]map[string]interface{}{},
+	}
+	client, streamsClient := stream.clients(t)
+	var seen []string
+	consumer := NewConsumer(client, streamsClient, "Customers", "search", "")
+	consumer.Register("record", recordedCustomers(&seen))
+	_, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+
+	stream.add("shardId-00000001700000000000-open", "c1")
+	stream.getRecordsFailures = 1
+	processed, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, processed)
+
+	processed, err = consumer.Poll(context.Background())
+
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed, "changes made while the read failed are not skipped")
+	assert.Equal(t, []string{"c1"}, seen)
+}
@@ -0,0 +1,126 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/events"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
+)
+
+// sequenceWidth is the most digits of a stream sequence number
+const sequenceWidth = 40
+
+// IndexSearch keeps the search index of client in sync with the customers
+// table, including writes made by other processes
+func IndexSearch(client *db.Client) Handler {
+	return func(ctx context.Context, record Record) error {
+		client.IndexCustomerChange(record.CustomerID, record.New)
+		return nil
+	}
+}
+
+// PublishChanges broadcasts each change to the customer event streams of
+// this process. Messages are identified by their padded sequence number,
+// which is the same in every process reading the stream.
+func PublishChanges(broker *pubsub.Broker) Handler {
+	return func(ctx context.Context, record Record) error {
+		message := pubsub.Message{
+			ID:         fmt.Sprintf("%0*s", sequenceWidth, record.SequenceNumber),
+			Type:       models.EventCustomerUpdated,
+			CustomerID: record.CustomerID,
+			Customer:   record.New,
+		}
+		switch {
+		case record.Operation == types.OperationTypeInsert:
+			message.Type = models.EventCustomerCreated
+		case record.Operation == types.OperationTypeRemove || record.New == nil:
+			message.Type = models.EventCustomerDeleted
+		}
+		broker.Publish(message)
+		return nil
+	}
+}
+
+// PublishEvents publishes the events describing each change to sink. They
+// are the events the outbox records for writes made through the API, so the
+// stream can replace the outbox as their source.
+func PublishEvents(sink events.Sink) Handler {
+	return func(ctx context.Context, record Record) error {
+		for _, event := range recordEvents(record) {
+			if err := sink.Publish(ctx, event); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// recordEvents describes a change as customer events. Their IDs are derived
+// from the record, so a record handled twice publishes the same events.
+func recordEvents(record Record) []models.Event {
+	var found []models.Event
+	add := func(eventType string, customer *models.Customer, data map[string]string) {
+		found = append(found, models.Event{
+			CustomerID: record.CustomerID,
+			ID:         fmt.Sprintf("%0*s#%d", sequenceWidth, record.SequenceNumber, len(found)),
+			Type:       eventType,
+			OccurredAt: record.OccurredAt.UTC().Format(time.RFC3339),
+			Customer:   customer,
+			Data:       data,
+		})
+	}
+
+	switch {
+	case record.Operation == types.OperationTypeInsert:
+		add(models.EventCustomerCreated, record.New, nil)
+	case record.Operation == types.OperationTypeRemove || record.New == nil:
+		add(models.EventCustomerDeleted, nil, nil)
+	case record.Old == nil:
+		add(models.EventCustomerUpdated, record.New, nil)
+	case record.New.IsErased() && !record.Old.IsErased():
+		add(models.EventCustomerErased, nil, nil)
+	case record.New.IsMerged() && !record.Old.IsMerged():
+		add(models.EventCustomerMerged, nil, map[string]string{"mergedInto": record.New.MergedInto})
+	default:
+		old, updated := *record.Old, *record.New
+		if old.Status != updated.Status {
+			add(models.EventCustomerStatusChanged, nil, map[string]string{"from": old.Status, "to": updated.Status})
+		}
+		for _, tag := range missingTags(updated.Tags, old.Tags) {
+			add(models.EventCustomerTagged, nil, map[string]string{"tag": tag})
+		}
+		for _, tag := range missingTags(old.Tags, updated.Tags) {
+			add(models.EventCustomerUntagged, nil, map[string]string{"tag": tag})
+		}
+
+		// Anything else changed is an update, as is a write changing nothing
+		old.Status, updated.Status = "", ""
+		old.Tags, updated.Tags = nil, nil
+		if len(found) == 0 || !reflect.DeepEqual(old, updated) {
+			add(models.EventCustomerUpdated, record.New, nil)
+		}
+	}
+	return found
+}
+
+// missingTags returns the tags of tags that aren't in other
+func missingTags(tags, other []string) []string {
+	present := make(map[string]bool, len(other))
+	for _, tag := range other {
+		present[tag] = true
+	}
+
+	var missing []string
+	for _, tag := range tags {
+		if !present[tag] {
+			missing = append(missing, tag)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,147 @@
+package streams
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/events"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/emiteze/tcc-ufu/internal/pubsub"
+	"github.com/emiteze/tcc-ufu/internal/search"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func modifyRecord(old, updated models.Customer) Record {
+	return Record{
+		ID:             "record-1",
+		Operation:      types.OperationTypeModify,
+		SequenceNumber: "100000000000000000042",
+		CustomerID:     old.ID,
+		OccurredAt:     time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Old:            &old,
+		New:            &updated,
+	}
+}
+
+func eventTypes(found []models.Event) []string {
+	types := make([]string, len(found))
+	for i, event := range found {
+		types[i] = event.Type
+	}
+	return types
+}
+
+func TestRecordEvents(t *testing.T) {
+	customer := models.Customer{ID: "c1", Name: "John", Email: "john@example.com", Status: models.StatusActive, Tags: []string{"vip"}}
+
+	t.Run("created and deleted", func(t *testing.T) {
+		created := recordEvents(Record{Operation: types.OperationTypeInsert, CustomerID: "c1", New: &customer})
+		require.Len(t, created, 1)
+		assert.Equal(t, models.EventCustomerCreated, created[0].Type)
+		assert.Equal(t, &customer, created[0].Customer)
+
+		deleted := recordEvents(Record{Operation: types.OperationTypeRemove, CustomerID: "c1", Old: &customer})
+		assert.Equal(t, []string{models.EventCustomerDeleted}, eventTypes(deleted))
+	})
+
+	t.Run("updated", func(t *testing.T) {
+		updated := customer
+		updated.Name = "John Smith"
+		assert.Equal(t, []string{models.EventCustomerUpdated}, eventTypes(recordEvents(modifyRecord(customer, updated))))
+	})
+
+	t.Run("status and tags only", func(t *testing.T) {
+		updated := customer
+		updated.Status = models.StatusSuspended
+		updated.Tags = []string{"newsletter"}
+
+		found := recordEvents(modifyRecord(customer, updated))
+
+		assert.Equal(t, []string{models.EventCustomerStatusChanged, models.EventCustomerTagged, models.EventCustomerUntagged}, eventTypes(found))
+		assert.Equal(t, map[string]string{"from": models.StatusActive, "to": models.StatusSuspended}, found[0].Data)
+		assert.Equal(t, map[string]string{"tag": "newsletter"}, found[1].Data)
+		assert.Equal(t, map[string]string{"tag": "vip"}, found[2].Data)
+	})
+
+	t.Run("erased and merged", func(t *testing.T) {
+		erased := models.Customer{ID: "c1", ErasedAt: "2026-03-01T12:00:00Z"}
+		assert.Equal(t, []string{models.EventCustomerErased}, eventTypes(recordEvents(modifyRecord(customer, erased))))
+
+		merged := customer
+		merged.MergedInto = "c2"
+		found := recordEvents(modifyRecord(customer, merged))
+		assert.Equal(t, []string{models.EventCustomerMerged}, eventTypes(found))
+		assert.Equal(t, map[string]string{"mergedInto": "c2"}, found[0].Data)
+	})
+
+	t.Run("ids are stable and ordered", func(t *testing.T) {
+		updated := customer
+		updated.Name = "John Smith"
+		updated.Status = models.StatusSuspended
+		record := modifyRecord(customer, updated)
+
+		first, again := recordEvents(record), recordEvents(record)
+
+		require.Len(t, first, 2)
+		assert.Equal(t, first, again)
+		assert.Equal(t, "0000000000000000000100000000000000000042#0", first[0].ID)
+		assert.Less(t, first[0].ID, first[1].ID)
+		assert.Equal(t, "2026-03-01T12:00:00Z", first[0].OccurredAt)
+	})
+}
+
+func TestPublishEvents(t *testing.T) {
+	sink := &events.MemorySink{}
+	updated := models.Customer{ID: "c1", Name: "John Smith"}
+	record := modifyRecord(models.Customer{ID: "c1", Name: "John"}, updated)
+
+	require.NoError(t, PublishEvents(sink)(context.Background(), record))
+	require.Len(t, sink.Events(), 1)
+	assert.Equal(t, models.EventCustomerUpdated, sink.Events()[0].Type)
+
+	sink.Fail = func(models.Event) error { return errors.New("unavailable") }
+	assert.Error(t, PublishEvents(sink)(context.Background(), record))
+}
+
+func TestPublishChanges(t *testing.T) {
+	broker := pubsub.NewBroker(10)
+	_, _, sub := broker.Subscribe("")
+	customer := models.Customer{ID: "c1", Name: "Written Elsewhere"}
+
+	require.NoError(t, PublishChanges(broker)(context.Background(), Record{Operation: types.OperationTypeInsert, SequenceNumber: "42", CustomerID: "c1", New: &customer}))
+	require.NoError(t, PublishChanges(broker)(context.Background(), modifyRecord(customer, customer)))
+	require.NoError(t, PublishChanges(broker)(context.Background(), Record{Operation: types.OperationTypeRemove, SequenceNumber: "100000000000000000043", CustomerID: "c1", Old: &customer}))
+
+	created := <-sub.C
+	assert.Equal(t, models.EventCustomerCreated, created.Type)
+	assert.Equal(t, &customer, created.Customer)
+	assert.Len(t, created.ID, sequenceWidth)
+	assert.Equal(t, models.EventCustomerUpdated, (<-sub.C).Type)
+	deleted := <-sub.C
+	assert.Equal(t, models.EventCustomerDeleted, deleted.Type)
+	assert.Nil(t, deleted.Customer)
+
+	// Another process gives the changes the same IDs, so streams resume on it
+	replay, complete, _ := broker.Subscribe(created.ID)
+	assert.True(t, complete)
+	assert.Len(t, replay, 2)
+}
+
+func TestIndexSearch(t *testing.T) {
+	index := search.NewIndex()
+	client := db.NewClient(nil, nil)
+	client.SetSearchIndex(index)
+	handle := IndexSearch(client)
+	customer := models.Customer{ID: "c1", Name: "Written Elsewhere"}
+
+	require.NoError(t, handle(context.Background(), Record{Operation: types.OperationTypeInsert, CustomerID: "c1", New: &customer}))
+	assert.Len(t, index.Search("elsewhere", search.FieldName, 10), 1)
+
+	require.NoError(t, handle(context.Background(), Record{Operation: types.OperationTypeRemove, CustomerID: "c1", Old: &customer}))
+	assert.Equal(t, 0, index.Len())
+}
@@ -0,0 +1,136 @@
+//go:build dynamodblocal
+
+package streams
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams"
+	streamstypes "github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests run against DynamoDB Local, started with `make run-docker`:
+//
+//	go test -tags dynamodblocal ./internal/streams/
+//
+// DYNAMODB_ENDPOINT overrides its default address.
+
+func localClients(t *testing.T) (*db.Client, *dynamodbstreams.Client) {
+	endpoint := os.Getenv("DYNAMODB_ENDPOINT")
+	if endpoint == "" {
+		endpoint = "http://localhost:8000"
+	}
+	awsConfig := aws.Config{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(endpoint),
+		Credentials:  credentials.NewStaticCredentialsProvider("local", "local", ""),
+	}
+	return db.NewClient(dynamodb.NewFromConfig(awsConfig), nil), dynamodbstreams.NewFromConfig(awsConfig)
+}
+
+// localTables creates a customers table and a checkpoints table removed after the test
+func localTables(t *testing.T, client *db.Client) (string, string) {
+	suffix := time.Now().UnixNano()
+	customers, checkpoints := fmt.Sprintf("StreamCustomers%d", suffix), fmt.Sprintf("StreamCheckpoints%d", suffix)
+	_, err := db.EnsureTableExists(context.Background(), client, customers)
+	require.NoError(t, err)
+	require.NoError(t, db.EnsureStreamCheckpointsTableExists(context.Background(), client, checkpoints))
+	t.Cleanup(func() {
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(customers)})
+		client.DeleteTable(context.Background(), &dynamodb.DeleteTableInput{TableName: aws.String(checkpoints)})
+	})
+	return customers, checkpoints
+}
+
+// pollFor polls the consumer until it has processed count records
+func pollFor(t *testing.T, consumer *Consumer, count int) {
+	deadline := time.Now().Add(10 * time.Second)
+	processed := 0
+	for processed < count {
+		require.True(t, time.Now().Before(deadline), "processed %d of %d records", processed, count)
+		n, err := consumer.Poll(context.Background())
+		require.NoError(t, err)
+		processed += n
+		if n == 0 {
+			time.Sleep(100 * time.Millisecond)
+		}
+	}
+}
+
+func putCustomerItem(t *testing.T, client *db.Client, table, id, name string) {
+	_, err := client.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(table),
+		Item: map[string]types.AttributeValue{
+			"id":    &types.AttributeValueMemberS{Value: id},
+			"name":  &types.AttributeValueMemberS{Value: name},
+			"email": &types.AttributeValueMemberS{Value: id + "@example.com"},
+		},
+	})
+	require.NoError(t, err)
+}
+
+func TestLocal_ConsumesWritesMadeOutsideTheAPI(t *testing.T) {
+	client, streamsClient := localClients(t)
+	table, checkpoints := localTables(t, client)
+
+	var records []Record
+	consumer := NewConsumer(client, streamsClient, table, "test", checkpoints)
+	consumer.Register("record", func(ctx context.Context, record Record) error {
+		records = append(records, record)
+		return nil
+	})
+	_, err := consumer.Poll(context.Background())
+	require.NoError(t, err)
+
+	putCustomerItem(t, client, table, "c1", "John")
+	putCustomerItem(t, client, table, "c1", "John Smith")
+	_, err = client.DeleteItem(context.Background(), &dynamodb.DeleteItemInput{
+		TableName: aws.String(table),
+		Key:       map[string]types.AttributeValue{"id": &types.AttributeValueMemberS{Value: "c1"}},
+	})
+	require.NoError(t, err)
+
+	pollFor(t, consumer, 3)
+
+	require.Len(t, records, 3)
+	assert.Equal(t, streamstypes.OperationTypeInsert, records[0].Operation)
+	assert.Equal(t, "John", records[0].New.Name)
+	assert.Equal(t, streamstypes.OperationTypeModify, records[1].Operation)
+	assert.Equal(t, "John", records[1].Old.Name)
+	assert.Equal(t, "John Smith", records[1].New.Name)
+	assert.Equal(t, streamstypes.OperationTypeRemove, records[2].Operation)
+	assert.Nil(t, records[2].New)
+	assert.Equal(t, "c1", records[2].CustomerID)
+}
+
+func TestLocal_RestartResumesFromCheckpoints(t *testing.T) {
+	client, streamsClient := localClients(t)
+	table, checkpoints := localTables(t, client)
+
+	var seen []string
+	first := NewConsumer(client, streamsClient, table, "test", checkpoints)
+	first.Register("record", recordedCustomers(&seen))
+	_, err := first.Poll(context.Background())
+	require.NoError(t, err)
+	putCustomerItem(t, client, table, "c1", "First")
+	pollFor(t, first, 1)
+
+	// Written while no consumer runs, a new one would start after it
+	putCustomerItem(t, client, table, "c2", "Second")
+	restarted := NewConsumer(client, streamsClient, table, "test", checkpoints)
+	restarted.Register("record", recordedCustomers(&seen))
+	pollFor(t, restarted, 1)
+
+	assert.Equal(t, []string{"c1", "c2"}, seen)
+}
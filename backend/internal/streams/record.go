@@ -0,0 +1,69 @@
+package streams
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodbstreams/types"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+)
+
+// Record is a change to a customer read from the table's stream
+type Record struct {
+	// ID identifies the record in the stream
+	ID string
+	// Operation is types.OperationTypeInsert, OperationTypeModify or OperationTypeRemove
+	Operation types.OperationType
+	// SequenceNumber orders the records of a customer
+	SequenceNumber string
+	CustomerID     string
+	OccurredAt     time.Time
+	// Old and New are the customer before and after the change, nil when it
+	// didn't exist
+	Old *models.Customer
+	New *models.Customer
+}
+
+// newRecord converts a stream record, decrypting the customer images
+func newRecord(ctx context.Context, client *db.Client, r types.Record) (Record, error) {
+	change := r.Dynamodb
+	if change == nil {
+		return Record{}, fmt.Errorf("record %s has no change", aws.ToString(r.EventID))
+	}
+
+	record := Record{
+		ID:             aws.ToString(r.EventID),
+		Operation:      r.EventName,
+		SequenceNumber: aws.ToString(change.SequenceNumber),
+		OccurredAt:     aws.ToTime(change.ApproximateCreationDateTime),
+	}
+	if id, ok := change.Keys["id"].(*types.AttributeValueMemberS); ok {
+		record.CustomerID = id.Value
+	}
+
+	var err error
+	if change.OldImage != nil {
+		if record.Old, err = customerImage(ctx, client, change.OldImage); err != nil {
+			return Record{}, err
+		}
+	}
+	if change.NewImage != nil {
+		if record.New, err = customerImage(ctx, client, change.NewImage); err != nil {
+			return Record{}, err
+		}
+	}
+	return record, nil
+}
+
+// customerImage converts a customer image of a stream record to a customer
+func customerImage(ctx context.Context, client *db.Client, image map[string]types.AttributeValue) (*models.Customer, error) {
+	item, err := attributevalue.FromDynamoDBStreamsMap(image)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert customer image: %w", err)
+	}
+	return db.UnmarshalCustomerImage(ctx, client, item)
+}
@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// ErrForbiddenAddress is returned for webhooks reaching loopback, link-local,
+// private or other non-public addresses
+var ErrForbiddenAddress = errors.New("webhook address is not public")
+
+// lookupIPAddr resolves host names, replaced in tests
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// forbiddenIP reports whether ip is an address webhooks may not reach
+func forbiddenIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast()
+}
+
+// CheckURL resolves the host of a webhook URL and fails with
+// ErrForbiddenAddress when any of its addresses isn't public. Deliveries are
+// checked again when they connect, as the host may resolve differently then.
+func CheckURL(ctx context.Context, rawURL string) error {
+	target, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+
+	host := target.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if forbiddenIP(ip) {
+			return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+		}
+		return nil
+	}
+
+	addrs, err := lookupIPAddr(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", host, err)
+	}
+	for _, addr := range addrs {
+		if forbiddenIP(addr.IP) {
+			return fmt.Errorf("%w: %s resolves to %s", ErrForbiddenAddress, host, addr.IP)
+		}
+	}
+	return nil
+}
+
+// checkDial rejects connections to addresses webhooks may not reach
+func checkDial(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || forbiddenIP(ip) {
+		return fmt.Errorf("%w: %s", ErrForbiddenAddress, host)
+	}
+	return nil
+}
+
+// newHTTPClient returns the client deliveries are posted with. Unless
+// allowPrivate is set it only connects to public addresses, including when
+// following redirects. Proxies aren't used, as the check would apply to the
+// proxy rather than the webhook.
+func newHTTPClient(allowPrivate bool) *http.Client {
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	if !allowPrivate {
+		dialer.Control = checkDial
+	}
+	return &http.Client{
+		Timeout: deliveryTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: deliveryTimeout,
+			IdleConnTimeout:     90 * time.Second,
+			MaxIdleConns:        deliveryWorkers,
+		},
+	}
+}
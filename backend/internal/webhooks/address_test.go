@@ -0,0 +1,54 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withLookup resolves host names with lookup for the duration of a test
+func withLookup(t *testing.T, lookup func(ctx context.Context, host string) ([]net.IPAddr, error)) {
+	previous := lookupIPAddr
+	lookupIPAddr = lookup
+	t.Cleanup(func() { lookupIPAddr = previous })
+}
+
+func TestCheckURL(t *testing.T) {
+	withLookup(t, func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		switch host {
+		case "partner.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+		case "internal.example.com":
+			return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.5")}}, nil
+		}
+		return nil, errors.New("no such host")
+	})
+
+	assert.NoError(t, CheckURL(context.Background(), "https://partner.example.com/hooks"))
+	assert.NoError(t, CheckURL(context.Background(), "https://93.184.216.34/hooks"))
+
+	for _, rawURL := range []string{
+		"http://127.0.0.1:9000/hooks",
+		"http://[::1]/hooks",
+		"http://169.254.169.254/latest/meta-data",
+		"http://192.168.1.10/hooks",
+		"http://[fd00::1]/hooks",
+		"http://0.0.0.0/hooks",
+		"https://internal.example.com/hooks",
+	} {
+		assert.ErrorIs(t, CheckURL(context.Background(), rawURL), ErrForbiddenAddress, rawURL)
+	}
+
+	err := CheckURL(context.Background(), "https://unknown.example.com/hooks")
+	assert.Error(t, err)
+	assert.NotErrorIs(t, err, ErrForbiddenAddress)
+}
+
+func TestCheckDial(t *testing.T) {
+	assert.NoError(t, checkDial("tcp4", "93.184.216.34:443", nil))
+	assert.ErrorIs(t, checkDial("tcp4", "127.0.0.1:443", nil), ErrForbiddenAddress)
+	assert.ErrorIs(t, checkDial("tcp6", "[fe80::1]:443", nil), ErrForbiddenAddress)
+}
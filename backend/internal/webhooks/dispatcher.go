@@ -0,0 +1,294 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/google/uuid"
+)
+
+// Headers set on every delivery besides X-Event-Id and X-Event-Type
+const (
+	WebhookIDHeader = "X-Webhook-Id"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// pollInterval is how often the dispatcher looks for due deliveries
+	pollInterval = 5 * time.Second
+	// deliveryTimeout bounds a single delivery attempt
+	deliveryTimeout = 10 * time.Second
+	// claimTimeout is how long a claimed delivery is left to its attempt
+	// before another dispatcher may take it over
+	claimTimeout = time.Minute
+	// webhookCacheTTL is how long the webhooks are cached for publishing, so
+	// a new or changed subscription applies to events published after it
+	webhookCacheTTL = 30 * time.Second
+	// deliveryWorkers is the number of deliveries attempted at once
+	deliveryWorkers = 8
+	// dueBatchSize is the most deliveries attempted in one pass
+	dueBatchSize = 100
+	// retryBase is the wait after the first failed attempt. It doubles with
+	// every further attempt, up to retryMax.
+	retryBase = 30 * time.Second
+	retryMax  = time.Hour
+)
+
+// Sign returns the signature of a delivery body sent at timestamp: the
+// hex-encoded HMAC-SHA256 of "<timestamp>.<body>" keyed by the webhook's
+// secret, prefixed with "sha256="
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher delivers customer events to the webhooks subscribed to them.
+// Publishing an event records a pending delivery per webhook, and Run
+// attempts due deliveries, retrying failures with exponential backoff until
+// they succeed or run out of attempts. Each attempt claims its delivery
+// first, so dispatchers of several replicas don't deliver it twice.
+type Dispatcher struct {
+	client          *db.Client
+	webhooksTable   string
+	deliveriesTable string
+	maxAttempts     int
+	retention       time.Duration
+	http            *http.Client
+	wake            chan struct{}
+
+	// webhooks caches the webhooks for publishing, read at webhooksReadAt
+	mu             sync.Mutex
+	webhooks       []models.Webhook
+	webhooksReadAt time.Time
+}
+
+// NewDispatcher creates a dispatcher for the webhook tables in cfg
+func NewDispatcher(client *db.Client, cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		client:          client,
+		webhooksTable:   cfg.WebhooksTableName,
+		deliveriesTable: cfg.WebhookDeliveriesTableName,
+		maxAttempts:     max(cfg.WebhookMaxAttempts, 1),
+		retention:       time.Duration(cfg.WebhookRetentionHours) * time.Hour,
+		http:            newHTTPClient(cfg.WebhookAllowPrivateNetworks),
+		wake:            make(chan struct{}, 1),
+	}
+}
+
+// Publish records a delivery of the event to each webhook subscribed to its
+// type, so the dispatcher can be used as an event sink
+func (d *Dispatcher) Publish(ctx context.Context, event models.Event) error {
+	webhooks, err := d.cachedWebhooks(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	created := false
+	for _, webhook := range webhooks {
+		if !webhook.Subscribes(event.Type) {
+			continue
+		}
+		delivery := &models.WebhookDelivery{
+			WebhookID:     webhook.ID,
+			ID:            event.ID,
+			EventType:     event.Type,
+			Status:        models.DeliveryPending,
+			NextAttemptAt: now.Unix(),
+			CreatedAt:     now.UTC().Format(time.RFC3339),
+			ExpiresAt:     now.Add(d.retention).Unix(),
+			Event:         &event,
+		}
+		if err := db.CreateWebhookDelivery(ctx, d.client, d.deliveriesTable, delivery); err != nil {
+			return err
+		}
+		created = true
+	}
+
+	if created {
+		d.Notify()
+	}
+	return nil
+}
+
+// cachedWebhooks returns the webhooks, reading them again once the cache is
+// older than webhookCacheTTL
+func (d *Dispatcher) cachedWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.webhooksReadAt.IsZero() || time.Since(d.webhooksReadAt) >= webhookCacheTTL {
+		webhooks, err := db.ListWebhooks(ctx, d.client, d.webhooksTable)
+		if err != nil {
+			return nil, err
+		}
+		d.webhooks, d.webhooksReadAt = webhooks, time.Now()
+	}
+	return d.webhooks, nil
+}
+
+// Notify wakes the dispatcher to attempt deliveries without waiting for the next poll
+func (d *Dispatcher) Notify() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run attempts due deliveries until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := d.DeliverDue(ctx, time.Now()); err != nil {
+			log.Printf("Failed to deliver webhooks: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// DeliverDue attempts the pending deliveries due at now and records their
+// outcome. Deliveries claimed by another dispatcher are skipped, and attempts
+// interrupted by ctx are retried once their claim expires. The webhooks are
+// read afresh so deliveries go to their current URL.
+func (d *Dispatcher) DeliverDue(ctx context.Context, now time.Time) error {
+	deliveries, err := db.ListDueWebhookDeliveries(ctx, d.client, d.deliveriesTable, now, dueBatchSize)
+	if err != nil || len(deliveries) == 0 {
+		return err
+	}
+
+	list, err := db.ListWebhooks(ctx, d.client, d.webhooksTable)
+	if err != nil {
+		return err
+	}
+	webhooks := make(map[string]*models.Webhook, len(list))
+	for i := range list {
+		webhooks[list[i].ID] = &list[i]
+	}
+
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, deliveryWorkers)
+	for i := range deliveries {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func(delivery *models.WebhookDelivery) {
+			defer func() { <-slots; wg.Done() }()
+
+			delivery.Owner = uuid.New().String()
+			if err := db.ClaimWebhookDelivery(ctx, d.client, d.deliveriesTable, delivery, now.Add(claimTimeout)); err != nil {
+				if !errors.Is(err, db.ErrWebhookDeliveryClaimed) {
+					log.Printf("Failed to claim webhook delivery %s: %v", delivery.ID, err)
+				}
+				return
+			}
+
+			d.attempt(ctx, webhooks[delivery.WebhookID], delivery, now)
+			if ctx.Err() != nil {
+				return
+			}
+			if err := db.SaveWebhookDelivery(ctx, d.client, d.deliveriesTable, delivery); err != nil {
+				log.Printf("Failed to record webhook delivery %s: %v", delivery.ID, err)
+			}
+		}(&deliveries[i])
+	}
+	wg.Wait()
+	return nil
+}
+
+// attempt posts a delivery to its webhook and updates the delivery with the
+// outcome. Deliveries of deleted webhooks are dead straight away.
+func (d *Dispatcher) attempt(ctx context.Context, webhook *models.Webhook, delivery *models.WebhookDelivery, now time.Time) {
+	delivery.Attempts++
+	delivery.LastAttemptAt = now.UTC().Format(time.RFC3339)
+	delivery.ResponseStatus = 0
+
+	var err error
+	switch {
+	case webhook == nil:
+		err = errors.New("webhook was deleted")
+		delivery.Attempts = max(delivery.Attempts, d.maxAttempts)
+	case delivery.Event == nil:
+		err = errors.New("event is missing")
+		delivery.Attempts = max(delivery.Attempts, d.maxAttempts)
+	default:
+		delivery.ResponseStatus, err = d.post(ctx, webhook, delivery.Event, now)
+	}
+
+	switch {
+	case err == nil:
+		delivery.Status = models.DeliveryDelivered
+		delivery.NextAttemptAt = 0
+		delivery.Error = ""
+	case delivery.Attempts >= d.maxAttempts:
+		delivery.Status = models.DeliveryDead
+		delivery.NextAttemptAt = 0
+		delivery.Error = err.Error()
+	default:
+		delivery.NextAttemptAt = now.Add(retryDelay(delivery.Attempts)).Unix()
+		delivery.Error = err.Error()
+	}
+}
+
+// post sends a signed event to a webhook and returns the response status
+func (d *Dispatcher) post(ctx context.Context, webhook *models.Webhook, event *models.Event, now time.Time) (int, error) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal event: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("failed to build request: %v", err)
+	}
+	timestamp := now.Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-Id", event.ID)
+	req.Header.Set("X-Event-Type", event.Type)
+	req.Header.Set(WebhookIDHeader, webhook.ID)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post event: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// retryDelay returns the wait before the attempt following the given number of attempts
+func retryDelay(attempts int) time.Duration {
+	// Later attempts wait retryMax anyway, and larger shifts could overflow
+	if attempts > 8 {
+		return retryMax
+	}
+	return min(retryBase<<(attempts-1), retryMax)
+}
@@ -0,0 +1,414 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/emiteze/tcc-ufu/internal/config"
+	"github.com/emiteze/tcc-ufu/internal/db"
+	"github.com/emiteze/tcc-ufu/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTables serves the webhooks and deliveries tables from memory. Queries
+// of the deliveries table return the pending deliveries due at the query's
+// time, and conditions made of equalities are checked.
+type fakeTables struct {
+	mu         sync.Mutex
+	webhooks   []map[string]interface{}
+	deliveries map[string]map[string]interface{}
+}
+
+func (f *fakeTables) client(t *testing.T) *db.Client {
+	f.deliveries = map[string]map[string]interface{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+		operation := strings.TrimPrefix(r.Header.Get("X-Amz-Target"), "DynamoDB_20120810.")
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var response interface{} = map[string]interface{}{}
+		switch {
+		case operation == "Scan" && body["TableName"] == "Webhooks":
+			response = map[string]interface{}{"Items": f.webhooks}
+		case operation == "Query":
+			var now int64
+			for _, value := range body["ExpressionAttributeValues"].(map[string]interface{}) {
+				if n, ok := value.(map[string]interface{})["N"]; ok {
+					now, _ = strconv.ParseInt(n.(string), 10, 64)
+				}
+			}
+			items := []interface{}{}
+			for _, item := range f.deliveries {
+				next, _ := strconv.ParseInt(attribute(item, "nextAttemptAt", "N"), 10, 64)
+				if attribute(item, "status", "S") == models.DeliveryPending && next <= now {
+					items = append(items, item)
+				}
+			}
+			response = map[string]interface{}{"Items": items}
+		case operation == "UpdateItem":
+			key := body["Key"].(map[string]interface{})
+			item := f.deliveries[attribute(key, "webhookId", "S")+"/"+attribute(key, "deliveryId", "S")]
+			if item == nil || !equalitiesHold(item, body, body["ConditionExpression"].(string)) {
+				response = conditionalCheckFailed(w)
+				break
+			}
+			names, values := expressionAttributes(body)
+			for _, match := range equalities.FindAllStringSubmatch(body["UpdateExpression"].(string), -1) {
+				item[names[match[1]]] = values[match[2]]
+			}
+		case operation == "PutItem":
+			item := body["Item"].(map[string]interface{})
+			key := attribute(item, "webhookId", "S") + "/" + attribute(item, "deliveryId", "S")
+			existing, exists := f.deliveries[key]
+			condition, _ := body["ConditionExpression"].(string)
+			failed := exists && strings.HasPrefix(condition, "attribute_not_exists")
+			if condition != "" && !strings.HasPrefix(condition, "attribute_not_exists") {
+				failed = !equalitiesHold(existing, body, condition)
+			}
+			if failed {
+				response = conditionalCheckFailed(w)
+				break
+			}
+			f.deliveries[key] = item
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		json.NewEncoder(w).Encode(response)
+	}))
+	t.Cleanup(server.Close)
+
+	return db.NewClient(dynamodb.New(dynamodb.Options{
+		Region:       "us-east-1",
+		BaseEndpoint: aws.String(server.URL),
+		Credentials:  credentials.NewStaticCredentialsProvider("test", "test", ""),
+		Retryer:      aws.NopRetryer{},
+	}), nil)
+}
+
+// equalities matches the "#name = :value" parts of condition and update expressions
+var equalities = regexp.MustCompile(`(#\w+) = (:\w+)`)
+
+// expressionAttributes returns the attribute names and values of a request
+func expressionAttributes(body map[string]interface{}) (map[string]string, map[string]interface{}) {
+	names := map[string]string{}
+	for placeholder, name := range body["ExpressionAttributeNames"].(map[string]interface{}) {
+		names[placeholder] = name.(string)
+	}
+	values, _ := body["ExpressionAttributeValues"].(map[string]interface{})
+	return names, values
+}
+
+// equalitiesHold reports whether item meets a condition made of equalities
+func equalitiesHold(item, body map[string]interface{}, condition string) bool {
+	names, values := expressionAttributes(body)
+	for _, match := range equalities.FindAllStringSubmatch(condition, -1) {
+		if !reflect.DeepEqual(item[names[match[1]]], values[match[2]]) {
+			return false
+		}
+	}
+	return true
+}
+
+// conditionalCheckFailed fails a request's condition
+func conditionalCheckFailed(w http.ResponseWriter) map[string]interface{} {
+	w.WriteHeader(http.StatusBadRequest)
+	return map[string]interface{}{
+		"__type":  "com.amazonaws.dynamodb.v20120810#ConditionalCheckFailedException",
+		"message": "The conditional request failed",
+	}
+}
+
+// addWebhook stores a webhook in the fake table
+func (f *fakeTables) addWebhook(t *testing.T, webhook models.Webhook) {
+	item, err := attributevalue.MarshalMap(webhook)
+	require.NoError(t, err)
+	f.webhooks = append(f.webhooks, jsonAttributes(item))
+}
+
+// delivery returns a stored delivery
+func (f *fakeTables) delivery(t *testing.T, webhookID, eventID string) models.WebhookDelivery {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	item, ok := f.deliveries[webhookID+"/"+eventID]
+	require.True(t, ok, "no delivery of %s to %s", eventID, webhookID)
+
+	var delivery models.WebhookDelivery
+	require.NoError(t, attributevalue.UnmarshalMap(attributeValues(item), &delivery))
+	return delivery
+}
+
+// jsonAttributes encodes an item as it is sent to DynamoDB
+func jsonAttributes(item map[string]types.AttributeValue) map[string]interface{} {
+	encoded := map[string]interface{}{}
+	for name, value := range item {
+		switch value := value.(type) {
+		case *types.AttributeValueMemberS:
+			encoded[name] = map[string]interface{}{"S": value.Value}
+		case *types.AttributeValueMemberN:
+			encoded[name] = map[string]interface{}{"N": value.Value}
+		case *types.AttributeValueMemberSS:
+			ss := make([]interface{}, len(value.Value))
+			for i, s := range value.Value {
+				ss[i] = s
+			}
+			encoded[name] = map[string]interface{}{"SS": ss}
+		}
+	}
+	return encoded
+}
+
+// attributeValues decodes a JSON-encoded item
+func attributeValues(item map[string]interface{}) map[string]types.AttributeValue {
+	values := map[string]types.AttributeValue{}
+	for name, value := range item {
+		values[name] = attributeValue(value.(map[string]interface{}))
+	}
+	return values
+}
+
+// attributeValue decodes a JSON-encoded attribute of the types the dispatcher writes
+func attributeValue(value map[string]interface{}) types.AttributeValue {
+	for kind, v := range value {
+		switch kind {
+		case "S":
+			return &types.AttributeValueMemberS{Value: v.(string)}
+		case "N":
+			return &types.AttributeValueMemberN{Value: v.(string)}
+		case "BOOL":
+			return &types.AttributeValueMemberBOOL{Value: v.(bool)}
+		case "NULL":
+			return &types.AttributeValueMemberNULL{Value: v.(bool)}
+		case "M":
+			return &types.AttributeValueMemberM{Value: attributeValues(v.(map[string]interface{}))}
+		case "SS":
+			var ss []string
+			for _, s := range v.([]interface{}) {
+				ss = append(ss, s.(string))
+			}
+			return &types.AttributeValueMemberSS{Value: ss}
+		}
+	}
+	return nil
+}
+
+// attribute reads a typed attribute of a JSON-encoded item
+func attribute(item map[string]interface{}, name, kind string) string {
+	value, _ := item[name].(map[string]interface{})
+	s, _ := value[kind].(string)
+	return s
+}
+
+func testDispatcher(client *db.Client, maxAttempts int) *Dispatcher {
+	return NewDispatcher(client, &config.Config{
+		WebhooksTableName:          "Webhooks",
+		WebhookDeliveriesTableName: "Deliveries",
+		WebhookMaxAttempts:         maxAttempts,
+		WebhookRetentionHours:      24,
+		// Receivers listen on the loopback interface
+		WebhookAllowPrivateNetworks: true,
+	})
+}
+
+func TestDispatcher_DeliversSignedEvents(t *testing.T) {
+	var received []*http.Request
+	var bodies [][]byte
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r)
+		bodies = append(bodies, body)
+	}))
+	defer receiver.Close()
+
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"})
+	tables.addWebhook(t, models.Webhook{ID: "w2", URL: receiver.URL, Secret: "fedcba9876543210", Events: []string{models.EventCustomerDeleted}})
+	dispatcher := testDispatcher(client, 3)
+
+	event := models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerTagged, Data: map[string]string{"tag": "vip"}}
+	require.NoError(t, dispatcher.Publish(context.Background(), event))
+	// Publishing again, as the relay does after a failure, doesn't deliver twice
+	require.NoError(t, dispatcher.Publish(context.Background(), event))
+
+	now := time.Now()
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now))
+
+	require.Len(t, received, 1)
+	timestamp := received[0].Header.Get(TimestampHeader)
+	assert.Equal(t, strconv.FormatInt(now.Unix(), 10), timestamp)
+	assert.Equal(t, Sign("0123456789abcdef", now.Unix(), bodies[0]), received[0].Header.Get(SignatureHeader))
+	assert.Equal(t, "w1", received[0].Header.Get(WebhookIDHeader))
+	assert.Equal(t, "e1", received[0].Header.Get("X-Event-Id"))
+
+	var delivered models.Event
+	require.NoError(t, json.Unmarshal(bodies[0], &delivered))
+	assert.Equal(t, event, delivered)
+
+	delivery := tables.delivery(t, "w1", "e1")
+	assert.Equal(t, models.DeliveryDelivered, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+	assert.Equal(t, http.StatusOK, delivery.ResponseStatus)
+}
+
+func TestDispatcher_RetriesThenDeadLetters(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"})
+	dispatcher := testDispatcher(client, 3)
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated}))
+
+	now := time.Now()
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now))
+	delivery := tables.delivery(t, "w1", "e1")
+	assert.Equal(t, models.DeliveryPending, delivery.Status)
+	assert.Equal(t, http.StatusInternalServerError, delivery.ResponseStatus)
+	assert.Equal(t, now.Add(retryBase).Unix(), delivery.NextAttemptAt)
+
+	// Nothing is due until the backoff has passed
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now.Add(retryBase/2)))
+	assert.Equal(t, 1, attempts)
+
+	now = now.Add(retryBase)
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now))
+	assert.Equal(t, now.Add(2*retryBase).Unix(), tables.delivery(t, "w1", "e1").NextAttemptAt)
+
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now.Add(2*retryBase)))
+	delivery = tables.delivery(t, "w1", "e1")
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, models.DeliveryDead, delivery.Status)
+	assert.Equal(t, "webhook responded 500", delivery.Error)
+}
+
+func TestDispatcher_DeletedWebhook(t *testing.T) {
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: "http://127.0.0.1:1", Secret: "0123456789abcdef"})
+	dispatcher := testDispatcher(client, 5)
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated}))
+	tables.webhooks = nil
+
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), time.Now()))
+
+	delivery := tables.delivery(t, "w1", "e1")
+	assert.Equal(t, models.DeliveryDead, delivery.Status)
+	assert.Equal(t, "webhook was deleted", delivery.Error)
+}
+
+func TestDispatcher_SkipsClaimedDeliveries(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer receiver.Close()
+
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"})
+	dispatcher := testDispatcher(client, 3)
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated}))
+
+	// Another replica claims the delivery and stops before recording its attempt
+	now := time.Now()
+	due, err := db.ListDueWebhookDeliveries(context.Background(), client, "Deliveries", now, 10)
+	require.NoError(t, err)
+	require.Len(t, due, 1)
+	stalled := due[0]
+	stalled.Owner = "stalled"
+	require.NoError(t, db.ClaimWebhookDelivery(context.Background(), client, "Deliveries", &stalled, now.Add(claimTimeout)))
+
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now))
+	assert.Equal(t, 0, attempts)
+
+	// Once the claim expires the delivery is taken over
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), now.Add(claimTimeout)))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, models.DeliveryDelivered, tables.delivery(t, "w1", "e1").Status)
+
+	// and the stalled attempt can't overwrite the outcome
+	stalled.Status = models.DeliveryDead
+	err = db.SaveWebhookDelivery(context.Background(), client, "Deliveries", &stalled)
+	assert.ErrorIs(t, err, db.ErrWebhookDeliveryLost)
+	assert.Equal(t, models.DeliveryDelivered, tables.delivery(t, "w1", "e1").Status)
+}
+
+func TestDispatcher_RefusesPrivateAddresses(t *testing.T) {
+	attempts := 0
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+	}))
+	defer receiver.Close()
+
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: receiver.URL, Secret: "0123456789abcdef"})
+	dispatcher := NewDispatcher(client, &config.Config{
+		WebhooksTableName:          "Webhooks",
+		WebhookDeliveriesTableName: "Deliveries",
+		WebhookMaxAttempts:         3,
+		WebhookRetentionHours:      24,
+	})
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated}))
+
+	require.NoError(t, dispatcher.DeliverDue(context.Background(), time.Now()))
+
+	assert.Equal(t, 0, attempts)
+	delivery := tables.delivery(t, "w1", "e1")
+	assert.Equal(t, models.DeliveryPending, delivery.Status)
+	assert.Contains(t, delivery.Error, ErrForbiddenAddress.Error())
+}
+
+func TestDispatcher_PublishCachesWebhooks(t *testing.T) {
+	tables := &fakeTables{}
+	client := tables.client(t)
+	tables.addWebhook(t, models.Webhook{ID: "w1", URL: "https://partner.example.com/hooks", Secret: "0123456789abcdef"})
+	dispatcher := testDispatcher(client, 3)
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e1", Type: models.EventCustomerCreated}))
+
+	// A webhook added meanwhile receives events once the cache expires
+	tables.addWebhook(t, models.Webhook{ID: "w2", URL: "https://other.example.com/hooks", Secret: "fedcba9876543210"})
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e2", Type: models.EventCustomerUpdated}))
+	assert.NotContains(t, tables.deliveries, "w2/e2")
+
+	dispatcher.webhooksReadAt = dispatcher.webhooksReadAt.Add(-webhookCacheTTL)
+	require.NoError(t, dispatcher.Publish(context.Background(), models.Event{CustomerID: "c1", ID: "e3", Type: models.EventCustomerUpdated}))
+	assert.Equal(t, models.DeliveryPending, tables.delivery(t, "w2", "e3").Status)
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", 1700000000, []byte(`{"id":"e1"}`))
+
+	assert.True(t, strings.HasPrefix(signature, "sha256="))
+	assert.Len(t, signature, len("sha256=")+64)
+	assert.NotEqual(t, signature, Sign("secret", 1700000001, []byte(`{"id":"e1"}`)))
+}
+
+func TestRetryDelay(t *testing.T) {
+	assert.Equal(t, retryBase, retryDelay(1))
+	assert.Equal(t, 4*retryBase, retryDelay(3))
+	assert.Equal(t, retryMax, retryDelay(8))
+	assert.Equal(t, retryMax, retryDelay(100))
+}
@@ -1,8 +1,8 @@
 import React from 'react';
-import { render, screen, fireEvent, waitFor } from '@testing-library/react';
+import { render, screen, fireEvent, waitFor, act } from '@testing-library/react';
 import '@testing-library/jest-dom';
-import CustomerList from './CustomerList';
-import { Customer } from '../types/Customer';
+import CustomerList, { applyChange } from './CustomerList';
+import { Customer, CustomerChange, CustomerChangeType } from '../types/Customer';
 import * as api from '../services/api';
 
 // Mock the API module
@@ -163,4 +163,62 @@ describe('CustomerList', () => {
       expect(mockedApi.customerApi.getAll).toHaveBeenCalledTimes(2);
     });
   });
+
+  test('applies streamed customer changes', async () => {
+    const stream: { onChange?: (type: CustomerChangeType, change: CustomerChange) => void } = {};
+    const close = jest.fn();
+    mockedApi.customerApi.subscribe = jest.fn().mockImplementation((onChange) => {
+      stream.onChange = onChange;
+      return close;
+    });
+
+    const { unmount } = render(<CustomerList onEdit={mockOnEdit} onDelete={mockOnDelete} refreshTrigger={0} />);
+
+    await waitFor(() => {
+      expect(screen.getByText('John Doe')).toBeInTheDocument();
+    });
+
+    act(() => {
+      stream.onChange!('customer.updated', { id: '1', customer: { ...mockCustomers[0], name: 'John Updated' } });
+      stream.onChange!('customer.deleted', { id: '2' });
+    });
+
+    expect(screen.getByText('John Updated')).toBeInTheDocument();
+    expect(screen.queryByText('Jane Smith')).not.toBeInTheDocument();
+
+    unmount();
+    expect(close).toHaveBeenCalled();
+  });
+
+  test('reloads customers when the stream resets', async () => {
+    mockedApi.customerApi.subscribe = jest.fn().mockImplementation((_onChange, onReset) => {
+      setTimeout(onReset, 0);
+      return () => {};
+    });
+
+    render(<CustomerList onEdit={mockOnEdit} onDelete={mockOnDelete} refreshTrigger={0} />);
+
+    await waitFor(() => {
+      expect(mockedApi.customerApi.getAll).toHaveBeenCalledTimes(2);
+    });
+  });
+});
+
+describe('applyChange', () => {
+  const john: Customer = { id: '1', name: 'John Doe', email: 'john@example.com', telephone: '' };
+
+  test('appends created customers', () => {
+    expect(applyChange([], 'customer.created', { id: '1', customer: john })).toEqual([john]);
+  });
+
+  test('replaces updated customers in place', () => {
+    const jane: Customer = { ...john, id: '2', name: 'Jane' };
+    const updated = { ...john, name: 'John Updated' };
+
+    expect(applyChange([john, jane], 'customer.updated', { id: '1', customer: updated })).toEqual([updated, jane]);
+  });
+
+  test('removes deleted customers', () => {
+    expect(applyChange([john], 'customer.deleted', { id: '1' })).toEqual([]);
+  });
 });
\ No newline at end of file
@@ -1,5 +1,5 @@
 import React, { useState, useEffect } from 'react';
-import { Customer } from '../types/Customer';
+import { Customer, CustomerChange, CustomerChangeType } from '../types/Customer';
 import { customerApi } from '../services/api';
 
 interface CustomerListProps {
@@ -8,6 +8,18 @@ interface CustomerListProps {
   refreshTrigger: number;
 }
 
+// applyChange returns the list with a streamed customer change applied
+export const applyChange = (customers: Customer[], type: CustomerChangeType, change: CustomerChange): Customer[] => {
+  const others = customers.filter((customer) => customer.id !== change.id);
+  if (type === 'customer.deleted' || !change.customer) {
+    return others;
+  }
+  if (others.length === customers.length) {
+    return [...customers, change.customer];
+  }
+  return customers.map((customer) => (customer.id === change.id ? change.customer! : customer));
+};
+
 const CustomerList: React.FC<CustomerListProps> = ({ onEdit, onDelete, refreshTrigger }) => {
   const [customers, setCustomers] = useState<Customer[]>([]);
   const [loading, setLoading] = useState(true);
@@ -17,6 +29,14 @@ const CustomerList: React.FC<CustomerListProps> = ({ onEdit, onDelete, refreshTr
     fetchCustomers();
   }, [refreshTrigger]);
 
+  // Apply changes made by anyone as they are streamed by the API
+  useEffect(() => {
+    return customerApi.subscribe(
+      (type, change) => setCustomers((current) => applyChange(current, type, change)),
+      () => fetchCustomers()
+    );
+  }, []);
+
   const fetchCustomers = async () => {
     try {
       setLoading(true);
@@ -1,5 +1,5 @@
 import axios from 'axios';
-import { Customer, CreateCustomer } from '../types/Customer';
+import { Customer, CreateCustomer, CustomerChange, CustomerChangeType } from '../types/Customer';
 
 // API Base URL - will be configured via environment variables in Kubernetes
 const API_BASE_URL = process.env.REACT_APP_API_URL || 'http://localhost:8080';
@@ -40,4 +40,25 @@ export const customerApi = {
   delete: async (id: string): Promise<void> => {
     await apiClient.delete(`/customers/${id}`);
   },
+
+  // Subscribe to customer changes. onReset is called when changes were missed
+  // and the list must be reloaded. Returns a function that closes the stream.
+  subscribe: (
+    onChange: (type: CustomerChangeType, change: CustomerChange) => void,
+    onReset: () => void
+  ): (() => void) => {
+    if (typeof EventSource === 'undefined') {
+      return () => {};
+    }
+
+    const source = new EventSource(`${API_BASE_URL}/customers/events`);
+    const changeTypes: CustomerChangeType[] = ['customer.created', 'customer.updated', 'customer.deleted'];
+    changeTypes.forEach((type) => {
+      source.addEventListener(type, (event) => {
+        onChange(type, JSON.parse((event as MessageEvent).data));
+      });
+    });
+    source.addEventListener('reset', onReset);
+    return () => source.close();
+  },
 };
\ No newline at end of file
@@ -9,4 +9,10 @@ export interface CreateCustomer {
   name: string;
   email: string;
   telephone: string;
-}
\ No newline at end of file
+}
+export type CustomerChangeType = 'customer.created' | 'customer.updated' | 'customer.deleted';
+
+export interface CustomerChange {
+  id: string;
+  customer?: Customer;
+}
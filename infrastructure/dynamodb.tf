@@ -3,6 +3,10 @@ resource "aws_dynamodb_table" "customers" {
   billing_mode = "PAY_PER_REQUEST"
   hash_key     = "id"
 
+  # Stream consumers keep derived data in sync with writes made outside the API
+  stream_enabled   = true
+  stream_view_type = "NEW_AND_OLD_IMAGES"
+
   attribute {
     name = "id"
     type = "S"
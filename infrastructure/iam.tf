@@ -93,6 +93,25 @@ resource "aws_iam_role" "pod_role" {
   tags = local.tags
 }
 
+# Tables the API creates next to the customers table, named as in the
+# config.tables values of the customer-api chart
+locals {
+  api_tables = [
+    "CustomerSettings",
+    "CustomerTags",
+    "CustomerHistory",
+    "CustomerNotes",
+    "CustomerJobs",
+    "CustomerJobData",
+    "CustomerOutbox",
+    "CustomerStreamCheckpoints",
+    "CustomerWebhooks",
+    "CustomerWebhookDeliveries",
+    "CustomerIdempotencyKeys"
+  ]
+  api_table_arns = [for name in local.api_tables : "arn:aws:dynamodb:${var.aws_region}:${var.account_id}:table/${name}"]
+}
+
 data "aws_iam_policy_document" "dynamodb_policy" {
   # Table management permissions (require wildcard resource)
   statement {
@@ -102,7 +121,11 @@ data "aws_iam_policy_document" "dynamodb_policy" {
       "dynamodb:CreateTable",
       "dynamodb:DescribeTable",
       "dynamodb:UpdateTable",
-      "dynamodb:DeleteTable"
+      "dynamodb:DeleteTable",
+      "dynamodb:DescribeContinuousBackups",
+      "dynamodb:UpdateContinuousBackups",
+      "dynamodb:DescribeTimeToLive",
+      "dynamodb:UpdateTimeToLive"
     ]
     resources = ["*"]
   }
@@ -118,11 +141,31 @@ data "aws_iam_policy_document" "dynamodb_policy" {
       "dynamodb:Scan",
       "dynamodb:Query",
       "dynamodb:BatchGetItem",
-      "dynamodb:BatchWriteItem"
+      "dynamodb:BatchWriteItem",
+      "dynamodb:ConditionCheckItem"
+    ]
+    resources = concat(
+      [
+        aws_dynamodb_table.customers.arn,
+        "${aws_dynamodb_table.customers.arn}/index/*",
+        "arn:aws:dynamodb:${var.aws_region}:${var.account_id}:table/Customers-*"
+      ],
+      local.api_table_arns,
+      [for arn in local.api_table_arns : "${arn}/index/*"]
+    )
+  }
+
+  # Stream reads of the customers table, for the stream consumers
+  statement {
+    effect = "Allow"
+    actions = [
+      "dynamodb:DescribeStream",
+      "dynamodb:GetShardIterator",
+      "dynamodb:GetRecords",
+      "dynamodb:ListStreams"
     ]
     resources = [
-      aws_dynamodb_table.customers.arn,
-      "arn:aws:dynamodb:${var.aws_region}:${var.account_id}:table/Customers-*"
+      "${aws_dynamodb_table.customers.arn}/stream/*"
     ]
   }
 }